@@ -0,0 +1,400 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// sessionIdleTimeout hides the sessions not seen for longer from the
+	// sessions page, their cookies having expired.
+	sessionIdleTimeout = 30 * 24 * time.Hour
+	// sessionTouchInterval is how often the last seen time of a session is
+	// updated.
+	sessionTouchInterval = time.Minute
+)
+
+// ErrSessionNotFound is returned by a UserStore when no session matches.
+var ErrSessionNotFound = errors.New("session not found")
+
+// UserSession is the server-side record of a browser session, which lets
+// users see and revoke their sessions. The session itself lives in the
+// cookies, referencing the record by ID.
+type UserSession struct {
+	ID         string     `json:"id"`
+	Sub        string     `json:"sub"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	Location   string     `json:"location,omitempty"` // country and city reported by the CDN
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+
+	// ProviderSessionID is the sid claim of the ID token of the login, the
+	// auth0 session ended by back-channel logouts.
+	ProviderSessionID string `json:"provider_session_id,omitempty"`
+}
+
+// requestLocation returns the location of the client reported by the CDN in
+// front of the server, if any.
+func requestLocation(ctx *gin.Context) string {
+	country := ctx.GetHeader("CF-IPCountry")
+	if country == "" {
+		country = ctx.GetHeader("CloudFront-Viewer-Country")
+	}
+	if country == "" || country == "XX" {
+		return ""
+	}
+	if city := ctx.GetHeader("CloudFront-Viewer-City"); city != "" {
+		return city + ", " + country
+	}
+	return country
+}
+
+// browserNames and platformNames map user agent tokens to browser and
+// platform names, the first match winning.
+var (
+	browserNames = [][2]string{
+		{"Edg/", "Edge"}, {"OPR/", "Opera"}, {"Firefox/", "Firefox"}, {"FxiOS/", "Firefox"},
+		{"CriOS/", "Chrome"}, {"Chrome/", "Chrome"}, {"Safari/", "Safari"},
+	}
+	platformNames = [][2]string{
+		{"iPhone", "iPhone"}, {"iPad", "iPad"}, {"Android", "Android"}, {"Windows", "Windows"},
+		{"Mac OS X", "macOS"}, {"CrOS", "ChromeOS"}, {"Linux", "Linux"},
+	}
+)
+
+// describeUserAgent returns a short description of the browser and platform
+// of a user agent, such as "Firefox on Windows".
+func describeUserAgent(userAgent string) string {
+	match := func(names [][2]string) string {
+		for _, name := range names {
+			if strings.Contains(userAgent, name[0]) {
+				return name[1]
+			}
+		}
+		return ""
+	}
+
+	browser, platform := match(browserNames), match(platformNames)
+	switch {
+	case browser != "" && platform != "":
+		return browser + " on " + platform
+	case browser != "":
+		return browser
+	case platform != "":
+		return platform
+	default:
+		return "Unknown device"
+	}
+}
+
+// startUserSession records the session of a login, referenced from the
+// session cookie, made with the session providerSessionID of the identity
+// provider, if known. The session is saved by the caller.
+func (s *Server) startUserSession(ctx *gin.Context, session sessions.Session, sub, providerSessionID string) {
+	// front-channel logouts name the session of the provider
+	if providerSessionID != "" {
+		session.Set("provider_sid", providerSessionID)
+	} else {
+		session.Delete("provider_sid")
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		slog.ErrorContext(ctx, "could not generate session id", "err", err)
+		return
+	}
+
+	now := time.Now().UTC()
+	record := &UserSession{
+		ID:         hex.EncodeToString(id),
+		Sub:        sub,
+		UserAgent:  ctx.Request.UserAgent(),
+		IP:         ctx.ClientIP(),
+		Location:   requestLocation(ctx),
+		CreatedAt:  now,
+		LastSeenAt: now,
+
+		ProviderSessionID: providerSessionID,
+	}
+	if err := s.users.CreateSession(ctx, record); err != nil {
+		slog.ErrorContext(ctx, "could not record session", "err", err)
+		return
+	}
+	session.Set("sid", record.ID)
+}
+
+// TrackSessions logs out the sessions revoked from the sessions page and
+// records when the others were last seen. Sessions created before sessions
+// were recorded are let through. It must run after IsAuthenticated.
+func (s *Server) TrackSessions() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		session := defaultSession(ctx)
+		_, pat := ctx.Get("access_token")
+		_, bearer := ctx.Get("bearer_token")
+		if pat || bearer || session == nil {
+			ctx.Next()
+			return
+		}
+		id, _ := session.Get("sid").(string)
+		if id == "" {
+			ctx.Next()
+			return
+		}
+
+		record, err := s.users.GetSession(ctx, id)
+		if err != nil && !errors.Is(err, ErrSessionNotFound) {
+			slog.ErrorContext(ctx, "could not get session", "err", err)
+			ctx.Next()
+			return
+		}
+		if err != nil || record.RevokedAt != nil {
+			if u, ok := currentUser(ctx); ok {
+				s.audit(ctx, auditAccessDenied, u.Sub, map[string]string{"reason": "session_revoked"})
+			}
+			s.endSession(ctx)
+			return
+		}
+
+		if now := time.Now().UTC(); now.Sub(record.LastSeenAt) > sessionTouchInterval {
+			if err := s.users.TouchSession(ctx, id, now, ctx.ClientIP()); err != nil {
+				slog.ErrorContext(ctx, "could not update session", "err", err)
+			}
+		}
+		ctx.Set("session_id", id)
+		ctx.Next()
+	}
+}
+
+// activeSessions returns the sessions of the user which are not revoked and
+// were seen recently, last seen first.
+func (s *Server) activeSessions(ctx *gin.Context, sub string) ([]UserSession, error) {
+	records, err := s.users.ListSessions(ctx, sub)
+	if err != nil {
+		return nil, err
+	}
+
+	active := []UserSession{}
+	for _, record := range records {
+		if time.Since(record.LastSeenAt) <= sessionIdleTimeout {
+			active = append(active, record)
+		}
+	}
+	return active, nil
+}
+
+// revokeUserSessions revokes every session of the user, including the
+// sessions created before sessions were recorded.
+func (s *Server) revokeUserSessions(ctx *gin.Context, sub string) error {
+	sessions, err := s.users.ListSessions(ctx, sub)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	for _, session := range sessions {
+		if err := s.users.RevokeSession(ctx, sub, session.ID, now); err != nil && !errors.Is(err, ErrSessionNotFound) {
+			slog.ErrorContext(ctx, "could not revoke session", "err", err)
+		}
+	}
+	s.revocations.RevokeUser(sub)
+	return nil
+}
+
+// accountSessionsPage lists the active sessions of the signed in user.
+func (s *Server) accountSessionsPage(ctx *gin.Context) {
+	u, _ := currentUser(ctx)
+	records, err := s.activeSessions(ctx, u.Sub)
+	if err != nil {
+		slog.ErrorContext(ctx, "could not list sessions", "err", err)
+		renderError(ctx, http.StatusInternalServerError, "Something went wrong", "An unexpected error occurred, please try again.")
+		return
+	}
+
+	location := currentPreferences(ctx).Location()
+	current := ctx.GetString("session_id")
+	var rows []gin.H
+	for _, record := range records {
+		rows = append(rows, gin.H{
+			"ID":        record.ID,
+			"Device":    describeUserAgent(record.UserAgent),
+			"IP":        record.IP,
+			"Location":  record.Location,
+			"CreatedAt": record.CreatedAt.In(location).Format(time.RFC1123),
+			"LastSeen":  record.LastSeenAt.In(location).Format(time.RFC1123),
+			"Current":   record.ID == current,
+		})
+	}
+
+	renderHTML(ctx, http.StatusOK, "sessions.html", gin.H{"Sessions": rows})
+}
+
+// revokeAccountSessionHandler revokes a session of the signed in user, the
+// user being logged out when it is the current one.
+func (s *Server) revokeAccountSessionHandler(ctx *gin.Context) {
+	u, _ := currentUser(ctx)
+	id := ctx.Param("id")
+
+	err := s.users.RevokeSession(ctx, u.Sub, id, time.Now().UTC())
+	if err != nil && !errors.Is(err, ErrSessionNotFound) {
+		slog.ErrorContext(ctx, "could not revoke session", "err", err)
+		renderError(ctx, http.StatusInternalServerError, "Something went wrong", "The session could not be revoked, please try again.")
+		return
+	}
+	if err == nil {
+		s.audit(ctx, auditSessionEnded, u.Sub, map[string]string{"session_id": id})
+	}
+
+	if id == ctx.GetString("session_id") {
+		s.logoutHandler(ctx)
+		return
+	}
+	if err == nil {
+		addFlash(ctx, flashSuccess, "The session was revoked.")
+	}
+	ctx.Redirect(http.StatusSeeOther, "/account/sessions")
+}
+
+// revokeAllAccountSessionsHandler logs the signed in user out everywhere:
+// every session is revoked, the current one being logged out.
+func (s *Server) revokeAllAccountSessionsHandler(ctx *gin.Context) {
+	u, _ := currentUser(ctx)
+	if err := s.revokeUserSessions(ctx, u.Sub); err != nil {
+		slog.ErrorContext(ctx, "could not list sessions", "err", err)
+		renderError(ctx, http.StatusInternalServerError, "Something went wrong", "The sessions could not be revoked, please try again.")
+		return
+	}
+
+	s.audit(ctx, auditSessionRevoked, u.Sub, map[string]string{"reason": "user"})
+	s.logoutHandler(ctx)
+}
+
+// accountSession is a session of the user in the API responses.
+type accountSession struct {
+	ID         string    `json:"id"`
+	Device     string    `json:"device"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	Location   string    `json:"location,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	Current    bool      `json:"current"`
+}
+
+// sessionsHandler lists the active sessions of the user for the API.
+func (s *Server) sessionsHandler(ctx *gin.Context) {
+	u, _ := currentUser(ctx)
+	records, err := s.activeSessions(ctx, u.Sub)
+	if err != nil {
+		slog.ErrorContext(ctx, "could not list sessions", "err", err)
+		abortWithError(ctx, http.StatusInternalServerError, "server_error", "could not list sessions")
+		return
+	}
+
+	current := ctx.GetString("session_id")
+	sessions := []accountSession{}
+	for _, record := range records {
+		sessions = append(sessions, accountSession{
+			ID:         record.ID,
+			Device:     describeUserAgent(record.UserAgent),
+			UserAgent:  record.UserAgent,
+			IP:         record.IP,
+			Location:   record.Location,
+			CreatedAt:  record.CreatedAt,
+			LastSeenAt: record.LastSeenAt,
+			Current:    record.ID == current,
+		})
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// revokeSessionAPIHandler revokes a session of the user for the API.
+func (s *Server) revokeSessionAPIHandler(ctx *gin.Context) {
+	u, _ := currentUser(ctx)
+	id := ctx.Param("id")
+	err := s.users.RevokeSession(ctx, u.Sub, id, time.Now().UTC())
+	if errors.Is(err, ErrSessionNotFound) {
+		abortWithError(ctx, http.StatusNotFound, "not_found", "no such session")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "could not revoke session", "err", err)
+		abortWithError(ctx, http.StatusInternalServerError, "server_error", "could not revoke session")
+		return
+	}
+
+	s.audit(ctx, auditSessionEnded, u.Sub, map[string]string{"session_id": id})
+	ctx.Status(http.StatusNoContent)
+}
+
+// revokeSessionsAPIHandler logs the user out everywhere for the API, the
+// session or token of the request included.
+func (s *Server) revokeSessionsAPIHandler(ctx *gin.Context) {
+	u, _ := currentUser(ctx)
+	if err := s.revokeUserSessions(ctx, u.Sub); err != nil {
+		slog.ErrorContext(ctx, "could not list sessions", "err", err)
+		abortWithError(ctx, http.StatusInternalServerError, "server_error", "could not list sessions")
+		return
+	}
+
+	s.audit(ctx, auditSessionRevoked, u.Sub, map[string]string{"reason": "user"})
+	ctx.Status(http.StatusNoContent)
+}
+
+// adminSessionsHandler lists the active sessions of a user for the admin
+// API.
+func (s *Server) adminSessionsHandler(ctx *gin.Context) {
+	sessions, err := s.users.ListSessions(ctx, ctx.Param("sub"))
+	if err != nil {
+		slog.ErrorContext(ctx, "could not list sessions", "err", err)
+		abortWithError(ctx, http.StatusInternalServerError, "server_error", "could not list sessions")
+		return
+	}
+	if sessions == nil {
+		sessions = []UserSession{}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// adminRevokeSessionsHandler revokes every session of a user for the admin
+// API, including the sessions created before sessions were recorded.
+func (s *Server) adminRevokeSessionsHandler(ctx *gin.Context) {
+	sub := ctx.Param("sub")
+	if err := s.revokeUserSessions(ctx, sub); err != nil {
+		slog.ErrorContext(ctx, "could not list sessions", "err", err)
+		abortWithError(ctx, http.StatusInternalServerError, "server_error", "could not list sessions")
+		return
+	}
+
+	s.audit(ctx, auditSessionRevoked, sub, map[string]string{"reason": "admin"})
+	ctx.Status(http.StatusNoContent)
+}
+
+// adminRevokeSessionHandler revokes a session of a user for the admin API.
+func (s *Server) adminRevokeSessionHandler(ctx *gin.Context) {
+	sub, id := ctx.Param("sub"), ctx.Param("id")
+	err := s.users.RevokeSession(ctx, sub, id, time.Now().UTC())
+	if errors.Is(err, ErrSessionNotFound) {
+		abortWithError(ctx, http.StatusNotFound, "not_found", "no such session")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "could not revoke session", "err", err)
+		abortWithError(ctx, http.StatusInternalServerError, "server_error", "could not revoke session")
+		return
+	}
+
+	s.audit(ctx, auditSessionEnded, sub, map[string]string{"session_id": id, "reason": "admin"})
+	ctx.Status(http.StatusNoContent)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultAuditPageSize is the number of events returned per page when no
+	// limit is given.
+	defaultAuditPageSize = 100
+	// maxAuditPageSize is the largest page of events returned.
+	maxAuditPageSize = 1000
+)
+
+// newAdminRouter creates the router of the admin API. It is served on its
+// own listener so it can be kept off the public network.
+func (s *Server) newAdminRouter() *gin.Engine {
+	router := gin.New()
+	router.ContextWithFallback = true
+	// the admin API is not served through the proxies, so no X-Forwarded-For
+	// header is trusted for the client address
+	router.SetTrustedProxies(nil)
+	router.Use(RequestID(), Recovery(s.errorReporter))
+
+	// the OpenAPI document and Swagger UI only describe the API, the calls
+	// made from Swagger UI carrying the admin token
+	router.GET("/admin/openapi.json", s.openAPIHandler)
+	router.GET("/admin/docs", swaggerUIHandler)
+
+	auditQuery := []apiParam{
+		{"user", "subject of the user"},
+		{"type", "event type"},
+		{"ip", "client address"},
+		{"since", "earliest time, RFC 3339"},
+		{"until", "latest time, RFC 3339"},
+		{"limit", "page size"},
+		{"cursor", "cursor of the next page"},
+	}
+	lockoutQuery := []apiParam{
+		{"user", "email or subject of the user"},
+		{"identifier", "login identifier"},
+	}
+
+	api := newAPIRoutes(router.Group("/admin/api/v1", AdminAuth(s.adminToken)), securityAdminToken)
+	api.handle(apiOperation{method: http.MethodGet, path: "/audit/events", summary: "List audit events", query: auditQuery}, s.auditEventsHandler)
+	api.handle(apiOperation{method: http.MethodGet, path: "/audit/events/export", summary: "Export audit events", query: append([]apiParam{{"format", "ndjson or csv"}}, auditQuery...)}, s.auditExportHandler)
+	api.handle(apiOperation{method: http.MethodGet, path: "/audit/dead-letters", summary: "List audit events which could not be delivered", query: []apiParam{{"limit", "page size"}}}, s.deadLettersHandler)
+	api.handle(apiOperation{method: http.MethodPost, path: "/audit/dead-letters/:id/retry", summary: "Retry the delivery of an audit event", status: http.StatusNoContent}, s.retryDeadLetterHandler)
+	api.handle(apiOperation{method: http.MethodGet, path: "/client-secret", summary: "Show the client secret rotation"}, s.clientSecretHandler)
+	api.handle(apiOperation{method: http.MethodGet, path: "/jobs", summary: "List background jobs"}, s.jobsHandler)
+	// the lockouts are read from the Management API
+	if s.management != nil {
+		api.handle(apiOperation{method: http.MethodGet, path: "/lockouts", summary: "Show login lockouts", query: lockoutQuery}, s.lockoutsHandler)
+		api.handle(apiOperation{method: http.MethodDelete, path: "/lockouts", summary: "Clear login lockouts", query: lockoutQuery, status: http.StatusNoContent}, s.clearLockoutsHandler)
+		api.handle(apiOperation{method: http.MethodGet, path: "/lockouts/ips/:ip", summary: "Show the lockout of a client address"}, s.ipLockoutHandler)
+		api.handle(apiOperation{method: http.MethodDelete, path: "/lockouts/ips/:ip", summary: "Clear the lockout of a client address", status: http.StatusNoContent}, s.clearIPLockoutHandler)
+	}
+	api.handle(apiOperation{method: http.MethodGet, path: "/idp/health", summary: "Show the health of the identity provider"}, s.idpHealthHandler)
+	api.handle(apiOperation{method: http.MethodGet, path: "/users/:sub/usage", summary: "Show the API usage of a user"}, s.adminUsageHandler)
+	api.handle(apiOperation{method: http.MethodGet, path: "/users/:sub/sessions", summary: "List the sessions of a user"}, s.adminSessionsHandler)
+	api.handle(apiOperation{method: http.MethodDelete, path: "/users/:sub/sessions", summary: "Revoke the sessions of a user", status: http.StatusNoContent}, s.adminRevokeSessionsHandler)
+	api.handle(apiOperation{method: http.MethodDelete, path: "/users/:sub/sessions/:id", summary: "Revoke a session of a user", status: http.StatusNoContent}, s.adminRevokeSessionHandler)
+	api.handle(apiOperation{method: http.MethodGet, path: "/maintenance", summary: "Show the maintenance mode"}, s.maintenanceHandler)
+	api.handle(apiOperation{method: http.MethodPut, path: "/maintenance", summary: "Turn the maintenance mode on or off", body: "enabled and message"}, s.setMaintenanceHandler)
+	api.handle(apiOperation{method: http.MethodPost, path: "/signed-urls", summary: "Sign the URL of a protected file", body: "path, expires_in (seconds) and sub"}, s.signURLHandler)
+	s.adminAPI = api
+
+	return router
+}
+
+// AdminAuth requires requests to carry the admin token as a bearer token.
+// Every request is rejected when token is empty.
+func AdminAuth(token string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		provided := strings.TrimPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			abortWithError(ctx, http.StatusUnauthorized, "unauthorized", "a valid admin token is required")
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// auditFilterFromQuery reads the audit filter from the query parameters
+// user, type, ip, since, until (RFC 3339), limit and cursor.
+func auditFilterFromQuery(ctx *gin.Context) (AuditFilter, bool) {
+	filter := AuditFilter{
+		Sub:   ctx.Query("user"),
+		Type:  ctx.Query("type"),
+		IP:    ctx.Query("ip"),
+		Limit: defaultAuditPageSize,
+	}
+
+	for name, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		value := ctx.Query(name)
+		if value == "" {
+			continue
+		}
+
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			abortWithError(ctx, http.StatusBadRequest, "invalid_request", name+" must be an RFC 3339 timestamp")
+			return filter, false
+		}
+		*t = parsed
+	}
+
+	if value := ctx.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxAuditPageSize {
+			abortWithError(ctx, http.StatusBadRequest, "invalid_request", "limit must be between 1 and 1000")
+			return filter, false
+		}
+		filter.Limit = limit
+	}
+
+	if value := ctx.Query("cursor"); value != "" {
+		cursor, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || cursor < 1 {
+			abortWithError(ctx, http.StatusBadRequest, "invalid_request", "invalid cursor")
+			return filter, false
+		}
+		filter.Before = cursor
+	}
+
+	return filter, true
+}
+
+// auditEventsHandler returns a page of audit events, newest first. The
+// next_cursor value of the response fetches the next page.
+func (s *Server) auditEventsHandler(ctx *gin.Context) {
+	filter, ok := auditFilterFromQuery(ctx)
+	if !ok {
+		return
+	}
+
+	events, err := s.auditor.store.QueryEvents(ctx, filter)
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "server_error", "could not query audit events")
+		return
+	}
+
+	response := gin.H{"events": events}
+	if len(events) == filter.Limit {
+		response["next_cursor"] = strconv.FormatInt(events[len(events)-1].ID, 10)
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// auditExportHandler streams every audit event matching the filter as
+// NDJSON, or CSV with format=csv.
+func (s *Server) auditExportHandler(ctx *gin.Context) {
+	filter, ok := auditFilterFromQuery(ctx)
+	if !ok {
+		return
+	}
+	filter.Limit = maxAuditPageSize
+
+	format := ctx.DefaultQuery("format", "ndjson")
+	var write func(event *AuditEvent) error
+
+	switch format {
+	case "ndjson":
+		ctx.Header("Content-Type", "application/x-ndjson")
+		encoder := json.NewEncoder(ctx.Writer)
+		write = func(event *AuditEvent) error { return encoder.Encode(event) }
+	case "csv":
+		ctx.Header("Content-Type", "text/csv")
+		writer := csv.NewWriter(ctx.Writer)
+		defer writer.Flush()
+
+		if err := writer.Write([]string{"id", "time", "type", "sub", "ip", "user_agent", "request_id", "details"}); err != nil {
+			return
+		}
+		write = func(event *AuditEvent) error {
+			var details []byte
+			if len(event.Details) > 0 {
+				details, _ = json.Marshal(event.Details)
+			}
+			return writer.Write([]string{
+				strconv.FormatInt(event.ID, 10), event.Time.Format(time.RFC3339Nano), event.Type,
+				event.Sub, event.IP, event.UserAgent, event.RequestID, string(details),
+			})
+		}
+	default:
+		abortWithError(ctx, http.StatusBadRequest, "invalid_request", "format must be ndjson or csv")
+		return
+	}
+
+	ctx.Header("Content-Disposition", "attachment; filename=audit-events."+format)
+	ctx.Status(http.StatusOK)
+
+	for {
+		events, err := s.auditor.store.QueryEvents(ctx, filter)
+		if err != nil {
+			// the status is already sent, the truncated export is all we can do
+			ctx.Error(err)
+			return
+		}
+
+		for i := range events {
+			if err := write(&events[i]); err != nil {
+				return
+			}
+		}
+
+		if len(events) < filter.Limit {
+			return
+		}
+		filter.Before = events[len(events)-1].ID
+	}
+}
+
+// jobsHandler reports the metrics of the background jobs, such as the
+// failures of the provider metadata refresh.
+func (s *Server) jobsHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"jobs": s.scheduler.Stats()})
+}
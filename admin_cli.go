@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// adminCLIUsage describes the commands of the admin CLI.
+const adminCLIUsage = `usage: go-auth0 admin [-url URL] [-token TOKEN] <command>
+
+commands:
+  sessions list <sub>              list the active sessions of a user
+  sessions revoke <sub> [<id>]     revoke a session, or every session, of a user
+  audit events [flags]             list audit events (-user, -type, -ip, -since, -until, -limit, -cursor)
+  audit export [flags]             download the audit events as NDJSON (-format csv for CSV)
+  lockouts list -identifier EMAIL  list the brute force blocks of a user (or -user SUB)
+  lockouts clear -identifier EMAIL clear the brute force blocks of a user (or -user SUB)
+  lockouts clear-ip <ip>           unblock a suspicious IP
+  maintenance status               report whether maintenance mode is on
+  maintenance on [-message TEXT]   turn maintenance mode on
+  maintenance off                  turn maintenance mode off
+
+The admin API is reached at ADMIN_URL, or on ADMIN_LISTEN_ADDR, with
+ADMIN_TOKEN as the bearer token.
+`
+
+// adminClient calls the admin API.
+type adminClient struct {
+	baseURL string
+	token   string
+	client  *http.Client
+	stdout  io.Writer
+}
+
+// defaultAdminURL returns the URL of the admin API in ADMIN_URL, or the one
+// of the local listener set by ADMIN_LISTEN_ADDR.
+func defaultAdminURL() string {
+	if raw := os.Getenv("ADMIN_URL"); raw != "" {
+		return raw
+	}
+	addr := os.Getenv("ADMIN_LISTEN_ADDR")
+	if addr == "" {
+		addr = "127.0.0.1:9091"
+	}
+	if strings.HasPrefix(addr, ":") {
+		addr = "127.0.0.1" + addr
+	}
+	return "http://" + addr
+}
+
+// runAdminCLI runs the admin command with args, returning the exit status.
+func runAdminCLI(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("admin", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() { fmt.Fprint(stderr, adminCLIUsage) }
+	baseURL := flags.String("url", defaultAdminURL(), "URL of the admin API")
+	token := flags.String("token", os.Getenv("ADMIN_TOKEN"), "admin token")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() < 2 {
+		flags.Usage()
+		return 2
+	}
+	if *token == "" {
+		fmt.Fprintln(stderr, "admin: ADMIN_TOKEN or -token is required")
+		return 2
+	}
+
+	c := &adminClient{
+		baseURL: strings.TrimSuffix(*baseURL, "/") + "/admin/api/v1",
+		token:   *token,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		stdout:  stdout,
+	}
+
+	ctx := context.Background()
+	command, rest := flags.Arg(0)+" "+flags.Arg(1), flags.Args()[2:]
+	var err error
+	switch command {
+	case "sessions list":
+		err = c.listSessions(ctx, rest)
+	case "sessions revoke":
+		err = c.revokeSessions(ctx, rest)
+	case "audit events":
+		err = c.auditEvents(ctx, rest, "/audit/events")
+	case "audit export":
+		err = c.auditEvents(ctx, rest, "/audit/events/export")
+	case "lockouts list":
+		err = c.lockouts(ctx, rest, http.MethodGet)
+	case "lockouts clear":
+		err = c.lockouts(ctx, rest, http.MethodDelete)
+	case "lockouts clear-ip":
+		err = c.clearIPLockout(ctx, rest)
+	case "maintenance status":
+		err = c.call(ctx, http.MethodGet, "/maintenance", nil, nil)
+	case "maintenance on", "maintenance off":
+		err = c.setMaintenance(ctx, flags.Arg(1) == "on", rest)
+	default:
+		flags.Usage()
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintf(stderr, "admin: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// call sends a request to the admin API, printing the response: JSON bodies
+// indented, others as is.
+func (c *adminClient) call(ctx context.Context, method, path string, query url.Values, body interface{}) error {
+	endpoint := c.baseURL + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error   string `json:"error"`
+			Message string `json:"message"`
+		}
+		raw, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		if json.Unmarshal(raw, &apiErr) == nil && apiErr.Message != "" {
+			return fmt.Errorf("%s: %s (%s)", resp.Status, apiErr.Message, apiErr.Error)
+		}
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(raw)))
+	}
+	if resp.StatusCode == http.StatusNoContent {
+		fmt.Fprintln(c.stdout, "ok")
+		return nil
+	}
+
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		_, err := io.Copy(c.stdout, resp.Body)
+		return err
+	}
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, raw, "", "  "); err != nil {
+		return fmt.Errorf("could not decode response: %v", err)
+	}
+	out.WriteByte('\n')
+	_, err = out.WriteTo(c.stdout)
+	return err
+}
+
+func (c *adminClient) listSessions(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: sessions list <sub>")
+	}
+	return c.call(ctx, http.MethodGet, "/users/"+url.PathEscape(args[0])+"/sessions", nil, nil)
+}
+
+func (c *adminClient) revokeSessions(ctx context.Context, args []string) error {
+	switch len(args) {
+	case 1:
+		return c.call(ctx, http.MethodDelete, "/users/"+url.PathEscape(args[0])+"/sessions", nil, nil)
+	case 2:
+		return c.call(ctx, http.MethodDelete, "/users/"+url.PathEscape(args[0])+"/sessions/"+url.PathEscape(args[1]), nil, nil)
+	default:
+		return fmt.Errorf("usage: sessions revoke <sub> [<id>]")
+	}
+}
+
+func (c *adminClient) auditEvents(ctx context.Context, args []string, path string) error {
+	flags := flag.NewFlagSet("audit", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	query := url.Values{}
+	for _, name := range []string{"user", "type", "ip", "since", "until", "limit", "cursor", "format"} {
+		name := name
+		flags.Func(name, "", func(value string) error {
+			query.Set(name, value)
+			return nil
+		})
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	return c.call(ctx, http.MethodGet, path, query, nil)
+}
+
+func (c *adminClient) lockouts(ctx context.Context, args []string, method string) error {
+	flags := flag.NewFlagSet("lockouts", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	identifier := flags.String("identifier", "", "")
+	user := flags.String("user", "", "")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if (*identifier == "") == (*user == "") {
+		return fmt.Errorf("either -identifier or -user is required")
+	}
+
+	query := url.Values{}
+	if *identifier != "" {
+		query.Set("identifier", *identifier)
+	} else {
+		query.Set("user", *user)
+	}
+	return c.call(ctx, method, "/lockouts", query, nil)
+}
+
+func (c *adminClient) clearIPLockout(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: lockouts clear-ip <ip>")
+	}
+	return c.call(ctx, http.MethodDelete, "/lockouts/ips/"+url.PathEscape(args[0]), nil, nil)
+}
+
+func (c *adminClient) setMaintenance(ctx context.Context, enabled bool, args []string) error {
+	flags := flag.NewFlagSet("maintenance", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	message := flags.String("message", "", "")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	return c.call(ctx, http.MethodPut, "/maintenance", nil, map[string]interface{}{"enabled": enabled, "message": *message})
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Audit event types.
+const (
+	auditLoginSuccess    = "login.success"
+	auditLoginFailure    = "login.failure"
+	auditLogout          = "logout"
+	auditUserEvent       = "user.event"
+	auditAccessDenied    = "access.denied"
+	auditSessionRevoked  = "session.revoked"
+	auditTermsAccepted   = "terms.accepted"
+	auditWebhookRejected = "webhook.rejected"
+	auditTokenCreated    = "token.created"
+	auditTokenRevoked    = "token.revoked"
+	auditLockoutCleared  = "lockout.cleared"
+	auditSessionEnded    = "session.ended"
+	auditLoginThrottled  = "login.throttled"
+)
+
+// AuditEvent is a security relevant event.
+type AuditEvent struct {
+	ID        int64             `json:"id"`
+	Time      time.Time         `json:"time"`
+	Type      string            `json:"type"`
+	Sub       string            `json:"sub,omitempty"`
+	IP        string            `json:"ip,omitempty"`
+	UserAgent string            `json:"user_agent,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+}
+
+// AuditFilter selects audit events. Zero fields match every event.
+type AuditFilter struct {
+	Sub    string
+	Type   string
+	IP     string
+	Since  time.Time // events at or after Since
+	Until  time.Time // events before Until
+	Before int64     // events with an ID lower than Before, used as a pagination cursor
+	Limit  int       // maximum number of events returned
+}
+
+// match reports whether event is selected by the filter, ignoring Limit.
+func (f AuditFilter) match(event *AuditEvent) bool {
+	switch {
+	case f.Sub != "" && event.Sub != f.Sub:
+		return false
+	case f.Type != "" && event.Type != f.Type:
+		return false
+	case f.IP != "" && event.IP != f.IP:
+		return false
+	case !f.Since.IsZero() && event.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !event.Time.Before(f.Until):
+		return false
+	case f.Before > 0 && event.ID >= f.Before:
+		return false
+	}
+	return true
+}
+
+// AuditStore persists audit events.
+type AuditStore interface {
+	// AppendEvent stores the event, setting its ID.
+	AppendEvent(ctx context.Context, event *AuditEvent) error
+	// QueryEvents returns the events matching filter, newest first.
+	QueryEvents(ctx context.Context, filter AuditFilter) ([]AuditEvent, error)
+}
+
+// NewAuditStore returns a Postgres backed store when dsn is set, and an in
+// memory store keeping the latest events otherwise.
+func NewAuditStore(dsn string) (AuditStore, error) {
+	if dsn == "" {
+		return newMemoryAuditStore(10000), nil
+	}
+	return newPostgresAuditStore(dsn)
+}
+
+// AuditSink receives a copy of every audit event, to forward it to an
+// external system.
+type AuditSink interface {
+	WriteEvent(event *AuditEvent) error
+}
+
+// Auditor records audit events in the store and forwards them to the sinks
+// through the outbox.
+type Auditor struct {
+	store       AuditStore
+	outbox      OutboxStore
+	dispatchers []*outboxDispatcher
+}
+
+// NewAuditor creates an Auditor storing events in store and forwarding them
+// to sinks, by name, through outbox. Every sink is written to from its own
+// job of scheduler so that a slow sink never delays requests, the failed
+// deliveries being retried up to maxAttempts times.
+func NewAuditor(store AuditStore, outbox OutboxStore, sinks map[string]AuditSink, maxAttempts int, scheduler *Scheduler) (*Auditor, error) {
+	auditor := &Auditor{store: store, outbox: outbox}
+	for name, sink := range sinks {
+		dispatcher := &outboxDispatcher{
+			name:        name,
+			sink:        sink,
+			outbox:      outbox,
+			maxAttempts: maxAttempts,
+			wake:        make(chan struct{}, 1),
+		}
+		auditor.dispatchers = append(auditor.dispatchers, dispatcher)
+		if err := scheduler.Add(Job{
+			Name:     "audit_outbox_" + name,
+			Interval: outboxPollInterval,
+			Run:      dispatcher.deliver,
+			Wake:     dispatcher.wake,
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	return auditor, nil
+}
+
+// Record stores event. Failures are logged, auditing never fails a request.
+func (a *Auditor) Record(ctx context.Context, event AuditEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	if err := a.store.AppendEvent(ctx, &event); err != nil {
+		slog.ErrorContext(ctx, "could not record audit event", "type", event.Type, "err", err)
+	}
+
+	for _, dispatcher := range a.dispatchers {
+		if err := a.outbox.Enqueue(ctx, dispatcher.name, &event); err != nil {
+			slog.ErrorContext(ctx, "could not enqueue audit event", "event_id", event.ID, "sink", dispatcher.name, "err", err)
+			continue
+		}
+		dispatcher.notify()
+	}
+}
+
+// audit records an event of the given type for the request.
+func (s *Server) audit(ctx *gin.Context, eventType, sub string, details map[string]string) {
+	s.auditor.Record(ctx, AuditEvent{
+		Type:      eventType,
+		Sub:       sub,
+		IP:        ctx.ClientIP(),
+		UserAgent: ctx.Request.UserAgent(),
+		RequestID: ctx.GetString("request_id"),
+		Details:   details,
+	})
+}
+
+// memoryAuditStore keeps the latest events in memory.
+type memoryAuditStore struct {
+	mu       sync.RWMutex
+	capacity int
+	events   []AuditEvent // oldest first
+	lastID   int64
+}
+
+func newMemoryAuditStore(capacity int) *memoryAuditStore {
+	return &memoryAuditStore{capacity: capacity}
+}
+
+func (s *memoryAuditStore) AppendEvent(ctx context.Context, event *AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastID++
+	event.ID = s.lastID
+
+	if len(s.events) >= s.capacity {
+		s.events = append(s.events[:0], s.events[1:]...)
+	}
+	s.events = append(s.events, *event)
+
+	return nil
+}
+
+func (s *memoryAuditStore) QueryEvents(ctx context.Context, filter AuditFilter) ([]AuditEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var events []AuditEvent
+	for i := len(s.events) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && len(events) >= filter.Limit {
+			break
+		}
+		if filter.match(&s.events[i]) {
+			events = append(events, s.events[i])
+		}
+	}
+
+	return events, nil
+}
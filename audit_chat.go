@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultAlertEventTypes are the audit events alerted on by default.
+var defaultAlertEventTypes = []string{
+	auditWebhookRejected,
+	auditSessionRevoked,
+}
+
+// chatAlertFlavor is the webhook payload format of a chat service.
+type chatAlertFlavor int
+
+const (
+	chatSlack chatAlertFlavor = iota
+	chatDiscord
+)
+
+// discordMaxContent is the maximum length of a Discord message.
+const discordMaxContent = 2000
+
+// chatSink posts alerts for high severity audit events to a Slack or Discord
+// incoming webhook. At most limit alerts are posted per minute, the ones
+// over the limit being counted and reported with the next alert posted.
+type chatSink struct {
+	webhookURL string
+	flavor     chatAlertFlavor
+	types      map[string]bool
+	client     *http.Client
+
+	mu         sync.Mutex
+	limit      int
+	window     time.Time // start of the current minute
+	posted     int       // alerts posted in the current minute
+	suppressed int       // alerts dropped since the last one posted
+}
+
+// newChatSink creates a sink posting alerts for eventTypes to webhookURL,
+// a Slack or a Discord (discord.com host) incoming webhook.
+func newChatSink(webhookURL string, eventTypes []string, limit int) (*chatSink, error) {
+	u, err := url.Parse(webhookURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("alert webhook URL must be an https URL")
+	}
+
+	flavor := chatSlack
+	if host := u.Hostname(); host == "discord.com" || host == "discordapp.com" {
+		flavor = chatDiscord
+	}
+
+	types := make(map[string]bool, len(eventTypes))
+	for _, eventType := range eventTypes {
+		types[eventType] = true
+	}
+
+	return &chatSink{
+		webhookURL: webhookURL,
+		flavor:     flavor,
+		types:      types,
+		limit:      limit,
+		client:     &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// allow reports whether an alert can be posted now, and how many were
+// suppressed before it.
+func (s *chatSink) allow(now time.Time) (bool, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.window) >= time.Minute {
+		s.window = now
+		s.posted = 0
+	}
+
+	if s.posted >= s.limit {
+		s.suppressed++
+		return false, 0
+	}
+
+	s.posted++
+	suppressed := s.suppressed
+	s.suppressed = 0
+	return true, suppressed
+}
+
+func (s *chatSink) WriteEvent(event *AuditEvent) error {
+	if !s.types[event.Type] {
+		return nil
+	}
+
+	ok, suppressed := s.allow(time.Now())
+	if !ok {
+		return nil
+	}
+
+	text := formatChatAlert(event, suppressed)
+
+	var payload interface{}
+	switch s.flavor {
+	case chatDiscord:
+		if len(text) > discordMaxContent {
+			text = text[:discordMaxContent-3] + "..."
+		}
+		// alerts must not ping @everyone or mentioned users
+		payload = map[string]interface{}{
+			"content":          text,
+			"allowed_mentions": map[string][]string{"parse": {}},
+		}
+	default:
+		payload = map[string]string{"text": text}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.client.Post(s.webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("could not post alert: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return fmt.Errorf("could not post alert: %s: %s", resp.Status, b)
+	}
+	return nil
+}
+
+// formatChatAlert formats the event as a short message readable in Slack as
+// well as in Discord.
+func formatChatAlert(event *AuditEvent, suppressed int) string {
+	name := auditEventNames[event.Type]
+	if name == "" {
+		name = event.Type
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, ":rotating_light: *%s* (`%s`) at %s", name, event.Type, event.Time.UTC().Format(time.RFC3339))
+	if event.Sub != "" {
+		fmt.Fprintf(&b, "\nUser: `%s`", chatEscape(event.Sub))
+	}
+	if event.IP != "" {
+		fmt.Fprintf(&b, "\nIP: `%s`", chatEscape(event.IP))
+	}
+	if event.RequestID != "" {
+		fmt.Fprintf(&b, "\nRequest ID: `%s`", chatEscape(event.RequestID))
+	}
+
+	keys := make([]string, 0, len(event.Details))
+	for key := range event.Details {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&b, "\n%s: `%s`", chatEscape(key), chatEscape(event.Details[key]))
+	}
+
+	if suppressed > 0 {
+		fmt.Fprintf(&b, "\n_%d earlier alerts were suppressed by the rate limit_", suppressed)
+	}
+	return b.String()
+}
+
+// chatEscape keeps values from breaking out of their code span or being
+// rendered as links and mentions.
+func chatEscape(value string) string {
+	return strings.NewReplacer("`", "'", "<", "‹", ">", "›", "@", "@​", "\n", " ").Replace(value)
+}
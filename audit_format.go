@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuditFormatter encodes an audit event for a sink.
+type AuditFormatter func(event *AuditEvent) ([]byte, error)
+
+// NewAuditFormatter returns the formatter registered under name: json (the
+// default), ecs for Elastic Common Schema documents or cef for ArcSight
+// Common Event Format lines.
+func NewAuditFormatter(name string) (AuditFormatter, error) {
+	switch name {
+	case "", "json":
+		return formatAuditJSON, nil
+	case "ecs":
+		return formatAuditECS, nil
+	case "cef":
+		return formatAuditCEF, nil
+	default:
+		return nil, fmt.Errorf("unknown audit format %q", name)
+	}
+}
+
+// auditEventNames are the human readable names of the event types.
+var auditEventNames = map[string]string{
+	auditLoginSuccess:    "Login succeeded",
+	auditLoginFailure:    "Login failed",
+	auditLogout:          "Logout",
+	auditUserEvent:       "User changed",
+	auditAccessDenied:    "Access denied",
+	auditSessionRevoked:  "Sessions revoked",
+	auditTermsAccepted:   "Terms accepted",
+	auditWebhookRejected: "Webhook rejected",
+	auditTokenCreated:    "Access token created",
+	auditTokenRevoked:    "Access token revoked",
+	auditLockoutCleared:  "Lockout cleared",
+	auditSessionEnded:    "Session revoked by the user",
+	auditLoginThrottled:  "Login throttled",
+}
+
+// auditEventFailed reports whether event is a failure or denial.
+func auditEventFailed(event *AuditEvent) bool {
+	return event.Type == auditLoginFailure || event.Type == auditAccessDenied || event.Type == auditWebhookRejected ||
+		event.Type == auditLoginThrottled
+}
+
+func formatAuditJSON(event *AuditEvent) ([]byte, error) {
+	return json.Marshal(event)
+}
+
+// ecsEventTypes maps the event types to the ECS event.type values.
+var ecsEventTypes = map[string]string{
+	auditLoginSuccess:    "start",
+	auditLoginFailure:    "start",
+	auditLogout:          "end",
+	auditUserEvent:       "change",
+	auditAccessDenied:    "denied",
+	auditSessionRevoked:  "end",
+	auditTermsAccepted:   "change",
+	auditWebhookRejected: "denied",
+	auditTokenCreated:    "creation",
+	auditTokenRevoked:    "deletion",
+	auditLockoutCleared:  "deletion",
+	auditSessionEnded:    "end",
+	auditLoginThrottled:  "denied",
+}
+
+// formatAuditECS encodes the event as an Elastic Common Schema document.
+func formatAuditECS(event *AuditEvent) ([]byte, error) {
+	outcome := "success"
+	if auditEventFailed(event) {
+		outcome = "failure"
+	}
+
+	eventType := ecsEventTypes[event.Type]
+	if eventType == "" {
+		eventType = "info"
+	}
+
+	document := map[string]interface{}{
+		"@timestamp": event.Time.UTC().Format(time.RFC3339Nano),
+		"ecs":        map[string]string{"version": "8.11.0"},
+		"message":    auditEventName(event),
+		"event": map[string]interface{}{
+			"id":       strconv.FormatInt(event.ID, 10),
+			"kind":     "event",
+			"category": []string{"authentication"},
+			"type":     []string{eventType},
+			"action":   event.Type,
+			"outcome":  outcome,
+			"dataset":  "go-auth0.audit",
+		},
+	}
+
+	if event.Sub != "" {
+		document["user"] = map[string]string{"id": event.Sub}
+	}
+	if event.IP != "" {
+		document["source"] = map[string]string{"ip": event.IP}
+	}
+	if event.UserAgent != "" {
+		document["user_agent"] = map[string]string{"original": event.UserAgent}
+	}
+	if event.RequestID != "" {
+		document["http"] = map[string]interface{}{"request": map[string]string{"id": event.RequestID}}
+	}
+	if len(event.Details) > 0 {
+		document["labels"] = event.Details
+	}
+
+	return json.Marshal(document)
+}
+
+// formatAuditCEF encodes the event as a Common Event Format line.
+func formatAuditCEF(event *AuditEvent) ([]byte, error) {
+	severity := "3"
+	if auditEventFailed(event) {
+		severity = "6"
+	}
+
+	extension := [][2]string{
+		{"rt", strconv.FormatInt(event.Time.UnixMilli(), 10)},
+		{"externalId", strconv.FormatInt(event.ID, 10)},
+		{"suser", event.Sub},
+		{"src", event.IP},
+		{"requestClientApplication", event.UserAgent},
+	}
+
+	if event.RequestID != "" {
+		extension = append(extension, [2]string{"cs1Label", "requestId"}, [2]string{"cs1", event.RequestID})
+	}
+
+	if len(event.Details) > 0 {
+		keys := make([]string, 0, len(event.Details))
+		for key := range event.Details {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		details := make([]string, 0, len(keys))
+		for _, key := range keys {
+			details = append(details, key+"="+event.Details[key])
+		}
+		extension = append(extension, [2]string{"msg", strings.Join(details, " ")})
+	}
+
+	var line strings.Builder
+	line.WriteString("CEF:0|go-auth0|go-auth0|1.0|")
+	line.WriteString(cefEscapeHeader(event.Type) + "|")
+	line.WriteString(cefEscapeHeader(auditEventName(event)) + "|")
+	line.WriteString(severity + "|")
+
+	first := true
+	for _, field := range extension {
+		if field[1] == "" {
+			continue
+		}
+		if !first {
+			line.WriteString(" ")
+		}
+		first = false
+		line.WriteString(field[0] + "=" + cefEscapeExtension(field[1]))
+	}
+
+	return []byte(line.String()), nil
+}
+
+// auditEventName returns the human readable name of the event.
+func auditEventName(event *AuditEvent) string {
+	if name, ok := auditEventNames[event.Type]; ok {
+		return name
+	}
+	return event.Type
+}
+
+// cefEscapeHeader escapes a CEF header field.
+func cefEscapeHeader(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ").Replace(value)
+}
+
+// cefEscapeExtension escapes a CEF extension value.
+func cefEscapeExtension(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`).Replace(value)
+}
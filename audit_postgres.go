@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// postgresAuditSchema creates the audit events table when missing.
+const postgresAuditSchema = `
+CREATE TABLE IF NOT EXISTS audit_events (
+	id         BIGSERIAL PRIMARY KEY,
+	time       TIMESTAMPTZ NOT NULL,
+	type       TEXT NOT NULL,
+	sub        TEXT NOT NULL DEFAULT '',
+	ip         TEXT NOT NULL DEFAULT '',
+	user_agent TEXT NOT NULL DEFAULT '',
+	request_id TEXT NOT NULL DEFAULT '',
+	details    JSONB
+);
+CREATE INDEX IF NOT EXISTS audit_events_sub_idx ON audit_events (sub, id);
+CREATE INDEX IF NOT EXISTS audit_events_time_idx ON audit_events (time)`
+
+// postgresAuditStore keeps audit events in a Postgres table.
+type postgresAuditStore struct {
+	db *sql.DB
+}
+
+func newPostgresAuditStore(dsn string) (*postgresAuditStore, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("could not open database: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := db.ExecContext(ctx, postgresAuditSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not create audit events table: %v", err)
+	}
+
+	return &postgresAuditStore{db: db}, nil
+}
+
+func (s *postgresAuditStore) AppendEvent(ctx context.Context, event *AuditEvent) error {
+	details, err := json.Marshal(event.Details)
+	if err != nil {
+		return fmt.Errorf("could not encode audit event details: %v", err)
+	}
+
+	err = s.db.QueryRowContext(ctx, `
+		INSERT INTO audit_events (time, type, sub, ip, user_agent, request_id, details)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id`,
+		event.Time, event.Type, event.Sub, event.IP, event.UserAgent, event.RequestID, details,
+	).Scan(&event.ID)
+	if err != nil {
+		return fmt.Errorf("could not insert audit event: %v", err)
+	}
+
+	return nil
+}
+
+func (s *postgresAuditStore) QueryEvents(ctx context.Context, filter AuditFilter) ([]AuditEvent, error) {
+	var conditions []string
+	var args []interface{}
+	where := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, strings.Replace(condition, "?", "$"+strconv.Itoa(len(args)), 1))
+	}
+
+	if filter.Sub != "" {
+		where("sub = ?", filter.Sub)
+	}
+	if filter.Type != "" {
+		where("type = ?", filter.Type)
+	}
+	if filter.IP != "" {
+		where("ip = ?", filter.IP)
+	}
+	if !filter.Since.IsZero() {
+		where("time >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		where("time < ?", filter.Until)
+	}
+	if filter.Before > 0 {
+		where("id < ?", filter.Before)
+	}
+
+	query := `SELECT id, time, type, sub, ip, user_agent, request_id, details FROM audit_events`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+	query += ` ORDER BY id DESC`
+	if filter.Limit > 0 {
+		query += ` LIMIT ` + strconv.Itoa(filter.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not query audit events: %v", err)
+	}
+	defer rows.Close()
+
+	var events []AuditEvent
+	for rows.Next() {
+		var event AuditEvent
+		var details []byte
+		if err := rows.Scan(&event.ID, &event.Time, &event.Type, &event.Sub, &event.IP, &event.UserAgent, &event.RequestID, &details); err != nil {
+			return nil, fmt.Errorf("could not read audit event: %v", err)
+		}
+		if len(details) > 0 {
+			if err := json.Unmarshal(details, &event.Details); err != nil {
+				return nil, fmt.Errorf("could not decode audit event details: %v", err)
+			}
+		}
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not query audit events: %v", err)
+	}
+	return events, nil
+}
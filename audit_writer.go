@@ -0,0 +1,31 @@
+package main
+
+import (
+	"io"
+	"sync"
+)
+
+// writerSink writes audit events to w, one formatted event per line. It is
+// used to send events to stdout for log shippers.
+type writerSink struct {
+	mu        sync.Mutex
+	w         io.Writer
+	formatter AuditFormatter
+}
+
+func newWriterSink(w io.Writer, formatter AuditFormatter) *writerSink {
+	return &writerSink{w: w, formatter: formatter}
+}
+
+func (s *writerSink) WriteEvent(event *AuditEvent) error {
+	line, err := s.formatter(event)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.w.Write(append(line, '\n'))
+	return err
+}
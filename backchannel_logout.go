@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/coreos/go-oidc"
+	"github.com/gin-gonic/gin"
+)
+
+// backChannelLogoutEvent is the event of the logout tokens (OpenID Connect
+// Back-Channel Logout 1.0).
+const backChannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+// logoutToken holds the claims of a verified logout token: the user and,
+// when set, the session of the identity provider which ended.
+type logoutToken struct {
+	JTI       string                     `json:"jti"`
+	Subject   string                     `json:"sub"`
+	SessionID string                     `json:"sid"`
+	Nonce     *string                    `json:"nonce"`
+	Events    map[string]json.RawMessage `json:"events"`
+}
+
+// backChannelLogoutVerifier verifies the logout tokens auth0 posts when a
+// session ends, signed by the tenant of the site of the request or one of
+// the failover tenants, and issued to its application.
+type backChannelLogoutVerifier struct {
+	server *Server
+}
+
+// tenants returns the tenants whose logout tokens are accepted by req.
+func (v *backChannelLogoutVerifier) tenants(req *http.Request) []*tenant {
+	if site := v.server.siteOf(req.Host); site != nil {
+		return []*tenant{site.tenant}
+	}
+
+	var tenants []*tenant
+	for _, t := range v.server.tenants {
+		if t.isAuth0() {
+			tenants = append(tenants, t)
+		}
+	}
+	return tenants
+}
+
+// verify checks the signature, issuer, audience and time claims of the token
+// with the tenants of req, and that it is a logout token.
+func (v *backChannelLogoutVerifier) verify(ctx context.Context, req *http.Request, raw string) (*logoutToken, time.Time, error) {
+	var idToken *oidc.IDToken
+	err := errors.New("no auth0 tenant")
+	for _, t := range v.tenants(req) {
+		verifier := t.provider.Verifier(&oidc.Config{ClientID: t.oauth2config.ClientID}, v.server.clockSkew)
+		if idToken, err = verifier.Verify(ctx, raw); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var token logoutToken
+	if err := idToken.Claims(&token); err != nil {
+		return nil, time.Time{}, fmt.Errorf("could not parse token claims: %v", err)
+	}
+	if idToken.IssuedAt.IsZero() {
+		return nil, time.Time{}, fmt.Errorf("token has no iat claim")
+	}
+	if token.JTI == "" {
+		return nil, time.Time{}, fmt.Errorf("token has no jti claim")
+	}
+	// ID tokens, which carry a nonce, must not be accepted as logout tokens
+	if token.Nonce != nil {
+		return nil, time.Time{}, fmt.Errorf("token has a nonce claim")
+	}
+	var event map[string]json.RawMessage
+	if err := json.Unmarshal(token.Events[backChannelLogoutEvent], &event); err != nil || event == nil {
+		return nil, time.Time{}, fmt.Errorf("token has no %s event", backChannelLogoutEvent)
+	}
+	if token.Subject == "" && token.SessionID == "" {
+		return nil, time.Time{}, fmt.Errorf("token has neither sub nor sid claim")
+	}
+	return &token, idToken.IssuedAt, nil
+}
+
+// Verify implements WebhookVerifier for the form posted by the provider, the
+// deliveries being identified by the jti of the token and sent at its iat.
+func (v *backChannelLogoutVerifier) Verify(ctx context.Context, req *http.Request, body []byte) (*WebhookDelivery, error) {
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse form: %v", err)
+	}
+	raw := form.Get("logout_token")
+	if raw == "" {
+		return nil, fmt.Errorf("no logout_token")
+	}
+
+	token, issuedAt, err := v.verify(ctx, req, raw)
+	if err != nil {
+		return nil, err
+	}
+	return &WebhookDelivery{ID: token.JTI, SentAt: issuedAt, Payload: token}, nil
+}
+
+// rejectLogoutToken answers a rejected logout token. A replayed token is
+// acknowledged again, the provider having missed the first response.
+func rejectLogoutToken(ctx *gin.Context, err error) {
+	ctx.Header("Cache-Control", "no-store")
+	if errors.Is(err, errWebhookReplay) {
+		ctx.AbortWithStatus(http.StatusOK)
+		return
+	}
+	abortWithError(ctx, http.StatusBadRequest, "invalid_request", "the logout token is invalid")
+}
+
+// backChannelLogoutHandler ends the local sessions of a logout token: the
+// ones made with the auth0 session it names, or every session of the user
+// when it names none.
+func (s *Server) backChannelLogoutHandler(ctx *gin.Context) {
+	token := webhookDelivery(ctx).Payload.(*logoutToken)
+	ctx.Header("Cache-Control", "no-store")
+
+	if token.SessionID == "" {
+		s.revocations.RevokeUser(token.Subject)
+		s.audit(ctx, auditSessionRevoked, token.Subject, map[string]string{
+			"reason": "backchannel_logout",
+			"jti":    token.JTI,
+		})
+		ctx.Status(http.StatusOK)
+		return
+	}
+
+	revoked, err := s.users.RevokeProviderSession(ctx, token.Subject, token.SessionID, time.Now().UTC())
+	if err != nil {
+		slog.ErrorContext(ctx, "could not revoke sessions", "err", err)
+		abortWithError(ctx, http.StatusInternalServerError, "server_error", "could not end the sessions")
+		return
+	}
+	for _, session := range revoked {
+		s.audit(ctx, auditSessionRevoked, session.Sub, map[string]string{
+			"reason":     "backchannel_logout",
+			"jti":        token.JTI,
+			"session_id": session.ID,
+		})
+	}
+	ctx.Status(http.StatusOK)
+}
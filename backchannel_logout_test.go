@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// logoutTokenClaims returns the claims of a logout token of the test client
+// for the user auth0|test, changed by change.
+func (p *testProvider) logoutTokenClaims(change func(claims map[string]interface{})) map[string]interface{} {
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":    p.URL + "/",
+		"aud":    testClientID,
+		"iat":    now.Unix(),
+		"exp":    now.Add(time.Minute).Unix(),
+		"jti":    "logout-" + strconv.FormatInt(now.UnixNano(), 10),
+		"sub":    "auth0|test",
+		"events": map[string]interface{}{backChannelLogoutEvent: map[string]interface{}{}},
+	}
+	if change != nil {
+		change(claims)
+	}
+	return claims
+}
+
+func TestBackChannelLogout(t *testing.T) {
+	provider := newTestProvider(t)
+	server := newTestServer(t, testConfig(provider), "memstore")
+	server.router.POST("/oidc/backchannel-logout",
+		server.VerifyWebhook("backchannel_logout", &backChannelLogoutVerifier{server: server}, rejectLogoutToken), server.backChannelLogoutHandler)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/oidc/backchannel-logout", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+	token := func(change func(claims map[string]interface{})) string {
+		return url.Values{"logout_token": {provider.sign(t, provider.logoutTokenClaims(change))}}.Encode()
+	}
+
+	replayed := token(nil)
+	if w := post(replayed); w.Code != http.StatusOK {
+		t.Fatalf("first delivery = %d %s", w.Code, w.Body)
+	}
+
+	for _, tt := range []struct {
+		name string
+		body string
+		want int
+	}{
+		{"user", token(func(c map[string]interface{}) { c["sub"] = "auth0|other" }), http.StatusOK},
+		{"provider session", token(func(c map[string]interface{}) { c["sid"] = "provider-session" }), http.StatusOK},
+		{"session without the user", token(func(c map[string]interface{}) { delete(c, "sub"); c["sid"] = "provider-session" }), http.StatusOK},
+		{"replayed", replayed, http.StatusOK},
+		{"no logout token", "", http.StatusBadRequest},
+		{"not a form", "logout_token=%zz", http.StatusBadRequest},
+		{"not a JWT", "logout_token=not-a-jwt", http.StatusBadRequest},
+		{"tampered", token(nil) + "x", http.StatusBadRequest},
+		{"ID token", token(func(c map[string]interface{}) { c["nonce"] = testNonce }), http.StatusBadRequest},
+		{"no event", token(func(c map[string]interface{}) { delete(c, "events") }), http.StatusBadRequest},
+		{"other event", token(func(c map[string]interface{}) {
+			c["events"] = map[string]interface{}{"http://schemas.openid.net/event/other": map[string]interface{}{}}
+		}), http.StatusBadRequest},
+		{"event not an object", token(func(c map[string]interface{}) {
+			c["events"] = map[string]interface{}{backChannelLogoutEvent: "logout"}
+		}), http.StatusBadRequest},
+		{"no jti", token(func(c map[string]interface{}) { delete(c, "jti") }), http.StatusBadRequest},
+		{"no iat", token(func(c map[string]interface{}) { delete(c, "iat") }), http.StatusBadRequest},
+		{"neither sub nor sid", token(func(c map[string]interface{}) { delete(c, "sub") }), http.StatusBadRequest},
+		{"other audience", token(func(c map[string]interface{}) { c["aud"] = "other-client" }), http.StatusBadRequest},
+		{"other issuer", token(func(c map[string]interface{}) { c["iss"] = "https://evil.example/" }), http.StatusBadRequest},
+		{"expired", token(func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() }), http.StatusBadRequest},
+		{"too old", token(func(c map[string]interface{}) {
+			c["iat"] = time.Now().Add(-defaultWebhookTolerance - time.Minute).Unix()
+		}), http.StatusBadRequest},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			w := post(tt.body)
+			if w.Code != tt.want {
+				t.Errorf("status = %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+			if w.Header().Get("Cache-Control") != "no-store" {
+				t.Errorf("Cache-Control = %q, want no-store", w.Header().Get("Cache-Control"))
+			}
+		})
+	}
+
+	// a token naming no provider session ends every session of the user
+	if !server.revocations.Revoked("auth0|test", time.Now().Add(-time.Minute)) {
+		t.Error("the sessions of the user were not revoked")
+	}
+}
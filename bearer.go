@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc"
+	"github.com/gin-gonic/gin"
+)
+
+// BearerToken is a JWT access token issued by auth0 for the API, sent by
+// the clients calling it without a session, such as mobile apps and scripts.
+type BearerToken struct {
+	Subject  string
+	Scopes   []string // scopes and auth0 permissions
+	IssuedAt time.Time
+}
+
+// verifyBearerToken verifies the signature, issuer, audience and time claims
+// of the raw JWT access token against the keys of the tenants.
+func (s *Server) verifyBearerToken(ctx *gin.Context, raw string) (*BearerToken, error) {
+	var err error
+	for _, t := range s.tenants {
+		verifier := t.provider.Verifier(&oidc.Config{ClientID: s.apiAudience}, s.clockSkew)
+
+		var token *oidc.IDToken
+		token, err = verifier.Verify(ctx, raw)
+		if err != nil {
+			continue
+		}
+
+		if token.Subject == "" {
+			return nil, fmt.Errorf("token has no sub claim")
+		}
+		scopes, _ := accessTokenScopes(raw)
+		return &BearerToken{Subject: token.Subject, Scopes: scopes, IssuedAt: token.IssuedAt}, nil
+	}
+	return nil, err
+}
+
+// authenticateBearerToken authenticates the request with the JWT access
+// token raw. The subject is stored in the context as the user under "user",
+// completed from its record when it signed in before, and the token under
+// "bearer_token".
+func (s *Server) authenticateBearerToken(ctx *gin.Context, raw string) {
+	token, err := s.verifyBearerToken(ctx, raw)
+	// tokens issued before the sessions of the user were revoked are
+	// revoked with them
+	if err == nil && s.revocations.Revoked(token.Subject, token.IssuedAt) {
+		err = fmt.Errorf("token was revoked")
+	}
+	if err != nil {
+		ctx.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		abortWithError(ctx, http.StatusUnauthorized, "invalid_token", "the access token is invalid, expired or revoked")
+		return
+	}
+
+	u := &UserInfo{Sub: token.Subject}
+	if user, err := s.users.GetUser(ctx, token.Subject); err == nil {
+		u.Name, u.Email, u.EmailVerified, u.Picture = user.Name, user.Email, user.EmailVerified, user.Picture
+	}
+	ctx.Set("user", u)
+	ctx.Set("bearer_token", token)
+	ctx.Next()
+}
+
+// isJWT reports whether value has the three parts of a compact JWS.
+func isJWT(value string) bool {
+	return strings.Count(value, ".") == 2
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// colorPattern matches the CSS hex colors accepted as primary color.
+var colorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// FooterLink is a link shown in the footer of every page.
+type FooterLink struct {
+	Label string
+	URL   string
+}
+
+// Brand is the branding of the pages, rendered by every template as .Brand.
+type Brand struct {
+	AppName      string
+	LogoURL      string
+	PrimaryColor string
+	FooterLinks  []FooterLink
+}
+
+// defaultBrand is the branding of the pages when none is configured.
+var defaultBrand = Brand{
+	AppName:      "SSO using Auth0",
+	LogoURL:      "/public/img/password.png",
+	PrimaryColor: "#41688f",
+}
+
+// loadBrand reads the branding of config: BRAND_APP_NAME, BRAND_LOGO_URL,
+// BRAND_PRIMARY_COLOR and BRAND_FOOTER_LINKS, a comma separated list of
+// label=url pairs, keeping the default of unset values.
+func loadBrand(config BrandConfig) (Brand, error) {
+	brand := defaultBrand
+
+	if name := config.AppName; name != "" {
+		brand.AppName = name
+	}
+
+	if logo := config.LogoURL; logo != "" {
+		if !isBrandURL(logo) {
+			return Brand{}, fmt.Errorf("BRAND_LOGO_URL must be an http(s) URL or a path: %q", logo)
+		}
+		brand.LogoURL = logo
+	}
+
+	if color := config.PrimaryColor; color != "" {
+		if !colorPattern.MatchString(color) {
+			return Brand{}, fmt.Errorf("BRAND_PRIMARY_COLOR must be a hex color such as #41688f: %q", color)
+		}
+		brand.PrimaryColor = color
+	}
+
+	for _, link := range config.FooterLinks {
+		label, linkURL, ok := strings.Cut(link, "=")
+		label, linkURL = strings.TrimSpace(label), strings.TrimSpace(linkURL)
+		if !ok || label == "" || !isBrandURL(linkURL) {
+			return Brand{}, fmt.Errorf("BRAND_FOOTER_LINKS must be label=url pairs: %q", link)
+		}
+		brand.FooterLinks = append(brand.FooterLinks, FooterLink{Label: label, URL: linkURL})
+	}
+
+	return brand, nil
+}
+
+// isBrandURL reports whether raw is an absolute http(s) URL or a path on
+// this site.
+func isBrandURL(raw string) bool {
+	if strings.HasPrefix(raw, "/") && !strings.HasPrefix(raw, "//") {
+		return true
+	}
+
+	parsed, err := url.Parse(raw)
+	return err == nil && (parsed.Scheme == "https" || parsed.Scheme == "http") && parsed.Host != ""
+}
+
+// Branding stores brand in the context under "brand" for renderHTML.
+func Branding(brand Brand) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Set("brand", brand)
+		ctx.Next()
+	}
+}
+
+// currentBrand returns the branding stored by Branding, or the default one.
+func currentBrand(ctx *gin.Context) Brand {
+	if brand, ok := ctx.Get("brand"); ok {
+		return brand.(Brand)
+	}
+	return defaultBrand
+}
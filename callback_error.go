@@ -0,0 +1,68 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// callbackErrorMessages maps the error codes auth0 sends back to the
+// callback to the title and message displayed to the user.
+var callbackErrorMessages = map[string][2]string{
+	"access_denied":           {"Sign in cancelled", "You cancelled the sign in or did not grant access to your account."},
+	"unauthorized":            {"Sign in not allowed", "Your account is not allowed to sign in to this application."},
+	"login_required":          {"Sign in required", "Please sign in to continue."},
+	"consent_required":        {"Consent required", "Access to your account needs to be granted to continue."},
+	"interaction_required":    {"Sign in required", "Please sign in to continue."},
+	"temporarily_unavailable": {"Sign in unavailable", "The sign in service is temporarily unavailable, please try again later."},
+}
+
+// handleCallbackError renders an error page when auth0 redirected back with
+// an error instead of an authorization code. It reports whether the callback
+// carried an error.
+//
+// The error description sent by the provider is only logged, as anybody can
+// craft a callback URL displaying an arbitrary text.
+func (s *Server) handleCallbackError(ctx *gin.Context) bool {
+	code := ctx.Query("error")
+	if code == "" {
+		return false
+	}
+
+	slog.WarnContext(ctx, "callback error", "error", code, "error_description", ctx.Query("error_description"))
+	s.audit(ctx, auditLoginFailure, "", map[string]string{
+		"reason":            code,
+		"error_description": ctx.Query("error_description"),
+	})
+	s.loginFailed(ctx)
+
+	title, message := "Sign in failed", "Something went wrong while signing you in."
+	text, known := callbackErrorMessages[code]
+	if known {
+		title, message = text[0], text[1]
+	}
+
+	status := http.StatusBadRequest
+	if code == "access_denied" || code == "unauthorized" {
+		status = http.StatusForbidden
+	}
+
+	if wantsJSON(ctx) {
+		if !known {
+			code = "login_failed"
+		}
+		abortWithError(ctx, status, code, message)
+		return true
+	}
+
+	renderHTML(ctx, status, "error.html", gin.H{
+		"Title":     title,
+		"Message":   message,
+		"RequestID": ctx.GetString("request_id"),
+		"RetryURL":  "/login",
+	})
+	ctx.Abort()
+
+	return true
+}
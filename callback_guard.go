@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// callbackGuard remembers the callbacks handled recently so that a repeated
+// callback (double click, browser prefetch) does not exchange the same
+// authorization code a second time.
+type callbackGuard struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]*callbackEntry
+}
+
+// callbackEntry tracks a callback being handled or handled recently.
+type callbackEntry struct {
+	expires time.Time
+	done    chan struct{} // closed once the callback is handled
+	ok      bool          // whether the user was signed in
+}
+
+// newCallbackGuard creates a callbackGuard remembering callbacks for ttl.
+func newCallbackGuard(ttl time.Duration) *callbackGuard {
+	return &callbackGuard{
+		ttl:     ttl,
+		entries: make(map[string]*callbackEntry),
+	}
+}
+
+// claim returns the entry for the state and code pair of a callback, and
+// whether the caller is the first to handle it. Only hashes of the values
+// are kept in memory.
+func (g *callbackGuard) claim(state, code string) (*callbackEntry, bool) {
+	sum := sha256.Sum256([]byte(state + "\x00" + code))
+	key := hex.EncodeToString(sum[:])
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	for k, entry := range g.entries {
+		if now.After(entry.expires) {
+			delete(g.entries, k)
+		}
+	}
+
+	if entry, ok := g.entries[key]; ok {
+		return entry, false
+	}
+
+	entry := &callbackEntry{
+		expires: now.Add(g.ttl),
+		done:    make(chan struct{}),
+	}
+	g.entries[key] = entry
+
+	return entry, true
+}
+
+// finish marks the callback as handled.
+func (e *callbackEntry) finish(ok bool) {
+	e.ok = ok
+	close(e.done)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// clientAssertionType is the client_assertion_type of private_key_jwt client
+// authentication (RFC 7523).
+const clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// clientAssertionSigner authenticates the client to the provider with JWTs
+// signed by a private key (private_key_jwt) instead of a shared secret.
+type clientAssertionSigner struct {
+	signer   jose.Signer
+	clientID string
+	audience string
+}
+
+// newClientAssertionSigner creates a signer using the PEM encoded RSA or EC
+// private key in keyFile, advertising keyID in the JWT header when set.
+func newClientAssertionSigner(keyFile, keyID, clientID, audience string) (*clientAssertionSigner, error) {
+	key, algorithm, err := loadSigningKey(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not load client assertion key: %v", err)
+	}
+
+	options := (&jose.SignerOptions{}).WithType("JWT")
+	if keyID != "" {
+		options = options.WithHeader("kid", keyID)
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: algorithm, Key: key}, options)
+	if err != nil {
+		return nil, fmt.Errorf("could not create client assertion signer: %v", err)
+	}
+
+	return &clientAssertionSigner{signer: signer, clientID: clientID, audience: audience}, nil
+}
+
+// loadSigningKey reads the PEM encoded RSA or EC private key in file and
+// returns it with the algorithm it signs with, RS256 or ES256.
+func loadSigningKey(file string) (crypto.Signer, jose.SignatureAlgorithm, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, "", err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, "", fmt.Errorf("key is not PEM encoded")
+	}
+
+	key, err := parsePrivateKey(block.Bytes)
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		return key, jose.RS256, nil
+	case *ecdsa.PrivateKey:
+		return key, jose.ES256, nil
+	default:
+		return nil, "", fmt.Errorf("unsupported key type %T", key)
+	}
+}
+
+// parsePrivateKey parses a PKCS #8, PKCS #1 or SEC 1 DER encoded private key.
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		if signer, ok := key.(crypto.Signer); ok {
+			return signer, nil
+		}
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	return x509.ParseECPrivateKey(der)
+}
+
+// assertion returns a new signed client assertion.
+func (c *clientAssertionSigner) assertion() (string, error) {
+	jti, err := generateRandomString()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	payload, err := json.Marshal(map[string]interface{}{
+		"iss": c.clientID,
+		"sub": c.clientID,
+		"aud": c.audience,
+		"jti": jti,
+		"iat": now.Unix(),
+		"exp": now.Add(time.Minute).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signed, err := c.signer.Sign(payload)
+	if err != nil {
+		return "", fmt.Errorf("could not sign client assertion: %v", err)
+	}
+	return signed.CompactSerialize()
+}
+
+// params returns the parameters authenticating a token endpoint request.
+func (c *clientAssertionSigner) params() (map[string]string, error) {
+	assertion, err := c.assertion()
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		"client_assertion_type": clientAssertionType,
+		"client_assertion":      assertion,
+	}, nil
+}
+
+// tokenRequestParams returns the parameters authenticating a request to
+// the provider with a client assertion, or none when the client secret is
+// used. Failover tenants always use their client secret.
+func (s *Server) tokenRequestParams(ctx *gin.Context) (map[string]string, error) {
+	if s.clientAssertion == nil || !s.tenant(ctx).primary {
+		return nil, nil
+	}
+	return s.clientAssertion.params()
+}
+
+// tokenRequestOptions returns tokenRequestParams as code exchange options.
+func (s *Server) tokenRequestOptions(ctx *gin.Context) ([]oauth2.AuthCodeOption, error) {
+	params, err := s.tokenRequestParams(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var opts []oauth2.AuthCodeOption
+	for name, value := range params {
+		opts = append(opts, oauth2.SetAuthURLParam(name, value))
+	}
+	return opts, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+// clientSecrets holds the client secrets accepted during a rotation: the
+// primary one and, while the rotation is in progress, a secondary one.
+type clientSecrets struct {
+	primary   string
+	secondary string
+
+	mu             sync.Mutex
+	secondaryInUse bool      // whether the last successful request used the secondary secret
+	lastFallback   time.Time // last time the secret in use was rejected
+}
+
+// ordered returns the secrets to try, the one in use first.
+func (c *clientSecrets) ordered() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.secondary == "" {
+		return []string{c.primary}
+	}
+	if c.secondaryInUse {
+		return []string{c.secondary, c.primary}
+	}
+	return []string{c.primary, c.secondary}
+}
+
+// use records that secret was accepted by the provider.
+func (c *clientSecrets) use(secret string, fallback bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.secondaryInUse = secret == c.secondary && secret != c.primary
+	if fallback {
+		c.lastFallback = time.Now()
+	}
+}
+
+// isInvalidClient reports whether the provider rejected the client
+// credentials of a token endpoint request.
+func isInvalidClient(err error) bool {
+	var retrieveErr *oauth2.RetrieveError
+	return errors.As(err, &retrieveErr) && retrieveErr.ErrorCode == "invalid_client"
+}
+
+// withClientSecret calls fn with the client secret in use and, if the
+// provider rejects it with invalid_client, again with the other secret,
+// which then becomes the one in use. fn is called once with an empty secret
+// when the client does not authenticate with a secret, and with their own
+// secret for failover tenants.
+func (s *Server) withClientSecret(ctx *gin.Context, fn func(secret string) error) error {
+	if tenant := s.tenant(ctx); !tenant.primary {
+		return fn(tenant.oauth2config.ClientSecret)
+	}
+	if s.clientSecrets == nil {
+		return fn("")
+	}
+
+	var err error
+	for i, secret := range s.clientSecrets.ordered() {
+		if err = fn(secret); !isInvalidClient(err) {
+			if err == nil {
+				s.clientSecrets.use(secret, i > 0)
+			}
+			return err
+		}
+
+		slog.WarnContext(ctx, "client secret rejected, trying the next one", "err", err)
+	}
+
+	return err
+}
+
+// clientSecretHandler reports which client secret is currently in use.
+func (s *Server) clientSecretHandler(ctx *gin.Context) {
+	if s.clientSecrets == nil {
+		ctx.JSON(http.StatusOK, gin.H{"in_use": "none"})
+		return
+	}
+
+	s.clientSecrets.mu.Lock()
+	defer s.clientSecrets.mu.Unlock()
+
+	inUse := "primary"
+	if s.clientSecrets.secondaryInUse {
+		inUse = "secondary"
+	}
+
+	response := gin.H{
+		"in_use":               inUse,
+		"secondary_configured": s.clientSecrets.secondary != "",
+	}
+	if !s.clientSecrets.lastFallback.IsZero() {
+		response["last_fallback_at"] = s.clientSecrets.lastFallback.UTC().Format(time.RFC3339)
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,972 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the configuration of the server, loaded once on startup by
+// LoadConfig from an optional YAML or TOML file and the environment.
+type Config struct {
+	Auth0          Auth0Config          `yaml:"auth0" toml:"auth0"`
+	Google         GoogleConfig         `yaml:"google" toml:"google"`
+	Providers      []ProviderConfig     `yaml:"providers" toml:"providers"` // PROVIDERS, PROVIDER_<NAME>_*
+	Sites          []SiteConfig         `yaml:"sites" toml:"sites"`         // SITES, SITE_<NAME>_*
+	Server         ServerConfig         `yaml:"server" toml:"server"`
+	Sessions       SessionConfig        `yaml:"sessions" toml:"sessions"`
+	Errors         ErrorsConfig         `yaml:"errors" toml:"errors"`
+	Log            LogConfig            `yaml:"log" toml:"log"`
+	Audit          AuditConfig          `yaml:"audit" toml:"audit"`
+	Alerts         AlertConfig          `yaml:"alerts" toml:"alerts"`
+	Access         AccessConfig         `yaml:"access" toml:"access"`
+	Login          LoginConfig          `yaml:"login" toml:"login"`
+	API            APIConfig            `yaml:"api" toml:"api"`
+	Device         DeviceConfig         `yaml:"device" toml:"device"`
+	Users          UsersConfig          `yaml:"users" toml:"users"`
+	Brand          BrandConfig          `yaml:"brand" toml:"brand"`
+	CORS           CORSConfig           `yaml:"cors" toml:"cors"`
+	Notify         NotifyConfig         `yaml:"notify" toml:"notify"`
+	Terms          TermsConfig          `yaml:"terms" toml:"terms"`
+	Tracing        TracingConfig        `yaml:"tracing" toml:"tracing"`
+	SecurityEvents SecurityEventsConfig `yaml:"security_events" toml:"security_events"`
+	Debug          DebugConfig          `yaml:"debug" toml:"debug"`
+}
+
+// Auth0Config is the auth0 application the users sign in with.
+type Auth0Config struct {
+	Domain                   string         `yaml:"domain" toml:"domain"`                                             // AUTH0_DOMAIN
+	Issuer                   string         `yaml:"issuer" toml:"issuer"`                                             // AUTH0_ISSUER
+	ClientID                 string         `yaml:"client_id" toml:"client_id"`                                       // AUTH0_CLIENT_ID
+	ClientSecret             string         `yaml:"client_secret" toml:"client_secret"`                               // AUTH0_CLIENT_SECRET
+	ClientSecretSecondary    string         `yaml:"client_secret_secondary" toml:"client_secret_secondary"`           // AUTH0_CLIENT_SECRET_SECONDARY
+	CallbackURLs             []string       `yaml:"callback_urls" toml:"callback_urls"`                               // AUTH0_CALLBACK_URL
+	Connection               string         `yaml:"connection" toml:"connection"`                                     // AUTH0_CONNECTION
+	Organization             string         `yaml:"organization" toml:"organization"`                                 // AUTH0_ORGANIZATION
+	ManagementDomain         string         `yaml:"management_domain" toml:"management_domain"`                       // AUTH0_MANAGEMENT_DOMAIN
+	ClientCertFile           string         `yaml:"client_cert_file" toml:"client_cert_file"`                         // AUTH0_CLIENT_CERT_FILE
+	ClientKeyFile            string         `yaml:"client_key_file" toml:"client_key_file"`                           // AUTH0_CLIENT_KEY_FILE
+	ClientAssertionKeyFile   string         `yaml:"client_assertion_key_file" toml:"client_assertion_key_file"`       // AUTH0_CLIENT_ASSERTION_KEY_FILE
+	ClientAssertionKeyID     string         `yaml:"client_assertion_key_id" toml:"client_assertion_key_id"`           // AUTH0_CLIENT_ASSERTION_KEY_ID
+	RequestObjectKeyFile     string         `yaml:"request_object_key_file" toml:"request_object_key_file"`           // AUTH0_REQUEST_OBJECT_KEY_FILE
+	IDTokenDecryptionKeyFile string         `yaml:"id_token_decryption_key_file" toml:"id_token_decryption_key_file"` // AUTH0_ID_TOKEN_DECRYPTION_KEY_FILE
+	IDTokenEncryptionAlg     string         `yaml:"id_token_encryption_alg" toml:"id_token_encryption_alg"`           // AUTH0_ID_TOKEN_ENCRYPTION_ALG
+	Resources                []string       `yaml:"resources" toml:"resources"`                                       // AUTH0_RESOURCES
+	WebhookSecret            string         `yaml:"webhook_secret" toml:"webhook_secret"`                             // AUTH0_WEBHOOK_SECRET
+	WebhookRequireTimestamp  bool           `yaml:"webhook_require_timestamp" toml:"webhook_require_timestamp"`       // AUTH0_WEBHOOK_REQUIRE_TIMESTAMP
+	JARM                     bool           `yaml:"jarm" toml:"jarm"`                                                 // AUTH0_JARM
+	UserInfoSigned           bool           `yaml:"userinfo_signed" toml:"userinfo_signed"`                           // AUTH0_USERINFO_SIGNED
+	DPoP                     bool           `yaml:"dpop" toml:"dpop"`                                                 // AUTH0_DPOP
+	FederatedLogout          bool           `yaml:"federated_logout" toml:"federated_logout"`                         // AUTH0_FEDERATED_LOGOUT
+	Failover                 []TenantConfig `yaml:"failover" toml:"failover"`                                         // AUTH0_FAILOVER_<n>_*
+}
+
+// GoogleConfig is the Google OAuth client users sign in with directly,
+// without an auth0 tenant, when AUTH0_DOMAIN is not set.
+type GoogleConfig struct {
+	ClientID      string   `yaml:"client_id" toml:"client_id"`           // GOOGLE_CLIENT_ID
+	ClientSecret  string   `yaml:"client_secret" toml:"client_secret"`   // GOOGLE_CLIENT_SECRET
+	HostedDomains []string `yaml:"hosted_domains" toml:"hosted_domains"` // GOOGLE_HOSTED_DOMAINS
+	Scopes        []string `yaml:"scopes" toml:"scopes"`                 // GOOGLE_SCOPES
+	PeopleAPI     bool     `yaml:"people_api" toml:"people_api"`         // GOOGLE_PEOPLE_API
+}
+
+// TenantConfig is a standby tenant taking over new logins when the ones
+// before it are unavailable.
+type TenantConfig struct {
+	Domain       string `yaml:"domain" toml:"domain"`
+	Issuer       string `yaml:"issuer" toml:"issuer"`
+	ClientID     string `yaml:"client_id" toml:"client_id"`
+	ClientSecret string `yaml:"client_secret" toml:"client_secret"`
+	Organization string `yaml:"organization" toml:"organization"`
+}
+
+// SiteConfig is an auth0 application served on its own host names by the
+// same process, with its own tenant, callback URL and sessions.
+type SiteConfig struct {
+	Name         string   `yaml:"name" toml:"name"`
+	Hosts        []string `yaml:"hosts" toml:"hosts"`
+	Domain       string   `yaml:"domain" toml:"domain"`
+	Issuer       string   `yaml:"issuer" toml:"issuer"`
+	ClientID     string   `yaml:"client_id" toml:"client_id"`
+	ClientSecret string   `yaml:"client_secret" toml:"client_secret"`
+	CallbackURL  string   `yaml:"callback_url" toml:"callback_url"`
+	Organization string   `yaml:"organization" toml:"organization"`
+}
+
+// ProviderConfig is an identity provider users can sign in with at
+// /login/<name>, besides auth0.
+type ProviderConfig struct {
+	Name          string   `yaml:"name" toml:"name"`
+	Type          string   `yaml:"type" toml:"type"`     // google, github, entra or oidc, the name by default
+	Issuer        string   `yaml:"issuer" toml:"issuer"` // issuer URL of the oidc providers
+	Tenant        string   `yaml:"tenant" toml:"tenant"` // tenant ID or domain of the entra providers
+	ClientID      string   `yaml:"client_id" toml:"client_id"`
+	ClientSecret  string   `yaml:"client_secret" toml:"client_secret"`
+	Scopes        []string `yaml:"scopes" toml:"scopes"`
+	Label         string   `yaml:"label" toml:"label"`                   // name shown on the sign in button
+	HostedDomains []string `yaml:"hosted_domains" toml:"hosted_domains"` // Google Workspace domains allowed to sign in
+}
+
+// googleMode reports whether users sign in with Google directly: when
+// GOOGLE_CLIENT_ID is set without AUTH0_DOMAIN.
+func (c *Config) googleMode() bool {
+	return c.Auth0.Domain == "" && c.Google.ClientID != ""
+}
+
+// kind returns the type of the provider, its name when not set, so that the
+// google and github providers need no type.
+func (p ProviderConfig) kind() string {
+	if p.Type == "" {
+		return p.Name
+	}
+	return p.Type
+}
+
+// ServerConfig is how the server listens and where it keeps its state.
+type ServerConfig struct {
+	Host            string   `yaml:"host" toml:"host"`                           // HOST
+	Port            string   `yaml:"port" toml:"port"`                           // PORT
+	ListenAddr      string   `yaml:"listen_addr" toml:"listen_addr"`             // LISTEN_ADDR, HOST:PORT when unset
+	AdminListenAddr string   `yaml:"admin_listen_addr" toml:"admin_listen_addr"` // ADMIN_LISTEN_ADDR
+	AdminToken      string   `yaml:"admin_token" toml:"admin_token"`             // ADMIN_TOKEN
+	ReusePort       bool     `yaml:"reuse_port" toml:"reuse_port"`               // REUSE_PORT
+	DatabaseURL     string   `yaml:"database_url" toml:"database_url"`           // DATABASE_URL
+	RedisURL        string   `yaml:"redis_url" toml:"redis_url"`                 // REDIS_URL
+	Region          string   `yaml:"region" toml:"region"`                       // REGION
+	TokenClockSkew  Duration `yaml:"token_clock_skew" toml:"token_clock_skew"`   // TOKEN_CLOCK_SKEW
+	RequestTimeout  Duration `yaml:"request_timeout" toml:"request_timeout"`     // REQUEST_TIMEOUT
+	CallbackTimeout Duration `yaml:"callback_timeout" toml:"callback_timeout"`   // CALLBACK_TIMEOUT
+	ShutdownTimeout Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`   // SHUTDOWN_TIMEOUT
+	TLSCertFile     string   `yaml:"tls_cert_file" toml:"tls_cert_file"`         // TLS_CERT_FILE
+	TLSKeyFile      string   `yaml:"tls_key_file" toml:"tls_key_file"`           // TLS_KEY_FILE
+	TLSRedirectAddr string   `yaml:"tls_redirect_addr" toml:"tls_redirect_addr"` // TLS_REDIRECT_ADDR
+	TLSHTTP3        bool     `yaml:"tls_http3" toml:"tls_http3"`                 // TLS_HTTP3
+	TrustedProxies  []string `yaml:"trusted_proxies" toml:"trusted_proxies"`     // TRUSTED_PROXIES
+
+	ProviderRefreshInterval Duration `yaml:"provider_refresh_interval" toml:"provider_refresh_interval"` // PROVIDER_REFRESH_INTERVAL
+	TenantHealthInterval    Duration `yaml:"tenant_health_interval" toml:"tenant_health_interval"`       // TENANT_HEALTH_INTERVAL
+	IdPMaxStaleness         Duration `yaml:"idp_max_staleness" toml:"idp_max_staleness"`                 // IDP_MAX_STALENESS
+	WebhookTolerance        Duration `yaml:"webhook_tolerance" toml:"webhook_tolerance"`                 // WEBHOOK_TOLERANCE
+	WebhookReplayRetention  Duration `yaml:"webhook_replay_retention" toml:"webhook_replay_retention"`   // WEBHOOK_REPLAY_RETENTION
+	SignedURLSecret         string   `yaml:"signed_url_secret" toml:"signed_url_secret"`                 // SIGNED_URL_SECRET
+	FilesDir                string   `yaml:"files_dir" toml:"files_dir"`                                 // FILES_DIR
+	TemplateDir             string   `yaml:"template_dir" toml:"template_dir"`                           // TEMPLATE_DIR
+}
+
+// SessionConfig is where and how the session values are kept.
+type SessionConfig struct {
+	Backend        string   `yaml:"backend" toml:"backend"`                 // SESSION_BACKEND
+	Codec          string   `yaml:"codec" toml:"codec"`                     // SESSION_CODEC
+	AuthKeys       []string `yaml:"auth_keys" toml:"auth_keys"`             // SESSION_AUTH_KEYS
+	EncryptionKeys []string `yaml:"encryption_keys" toml:"encryption_keys"` // SESSION_ENCRYPTION_KEYS
+	CookieSameSite SameSite `yaml:"cookie_samesite" toml:"cookie_samesite"` // SESSION_COOKIE_SAMESITE
+
+	RevalidateInterval  Duration `yaml:"revalidate_interval" toml:"revalidate_interval"`     // SESSION_REVALIDATE_INTERVAL
+	Replication         bool     `yaml:"replication" toml:"replication"`                     // SESSION_REPLICATION
+	ReplicationRedisURL string   `yaml:"replication_redis_url" toml:"replication_redis_url"` // SESSION_REPLICATION_REDIS_URL
+	ReplicationInterval Duration `yaml:"replication_interval" toml:"replication_interval"`   // SESSION_REPLICATION_INTERVAL
+}
+
+// ErrorsConfig is the error tracker the recovered panics are reported to.
+type ErrorsConfig struct {
+	SentryDSN   string `yaml:"sentry_dsn" toml:"sentry_dsn"`   // SENTRY_DSN
+	Environment string `yaml:"environment" toml:"environment"` // SENTRY_ENVIRONMENT
+}
+
+// LogConfig is the format of the logs and what they tell of the users.
+type LogConfig struct {
+	Format     string `yaml:"format" toml:"format"`           // LOG_FORMAT
+	Level      string `yaml:"level" toml:"level"`             // LOG_LEVEL
+	HashSub    bool   `yaml:"hash_sub" toml:"hash_sub"`       // LOG_HASH_SUB
+	RolesClaim string `yaml:"roles_claim" toml:"roles_claim"` // LOG_ROLES_CLAIM
+}
+
+// AuditConfig is where the audit events are forwarded.
+type AuditConfig struct {
+	SyslogURL         string `yaml:"syslog_url" toml:"syslog_url"`                   // AUDIT_SYSLOG_URL
+	SyslogFormat      string `yaml:"syslog_format" toml:"syslog_format"`             // AUDIT_SYSLOG_FORMAT
+	SyslogAppName     string `yaml:"syslog_app_name" toml:"syslog_app_name"`         // AUDIT_SYSLOG_APP_NAME
+	Stdout            bool   `yaml:"stdout" toml:"stdout"`                           // AUDIT_STDOUT
+	StdoutFormat      string `yaml:"stdout_format" toml:"stdout_format"`             // AUDIT_STDOUT_FORMAT
+	OutboxMaxAttempts int    `yaml:"outbox_max_attempts" toml:"outbox_max_attempts"` // AUDIT_OUTBOX_MAX_ATTEMPTS
+}
+
+// AlertConfig is the chat channels alerted of the high severity events.
+type AlertConfig struct {
+	SlackWebhookURL   string   `yaml:"slack_webhook_url" toml:"slack_webhook_url"`     // ALERT_SLACK_WEBHOOK_URL
+	DiscordWebhookURL string   `yaml:"discord_webhook_url" toml:"discord_webhook_url"` // ALERT_DISCORD_WEBHOOK_URL
+	EventTypes        []string `yaml:"event_types" toml:"event_types"`                 // ALERT_EVENT_TYPES
+	RateLimit         int      `yaml:"rate_limit" toml:"rate_limit"`                   // ALERT_RATE_LIMIT
+}
+
+// AccessConfig is who may reach the pages for signed in users, and where
+// they may be sent back to.
+type AccessConfig struct {
+	ReturnToAllowlist []string `yaml:"return_to_allowlist" toml:"return_to_allowlist"` // RETURN_TO_ALLOWLIST
+	RolesClaim        string   `yaml:"roles_claim" toml:"roles_claim"`                 // ROLES_CLAIM
+	GroupsClaim       string   `yaml:"groups_claim" toml:"groups_claim"`               // GROUPS_CLAIM
+	RequiredGroups    []string `yaml:"required_groups" toml:"required_groups"`         // REQUIRED_GROUPS
+	RequiredRoles     []string `yaml:"required_roles" toml:"required_roles"`           // REQUIRED_ROLES
+}
+
+// LoginConfig is how users pick the connection they sign in with, and how
+// often they may try.
+type LoginConfig struct {
+	HomeRealmDiscovery         bool   `yaml:"home_realm_discovery" toml:"home_realm_discovery"`                   // HOME_REALM_DISCOVERY
+	HomeRealmRules             string `yaml:"home_realm_rules" toml:"home_realm_rules"`                           // HOME_REALM_RULES
+	HomeRealmDefaultConnection string `yaml:"home_realm_default_connection" toml:"home_realm_default_connection"` // HOME_REALM_DEFAULT_CONNECTION
+	Chooser                    bool   `yaml:"chooser" toml:"chooser"`                                             // LOGIN_CHOOSER
+	AutoRedirect               bool   `yaml:"auto_redirect" toml:"auto_redirect"`                                 // LOGIN_AUTO_REDIRECT
+	Throttle                   string `yaml:"throttle" toml:"throttle"`                                           // LOGIN_THROTTLE
+}
+
+// APIConfig is the access tokens accepted by the JSON API and its quotas.
+type APIConfig struct {
+	Audience string `yaml:"audience" toml:"audience"` // API_AUDIENCE
+	Quota    string `yaml:"quota" toml:"quota"`       // API_QUOTA
+}
+
+// DeviceConfig is the native application the CLIs sign in with.
+type DeviceConfig struct {
+	ClientID string   `yaml:"client_id" toml:"client_id"` // DEVICE_CLIENT_ID
+	Scopes   []string `yaml:"scopes" toml:"scopes"`       // DEVICE_SCOPES
+	Audience string   `yaml:"audience" toml:"audience"`   // DEVICE_AUDIENCE
+}
+
+// UsersConfig is how the local user records are kept up to date.
+type UsersConfig struct {
+	SyncInterval       Duration `yaml:"sync_interval" toml:"sync_interval"`               // USER_SYNC_INTERVAL
+	SyncCheckpointFile string   `yaml:"sync_checkpoint_file" toml:"sync_checkpoint_file"` // USER_SYNC_CHECKPOINT_FILE
+	ProfileFields      string   `yaml:"profile_fields" toml:"profile_fields"`             // PROFILE_FIELDS
+}
+
+// BrandConfig white-labels the pages.
+type BrandConfig struct {
+	AppName      string   `yaml:"app_name" toml:"app_name"`           // BRAND_APP_NAME
+	LogoURL      string   `yaml:"logo_url" toml:"logo_url"`           // BRAND_LOGO_URL
+	PrimaryColor string   `yaml:"primary_color" toml:"primary_color"` // BRAND_PRIMARY_COLOR
+	FooterLinks  []string `yaml:"footer_links" toml:"footer_links"`   // BRAND_FOOTER_LINKS
+}
+
+// CORSConfig is the origins whose single page apps may call the server.
+type CORSConfig struct {
+	AllowedOrigins   []string `yaml:"allowed_origins" toml:"allowed_origins"`     // CORS_ALLOWED_ORIGINS
+	AllowCredentials bool     `yaml:"allow_credentials" toml:"allow_credentials"` // CORS_ALLOW_CREDENTIALS
+	AllowedHeaders   []string `yaml:"allowed_headers" toml:"allowed_headers"`     // CORS_ALLOWED_HEADERS
+	MaxAge           Duration `yaml:"max_age" toml:"max_age"`                     // CORS_MAX_AGE
+}
+
+// NotifyConfig is the email provider of the notifications.
+type NotifyConfig struct {
+	Provider           string   `yaml:"provider" toml:"provider"`                           // NOTIFY_PROVIDER
+	From               string   `yaml:"from" toml:"from"`                                   // NOTIFY_FROM
+	TemplateDir        string   `yaml:"template_dir" toml:"template_dir"`                   // NOTIFY_TEMPLATE_DIR
+	AdminEmails        []string `yaml:"admin_emails" toml:"admin_emails"`                   // ADMIN_NOTIFY_EMAILS
+	SMTPAddr           string   `yaml:"smtp_addr" toml:"smtp_addr"`                         // SMTP_ADDR
+	SMTPUsername       string   `yaml:"smtp_username" toml:"smtp_username"`                 // SMTP_USERNAME
+	SMTPPassword       string   `yaml:"smtp_password" toml:"smtp_password"`                 // SMTP_PASSWORD
+	AWSRegion          string   `yaml:"aws_region" toml:"aws_region"`                       // AWS_REGION
+	AWSAccessKeyID     string   `yaml:"aws_access_key_id" toml:"aws_access_key_id"`         // AWS_ACCESS_KEY_ID
+	AWSSecretAccessKey string   `yaml:"aws_secret_access_key" toml:"aws_secret_access_key"` // AWS_SECRET_ACCESS_KEY
+	AWSSessionToken    string   `yaml:"aws_session_token" toml:"aws_session_token"`         // AWS_SESSION_TOKEN
+	SendGridAPIKey     string   `yaml:"sendgrid_api_key" toml:"sendgrid_api_key"`           // SENDGRID_API_KEY
+}
+
+// TermsConfig is the legal documents users must accept.
+type TermsConfig struct {
+	TermsVersion         string `yaml:"terms_version" toml:"terms_version"`                   // TERMS_VERSION
+	TermsFile            string `yaml:"terms_file" toml:"terms_file"`                         // TERMS_FILE
+	PrivacyPolicyVersion string `yaml:"privacy_policy_version" toml:"privacy_policy_version"` // PRIVACY_POLICY_VERSION
+	PrivacyPolicyFile    string `yaml:"privacy_policy_file" toml:"privacy_policy_file"`       // PRIVACY_POLICY_FILE
+}
+
+// TracingConfig is the OTLP collector the spans are exported to, read from
+// the standard OpenTelemetry variables.
+type TracingConfig struct {
+	Endpoint       string   `yaml:"endpoint" toml:"endpoint"`               // OTEL_EXPORTER_OTLP_ENDPOINT
+	TracesEndpoint string   `yaml:"traces_endpoint" toml:"traces_endpoint"` // OTEL_EXPORTER_OTLP_TRACES_ENDPOINT
+	Headers        []string `yaml:"headers" toml:"headers"`                 // OTEL_EXPORTER_OTLP_HEADERS
+	ServiceName    string   `yaml:"service_name" toml:"service_name"`       // OTEL_SERVICE_NAME
+	Sampler        string   `yaml:"sampler" toml:"sampler"`                 // OTEL_TRACES_SAMPLER
+	SamplerArg     string   `yaml:"sampler_arg" toml:"sampler_arg"`         // OTEL_TRACES_SAMPLER_ARG
+}
+
+// SecurityEventsConfig is the shared signals transmitter whose security
+// event tokens are received.
+type SecurityEventsConfig struct {
+	JWKSURL  string `yaml:"jwks_url" toml:"jwks_url"` // SSF_JWKS_URL
+	Issuer   string `yaml:"issuer" toml:"issuer"`     // SSF_ISSUER
+	Audience string `yaml:"audience" toml:"audience"` // SSF_AUDIENCE
+}
+
+// DebugConfig is who may collect profiles from production.
+type DebugConfig struct {
+	Token string   `yaml:"token" toml:"token"` // DEBUG_TOKEN
+	Roles []string `yaml:"roles" toml:"roles"` // DEBUG_ROLES
+}
+
+// Duration is a time.Duration read from a Go duration string such as 10s.
+type Duration time.Duration
+
+// UnmarshalText parses a Go duration string.
+func (d *Duration) UnmarshalText(text []byte) error {
+	value, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(value)
+	return nil
+}
+
+// SameSite is the SameSite attribute of a cookie, set in YAML, TOML or
+// environment variables as lax, strict or none.
+type SameSite http.SameSite
+
+// UnmarshalText parses lax, strict or none.
+func (s *SameSite) UnmarshalText(text []byte) error {
+	value, err := parseSameSite(string(text))
+	if err != nil {
+		return err
+	}
+	*s = SameSite(value)
+	return nil
+}
+
+// defaultConfig returns the configuration used for the unset settings.
+func defaultConfig() *Config {
+	return &Config{
+		Auth0: Auth0Config{
+			WebhookRequireTimestamp: true,
+		},
+		Server: ServerConfig{
+			Port:            "9090",
+			TokenClockSkew:  Duration(defaultClockSkew),
+			RequestTimeout:  Duration(5 * time.Second),
+			CallbackTimeout: Duration(15 * time.Second),
+			ShutdownTimeout: Duration(defaultShutdownTimeout),
+
+			ProviderRefreshInterval: Duration(time.Hour),
+			TenantHealthInterval:    Duration(defaultTenantHealthInterval),
+			IdPMaxStaleness:         Duration(defaultMaxStaleness),
+			WebhookTolerance:        Duration(defaultWebhookTolerance),
+			WebhookReplayRetention:  Duration(defaultWebhookReplayRetention),
+		},
+		Sessions: SessionConfig{
+			ReplicationInterval: Duration(time.Second),
+		},
+		Audit: AuditConfig{
+			OutboxMaxAttempts: defaultOutboxMaxAttempts,
+		},
+		Alerts: AlertConfig{
+			RateLimit: 10,
+		},
+		CORS: CORSConfig{
+			MaxAge: Duration(10 * time.Minute),
+		},
+	}
+}
+
+// LoadConfig reads the configuration from the file at path, when set, and
+// from the environment, whose variables take precedence over the file. The
+// file is YAML or TOML depending on its extension. It fails listing every
+// missing or invalid setting, before anything is started.
+func LoadConfig(path string) (*Config, error) {
+	config := defaultConfig()
+
+	if path != "" {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not read config file: %v", err)
+		}
+		switch ext := strings.ToLower(filepath.Ext(path)); ext {
+		case ".yaml", ".yml":
+			err = yaml.Unmarshal(raw, config)
+		case ".toml":
+			err = toml.Unmarshal(raw, config)
+		default:
+			return nil, fmt.Errorf("unknown config file format %q", ext)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not parse config file %s: %v", path, err)
+		}
+	}
+
+	if err := config.loadEnv(); err != nil {
+		return nil, err
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// loadEnv overrides the settings whose environment variable is set.
+func (c *Config) loadEnv() error {
+	a := &c.Auth0
+	envString(&a.Domain, "AUTH0_DOMAIN")
+	envString(&a.Issuer, "AUTH0_ISSUER")
+	envString(&a.ClientID, "AUTH0_CLIENT_ID")
+	envString(&a.ClientSecret, "AUTH0_CLIENT_SECRET")
+	envString(&a.ClientSecretSecondary, "AUTH0_CLIENT_SECRET_SECONDARY")
+	envString(&a.Connection, "AUTH0_CONNECTION")
+	envString(&a.Organization, "AUTH0_ORGANIZATION")
+	envList(&a.CallbackURLs, "AUTH0_CALLBACK_URL")
+	envString(&a.ManagementDomain, "AUTH0_MANAGEMENT_DOMAIN")
+	envString(&a.ClientCertFile, "AUTH0_CLIENT_CERT_FILE")
+	envString(&a.ClientKeyFile, "AUTH0_CLIENT_KEY_FILE")
+	envString(&a.ClientAssertionKeyFile, "AUTH0_CLIENT_ASSERTION_KEY_FILE")
+	envString(&a.ClientAssertionKeyID, "AUTH0_CLIENT_ASSERTION_KEY_ID")
+	envString(&a.RequestObjectKeyFile, "AUTH0_REQUEST_OBJECT_KEY_FILE")
+	envString(&a.IDTokenDecryptionKeyFile, "AUTH0_ID_TOKEN_DECRYPTION_KEY_FILE")
+	envString(&a.IDTokenEncryptionAlg, "AUTH0_ID_TOKEN_ENCRYPTION_ALG")
+	envList(&a.Resources, "AUTH0_RESOURCES")
+	envString(&a.WebhookSecret, "AUTH0_WEBHOOK_SECRET")
+	envString(&c.Google.ClientID, "GOOGLE_CLIENT_ID")
+	envString(&c.Google.ClientSecret, "GOOGLE_CLIENT_SECRET")
+	envList(&c.Google.HostedDomains, "GOOGLE_HOSTED_DOMAINS")
+	envList(&c.Google.Scopes, "GOOGLE_SCOPES")
+
+	// the standby tenants of the environment replace the ones of the file
+	var failover []TenantConfig
+	for n := 1; ; n++ {
+		prefix := fmt.Sprintf("AUTH0_FAILOVER_%d_", n)
+		domain := os.Getenv(prefix + "DOMAIN")
+		if domain == "" {
+			break
+		}
+		failover = append(failover, TenantConfig{
+			Domain:       domain,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			Organization: os.Getenv(prefix + "ORGANIZATION"),
+		})
+	}
+	if len(failover) > 0 {
+		a.Failover = failover
+	}
+
+	// the providers of the environment replace the ones of the file
+	if names := splitList(os.Getenv("PROVIDERS")); len(names) > 0 {
+		c.Providers = nil
+		for _, name := range names {
+			prefix := "PROVIDER_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+			c.Providers = append(c.Providers, ProviderConfig{
+				Name:          name,
+				Type:          os.Getenv(prefix + "TYPE"),
+				Issuer:        os.Getenv(prefix + "ISSUER"),
+				Tenant:        os.Getenv(prefix + "TENANT"),
+				ClientID:      os.Getenv(prefix + "CLIENT_ID"),
+				ClientSecret:  os.Getenv(prefix + "CLIENT_SECRET"),
+				Scopes:        splitList(os.Getenv(prefix + "SCOPES")),
+				Label:         os.Getenv(prefix + "LABEL"),
+				HostedDomains: splitList(os.Getenv(prefix + "HOSTED_DOMAINS")),
+			})
+		}
+	}
+
+	// the sites of the environment replace the ones of the file
+	if names := splitList(os.Getenv("SITES")); len(names) > 0 {
+		c.Sites = nil
+		for _, name := range names {
+			prefix := "SITE_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+			c.Sites = append(c.Sites, SiteConfig{
+				Name:         name,
+				Hosts:        splitList(os.Getenv(prefix + "HOSTS")),
+				Domain:       os.Getenv(prefix + "DOMAIN"),
+				Issuer:       os.Getenv(prefix + "ISSUER"),
+				ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+				ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+				CallbackURL:  os.Getenv(prefix + "CALLBACK_URL"),
+				Organization: os.Getenv(prefix + "ORGANIZATION"),
+			})
+		}
+	}
+
+	s := &c.Server
+	envString(&s.Host, "HOST")
+	envString(&s.Port, "PORT")
+	envString(&s.ListenAddr, "LISTEN_ADDR")
+	if s.ListenAddr == "" {
+		s.ListenAddr = net.JoinHostPort(s.Host, s.Port)
+	}
+	envString(&s.AdminListenAddr, "ADMIN_LISTEN_ADDR")
+	envString(&s.AdminToken, "ADMIN_TOKEN")
+	envString(&s.DatabaseURL, "DATABASE_URL")
+	envString(&s.RedisURL, "REDIS_URL")
+	envString(&s.Region, "REGION")
+	envString(&s.TLSCertFile, "TLS_CERT_FILE")
+	envString(&s.TLSKeyFile, "TLS_KEY_FILE")
+	envString(&s.TLSRedirectAddr, "TLS_REDIRECT_ADDR")
+	envList(&s.TrustedProxies, "TRUSTED_PROXIES")
+	envString(&c.Sessions.Backend, "SESSION_BACKEND")
+	envString(&c.Sessions.Codec, "SESSION_CODEC")
+	envList(&c.Sessions.AuthKeys, "SESSION_AUTH_KEYS")
+	envList(&c.Sessions.EncryptionKeys, "SESSION_ENCRYPTION_KEYS")
+	envString(&c.Errors.SentryDSN, "SENTRY_DSN")
+	envString(&c.Errors.Environment, "SENTRY_ENVIRONMENT")
+	envString(&c.Sessions.ReplicationRedisURL, "SESSION_REPLICATION_REDIS_URL")
+	envString(&s.SignedURLSecret, "SIGNED_URL_SECRET")
+	envString(&s.FilesDir, "FILES_DIR")
+	envString(&s.TemplateDir, "TEMPLATE_DIR")
+
+	envString(&c.Log.Format, "LOG_FORMAT")
+	envString(&c.Log.Level, "LOG_LEVEL")
+	envString(&c.Log.RolesClaim, "LOG_ROLES_CLAIM")
+
+	envString(&c.Audit.SyslogURL, "AUDIT_SYSLOG_URL")
+	envString(&c.Audit.SyslogFormat, "AUDIT_SYSLOG_FORMAT")
+	envString(&c.Audit.SyslogAppName, "AUDIT_SYSLOG_APP_NAME")
+	envString(&c.Audit.StdoutFormat, "AUDIT_STDOUT_FORMAT")
+	envString(&c.Alerts.SlackWebhookURL, "ALERT_SLACK_WEBHOOK_URL")
+	envString(&c.Alerts.DiscordWebhookURL, "ALERT_DISCORD_WEBHOOK_URL")
+	envList(&c.Alerts.EventTypes, "ALERT_EVENT_TYPES")
+
+	envList(&c.Access.ReturnToAllowlist, "RETURN_TO_ALLOWLIST")
+	envString(&c.Access.RolesClaim, "ROLES_CLAIM")
+	envString(&c.Access.GroupsClaim, "GROUPS_CLAIM")
+	envList(&c.Access.RequiredGroups, "REQUIRED_GROUPS")
+	envList(&c.Access.RequiredRoles, "REQUIRED_ROLES")
+
+	envString(&c.Login.HomeRealmRules, "HOME_REALM_RULES")
+	envString(&c.Login.HomeRealmDefaultConnection, "HOME_REALM_DEFAULT_CONNECTION")
+	envString(&c.Login.Throttle, "LOGIN_THROTTLE")
+
+	envString(&c.API.Audience, "API_AUDIENCE")
+	envString(&c.API.Quota, "API_QUOTA")
+	envString(&c.Device.ClientID, "DEVICE_CLIENT_ID")
+	envList(&c.Device.Scopes, "DEVICE_SCOPES")
+	envString(&c.Device.Audience, "DEVICE_AUDIENCE")
+	envString(&c.Users.SyncCheckpointFile, "USER_SYNC_CHECKPOINT_FILE")
+	envString(&c.Users.ProfileFields, "PROFILE_FIELDS")
+
+	envString(&c.Brand.AppName, "BRAND_APP_NAME")
+	envString(&c.Brand.LogoURL, "BRAND_LOGO_URL")
+	envString(&c.Brand.PrimaryColor, "BRAND_PRIMARY_COLOR")
+	envList(&c.Brand.FooterLinks, "BRAND_FOOTER_LINKS")
+	envList(&c.CORS.AllowedOrigins, "CORS_ALLOWED_ORIGINS")
+	envList(&c.CORS.AllowedHeaders, "CORS_ALLOWED_HEADERS")
+
+	n := &c.Notify
+	envString(&n.Provider, "NOTIFY_PROVIDER")
+	envString(&n.From, "NOTIFY_FROM")
+	envString(&n.TemplateDir, "NOTIFY_TEMPLATE_DIR")
+	envList(&n.AdminEmails, "ADMIN_NOTIFY_EMAILS")
+	envString(&n.SMTPAddr, "SMTP_ADDR")
+	envString(&n.SMTPUsername, "SMTP_USERNAME")
+	envString(&n.SMTPPassword, "SMTP_PASSWORD")
+	envString(&n.AWSRegion, "AWS_REGION")
+	envString(&n.AWSAccessKeyID, "AWS_ACCESS_KEY_ID")
+	envString(&n.AWSSecretAccessKey, "AWS_SECRET_ACCESS_KEY")
+	envString(&n.AWSSessionToken, "AWS_SESSION_TOKEN")
+	envString(&n.SendGridAPIKey, "SENDGRID_API_KEY")
+
+	envString(&c.Terms.TermsVersion, "TERMS_VERSION")
+	envString(&c.Terms.TermsFile, "TERMS_FILE")
+	envString(&c.Terms.PrivacyPolicyVersion, "PRIVACY_POLICY_VERSION")
+	envString(&c.Terms.PrivacyPolicyFile, "PRIVACY_POLICY_FILE")
+
+	envString(&c.Tracing.Endpoint, "OTEL_EXPORTER_OTLP_ENDPOINT")
+	envString(&c.Tracing.TracesEndpoint, "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	envList(&c.Tracing.Headers, "OTEL_EXPORTER_OTLP_HEADERS")
+	envString(&c.Tracing.ServiceName, "OTEL_SERVICE_NAME")
+	envString(&c.Tracing.Sampler, "OTEL_TRACES_SAMPLER")
+	envString(&c.Tracing.SamplerArg, "OTEL_TRACES_SAMPLER_ARG")
+
+	envString(&c.SecurityEvents.JWKSURL, "SSF_JWKS_URL")
+	envString(&c.SecurityEvents.Issuer, "SSF_ISSUER")
+	envString(&c.SecurityEvents.Audience, "SSF_AUDIENCE")
+	envString(&c.Debug.Token, "DEBUG_TOKEN")
+	envList(&c.Debug.Roles, "DEBUG_ROLES")
+
+	if value := os.Getenv("SESSION_COOKIE_SAMESITE"); value != "" {
+		if err := c.Sessions.CookieSameSite.UnmarshalText([]byte(value)); err != nil {
+			return fmt.Errorf("invalid SESSION_COOKIE_SAMESITE: %v", err)
+		}
+	}
+
+	for name, dst := range map[string]*bool{
+		"AUTH0_WEBHOOK_REQUIRE_TIMESTAMP": &a.WebhookRequireTimestamp,
+		"AUTH0_JARM":                      &a.JARM,
+		"AUTH0_USERINFO_SIGNED":           &a.UserInfoSigned,
+		"AUTH0_DPOP":                      &a.DPoP,
+		"AUTH0_FEDERATED_LOGOUT":          &a.FederatedLogout,
+		"REUSE_PORT":                      &s.ReusePort,
+		"TLS_HTTP3":                       &s.TLSHTTP3,
+		"SESSION_REPLICATION":             &c.Sessions.Replication,
+		"GOOGLE_PEOPLE_API":               &c.Google.PeopleAPI,
+		"LOG_HASH_SUB":                    &c.Log.HashSub,
+		"AUDIT_STDOUT":                    &c.Audit.Stdout,
+		"HOME_REALM_DISCOVERY":            &c.Login.HomeRealmDiscovery,
+		"LOGIN_CHOOSER":                   &c.Login.Chooser,
+		"LOGIN_AUTO_REDIRECT":             &c.Login.AutoRedirect,
+		"CORS_ALLOW_CREDENTIALS":          &c.CORS.AllowCredentials,
+	} {
+		if err := envBool(dst, name); err != nil {
+			return err
+		}
+	}
+
+	for name, dst := range map[string]*Duration{
+		"TOKEN_CLOCK_SKEW": &s.TokenClockSkew,
+		"REQUEST_TIMEOUT":  &s.RequestTimeout,
+		"CALLBACK_TIMEOUT": &s.CallbackTimeout,
+		"SHUTDOWN_TIMEOUT": &s.ShutdownTimeout,
+
+		"PROVIDER_REFRESH_INTERVAL":    &s.ProviderRefreshInterval,
+		"TENANT_HEALTH_INTERVAL":       &s.TenantHealthInterval,
+		"IDP_MAX_STALENESS":            &s.IdPMaxStaleness,
+		"WEBHOOK_TOLERANCE":            &s.WebhookTolerance,
+		"WEBHOOK_REPLAY_RETENTION":     &s.WebhookReplayRetention,
+		"SESSION_REVALIDATE_INTERVAL":  &c.Sessions.RevalidateInterval,
+		"SESSION_REPLICATION_INTERVAL": &c.Sessions.ReplicationInterval,
+		"USER_SYNC_INTERVAL":           &c.Users.SyncInterval,
+		"CORS_MAX_AGE":                 &c.CORS.MaxAge,
+	} {
+		if err := envDuration(dst, name); err != nil {
+			return err
+		}
+	}
+
+	for name, dst := range map[string]*int{
+		"AUDIT_OUTBOX_MAX_ATTEMPTS": &c.Audit.OutboxMaxAttempts,
+		"ALERT_RATE_LIMIT":          &c.Alerts.RateLimit,
+	} {
+		if err := envInt(dst, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validate reports every required setting that is missing or invalid.
+func (c *Config) validate() error {
+	var problems []string
+	required := func(value, name string) bool {
+		if value == "" {
+			problems = append(problems, name+" is required")
+		}
+		return value != ""
+	}
+
+	// without AUTH0_DOMAIN, users sign in with Google directly and the auth0
+	// features are not available
+	if c.googleMode() {
+		required(c.Google.ClientSecret, "GOOGLE_CLIENT_SECRET")
+		for _, setting := range []struct {
+			name string
+			set  bool
+		}{
+			{"AUTH0_FAILOVER_1_DOMAIN", len(c.Auth0.Failover) > 0},
+			{"AUTH0_MANAGEMENT_DOMAIN", c.Auth0.ManagementDomain != ""},
+			{"AUTH0_CLIENT_CERT_FILE", c.Auth0.ClientCertFile != ""},
+			{"AUTH0_CLIENT_ASSERTION_KEY_FILE", c.Auth0.ClientAssertionKeyFile != ""},
+			{"AUTH0_REQUEST_OBJECT_KEY_FILE", c.Auth0.RequestObjectKeyFile != ""},
+			{"AUTH0_ID_TOKEN_DECRYPTION_KEY_FILE", c.Auth0.IDTokenDecryptionKeyFile != ""},
+			{"AUTH0_RESOURCES", len(c.Auth0.Resources) > 0},
+			{"AUTH0_WEBHOOK_SECRET", c.Auth0.WebhookSecret != ""},
+			{"AUTH0_JARM", c.Auth0.JARM},
+			{"AUTH0_USERINFO_SIGNED", c.Auth0.UserInfoSigned},
+			{"AUTH0_DPOP", c.Auth0.DPoP},
+			{"AUTH0_CONNECTION", c.Auth0.Connection != ""},
+			{"AUTH0_ORGANIZATION", c.Auth0.Organization != ""},
+			{"SITES", len(c.Sites) > 0},
+			// the features reading the Management API with machine to
+			// machine tokens
+			{"LOGIN_CHOOSER", c.Login.Chooser},
+			{"PROFILE_FIELDS", c.Users.ProfileFields != ""},
+			{"USER_SYNC_INTERVAL", c.Users.SyncInterval > 0},
+		} {
+			if setting.set {
+				problems = append(problems, setting.name+" needs AUTH0_DOMAIN")
+			}
+		}
+	} else {
+		if len(c.Google.HostedDomains) > 0 {
+			problems = append(problems, "GOOGLE_HOSTED_DOMAINS needs GOOGLE_CLIENT_ID without AUTH0_DOMAIN")
+		}
+		if required(c.Auth0.Domain, "AUTH0_DOMAIN") {
+			if err := validateDomain(c.Auth0.Domain); err != nil {
+				problems = append(problems, fmt.Sprintf("AUTH0_DOMAIN: %v", err))
+			}
+		}
+		required(c.Auth0.ClientID, "AUTH0_CLIENT_ID")
+		// private_key_jwt replaces the client secret
+		if c.Auth0.ClientAssertionKeyFile == "" {
+			required(c.Auth0.ClientSecret, "AUTH0_CLIENT_SECRET")
+		}
+		for n, t := range c.Auth0.Failover {
+			prefix := fmt.Sprintf("AUTH0_FAILOVER_%d_", n+1)
+			if required(t.Domain, prefix+"DOMAIN") {
+				if err := validateDomain(t.Domain); err != nil {
+					problems = append(problems, fmt.Sprintf("%sDOMAIN: %v", prefix, err))
+				}
+			}
+			required(t.ClientID, prefix+"CLIENT_ID")
+			required(t.ClientSecret, prefix+"CLIENT_SECRET")
+			if t.Organization != "" && !organizationPattern.MatchString(t.Organization) {
+				problems = append(problems, fmt.Sprintf("%sORGANIZATION: %q is not an organization ID or name", prefix, t.Organization))
+			}
+		}
+		if c.Auth0.Organization != "" && !organizationPattern.MatchString(c.Auth0.Organization) {
+			problems = append(problems, fmt.Sprintf("AUTH0_ORGANIZATION: %q is not an organization ID or name", c.Auth0.Organization))
+		}
+	}
+
+	if len(c.Auth0.CallbackURLs) == 0 {
+		problems = append(problems, "AUTH0_CALLBACK_URL is required")
+	}
+	for _, raw := range c.Auth0.CallbackURLs {
+		if u, err := url.Parse(raw); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("AUTH0_CALLBACK_URL: %q is not an absolute http(s) URL", raw))
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, p := range c.Providers {
+		prefix := "PROVIDER_" + strings.ToUpper(strings.ReplaceAll(p.Name, "-", "_")) + "_"
+		// the name of the primary provider is taken
+		if !providerNamePattern.MatchString(p.Name) || p.Name == providerAuth0 || (p.Name == providerGoogle && c.googleMode()) || seen[p.Name] {
+			problems = append(problems, fmt.Sprintf("PROVIDERS: invalid or duplicate provider name %q", p.Name))
+			continue
+		}
+		seen[p.Name] = true
+		switch p.kind() {
+		case providerGoogle, providerGitHub:
+		case providerOIDC:
+			if required(p.Issuer, prefix+"ISSUER") {
+				if u, err := url.Parse(p.Issuer); err != nil || u.Scheme != "https" || u.Host == "" {
+					problems = append(problems, fmt.Sprintf("%sISSUER: %q is not an https URL", prefix, p.Issuer))
+				}
+			}
+		case providerEntra:
+			// the issuer of the multi-tenant aliases depends on the user
+			if required(p.Tenant, prefix+"TENANT") && containsString(entraMultiTenants, strings.ToLower(p.Tenant)) {
+				problems = append(problems, fmt.Sprintf("%sTENANT: %q is not a single tenant, expected its ID or domain", prefix, p.Tenant))
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("%sTYPE: %q is not google, github, entra or oidc", prefix, p.kind()))
+		}
+		if len(p.HostedDomains) > 0 && p.kind() != providerGoogle {
+			problems = append(problems, prefix+"HOSTED_DOMAINS only applies to google providers")
+		}
+		required(p.ClientID, prefix+"CLIENT_ID")
+		required(p.ClientSecret, prefix+"CLIENT_SECRET")
+	}
+
+	// every host is served by a single site
+	sites, hosts := map[string]bool{}, map[string]string{}
+	for _, site := range c.Sites {
+		prefix := "SITE_" + strings.ToUpper(strings.ReplaceAll(site.Name, "-", "_")) + "_"
+		if !providerNamePattern.MatchString(site.Name) || sites[site.Name] {
+			problems = append(problems, fmt.Sprintf("SITES: invalid or duplicate site name %q", site.Name))
+			continue
+		}
+		sites[site.Name] = true
+		if len(site.Hosts) == 0 {
+			problems = append(problems, prefix+"HOSTS is required")
+		}
+		for _, host := range site.Hosts {
+			host = strings.ToLower(host)
+			if other, ok := hosts[host]; ok {
+				problems = append(problems, fmt.Sprintf("%sHOSTS: %q is already served by site %s", prefix, host, other))
+			}
+			hosts[host] = site.Name
+		}
+		if required(site.Domain, prefix+"DOMAIN") {
+			if err := validateDomain(site.Domain); err != nil {
+				problems = append(problems, fmt.Sprintf("%sDOMAIN: %v", prefix, err))
+			}
+		}
+		required(site.ClientID, prefix+"CLIENT_ID")
+		required(site.ClientSecret, prefix+"CLIENT_SECRET")
+		if required(site.CallbackURL, prefix+"CALLBACK_URL") {
+			if u, err := url.Parse(site.CallbackURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				problems = append(problems, fmt.Sprintf("%sCALLBACK_URL: %q is not an absolute http(s) URL", prefix, site.CallbackURL))
+			} else if !containsString(hostNames(site.Hosts), strings.ToLower(u.Hostname())) {
+				problems = append(problems, fmt.Sprintf("%sCALLBACK_URL: %q is not on one of the hosts of the site", prefix, site.CallbackURL))
+			}
+		}
+		if site.Organization != "" && !organizationPattern.MatchString(site.Organization) {
+			problems = append(problems, fmt.Sprintf("%sORGANIZATION: %q is not an organization ID or name", prefix, site.Organization))
+		}
+	}
+
+	if _, err := sessionKeyPairs(c.Sessions); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if c.Errors.SentryDSN != "" {
+		if _, _, err := parseSentryDSN(c.Errors.SentryDSN); err != nil {
+			problems = append(problems, fmt.Sprintf("SENTRY_DSN: %v", err))
+		}
+	}
+
+	if err := validateListenAddr(c.Server.ListenAddr); err != nil {
+		problems = append(problems, fmt.Sprintf("LISTEN_ADDR: %v", err))
+	}
+	if c.Server.AdminListenAddr != "" {
+		if err := validateListenAddr(c.Server.AdminListenAddr); err != nil {
+			problems = append(problems, fmt.Sprintf("ADMIN_LISTEN_ADDR: %v", err))
+		} else if c.Server.AdminToken == "" {
+			problems = append(problems, "ADMIN_TOKEN is required with ADMIN_LISTEN_ADDR")
+		} else if sameListenAddr(c.Server.AdminListenAddr, c.Server.ListenAddr) {
+			problems = append(problems, "ADMIN_LISTEN_ADDR must differ from LISTEN_ADDR")
+		}
+	}
+
+	if (c.Server.TLSCertFile == "") != (c.Server.TLSKeyFile == "") {
+		problems = append(problems, "TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if c.Server.TLSRedirectAddr != "" {
+		if c.Server.TLSCertFile == "" {
+			problems = append(problems, "TLS_REDIRECT_ADDR needs TLS_CERT_FILE and TLS_KEY_FILE")
+		} else if err := validateListenAddr(c.Server.TLSRedirectAddr); err != nil {
+			problems = append(problems, fmt.Sprintf("TLS_REDIRECT_ADDR: %v", err))
+		} else if sameListenAddr(c.Server.TLSRedirectAddr, c.Server.ListenAddr) {
+			problems = append(problems, "TLS_REDIRECT_ADDR must differ from LISTEN_ADDR")
+		} else if c.Server.AdminListenAddr != "" && sameListenAddr(c.Server.TLSRedirectAddr, c.Server.AdminListenAddr) {
+			problems = append(problems, "TLS_REDIRECT_ADDR must differ from ADMIN_LISTEN_ADDR")
+		}
+	}
+	if c.Server.TLSHTTP3 && c.Server.TLSCertFile == "" {
+		problems = append(problems, "TLS_HTTP3 needs TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+	if _, err := parseTrustedProxies(c.Server.TrustedProxies); err != nil {
+		problems = append(problems, fmt.Sprintf("TRUSTED_PROXIES: %v", err))
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		problems = append(problems, "SHUTDOWN_TIMEOUT must be positive")
+	}
+	// the intervals of the scheduled jobs, the optional ones being turned
+	// off with 0
+	if c.Server.TenantHealthInterval <= 0 {
+		problems = append(problems, "TENANT_HEALTH_INTERVAL must be positive")
+	}
+	if c.Sessions.ReplicationInterval <= 0 {
+		problems = append(problems, "SESSION_REPLICATION_INTERVAL must be positive")
+	}
+	if c.Server.ProviderRefreshInterval < 0 {
+		problems = append(problems, "PROVIDER_REFRESH_INTERVAL must not be negative")
+	}
+	if c.Users.SyncInterval < 0 {
+		problems = append(problems, "USER_SYNC_INTERVAL must not be negative")
+	}
+	if c.Server.WebhookReplayRetention < c.Server.WebhookTolerance {
+		problems = append(problems, "WEBHOOK_REPLAY_RETENTION must be at least WEBHOOK_TOLERANCE")
+	}
+	if c.Audit.OutboxMaxAttempts <= 0 {
+		problems = append(problems, "AUDIT_OUTBOX_MAX_ATTEMPTS must be positive")
+	}
+	if c.Alerts.RateLimit <= 0 {
+		problems = append(problems, "ALERT_RATE_LIMIT must be positive")
+	}
+	if (c.SecurityEvents.Issuer == "" || c.SecurityEvents.Audience == "") && c.SecurityEvents.JWKSURL != "" {
+		problems = append(problems, "SSF_ISSUER and SSF_AUDIENCE are required with SSF_JWKS_URL")
+	}
+	if c.Device.ClientID != "" && c.googleMode() {
+		problems = append(problems, "DEVICE_CLIENT_ID needs AUTH0_DOMAIN")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n\t%s", strings.Join(problems, "\n\t"))
+	}
+	return nil
+}
+
+// providerNamePattern matches the names of the providers and sites, used in
+// the login URLs, the PROVIDER_<NAME>_* and SITE_<NAME>_* variables.
+var providerNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// validateDomain checks that domain is a host name, such as
+// your-tenant.auth0.com, rather than a URL.
+func validateDomain(domain string) error {
+	if strings.Contains(domain, "://") {
+		return fmt.Errorf("%q is a URL, expected a host name such as your-tenant.auth0.com", domain)
+	}
+	if strings.Contains(strings.TrimSuffix(domain, "/"), "/") {
+		return fmt.Errorf("%q has a path, expected a host name such as your-tenant.auth0.com", domain)
+	}
+	return nil
+}
+
+// validateListenAddr checks that addr is a host, possibly empty to listen on
+// every interface, and a port.
+func validateListenAddr(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
+
+// envString sets dst to the environment variable name when set.
+func envString(dst *string, name string) {
+	if value := os.Getenv(name); value != "" {
+		*dst = value
+	}
+}
+
+// envList sets dst to the comma separated list of the environment variable
+// name when set.
+func envList(dst *[]string, name string) {
+	if value := os.Getenv(name); value != "" {
+		*dst = splitList(value)
+	}
+}
+
+// envBool sets dst to the boolean of the environment variable name when set.
+func envBool(dst *bool, name string) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q", name, value)
+	}
+	*dst = b
+	return nil
+}
+
+// envInt sets dst to the integer of the environment variable name when set.
+func envInt(dst *int, name string) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q", name, value)
+	}
+	*dst = n
+	return nil
+}
+
+// envDuration sets dst to the duration of the environment variable name
+// when set.
+func envDuration(dst *Duration, name string) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %v", name, err)
+	}
+	*dst = Duration(d)
+	return nil
+}
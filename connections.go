@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// connectionsTTL is how long the list of connections is cached.
+	connectionsTTL = 10 * time.Minute
+	// connectionsPageSize is the number of connections fetched per page.
+	connectionsPageSize = 100
+)
+
+// Connection is an auth0 connection users can sign in with.
+type Connection struct {
+	Name           string   `json:"name"`
+	DisplayName    string   `json:"display_name"`
+	Strategy       string   `json:"strategy"`
+	EnabledClients []string `json:"enabled_clients"`
+}
+
+// Label returns the name displayed for the connection.
+func (c Connection) Label() string {
+	if c.DisplayName != "" {
+		return c.DisplayName
+	}
+	return c.Name
+}
+
+// ConnectionList caches the connections enabled for the application, read
+// from the Management API, which needs the read:connections scope.
+type ConnectionList struct {
+	management *ManagementClient
+	clientID   string
+
+	mu          sync.Mutex
+	connections []Connection
+	expires     time.Time
+}
+
+// NewConnectionList creates a list of the connections enabled for clientID.
+func NewConnectionList(management *ManagementClient, clientID string) *ConnectionList {
+	return &ConnectionList{management: management, clientID: clientID}
+}
+
+// List returns the connections enabled for the application. The last list
+// fetched is returned when the Management API cannot be reached.
+func (l *ConnectionList) List(ctx context.Context) ([]Connection, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if time.Now().Before(l.expires) {
+		return l.connections, nil
+	}
+
+	connections, err := l.fetch(ctx)
+	if err != nil {
+		if l.connections != nil {
+			slog.WarnContext(ctx, "could not refresh connections, using the cached ones", "err", err)
+			return l.connections, nil
+		}
+		return nil, err
+	}
+
+	l.connections, l.expires = connections, time.Now().Add(connectionsTTL)
+	return connections, nil
+}
+
+// fetch reads the connections enabled for the application.
+func (l *ConnectionList) fetch(ctx context.Context) ([]Connection, error) {
+	query := url.Values{
+		"fields":   {"name,display_name,strategy,enabled_clients"},
+		"per_page": {strconv.Itoa(connectionsPageSize)},
+	}
+
+	var enabled []Connection
+	for page := 0; ; page++ {
+		query.Set("page", strconv.Itoa(page))
+
+		var connections []Connection
+		if err := l.management.get(ctx, "/connections", query, &connections); err != nil {
+			return nil, err
+		}
+
+		for _, connection := range connections {
+			for _, clientID := range connection.EnabledClients {
+				if clientID == l.clientID {
+					enabled = append(enabled, connection)
+					break
+				}
+			}
+		}
+
+		if len(connections) < connectionsPageSize {
+			return enabled, nil
+		}
+	}
+}
+
+// Enabled reports whether name is a connection enabled for the application.
+func (l *ConnectionList) Enabled(ctx context.Context, name string) bool {
+	connections, err := l.List(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "could not list connections", "err", err)
+		return false
+	}
+
+	for _, connection := range connections {
+		if connection.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// corsAllowedMethods are the methods cross-origin requests may use.
+const corsAllowedMethods = "GET, HEAD, POST, PUT, PATCH, DELETE"
+
+// corsExposedHeaders are the response headers readable by cross-origin
+// callers.
+const corsExposedHeaders = "X-CSRF-Token, X-Request-ID, X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset, Retry-After"
+
+// defaultCORSAllowedHeaders are the request headers cross-origin requests
+// may send when CORS_ALLOWED_HEADERS is not set.
+var defaultCORSAllowedHeaders = []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"}
+
+// CORSPolicy is the cross-origin resource sharing policy, letting single
+// page apps hosted on other origins call the server. The zero policy allows
+// no origin.
+type CORSPolicy struct {
+	AllowedOrigins   map[string]bool // scheme://host of the allowed origins
+	AllowAnyOrigin   bool
+	AllowCredentials bool // whether the cookies are sent
+	AllowedHeaders   []string
+	MaxAge           time.Duration // how long browsers cache a preflight
+}
+
+// loadCORSPolicy reads the policy of config: CORS_ALLOWED_ORIGINS, a comma
+// separated list of origins or *, CORS_ALLOW_CREDENTIALS,
+// CORS_ALLOWED_HEADERS and CORS_MAX_AGE.
+func loadCORSPolicy(config CORSConfig) (CORSPolicy, error) {
+	policy := CORSPolicy{AllowedOrigins: map[string]bool{}, AllowedHeaders: defaultCORSAllowedHeaders}
+
+	for _, value := range config.AllowedOrigins {
+		if value == "*" {
+			policy.AllowAnyOrigin = true
+			continue
+		}
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || strings.Trim(u.Path, "/") != "" {
+			return CORSPolicy{}, fmt.Errorf("CORS_ALLOWED_ORIGINS must be origins such as https://app.example.com: %q", value)
+		}
+		policy.AllowedOrigins[u.Scheme+"://"+strings.ToLower(u.Host)] = true
+	}
+
+	policy.AllowCredentials = config.AllowCredentials
+	// browsers refuse credentials for any origin, and they would let any
+	// site use the sessions of the users
+	if policy.AllowCredentials && policy.AllowAnyOrigin {
+		return CORSPolicy{}, fmt.Errorf("CORS_ALLOW_CREDENTIALS cannot be used with the * origin")
+	}
+
+	if headers := config.AllowedHeaders; len(headers) > 0 {
+		policy.AllowedHeaders = headers
+	}
+	policy.MaxAge = time.Duration(config.MaxAge)
+
+	return policy, nil
+}
+
+// enabled reports whether the policy allows any origin at all.
+func (p CORSPolicy) enabled() bool {
+	return p.AllowAnyOrigin || len(p.AllowedOrigins) > 0
+}
+
+// allows reports whether requests from origin are allowed.
+func (p CORSPolicy) allows(origin string) bool {
+	if p.AllowAnyOrigin {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && p.AllowedOrigins[u.Scheme+"://"+strings.ToLower(u.Host)]
+}
+
+// CORS adds the CORS headers of policy to the responses to the allowed
+// origins, and answers their preflight requests. The requests of other
+// origins get no CORS headers, so browsers keep the responses from them.
+func CORS(policy CORSPolicy) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !policy.enabled() {
+			ctx.Next()
+			return
+		}
+
+		header := ctx.Writer.Header()
+		header.Add("Vary", "Origin")
+		origin := ctx.GetHeader("Origin")
+		if origin == "" || !policy.allows(origin) {
+			ctx.Next()
+			return
+		}
+
+		if policy.AllowAnyOrigin {
+			header.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			header.Set("Access-Control-Allow-Origin", origin)
+		}
+		if policy.AllowCredentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if ctx.Request.Method == http.MethodOptions && ctx.GetHeader("Access-Control-Request-Method") != "" {
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+			header.Set("Access-Control-Allow-Methods", corsAllowedMethods)
+			header.Set("Access-Control-Allow-Headers", strings.Join(policy.AllowedHeaders, ", "))
+			header.Set("Access-Control-Max-Age", strconv.Itoa(int(policy.MaxAge.Seconds())))
+			ctx.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		header.Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		ctx.Next()
+	}
+}
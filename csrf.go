@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// csrfHeader carries the CSRF token of the requests made by scripts, and the
+// token of the session in the JSON responses.
+const csrfHeader = "X-CSRF-Token"
+
+// csrfExemptPaths are the path prefixes of the requests sent by other
+// servers, authenticated with their signature rather than the session, and
+// by the device clients, which have no session.
+var csrfExemptPaths = []string{"/webhooks/", "/ssf/", "/oidc/", "/device/"}
+
+// CSRF keeps a random token in the session, stored in the context under
+// "csrf_token" for the forms, and rejects the state changing requests made
+// with the session cookie which do not send it back in the csrf_token form
+// field or the X-CSRF-Token header. Requests carrying an Authorization
+// header are not made by the browser on behalf of another site, which
+// cannot add one without a CORS preflight.
+func (s *Server) CSRF() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		session := defaultSession(ctx)
+		if session == nil {
+			ctx.Next()
+			return
+		}
+
+		token, _ := session.Get("csrf_token").(string)
+		if token == "" {
+			var err error
+			token, err = generateRandomString()
+			if err != nil {
+				ctx.String(http.StatusInternalServerError, err.Error())
+				ctx.Abort()
+				return
+			}
+			session.Set("csrf_token", token)
+			if err := session.Save(); err != nil {
+				slog.ErrorContext(ctx, "could not save session", "err", err)
+			}
+		}
+		ctx.Set("csrf_token", token)
+		// single page apps read the token from their requests
+		if wantsJSON(ctx) {
+			ctx.Header(csrfHeader, token)
+		}
+
+		switch ctx.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			ctx.Next()
+			return
+		}
+		if ctx.GetHeader("Authorization") != "" || isCSRFExempt(ctx.Request.URL.Path) {
+			ctx.Next()
+			return
+		}
+
+		sent := ctx.GetHeader(csrfHeader)
+		if sent == "" {
+			sent = ctx.PostForm("csrf_token")
+		}
+		if subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+			sub := ""
+			if u, ok := currentUser(ctx); ok {
+				sub = u.Sub
+			}
+			s.audit(ctx, auditAccessDenied, sub, map[string]string{"reason": "invalid_csrf_token"})
+			renderError(ctx, http.StatusForbidden, "Request expired", "The page was open for too long or was sent from another site, please go back and try again.")
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// isCSRFExempt reports whether the requests to path are exempt from the
+// CSRF check.
+func isCSRFExempt(path string) bool {
+	for _, prefix := range csrfExemptPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
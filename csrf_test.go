@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCSRF(t *testing.T) {
+	provider := newTestProvider(t)
+	server := newTestServer(t, testConfig(provider), "memstore")
+	server.router.Use(server.CSRF())
+	server.router.GET("/test/csrf", func(ctx *gin.Context) {
+		ctx.String(http.StatusOK, ctx.GetString("csrf_token"))
+	})
+	for _, path := range []string{"/account", "/webhooks/auth0/users", "/ssf/events", "/oidc/backchannel-logout", "/device/token", "/webhooks-admin"} {
+		server.router.POST(path, func(ctx *gin.Context) {
+			ctx.Status(http.StatusNoContent)
+		})
+	}
+
+	w := serve(server, "/test/csrf")
+	cookie, token := responseCookie(w, sessionCookieName), w.Body.String()
+	if cookie == nil || token == "" {
+		t.Fatalf("no CSRF token in the session: %d %s", w.Code, w.Body)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		path   string
+		header map[string]string
+		form   string // the csrf_token form field
+		cookie bool
+		want   int
+	}{
+		{"header", "/account", map[string]string{csrfHeader: token}, "", true, http.StatusNoContent},
+		{"form field", "/account", nil, token, true, http.StatusNoContent},
+		{"no token", "/account", nil, "", true, http.StatusForbidden},
+		{"other token", "/account", map[string]string{csrfHeader: "other"}, "", true, http.StatusForbidden},
+		{"other token in the form", "/account", nil, "other", true, http.StatusForbidden},
+		{"token of the header first", "/account", map[string]string{csrfHeader: "other"}, token, true, http.StatusForbidden},
+		{"token without the session", "/account", map[string]string{csrfHeader: token}, "", false, http.StatusForbidden},
+		{"authorization header", "/account", map[string]string{"Authorization": "Bearer access-token"}, "", true, http.StatusNoContent},
+		{"webhook", "/webhooks/auth0/users", nil, "", true, http.StatusNoContent},
+		{"security event", "/ssf/events", nil, "", true, http.StatusNoContent},
+		{"back-channel logout", "/oidc/backchannel-logout", nil, "", false, http.StatusNoContent},
+		{"device client", "/device/token", nil, "", false, http.StatusNoContent},
+		{"exempt prefix only", "/webhooks-admin", nil, "", true, http.StatusForbidden},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			if tt.form != "" {
+				form.Set("csrf_token", tt.form)
+			}
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			// the rejections are answered in JSON, without the templates
+			req.Header.Set("Accept", "application/json")
+			for name, value := range tt.header {
+				req.Header.Set(name, value)
+			}
+			if tt.cookie {
+				req.AddCookie(cookie)
+			}
+
+			w := httptest.NewRecorder()
+			server.router.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/subtle"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// debugRoutes registers the pprof profiles and the expvar variables under
+// /debug on router, behind auth. The routes get no timeout, as CPU profiles
+// and traces run for the seconds requested.
+func debugRoutes(router gin.IRouter, auth ...gin.HandlerFunc) {
+	debug := router.Group("/debug", auth...)
+	debug.GET("/vars", gin.WrapH(expvar.Handler()))
+	debug.GET("/pprof/*profile", pprofHandler)
+	debug.POST("/pprof/*profile", pprofHandler)
+}
+
+// pprofHandler serves the pprof profile named in the path, such as
+// /debug/pprof/heap, or the index of the profiles.
+func pprofHandler(ctx *gin.Context) {
+	switch ctx.Param("profile") {
+	case "/cmdline":
+		pprof.Cmdline(ctx.Writer, ctx.Request)
+	case "/profile":
+		pprof.Profile(ctx.Writer, ctx.Request)
+	case "/symbol":
+		pprof.Symbol(ctx.Writer, ctx.Request)
+	case "/trace":
+		pprof.Trace(ctx.Writer, ctx.Request)
+	default:
+		if ctx.Request.Method != http.MethodGet {
+			ctx.AbortWithStatus(http.StatusMethodNotAllowed)
+			return
+		}
+		pprof.Index(ctx.Writer, ctx.Request)
+	}
+}
+
+// DebugAuth lets through the requests carrying token as a bearer token, and
+// those of signed in users having one of roles. The others get a 401, or a
+// 403 for the users without a role. A request with another Authorization
+// header is rejected, even when made with a session.
+func (s *Server) DebugAuth(token string, roles []string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if header := ctx.GetHeader("Authorization"); header != "" {
+			provided := strings.TrimPrefix(header, "Bearer ")
+			if token == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+				abortWithError(ctx, http.StatusUnauthorized, "unauthorized", "a valid debug token is required")
+				return
+			}
+			ctx.Next()
+			return
+		}
+
+		tokens, ok := sessionTokens(ctx)
+		u, signedIn := currentUser(ctx)
+		if len(roles) == 0 || !ok || !signedIn || tokens.AccessToken == "" || (!tokens.Expiry.IsZero() && time.Now().After(tokens.Expiry)) {
+			abortWithError(ctx, http.StatusUnauthorized, "unauthorized", "a debug token or a signed in user with a debug role is required")
+			return
+		}
+		if s.revocations.Revoked(u.Sub, sessionLoginTime(ctx)) {
+			s.audit(ctx, auditAccessDenied, u.Sub, map[string]string{"reason": "session_revoked"})
+			abortWithError(ctx, http.StatusUnauthorized, "unauthorized", "your session has ended, please log in again")
+			return
+		}
+
+		s.RequireRole(roles...)(ctx)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+// defaultMaxStaleness is how long sessions are served from cached claims
+// during an outage of the identity provider.
+const defaultMaxStaleness = 24 * time.Hour
+
+// IdPHealth tracks the outages of the identity provider. While it is
+// unavailable, the server is in degraded mode: existing sessions are served
+// from the claims cached at login, for up to maxStaleness since they were
+// last checked.
+type IdPHealth struct {
+	maxStaleness time.Duration
+
+	mu            sync.Mutex
+	degradedSince time.Time // zero when the provider is available
+	degradedTotal time.Duration
+	outages       int64
+	lastError     string
+}
+
+// IdPHealthStats are the metrics of the degraded mode.
+type IdPHealthStats struct {
+	Degraded        bool       `json:"degraded"`
+	DegradedSince   *time.Time `json:"degraded_since,omitempty"`
+	DegradedSeconds float64    `json:"degraded_seconds"` // total time spent in degraded mode
+	Outages         int64      `json:"outages"`
+	LastError       string     `json:"last_error,omitempty"`
+}
+
+// NewIdPHealth creates an IdPHealth serving cached claims for up to
+// maxStaleness.
+func NewIdPHealth(maxStaleness time.Duration) *IdPHealth {
+	return &IdPHealth{maxStaleness: maxStaleness}
+}
+
+// failed records a call which failed because of an outage, entering the
+// degraded mode.
+func (h *IdPHealth) failed(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.degradedSince.IsZero() {
+		h.degradedSince = time.Now()
+		h.outages++
+		slog.Warn("identity provider unavailable, serving sessions from cached claims", "err", err)
+	}
+	h.lastError = err.Error()
+}
+
+// succeeded records a successful call, leaving the degraded mode.
+func (h *IdPHealth) succeeded() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.degradedSince.IsZero() {
+		return
+	}
+	elapsed := time.Since(h.degradedSince)
+	h.degradedTotal += elapsed
+	h.degradedSince = time.Time{}
+	slog.Info("identity provider available again", "unavailable_for", elapsed.Round(time.Second))
+}
+
+// Stats returns the metrics of the degraded mode.
+func (h *IdPHealth) Stats() IdPHealthStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	stats := IdPHealthStats{
+		DegradedSeconds: h.degradedTotal.Seconds(),
+		Outages:         h.outages,
+		LastError:       h.lastError,
+	}
+	if !h.degradedSince.IsZero() {
+		since := h.degradedSince
+		stats.Degraded = true
+		stats.DegradedSince = &since
+		stats.DegradedSeconds += time.Since(since).Seconds()
+	}
+	return stats
+}
+
+// userInfoStatusError is returned when the userinfo endpoint answers with an
+// unexpected status.
+type userInfoStatusError struct {
+	status int
+}
+
+func (e *userInfoStatusError) Error() string {
+	return fmt.Sprintf("userinfo endpoint returned %d %s", e.status, http.StatusText(e.status))
+}
+
+// isIdPOutage reports whether err is caused by the identity provider being
+// unavailable (network errors, timeouts and 5xx or 429 responses), rather
+// than by a rejected token.
+func isIdPOutage(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	status := 0
+	var statusErr *userInfoStatusError
+	var managementErr *ManagementError
+	var retrieveErr *oauth2.RetrieveError
+	switch {
+	case errors.As(err, &statusErr):
+		status = statusErr.status
+	case errors.As(err, &managementErr):
+		status = managementErr.StatusCode
+	case errors.As(err, &retrieveErr) && retrieveErr.Response != nil:
+		status = retrieveErr.Response.StatusCode
+	}
+	return status >= 500 || status == http.StatusTooManyRequests
+}
+
+// fetchUserInfo calls the userinfo endpoint with client, returning the
+// verified claims.
+func (s *Server) fetchUserInfo(ctx *gin.Context, client *http.Client) ([]byte, error) {
+	tenant := s.tenant(ctx)
+	// the context of the request, as gin reuses ctx for the next requests
+	req, err := http.NewRequestWithContext(ctx.Request.Context(), http.MethodGet, tenant.provider.Metadata().UserInfoURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create userinfo request: %v", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch user information: %w", err)
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("could not read user information: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &userInfoStatusError{status: resp.StatusCode}
+	}
+
+	if isSignedUserInfo(resp.Header.Get("Content-Type")) {
+		return s.verifySignedUserInfo(ctx, b)
+	} else if s.signedUserInfo && tenant.isAuth0() {
+		return nil, fmt.Errorf("user information is not signed")
+	}
+	return b, nil
+}
+
+// markDegraded flags the request as served from cached data, in the
+// X-Auth-Degraded header and in the context under "degraded" for the pages
+// to show a banner.
+func markDegraded(ctx *gin.Context) {
+	ctx.Set("degraded", true)
+	ctx.Header("X-Auth-Degraded", "true")
+}
+
+// claimsCheckedAt returns when the session was last checked with the
+// userinfo endpoint, the login time when it was not checked since.
+func claimsCheckedAt(ctx *gin.Context) time.Time {
+	if session := defaultSession(ctx); session != nil {
+		value, _ := session.Get("claims_at").(string)
+		if nanos, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return time.Unix(0, nanos)
+		}
+	}
+	return sessionLoginTime(ctx)
+}
+
+// RevalidateSessions calls the userinfo endpoint again once the last check
+// is older than interval, ending the sessions whose access token is no
+// longer accepted. When the identity provider is unavailable, the cached
+// claims are served instead, the request being flagged as degraded, until
+// they are older than the staleness cap. It must run after IsAuthenticated.
+func (s *Server) RevalidateSessions(interval time.Duration) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		// the sessions of GitHub and Entra ID are not revalidated
+		session := defaultSession(ctx)
+		if _, ok := ctx.Get("access_token"); ok || session == nil || !s.tenant(ctx).revalidates() {
+			ctx.Next()
+			return
+		}
+
+		checkedAt := claimsCheckedAt(ctx)
+		if time.Since(checkedAt) < interval {
+			ctx.Next()
+			return
+		}
+
+		var accessToken string
+		if tokens, ok := sessionTokens(ctx); ok {
+			accessToken = tokens.AccessToken
+		}
+		token := &oauth2.Token{AccessToken: accessToken, TokenType: "Bearer"}
+		clientCtx := s.clientContext(ctx)
+		if id, ok := session.Get("dpop_key").(string); ok && s.dpopKeys != nil {
+			key, ok := s.dpopKeys.get(id)
+			if !ok {
+				// the proof key the token is bound to is gone
+				ctx.Next()
+				return
+			}
+			token.TokenType = "DPoP"
+			clientCtx = dpopClientContext(ctx, key, s.transport)
+		}
+
+		// the concurrent requests of a session share one userinfo call
+		var sub string
+		if u, ok := currentUser(ctx); ok {
+			sub = u.Sub
+		}
+		client := s.oauth2Config(ctx).Client(clientCtx, token)
+		// the claims verified at login are kept, the userinfo response only
+		// telling whether the session is still valid
+		_, err := s.calls.Do(ctx, "userinfo\x00"+sub+"\x00"+hashTokenSecret(accessToken), func() (interface{}, error) {
+			return s.fetchUserInfo(ctx, client)
+		})
+		switch {
+		case err == nil:
+			s.idpHealth.succeeded()
+			session.Set("claims_at", strconv.FormatInt(time.Now().UnixNano(), 10))
+			if err := session.Save(); err != nil {
+				slog.ErrorContext(ctx, "could not save session", "err", err)
+			}
+		case isIdPOutage(err) && time.Since(checkedAt) < s.idpHealth.maxStaleness:
+			s.idpHealth.failed(err)
+			markDegraded(ctx)
+		default:
+			if isIdPOutage(err) {
+				s.idpHealth.failed(err)
+			} else {
+				slog.WarnContext(ctx, "session no longer valid", "err", err)
+			}
+			s.deleteSessionTokens(ctx)
+			ctx.SetCookie("u", "", -1, "/", "", false, true)
+			addFlash(ctx, flashWarning, "Your session expired, please log in again.")
+			ctx.Redirect(http.StatusTemporaryRedirect, "/")
+			ctx.Abort()
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// idpHealthHandler reports the degraded mode metrics for the admin API.
+func (s *Server) idpHealthHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, s.idpHealth.Stats())
+}
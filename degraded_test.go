@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// expireAccessToken adds the /test/expire route, setting the expiry of the
+// access token of the session to a minute ago.
+func expireAccessToken(t *testing.T, server *Server) {
+	server.router.GET("/test/expire", func(ctx *gin.Context) {
+		tokens, ok := sessionTokens(ctx)
+		if !ok {
+			ctx.Status(http.StatusUnauthorized)
+			return
+		}
+		expired := *tokens
+		expired.Expiry = time.Now().Add(-time.Minute)
+		if err := server.saveSessionTokens(ctx, &expired); err != nil {
+			t.Errorf("could not save session tokens: %v", err)
+		}
+		ctx.Status(http.StatusNoContent)
+	})
+}
+
+func TestIsAuthenticatedDuringOutage(t *testing.T) {
+	for _, test := range []struct {
+		name         string
+		down         bool
+		maxStaleness time.Duration
+		status       int
+		degraded     bool
+	}{
+		{name: "renewed", status: http.StatusOK},
+		{name: "outage", down: true, maxStaleness: time.Hour, status: http.StatusOK, degraded: true},
+		{name: "outage past the staleness cap", down: true, maxStaleness: 0, status: http.StatusTemporaryRedirect, degraded: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			provider := newTestProvider(t)
+			server := newTestServer(t, testConfig(provider), "cookie")
+			startTestLogin(server)
+			expireAccessToken(t, server)
+			server.router.GET("/callback", server.callbackHandler)
+			server.router.GET("/profile", server.RefreshAccessTokens(), IsAuthenticated(test.maxStaleness), func(ctx *gin.Context) {
+				ctx.Status(http.StatusOK)
+			})
+
+			cookie := signIn(t, server)
+			if w := serve(server, "/test/expire", cookie); w.Code != http.StatusNoContent {
+				t.Fatalf("expire = %d", w.Code)
+			}
+			if test.down {
+				atomic.StoreInt32(&provider.down, 1)
+			}
+
+			w := serve(server, "/profile", cookie)
+			if w.Code != test.status {
+				t.Errorf("status = %d, want %d", w.Code, test.status)
+			}
+			if degraded := w.Header().Get("X-Auth-Degraded") == "true"; degraded != test.degraded {
+				t.Errorf("degraded = %t, want %t", degraded, test.degraded)
+			}
+		})
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// deviceGrantType is the grant type of the device access token requests
+	// (RFC 8628).
+	deviceGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+	// defaultDeviceInterval is how often the clients may poll when auth0
+	// does not say.
+	defaultDeviceInterval = 5 * time.Second
+	// maxDeviceLogins bounds the device logins waiting for their user.
+	maxDeviceLogins = 10000
+)
+
+// Statuses of a device login.
+const (
+	deviceLoginPending  = "pending"
+	deviceLoginComplete = "complete"
+	deviceLoginDenied   = "denied"
+	deviceLoginExpired  = "expired"
+)
+
+// deviceAuthorization is the response of the device authorization endpoint
+// (RFC 8628 section 3.2).
+type deviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval,omitempty"`
+}
+
+// deviceLogin is a device authorization waiting for its user, or completed.
+type deviceLogin struct {
+	authorization deviceAuthorization
+	expiresAt     time.Time
+	interval      time.Duration
+	polledAt      time.Time
+	status        string
+}
+
+// DeviceFlow lets headless clients, such as CLIs, sign users in with the
+// device authorization grant of auth0. The clients only talk to this
+// server, which polls auth0 on their behalf, so that the verification page
+// knows when the login completes.
+type DeviceFlow struct {
+	provider *Provider
+	clientID string // native application with the device code grant
+	scopes   []string
+	audience string
+	client   *http.Client
+
+	mu     sync.Mutex
+	logins map[string]*deviceLogin // by device code
+}
+
+// NewDeviceFlow creates a device flow for the application clientID of
+// provider, requesting scopes and, when set, an access token for audience.
+func NewDeviceFlow(provider *Provider, clientID string, scopes []string, audience string, transport http.RoundTripper) *DeviceFlow {
+	return &DeviceFlow{
+		provider: provider,
+		clientID: clientID,
+		scopes:   scopes,
+		audience: audience,
+		client:   &http.Client{Transport: transport},
+		logins:   map[string]*deviceLogin{},
+	}
+}
+
+// login returns the device login of a user code, if it did not expire.
+func (f *DeviceFlow) login(userCode string) (*deviceLogin, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, login := range f.logins {
+		if login.authorization.UserCode == userCode && time.Now().Before(login.expiresAt) {
+			return login, true
+		}
+	}
+	return nil, false
+}
+
+// add records a new device login, once the expired ones are removed. It
+// fails when too many logins are pending.
+func (f *DeviceFlow) add(authorization deviceAuthorization) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	for code, login := range f.logins {
+		if now.After(login.expiresAt) {
+			delete(f.logins, code)
+		}
+	}
+	if len(f.logins) >= maxDeviceLogins {
+		return fmt.Errorf("too many pending device logins")
+	}
+
+	interval := time.Duration(authorization.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultDeviceInterval
+	}
+	f.logins[authorization.DeviceCode] = &deviceLogin{
+		authorization: authorization,
+		expiresAt:     now.Add(time.Duration(authorization.ExpiresIn) * time.Second),
+		interval:      interval,
+		status:        deviceLoginPending,
+	}
+	return nil
+}
+
+// postForm posts params to endpoint, returning the status code and body of
+// the response.
+func (f *DeviceFlow) postForm(ctx context.Context, endpoint string, params url.Values) (int, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(params.Encode()))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	return resp.StatusCode, body, err
+}
+
+// deviceError answers a device flow request with an OAuth2 error.
+func deviceError(ctx *gin.Context, status int, code, description string) {
+	ctx.AbortWithStatusJSON(status, gin.H{"error": code, "error_description": description})
+}
+
+// deviceCodeHandler is the device authorization endpoint: it starts a
+// device login with auth0 and returns its codes, the user being sent to the
+// verification page of this server.
+func (s *Server) deviceCodeHandler(ctx *gin.Context) {
+	f := s.deviceFlow
+	endpoint := f.provider.Metadata().DeviceAuthURL
+	if endpoint == "" {
+		deviceError(ctx, http.StatusServiceUnavailable, "temporarily_unavailable", "the provider does not support the device authorization grant")
+		return
+	}
+
+	scope := ctx.PostForm("scope")
+	if scope == "" {
+		scope = strings.Join(f.scopes, " ")
+	}
+	params := url.Values{"client_id": {f.clientID}, "scope": {scope}}
+	if f.audience != "" {
+		params.Set("audience", f.audience)
+	}
+
+	status, body, err := f.postForm(ctx, endpoint, params)
+	if err != nil {
+		slog.ErrorContext(ctx, "could not request device code", "err", err)
+		deviceError(ctx, http.StatusBadGateway, "temporarily_unavailable", "could not reach the provider")
+		return
+	}
+	// the errors of auth0, such as invalid_scope, are the client's
+	if status != http.StatusOK {
+		ctx.Data(status, "application/json", body)
+		return
+	}
+
+	var authorization deviceAuthorization
+	if err := json.Unmarshal(body, &authorization); err != nil || authorization.DeviceCode == "" || authorization.UserCode == "" {
+		slog.ErrorContext(ctx, "invalid device authorization response", "err", err)
+		deviceError(ctx, http.StatusBadGateway, "temporarily_unavailable", "invalid response from the provider")
+		return
+	}
+	if err := f.add(authorization); err != nil {
+		deviceError(ctx, http.StatusServiceUnavailable, "temporarily_unavailable", err.Error())
+		return
+	}
+
+	// the user enters the code at auth0 from the verification page, which
+	// follows the login
+	verificationURI := requestScheme(ctx) + "://" + ctx.Request.Host + "/device"
+	response := authorization
+	response.VerificationURI = verificationURI
+	response.VerificationURIComplete = verificationURI + "?user_code=" + url.QueryEscape(authorization.UserCode)
+	ctx.JSON(http.StatusOK, response)
+}
+
+// deviceTokenHandler is the token endpoint of the device clients. It polls
+// auth0 for the tokens of the device code, at most once per interval, and
+// returns its response: the tokens, or errors such as
+// authorization_pending until the user signed in.
+func (s *Server) deviceTokenHandler(ctx *gin.Context) {
+	if ctx.PostForm("grant_type") != deviceGrantType {
+		deviceError(ctx, http.StatusBadRequest, "unsupported_grant_type", "grant_type must be "+deviceGrantType)
+		return
+	}
+
+	f := s.deviceFlow
+	deviceCode := ctx.PostForm("device_code")
+	f.mu.Lock()
+	login, ok := f.logins[deviceCode]
+	if !ok || login.status != deviceLoginPending {
+		f.mu.Unlock()
+		deviceError(ctx, http.StatusBadRequest, "invalid_grant", "unknown or used device code")
+		return
+	}
+	if time.Now().After(login.expiresAt) {
+		login.status = deviceLoginExpired
+		f.mu.Unlock()
+		deviceError(ctx, http.StatusBadRequest, "expired_token", "the device code expired")
+		return
+	}
+	// clients polling too fast wait 5 more seconds (RFC 8628 section 3.5)
+	if time.Since(login.polledAt) < login.interval {
+		login.interval += 5 * time.Second
+		f.mu.Unlock()
+		deviceError(ctx, http.StatusBadRequest, "slow_down", "polling too fast")
+		return
+	}
+	login.polledAt = time.Now()
+	f.mu.Unlock()
+
+	status, body, err := f.postForm(ctx, f.provider.Endpoint().TokenURL, url.Values{
+		"grant_type":  {deviceGrantType},
+		"device_code": {deviceCode},
+		"client_id":   {f.clientID},
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "could not poll device token", "err", err)
+		deviceError(ctx, http.StatusBadGateway, "temporarily_unavailable", "could not reach the provider")
+		return
+	}
+
+	var response struct {
+		Error   string `json:"error"`
+		IDToken string `json:"id_token"`
+	}
+	_ = json.Unmarshal(body, &response)
+
+	f.mu.Lock()
+	switch {
+	case status == http.StatusOK:
+		login.status = deviceLoginComplete
+	case response.Error == "slow_down":
+		login.interval += 5 * time.Second
+	case response.Error == "access_denied":
+		login.status = deviceLoginDenied
+	case response.Error == "expired_token":
+		login.status = deviceLoginExpired
+	}
+	f.mu.Unlock()
+
+	if status == http.StatusOK {
+		// the user is only known from a verified ID token
+		sub := ""
+		if response.IDToken != "" {
+			if idToken, err := f.provider.Verifier(&oidc.Config{ClientID: f.clientID}, s.clockSkew).Verify(ctx, response.IDToken); err == nil {
+				sub = idToken.Subject
+			} else {
+				slog.WarnContext(ctx, "invalid device ID token", "err", err)
+			}
+		}
+		s.audit(ctx, auditLoginSuccess, sub, map[string]string{"grant": "device_code"})
+	}
+	ctx.Data(status, "application/json", body)
+}
+
+// devicePage shows the user code of a device login with the link to enter
+// it at auth0, and follows the login until it completes.
+func (s *Server) devicePage(ctx *gin.Context) {
+	login, ok := s.deviceFlow.login(ctx.Query("user_code"))
+	if !ok {
+		renderError(ctx, http.StatusNotFound, "Unknown code", "This code is unknown or expired, please start again from your device.")
+		return
+	}
+
+	s.deviceFlow.mu.Lock()
+	authorization, status := login.authorization, login.status
+	s.deviceFlow.mu.Unlock()
+	verificationURI := authorization.VerificationURIComplete
+	if verificationURI == "" {
+		verificationURI = authorization.VerificationURI
+	}
+	renderHTML(ctx, http.StatusOK, "device.html", gin.H{
+		"UserCode":        authorization.UserCode,
+		"VerificationURI": verificationURI,
+		"Status":          status,
+	})
+}
+
+// deviceStatusHandler reports the status of the device login of a user
+// code to the verification page: pending, complete, denied or expired.
+func (s *Server) deviceStatusHandler(ctx *gin.Context) {
+	login, ok := s.deviceFlow.login(ctx.Query("user_code"))
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{"status": deviceLoginExpired})
+		return
+	}
+
+	s.deviceFlow.mu.Lock()
+	status := login.status
+	s.deviceFlow.mu.Unlock()
+	ctx.JSON(http.StatusOK, gin.H{"status": status})
+}
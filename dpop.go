@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// dpopKeyTTL is how long the proof key of a session is kept.
+const dpopKeyTTL = 24 * time.Hour
+
+// dpopKeyStore keeps the DPoP proof keys of the sessions server side, the
+// session only holding the key ID. Access tokens bound to a key are useless
+// without it.
+type dpopKeyStore struct {
+	mu   sync.Mutex
+	keys map[string]*dpopKey
+}
+
+// dpopKey is the proof key of a session.
+type dpopKey struct {
+	key     *ecdsa.PrivateKey
+	expires time.Time
+}
+
+// newDPoPKeyStore creates an empty key store.
+func newDPoPKeyStore() *dpopKeyStore {
+	return &dpopKeyStore{keys: make(map[string]*dpopKey)}
+}
+
+// generate creates a new proof key and returns its ID.
+func (s *dpopKeyStore) generate() (string, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", nil, fmt.Errorf("could not generate DPoP key: %v", err)
+	}
+
+	id, err := generateRandomString()
+	if err != nil {
+		return "", nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, entry := range s.keys {
+		if now.After(entry.expires) {
+			delete(s.keys, k)
+		}
+	}
+	s.keys[id] = &dpopKey{key: key, expires: now.Add(dpopKeyTTL)}
+
+	return id, key, nil
+}
+
+// get returns the proof key with the given ID.
+func (s *dpopKeyStore) get(id string) (*ecdsa.PrivateKey, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.keys[id]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.key, true
+}
+
+// delete forgets the proof key with the given ID.
+func (s *dpopKeyStore) delete(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.keys, id)
+}
+
+// dpopTransport attaches a DPoP proof (RFC 9449) to every request, binding
+// the access tokens issued for and used with them to the proof key.
+type dpopTransport struct {
+	key  *ecdsa.PrivateKey
+	base http.RoundTripper
+
+	mu    sync.Mutex
+	nonce string // last nonce required by the server
+}
+
+// dpopClientContext returns a context whose OAuth2 HTTP client signs DPoP
+// proofs with key, for the token requests and the resource requests made
+// with the tokens.
+func dpopClientContext(ctx context.Context, key *ecdsa.PrivateKey, base http.RoundTripper) context.Context {
+	client := &http.Client{Transport: &dpopTransport{key: key, base: base}}
+	return context.WithValue(ctx, oauth2.HTTPClient, client)
+}
+
+func (t *dpopTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	nonce := t.nonce
+	t.mu.Unlock()
+
+	resp, err := t.roundTrip(req, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	// the server asks for a nonce (use_dpop_nonce) by failing the request
+	// and sending the nonce to include in the proof
+	serverNonce := resp.Header.Get("DPoP-Nonce")
+	if serverNonce == "" || serverNonce == nonce {
+		return resp, nil
+	}
+
+	t.mu.Lock()
+	t.nonce = serverNonce
+	t.mu.Unlock()
+
+	if resp.StatusCode != http.StatusBadRequest && resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+
+	resp.Body.Close()
+	return t.roundTrip(req, serverNonce)
+}
+
+// roundTrip sends a copy of req carrying a fresh proof.
+func (t *dpopTransport) roundTrip(req *http.Request, nonce string) (*http.Response, error) {
+	proof, err := t.proof(req, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	clone := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if clone.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	clone.Header.Set("DPoP", proof)
+
+	return t.base.RoundTrip(clone)
+}
+
+// proof creates the DPoP proof JWT for req.
+func (t *dpopTransport) proof(req *http.Request, nonce string) (string, error) {
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.ES256, Key: t.key},
+		(&jose.SignerOptions{EmbedJWK: true}).WithType("dpop+jwt"),
+	)
+	if err != nil {
+		return "", fmt.Errorf("could not create DPoP signer: %v", err)
+	}
+
+	jti, err := generateRandomString()
+	if err != nil {
+		return "", err
+	}
+
+	htu := *req.URL
+	htu.RawQuery, htu.Fragment = "", ""
+
+	claims := map[string]interface{}{
+		"jti": jti,
+		"htm": req.Method,
+		"htu": htu.String(),
+		"iat": time.Now().Unix(),
+	}
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	// resource requests also carry the hash of the access token
+	if token := strings.TrimPrefix(req.Header.Get("Authorization"), "DPoP "); token != req.Header.Get("Authorization") {
+		sum := sha256.Sum256([]byte(token))
+		claims["ath"] = base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signed, err := signer.Sign(payload)
+	if err != nil {
+		return "", fmt.Errorf("could not sign DPoP proof: %v", err)
+	}
+	return signed.CompactSerialize()
+}
+
+// sessionDPoPKey returns the proof key of the session of the request, none
+// without AUTH0_DPOP.
+func (s *Server) sessionDPoPKey(ctx *gin.Context) (*ecdsa.PrivateKey, bool) {
+	session := defaultSession(ctx)
+	if session == nil || s.dpopKeys == nil {
+		return nil, false
+	}
+
+	id, _ := session.Get("dpop_key").(string)
+	return s.dpopKeys.get(id)
+}
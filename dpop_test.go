@@ -0,0 +1,206 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// roundTripperFunc is an http.RoundTripper answering with a function.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// dpopProofClaims verifies the proof of req with the key it embeds, which
+// must be key, and returns its claims.
+func dpopProofClaims(t *testing.T, req *http.Request, key *ecdsa.PrivateKey) map[string]interface{} {
+	t.Helper()
+
+	signed, err := jose.ParseSigned(req.Header.Get("DPoP"))
+	if err != nil {
+		t.Fatalf("invalid proof: %v", err)
+	}
+	header := signed.Signatures[0].Protected
+	if header.JSONWebKey == nil || header.ExtraHeaders["typ"] != "dpop+jwt" {
+		t.Fatalf("proof header = %+v, want the dpop+jwt type and the embedded key", header)
+	}
+	payload, err := signed.Verify(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("proof not signed by the key: %v", err)
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		t.Fatal(err)
+	}
+	return claims
+}
+
+func TestDPoPTransportProof(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("access-token"))
+	ath := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	for _, tt := range []struct {
+		name          string
+		method        string
+		url           string
+		authorization string
+		wantHTU       string
+		wantATH       string
+	}{
+		{"token request", http.MethodPost, "https://tenant.example/oauth/token", "", "https://tenant.example/oauth/token", ""},
+		{"query and fragment", http.MethodGet, "https://api.example/orders?page=2#top", "", "https://api.example/orders", ""},
+		{"resource request", http.MethodGet, "https://api.example/orders", "DPoP access-token", "https://api.example/orders", ath},
+		{"bearer token", http.MethodGet, "https://api.example/orders", "Bearer access-token", "https://api.example/orders", ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var claims map[string]interface{}
+			transport := &dpopTransport{key: key, base: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				claims = dpopProofClaims(t, req, key)
+				return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Header: http.Header{}}, nil
+			})}
+
+			req, _ := http.NewRequest(tt.method, tt.url, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			if _, err := transport.RoundTrip(req); err != nil {
+				t.Fatal(err)
+			}
+			if claims["htm"] != tt.method || claims["htu"] != tt.wantHTU || claims["jti"] == "" || claims["iat"] == nil {
+				t.Errorf("claims = %v, want htm %s and htu %s", claims, tt.method, tt.wantHTU)
+			}
+			if ath, _ := claims["ath"].(string); ath != tt.wantATH {
+				t.Errorf("ath = %q, want %q", ath, tt.wantATH)
+			}
+			if req.Header.Get("DPoP") != "" {
+				t.Error("the proof was added to the request of the caller")
+			}
+		})
+	}
+}
+
+func TestDPoPTransportNonce(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name      string
+		status    int // of the response asking for a nonce
+		body      io.Reader
+		replay    bool // whether the body can be sent again
+		wantSends int
+	}{
+		{"bad request", http.StatusBadRequest, strings.NewReader("grant_type=refresh_token"), true, 2},
+		{"unauthorized", http.StatusUnauthorized, nil, true, 2},
+		{"success", http.StatusOK, nil, true, 1},
+		{"server error", http.StatusInternalServerError, nil, true, 1},
+		{"body not replayable", http.StatusBadRequest, strings.NewReader("grant_type=refresh_token"), false, 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var nonces []interface{}
+			var bodies []string
+			transport := &dpopTransport{key: key, base: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				nonces = append(nonces, dpopProofClaims(t, req, key)["nonce"])
+				if req.Body != nil {
+					b, _ := io.ReadAll(req.Body)
+					bodies = append(bodies, string(b))
+				}
+				status := tt.status
+				if len(nonces) > 1 {
+					status = http.StatusOK
+				}
+				return &http.Response{StatusCode: status, Body: http.NoBody, Header: http.Header{"Dpop-Nonce": {"server-nonce"}}}, nil
+			})}
+
+			req, _ := http.NewRequest(http.MethodPost, "https://tenant.example/oauth/token", tt.body)
+			if !tt.replay {
+				req.GetBody = nil
+			}
+			if _, err := transport.RoundTrip(req); err != nil {
+				t.Fatal(err)
+			}
+			if len(nonces) != tt.wantSends {
+				t.Fatalf("request sent %d times, want %d", len(nonces), tt.wantSends)
+			}
+			if nonces[0] != nil || (tt.wantSends == 2 && nonces[1] != "server-nonce") {
+				t.Errorf("nonces = %v, want none then the nonce of the server", nonces)
+			}
+			if tt.wantSends == 2 && tt.body != nil && bodies[0] != bodies[1] {
+				t.Errorf("bodies = %q, want the body sent again", bodies)
+			}
+
+			// the nonce is remembered for the next requests
+			nonces = nil
+			req, _ = http.NewRequest(http.MethodGet, "https://api.example/orders", nil)
+			if _, err := transport.RoundTrip(req); err != nil {
+				t.Fatal(err)
+			}
+			if len(nonces) != 1 || nonces[0] != "server-nonce" {
+				t.Errorf("nonces of the next request = %v, want the nonce of the server", nonces)
+			}
+		})
+	}
+}
+
+func TestDPoPKeyStore(t *testing.T) {
+	store := newDPoPKeyStore()
+	id, key, err := store.generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, _, err := store.generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.keys[expired].expires = time.Now().Add(-time.Second)
+	deleted, _, err := store.generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.delete(deleted)
+
+	for _, tt := range []struct {
+		name   string
+		id     string
+		wantOK bool
+	}{
+		{"key", id, true},
+		{"expired", expired, false},
+		{"deleted", deleted, false},
+		{"unknown", "unknown", false},
+		{"no key", "", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := store.get(tt.id)
+			if ok != tt.wantOK || (ok && got != key) {
+				t.Errorf("get(%q) = %v, %t, want %t", tt.id, got, ok, tt.wantOK)
+			}
+		})
+	}
+
+	// expired keys are dropped when the next key is generated
+	if _, _, err := store.generate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.keys[expired]; ok {
+		t.Error("expired key kept")
+	}
+}
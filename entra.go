@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// entraHost is the host of the Microsoft Entra ID (Azure AD) endpoints.
+const entraHost = "login.microsoftonline.com"
+
+// entraMultiTenants are the tenant aliases letting any organization sign in,
+// whose ID tokens have the issuer of the tenant of the user.
+var entraMultiTenants = []string{"common", "organizations", "consumers"}
+
+// newEntraProvider discovers the v2.0 endpoints of the Entra ID tenant, its
+// ID, such as 72f988bf-86f1-41af-91ab-2d7cd011db47, or one of its domains,
+// such as contoso.onmicrosoft.com. The issuer is the one of the discovery
+// document, which names the tenant by ID.
+func newEntraProvider(ctx context.Context, tenantID string) (*Provider, error) {
+	p := &Provider{baseURL: "https://" + entraHost + "/" + tenantID + "/v2.0", client: contextClient(ctx)}
+
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if metadata.Issuer == "" || strings.Contains(metadata.Issuer, "{tenantid}") {
+		return nil, fmt.Errorf("tenant %q has no fixed issuer", tenantID)
+	}
+
+	p.issuer = metadata.Issuer
+	p.metadata = metadata
+	p.keySet = p.newKeySet(metadata.JWKSURL)
+	return p, nil
+}
+
+// entraProfile normalizes the claims of an Entra ID token into the ones of
+// auth0. The sub of Entra ID differs for each application, so the object ID
+// of the user (oid) is used instead, prefixed with entra|. The
+// preferred_username, usually the user principal name, is the nickname and
+// the email address when the optional email claim is not issued. Entra ID
+// does not verify the addresses.
+func entraProfile(profile []byte) ([]byte, error) {
+	var claims map[string]interface{}
+	if err := json.Unmarshal(profile, &claims); err != nil {
+		return nil, fmt.Errorf("could not parse id token claims: %v", err)
+	}
+
+	oid, _ := claims["oid"].(string)
+	if oid == "" {
+		return nil, fmt.Errorf("id token has no oid claim, is the profile scope requested?")
+	}
+	claims["sub"] = "entra|" + oid
+
+	username, _ := claims["preferred_username"].(string)
+	if _, ok := claims["nickname"]; !ok && username != "" {
+		claims["nickname"] = username
+	}
+	if email, _ := claims["email"].(string); email == "" && strings.Contains(username, "@") {
+		claims["email"] = username
+	}
+	claims["email_verified"] = false
+
+	return json.Marshal(claims)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sentryQueueSize is the number of reports waiting to be sent, the ones
+// over it being dropped so that a burst of panics does not pile up.
+const sentryQueueSize = 100
+
+// sentryReporter sends the recovered panics to Sentry, or a service taking
+// the same envelopes such as GlitchTip, from a background goroutine.
+type sentryReporter struct {
+	endpoint    string // envelope endpoint of the project
+	dsn         string
+	auth        string // X-Sentry-Auth header
+	environment string
+	region      string
+	client      *http.Client
+	queue       chan []byte
+}
+
+// parseSentryDSN returns the envelope endpoint and the public key of dsn,
+// of the form https://<key>@<host>/<project>.
+func parseSentryDSN(dsn string) (string, string, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.User == nil || u.User.Username() == "" {
+		return "", "", fmt.Errorf("DSN must be an http(s) URL with the public key as user")
+	}
+	path := strings.TrimSuffix(u.Path, "/")
+	slash := strings.LastIndex(path, "/")
+	if slash < 0 || slash == len(path)-1 {
+		return "", "", fmt.Errorf("DSN has no project ID")
+	}
+
+	endpoint := url.URL{Scheme: u.Scheme, Host: u.Host, Path: path[:slash] + "/api/" + path[slash+1:] + "/envelope/"}
+	return endpoint.String(), u.User.Username(), nil
+}
+
+// newSentryReporter creates the reporter of the project of dsn.
+func newSentryReporter(dsn, environment, region string) (*sentryReporter, error) {
+	endpoint, key, err := parseSentryDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &sentryReporter{
+		endpoint:    endpoint,
+		dsn:         dsn,
+		auth:        "Sentry sentry_version=7, sentry_client=go-auth0/1.0, sentry_key=" + key,
+		environment: environment,
+		region:      region,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		queue:       make(chan []byte, sentryQueueSize),
+	}
+	go r.run()
+	return r, nil
+}
+
+// Report implements ErrorReporter, queueing an event of err with the stack
+// and the request it happened in. The headers and query of the request are
+// left out, as they carry credentials.
+func (r *sentryReporter) Report(ctx *gin.Context, err error, stack []byte) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		slog.ErrorContext(ctx, "could not report error", "err", err)
+		return
+	}
+	eventID := hex.EncodeToString(id)
+
+	event := map[string]interface{}{
+		"event_id":    eventID,
+		"timestamp":   time.Now().UTC().Format(time.RFC3339Nano),
+		"level":       "fatal",
+		"platform":    "go",
+		"environment": r.environment,
+		"exception": map[string]interface{}{
+			"values": []map[string]interface{}{{
+				"type":      fmt.Sprintf("%T", err),
+				"value":     err.Error(),
+				"mechanism": map[string]interface{}{"type": "recovery", "handled": true},
+			}},
+		},
+		"request": map[string]interface{}{
+			"method": ctx.Request.Method,
+			"url":    ctx.Request.URL.Path,
+		},
+		"tags": map[string]string{
+			"request_id": ctx.GetString("request_id"),
+			"trace_id":   ctx.GetString("trace_id"),
+			"region":     r.region,
+			"route":      ctx.FullPath(),
+		},
+		"extra": map[string]string{
+			"stack": string(stack),
+		},
+	}
+
+	var envelope bytes.Buffer
+	encoder := json.NewEncoder(&envelope)
+	for _, item := range []interface{}{
+		map[string]string{"event_id": eventID, "dsn": r.dsn},
+		map[string]string{"type": "event"},
+		event,
+	} {
+		if err := encoder.Encode(item); err != nil {
+			slog.ErrorContext(ctx, "could not report error", "err", err)
+			return
+		}
+	}
+
+	select {
+	case r.queue <- envelope.Bytes():
+	default:
+		slog.ErrorContext(ctx, "could not report error, queue full")
+	}
+}
+
+// run sends the queued envelopes.
+func (r *sentryReporter) run() {
+	for envelope := range r.queue {
+		if err := r.send(envelope); err != nil {
+			slog.Error("could not report error", "err", err)
+		}
+	}
+}
+
+// send posts an envelope to the endpoint.
+func (r *sentryReporter) send(envelope []byte) error {
+	req, err := http.NewRequest(http.MethodPost, r.endpoint, bytes.NewReader(envelope))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", r.auth)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("sentry answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errorResponse is the JSON envelope returned for errors by JSON endpoints.
+type errorResponse struct {
+	Error   string `json:"error"`             // machine readable error code
+	Message string `json:"message,omitempty"` // human readable description
+}
+
+// abortWithError aborts the request with the standard JSON error envelope.
+func abortWithError(ctx *gin.Context, status int, code, message string) {
+	ctx.AbortWithStatusJSON(status, errorResponse{
+		Error:   code,
+		Message: message,
+	})
+}
+
+// wantsJSON reports whether the request is answered in JSON: API requests,
+// and the requests of single page apps asking for JSON with their Accept
+// header, which get errors and 401s instead of pages and redirects.
+func wantsJSON(ctx *gin.Context) bool {
+	return strings.HasPrefix(ctx.Request.URL.Path, "/api/") || ctx.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON
+}
+
+// statusErrorCode returns the error code of status, such as bad_request.
+func statusErrorCode(status int) string {
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
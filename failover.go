@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+// defaultTenantHealthInterval is the time between two health checks of the
+// tenants when failover tenants are configured.
+const defaultTenantHealthInterval = 30 * time.Second
+
+// tenant is an auth0 tenant users can sign in with: the primary one or a
+// standby tenant, in another region for example, used when the ones before
+// it are unavailable. The other identity providers are tenants of their own
+// kind.
+type tenant struct {
+	name         string   // tenant domain, or name of the provider
+	kind         string   // providerAuth0, providerGoogle, providerGitHub or providerOIDC
+	label        string   // name shown on the sign in button of the providers
+	hosted       []string // Google Workspace domains allowed to sign in, any account when empty
+	organization string   // auth0 organization the logins sign in to, by ID or name
+	primary      bool
+	provider     *Provider
+	oauth2config *oauth2.Config
+	healthy      int32 // set while the last health check succeeded
+}
+
+// loadFailoverTenants creates the standby tenants of configs, in priority
+// order.
+func loadFailoverTenants(ctx context.Context, base *oauth2.Config, configs []TenantConfig) ([]*tenant, error) {
+	var tenants []*tenant
+	for _, c := range configs {
+		t, err := newAuth0Tenant(ctx, base, c)
+		if err != nil {
+			return nil, fmt.Errorf("could not create failover provider %s: %v", c.Domain, err)
+		}
+		tenants = append(tenants, t)
+	}
+	return tenants, nil
+}
+
+// newAuth0Tenant creates the tenant of c, authenticating with its client
+// secret and the other settings of base.
+func newAuth0Tenant(ctx context.Context, base *oauth2.Config, c TenantConfig) (*tenant, error) {
+	provider, err := NewProvider(ctx, c.Domain, c.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	config := *base
+	config.ClientID = c.ClientID
+	config.ClientSecret = c.ClientSecret
+	config.Endpoint = provider.Endpoint()
+
+	return &tenant{
+		name:         c.Domain,
+		kind:         providerAuth0,
+		organization: c.Organization,
+		provider:     provider,
+		oauth2config: &config,
+		healthy:      1,
+	}, nil
+}
+
+// checkTenants checks that the discovery document of every tenant can be
+// fetched. It fails when no tenant is available.
+func (s *Server) checkTenants(ctx context.Context) error {
+	available := 0
+	for _, t := range s.tenants {
+		_, err := t.provider.discover(ctx)
+
+		healthy := int32(0)
+		if err == nil {
+			healthy = 1
+			available++
+		}
+		if previous := atomic.SwapInt32(&t.healthy, healthy); previous != healthy {
+			slog.WarnContext(ctx, "tenant health changed", "tenant", t.name, "healthy", healthy == 1, "err", err)
+		}
+	}
+
+	if available == 0 {
+		return fmt.Errorf("no tenant is available")
+	}
+	return nil
+}
+
+// refreshProviders refreshes the metadata of the provider of every tenant,
+// of the OpenID Connect providers and of the sites.
+func (s *Server) refreshProviders(ctx context.Context) error {
+	refreshed := append([]*tenant(nil), s.tenants...)
+	for _, t := range s.providers {
+		if !t.isAuth0() && t.hasIDToken() {
+			refreshed = append(refreshed, t)
+		}
+	}
+	for _, site := range s.sites {
+		refreshed = append(refreshed, site.tenant)
+	}
+
+	var failed []string
+	for _, t := range refreshed {
+		if err := t.provider.Refresh(ctx); err != nil {
+			slog.ErrorContext(ctx, "could not refresh provider", "tenant", t.name, "err", err)
+			failed = append(failed, t.name)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("could not refresh providers: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// loginTenant returns the tenant new logins are sent to: the one of the site
+// of the request, or the first healthy one in priority order, or the
+// primary one when none is.
+func (s *Server) loginTenant(ctx *gin.Context) *tenant {
+	if site := s.site(ctx); site != nil {
+		return site.tenant
+	}
+	for _, t := range s.tenants {
+		if atomic.LoadInt32(&t.healthy) == 1 {
+			return t
+		}
+	}
+	return s.tenants[0]
+}
+
+// tenant returns the tenant or provider the session of the request signed
+// in with, the primary tenant by default. Sessions created with a tenant
+// keep using it, so they stay valid when logins fail over or back, and the
+// callbacks are handled by the provider the login was started with. The
+// requests of a site only use its tenant.
+func (s *Server) tenant(ctx *gin.Context) *tenant {
+	if site := s.site(ctx); site != nil {
+		return site.tenant
+	}
+	if session := defaultSession(ctx); session != nil {
+		if name, ok := session.Get("tenant").(string); ok {
+			for _, t := range s.tenants {
+				if t.name == name {
+					return t
+				}
+			}
+			if t, ok := s.providers[name]; ok {
+				return t
+			}
+		}
+	}
+	return s.tenants[0]
+}
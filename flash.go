@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log/slog"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// Flash message levels.
+const (
+	flashSuccess = "success"
+	flashWarning = "warning"
+	flashError   = "error"
+)
+
+// flashLevels lists the levels in the order messages are displayed.
+var flashLevels = []string{flashError, flashWarning, flashSuccess}
+
+// flashMessage is a message set by a handler and displayed on the next
+// rendered page.
+type flashMessage struct {
+	Level   string
+	Message string
+}
+
+// defaultSession returns the session of the request, or nil when the
+// sessions middleware did not run.
+func defaultSession(ctx *gin.Context) sessions.Session {
+	if _, ok := ctx.Get(sessions.DefaultKey); !ok {
+		return nil
+	}
+	return sessions.Default(ctx)
+}
+
+// addFlash stores a flash message in the session, to be displayed on the
+// next rendered page.
+func addFlash(ctx *gin.Context, level, message string) {
+	session := defaultSession(ctx)
+	if session == nil {
+		return
+	}
+
+	session.AddFlash(message, "flash_"+level)
+	if err := session.Save(); err != nil {
+		slog.ErrorContext(ctx, "could not save flash message", "err", err)
+	}
+}
+
+// consumeFlashes returns the pending flash messages and removes them from
+// the session.
+func consumeFlashes(ctx *gin.Context) []flashMessage {
+	session := defaultSession(ctx)
+	if session == nil {
+		return nil
+	}
+
+	var messages []flashMessage
+	for _, level := range flashLevels {
+		for _, message := range session.Flashes("flash_" + level) {
+			if text, ok := message.(string); ok {
+				messages = append(messages, flashMessage{Level: level, Message: text})
+			}
+		}
+	}
+
+	if len(messages) > 0 {
+		if err := session.Save(); err != nil {
+			slog.ErrorContext(ctx, "could not save session after reading flash messages", "err", err)
+		}
+	}
+
+	return messages
+}
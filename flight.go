@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// errCallPanicked is returned to the callers waiting for a call which
+// panicked.
+var errCallPanicked = errors.New("coalesced call panicked")
+
+// callGroup coalesces identical concurrent calls to the identity provider,
+// in the manner of golang.org/x/sync/singleflight: while a call for a key is
+// in flight, the callers asking for the same key wait for its result instead
+// of making their own, so that bursts of requests of a user make one call.
+//
+// The JWKS needs no coalescing, the key sets of go-oidc already share a
+// single refresh between the verifications waiting for it.
+type callGroup struct {
+	mu    sync.Mutex
+	calls map[string]*groupCall
+}
+
+// groupCall is a call in flight, whose result is set once done is closed.
+type groupCall struct {
+	done chan struct{}
+	val  interface{}
+	err  error
+}
+
+// newCallGroup creates a group with no calls in flight.
+func newCallGroup() *callGroup {
+	return &callGroup{calls: make(map[string]*groupCall)}
+}
+
+// Do returns the result of fn, run unless a call for key is already in
+// flight, in which case the result of that call is returned. A waiting
+// caller stops once ctx is done, and makes the call again when the shared
+// call was canceled or timed out with the request which made it.
+func (g *callGroup) Do(ctx context.Context, key string, fn func() (interface{}, error)) (interface{}, error) {
+	for {
+		g.mu.Lock()
+		c, ok := g.calls[key]
+		if !ok {
+			c = &groupCall{done: make(chan struct{})}
+			g.calls[key] = c
+			g.mu.Unlock()
+
+			g.run(key, c, fn)
+			return c.val, c.err
+		}
+		g.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-c.done:
+		}
+
+		canceled := errors.Is(c.err, context.Canceled) || errors.Is(c.err, context.DeadlineExceeded)
+		if !canceled || ctx.Err() != nil {
+			return c.val, c.err
+		}
+	}
+}
+
+// run makes the call c for key, releasing the waiting callers even when fn
+// panics.
+func (g *callGroup) run(key string, c *groupCall, fn func() (interface{}, error)) {
+	c.err = errCallPanicked
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(c.done)
+	}()
+
+	c.val, c.err = fn()
+}
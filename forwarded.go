@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// sessionCookieOptions are the options of the session cookie, marked Secure
+// by SecureSessionCookie on HTTPS requests.
+var sessionCookieOptions = sessions.Options{Path: "/", MaxAge: defaultSessionMaxAge, HttpOnly: true}
+
+// parseSameSite parses the SameSite attribute of the session cookie: lax,
+// strict or none, the browser default when empty.
+func parseSameSite(value string) (http.SameSite, error) {
+	switch strings.ToLower(value) {
+	case "":
+		return http.SameSiteDefaultMode, nil
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return 0, fmt.Errorf("%q is not lax, strict or none", value)
+	}
+}
+
+// parseTrustedProxies parses the IP addresses and CIDR ranges of the
+// trusted reverse proxies.
+func parseTrustedProxies(values []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", value)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", value)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// ForwardedHeaders applies the X-Forwarded-Proto and X-Forwarded-Host
+// headers of the requests sent by a trusted proxy: the host replaces the one
+// of the request and the scheme is stored in the context under
+// "request_scheme" for requestScheme. The headers of other clients are
+// ignored.
+func ForwardedHeaders(trusted []*net.IPNet) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if len(trusted) == 0 || !isTrustedProxy(trusted, ctx.RemoteIP()) {
+			ctx.Next()
+			return
+		}
+
+		if host := firstForwardedValue(ctx.GetHeader("X-Forwarded-Host")); host != "" {
+			ctx.Request.Host = host
+		}
+		switch proto := strings.ToLower(firstForwardedValue(ctx.GetHeader("X-Forwarded-Proto"))); proto {
+		case "http", "https":
+			ctx.Set("request_scheme", proto)
+		}
+		ctx.Next()
+	}
+}
+
+// isTrustedProxy reports whether the peer address ip is in trusted.
+func isTrustedProxy(trusted []*net.IPNet, ip string) bool {
+	peer := net.ParseIP(ip)
+	if peer == nil {
+		return false
+	}
+	for _, ipNet := range trusted {
+		if ipNet.Contains(peer) {
+			return true
+		}
+	}
+	return false
+}
+
+// firstForwardedValue returns the value added by the client-facing proxy
+// when proxies are chained.
+func firstForwardedValue(header string) string {
+	value, _, _ := strings.Cut(header, ",")
+	return strings.TrimSpace(value)
+}
+
+// SecureSessionCookie marks the session cookie Secure on the requests made
+// over HTTPS, directly or through a trusted proxy, so browsers never send it
+// in clear.
+func SecureSessionCookie() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if requestScheme(ctx) == "https" {
+			if session := defaultSession(ctx); session != nil {
+				options := sessionCookieOptions
+				options.Secure = true
+				session.Options(options)
+			}
+		}
+		ctx.Next()
+	}
+}
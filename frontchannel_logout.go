@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// frontChannelLogoutHandler is the front-channel logout URI (OpenID Connect
+// Front-Channel Logout 1.0) the provider loads in a hidden frame of its
+// logout page. It logs the user out when the iss and sid parameters name the
+// provider and the provider session of the login, so that other sites
+// cannot log users out. It always answers an empty page.
+func (s *Server) frontChannelLogoutHandler(ctx *gin.Context) {
+	ctx.Header("Cache-Control", "no-cache, no-store")
+	ctx.Header("Pragma", "no-cache")
+
+	session := defaultSession(ctx)
+	if session == nil {
+		ctx.Status(http.StatusOK)
+		return
+	}
+	sid, _ := session.Get("provider_sid").(string)
+	if sid == "" || ctx.Query("sid") != sid || ctx.Query("iss") != s.tenant(ctx).provider.issuer {
+		ctx.Status(http.StatusOK)
+		return
+	}
+
+	s.endLocalSession(ctx, map[string]string{"reason": "frontchannel_logout"})
+	ctx.Status(http.StatusOK)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestFrontChannelLogout(t *testing.T) {
+	const sid = "provider-session"
+
+	for _, tt := range []struct {
+		name      string
+		loginSID  string // the sid claim of the ID token of the login
+		query     url.Values
+		signedOut bool
+	}{
+		{"provider session", sid, url.Values{"sid": {sid}, "iss": {""}}, true},
+		{"other session", sid, url.Values{"sid": {"other-session"}, "iss": {""}}, false},
+		{"no session", sid, url.Values{"iss": {""}}, false},
+		{"other issuer", sid, url.Values{"sid": {sid}, "iss": {"https://evil.example/"}}, false},
+		{"no issuer", sid, url.Values{"sid": {sid}}, false},
+		{"login without a session", "", url.Values{"sid": {""}, "iss": {""}}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newTestProvider(t)
+			if tt.loginSID != "" {
+				provider.claims["sid"] = tt.loginSID
+			}
+			server := newTestServer(t, testConfig(provider), "memstore")
+			startTestLogin(server)
+			server.router.GET("/callback", server.callbackHandler)
+			server.router.GET("/oidc/frontchannel-logout", server.frontChannelLogoutHandler)
+			server.router.GET("/test/user", func(ctx *gin.Context) {
+				if _, ok := currentUser(ctx); !ok {
+					ctx.Status(http.StatusUnauthorized)
+					return
+				}
+				ctx.Status(http.StatusNoContent)
+			})
+
+			cookie := signIn(t, server)
+			query := url.Values{}
+			for name, values := range tt.query {
+				// the issuer of the provider, only known once it started
+				if name == "iss" && values[0] == "" {
+					values = []string{provider.URL + "/"}
+				}
+				query[name] = values
+			}
+
+			w := serve(server, "/oidc/frontchannel-logout?"+query.Encode(), cookie)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+			}
+			if w.Header().Get("Cache-Control") != "no-cache, no-store" {
+				t.Errorf("Cache-Control = %q, want the page kept out of the caches", w.Header().Get("Cache-Control"))
+			}
+			if updated := responseCookie(w, sessionCookieName); updated != nil {
+				cookie = updated
+			}
+			if signedOut := serve(server, "/test/user", cookie).Code == http.StatusUnauthorized; signedOut != tt.signedOut {
+				t.Errorf("signed out = %t, want %t", signedOut, tt.signedOut)
+			}
+		})
+	}
+
+	t.Run("signed out", func(t *testing.T) {
+		provider := newTestProvider(t)
+		server := newTestServer(t, testConfig(provider), "memstore")
+		server.router.GET("/oidc/frontchannel-logout", server.frontChannelLogoutHandler)
+
+		if w := serve(server, "/oidc/frontchannel-logout?sid="+sid); w.Code != http.StatusOK {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+		}
+	})
+}
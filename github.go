@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// githubUserURL and githubEmailsURL return the profile and the email
+	// addresses of the GitHub user, which has no ID token.
+	githubUserURL   = "https://api.github.com/user"
+	githubEmailsURL = "https://api.github.com/user/emails"
+)
+
+// newGitHubProvider returns the GitHub OAuth2 endpoints. GitHub serves no
+// discovery document, and issues no ID token.
+func newGitHubProvider() *Provider {
+	return &Provider{
+		baseURL: "https://github.com",
+		metadata: providerMetadata{
+			AuthURL:  "https://github.com/login/oauth/authorize",
+			TokenURL: "https://github.com/login/oauth/access_token",
+		},
+	}
+}
+
+// githubUser is the profile of a GitHub user returned by the API.
+type githubUser struct {
+	ID        int64     `json:"id"`
+	Login     string    `json:"login"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"` // public email address, if any
+	AvatarURL string    `json:"avatar_url"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// githubEmail is an email address of a GitHub user.
+type githubEmail struct {
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
+}
+
+// userInfo normalizes the profile into the claims of the ID tokens, with
+// emails the addresses of the user when the user:email scope is granted.
+// The sub is prefixed with github| as auth0 does, and the name defaults to
+// the login.
+func (u *githubUser) userInfo(emails []githubEmail) UserInfo {
+	info := UserInfo{
+		Sub:       "github|" + strconv.FormatInt(u.ID, 10),
+		Nickname:  u.Login,
+		Name:      u.Name,
+		Picture:   u.AvatarURL,
+		UpdatedAt: u.UpdatedAt,
+		Email:     u.Email,
+	}
+	if info.Name == "" {
+		info.Name = u.Login
+	}
+	// only the primary address is known to be verified
+	for _, e := range emails {
+		if e.Primary {
+			info.Email, info.EmailVerified = e.Email, e.Verified
+		}
+	}
+	return info
+}
+
+// fetchGitHubProfile returns the claims of the GitHub user of client, as
+// the JSON of a UserInfo.
+func fetchGitHubProfile(ctx context.Context, client *http.Client) ([]byte, error) {
+	var user githubUser
+	if err := getGitHubJSON(ctx, client, githubUserURL, &user); err != nil {
+		return nil, err
+	}
+	if user.ID == 0 {
+		return nil, fmt.Errorf("github user has no id")
+	}
+
+	// the addresses are not returned without the user:email scope, the
+	// public address being used then
+	var emails []githubEmail
+	if err := getGitHubJSON(ctx, client, githubEmailsURL, &emails); err != nil {
+		emails = nil
+	}
+
+	return json.Marshal(user.userInfo(emails))
+}
+
+// getGitHubJSON decodes the response of the GitHub API at rawURL into v.
+func getGitHubJSON(ctx context.Context, client *http.Client, rawURL string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return fmt.Errorf("could not create github request: %v", err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not call github: %w", err)
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("could not read github response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("github returned %s: %s", resp.Status, b)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("could not decode github response: %v", err)
+	}
+	return nil
+}
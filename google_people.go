@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+const (
+	googleTokenInfoURL = "https://oauth2.googleapis.com/tokeninfo"
+	googlePeopleURL    = "https://people.googleapis.com/v1/people/me"
+	// peopleProfileTTL is how long the enriched profiles are cached.
+	peopleProfileTTL = time.Hour
+)
+
+// peopleFieldScopes maps the People API person fields to the scopes, any of
+// which grants access to them.
+var peopleFieldScopes = map[string][]string{
+	"organizations": {"https://www.googleapis.com/auth/user.organization.read", "https://www.googleapis.com/auth/contacts.readonly"},
+	"birthdays":     {"https://www.googleapis.com/auth/user.birthday.read", "https://www.googleapis.com/auth/contacts.readonly"},
+	"addresses":     {"https://www.googleapis.com/auth/user.addresses.read", "https://www.googleapis.com/auth/contacts.readonly"},
+}
+
+// PeopleProfile is the profile data read from the Google People API. Fields
+// whose scope was not granted are left empty.
+type PeopleProfile struct {
+	Organization string   `json:"organization,omitempty"`
+	JobTitle     string   `json:"job_title,omitempty"`
+	Birthday     string   `json:"birthday,omitempty"`
+	Addresses    []string `json:"addresses,omitempty"`
+}
+
+// person is the subset of a People API person used.
+type person struct {
+	Organizations []struct {
+		Name  string `json:"name"`
+		Title string `json:"title"`
+	} `json:"organizations"`
+	Birthdays []struct {
+		Date struct {
+			Year  int `json:"year"`
+			Month int `json:"month"`
+			Day   int `json:"day"`
+		} `json:"date"`
+	} `json:"birthdays"`
+	Addresses []struct {
+		FormattedValue string `json:"formattedValue"`
+	} `json:"addresses"`
+}
+
+// PeopleClient reads profiles from the Google People API, caching them.
+type PeopleClient struct {
+	client *http.Client
+
+	mu       sync.Mutex
+	profiles map[string]cachedPeopleProfile
+}
+
+// cachedPeopleProfile is a profile cached until expires.
+type cachedPeopleProfile struct {
+	profile *PeopleProfile
+	expires time.Time
+}
+
+// NewPeopleClient creates a People API client sending its requests through
+// transport.
+func NewPeopleClient(transport http.RoundTripper) *PeopleClient {
+	return &PeopleClient{
+		client:   &http.Client{Transport: transport, Timeout: 5 * time.Second},
+		profiles: make(map[string]cachedPeopleProfile),
+	}
+}
+
+// Profile returns the profile of the user sub, read with their Google token.
+// Only the fields allowed by the granted scopes are requested, so a missing
+// scope degrades to an emptier profile instead of an error.
+func (c *PeopleClient) Profile(ctx context.Context, sub string, token *oauth2.Token) (*PeopleProfile, error) {
+	c.mu.Lock()
+	cached, ok := c.profiles[sub]
+	c.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.profile, nil
+	}
+
+	scopes, err := c.grantedScopes(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []string
+	for field, fieldScopes := range peopleFieldScopes {
+		for _, scope := range fieldScopes {
+			if scopes[scope] {
+				fields = append(fields, field)
+				break
+			}
+		}
+	}
+
+	profile := &PeopleProfile{}
+	if len(fields) > 0 {
+		var p person
+		if err := c.get(ctx, token, googlePeopleURL, url.Values{"personFields": {strings.Join(fields, ",")}}, &p); err != nil {
+			return nil, err
+		}
+
+		if len(p.Organizations) > 0 {
+			profile.Organization, profile.JobTitle = p.Organizations[0].Name, p.Organizations[0].Title
+		}
+		if len(p.Birthdays) > 0 {
+			profile.Birthday = formatBirthday(p.Birthdays[0].Date.Year, p.Birthdays[0].Date.Month, p.Birthdays[0].Date.Day)
+		}
+		for _, address := range p.Addresses {
+			if address.FormattedValue != "" {
+				profile.Addresses = append(profile.Addresses, address.FormattedValue)
+			}
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for k, cached := range c.profiles {
+		if now.After(cached.expires) {
+			delete(c.profiles, k)
+		}
+	}
+	c.profiles[sub] = cachedPeopleProfile{profile: profile, expires: now.Add(peopleProfileTTL)}
+
+	return profile, nil
+}
+
+// grantedScopes returns the scopes granted to token.
+func (c *PeopleClient) grantedScopes(ctx context.Context, token *oauth2.Token) (map[string]bool, error) {
+	var info struct {
+		Scope string `json:"scope"`
+	}
+	if err := c.get(ctx, nil, googleTokenInfoURL, url.Values{"access_token": {token.AccessToken}}, &info); err != nil {
+		return nil, err
+	}
+
+	scopes := make(map[string]bool)
+	for _, scope := range strings.Fields(info.Scope) {
+		scopes[scope] = true
+	}
+	return scopes, nil
+}
+
+// get calls a Google API, authenticated with token when set, and decodes the
+// JSON response into v.
+func (c *PeopleClient) get(ctx context.Context, token *oauth2.Token, endpoint string, query url.Values, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return fmt.Errorf("could not create Google API request: %v", err)
+	}
+	if token != nil {
+		token.SetAuthHeader(req)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not call Google API: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("could not read Google API response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Google API %s: %s: %s", req.URL.Path, resp.Status, body)
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("could not decode Google API response: %v", err)
+	}
+	return nil
+}
+
+// formatBirthday formats a People API date, whose year may be missing.
+func formatBirthday(year, month, day int) string {
+	if month == 0 || day == 0 {
+		return ""
+	}
+	if year == 0 {
+		return time.Date(2000, time.Month(month), day, 0, 0, 0, 0, time.UTC).Format("January 2")
+	}
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC).Format("January 2, 2006")
+}
+
+// peopleProfile returns the People API profile of the signed in user, or
+// nil when the People API is disabled or cannot be reached.
+func (s *Server) peopleProfile(ctx *gin.Context) *PeopleProfile {
+	if s.people == nil {
+		return nil
+	}
+
+	u, ok := currentUser(ctx)
+	if !ok {
+		return nil
+	}
+
+	token, err := s.GoogleToken(ctx)
+	if err == nil {
+		var profile *PeopleProfile
+		if profile, err = s.people.Profile(ctx, u.Sub, token); err == nil {
+			return profile
+		}
+	}
+
+	slog.ErrorContext(ctx, "could not read People API profile", "err", err)
+	return nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	googleDirectoryGroupsURL = "https://admin.googleapis.com/admin/directory/v1/groups"
+	// groupsTTL is how long the groups read from the Directory API are
+	// cached.
+	groupsTTL = 10 * time.Minute
+)
+
+// GroupResolver returns the Google Workspace groups of the signed in user,
+// by email address.
+//
+// Groups are read from the claim named claim of the user information when
+// set, which an auth0 action can add, or else from the Directory API with the
+// Google token of the user, which needs the
+// admin.directory.group.readonly scope.
+type GroupResolver struct {
+	claim  string
+	server *Server
+
+	mu     sync.Mutex
+	groups map[string]cachedGroups
+}
+
+// cachedGroups are the groups of a user cached until expires.
+type cachedGroups struct {
+	groups  []string
+	expires time.Time
+}
+
+// NewGroupResolver creates a resolver reading groups from claim, or from the
+// Directory API when claim is empty.
+func NewGroupResolver(server *Server, claim string) *GroupResolver {
+	return &GroupResolver{
+		claim:  claim,
+		server: server,
+		groups: make(map[string]cachedGroups),
+	}
+}
+
+// Groups returns the groups of the signed in user.
+func (r *GroupResolver) Groups(ctx *gin.Context) ([]string, error) {
+	if r.claim != "" {
+		return r.claimGroups(ctx)
+	}
+
+	u, ok := currentUser(ctx)
+	if !ok {
+		return nil, fmt.Errorf("user is not signed in")
+	}
+
+	r.mu.Lock()
+	cached, ok := r.groups[u.Sub]
+	r.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.groups, nil
+	}
+
+	token, err := r.server.GoogleToken(ctx)
+	var groups []string
+	if err == nil {
+		groups, err = directoryGroups(ctx, r.server.outbound, token.AccessToken, u.Email)
+	}
+	if err != nil {
+		// during an outage the last known groups are kept until the
+		// staleness cap
+		if ok && isIdPOutage(err) && time.Since(cached.expires) < r.server.idpHealth.maxStaleness {
+			r.server.idpHealth.failed(err)
+			markDegraded(ctx)
+			return cached.groups, nil
+		}
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for k, cached := range r.groups {
+		if now.After(cached.expires.Add(r.server.idpHealth.maxStaleness)) {
+			delete(r.groups, k)
+		}
+	}
+	r.groups[u.Sub] = cachedGroups{groups: groups, expires: now.Add(groupsTTL)}
+
+	return groups, nil
+}
+
+// claimGroups reads the groups from the verified claims saved server-side
+// at login.
+func (r *GroupResolver) claimGroups(ctx *gin.Context) ([]string, error) {
+	profile, ok := sessionProfile(ctx)
+	if !ok {
+		return nil, fmt.Errorf("user is not signed in")
+	}
+
+	var claims map[string]json.RawMessage
+	if err := json.Unmarshal(profile, &claims); err != nil {
+		return nil, fmt.Errorf("could not parse user information: %v", err)
+	}
+
+	var groups []string
+	if value, ok := claims[r.claim]; ok {
+		if err := json.Unmarshal(value, &groups); err != nil {
+			return nil, fmt.Errorf("could not parse %s claim: %v", r.claim, err)
+		}
+	}
+	return groups, nil
+}
+
+// directoryGroups lists the email addresses of the groups email is a member
+// of with the Directory API, sending the requests through transport.
+func directoryGroups(ctx context.Context, transport http.RoundTripper, accessToken, email string) ([]string, error) {
+	client := &http.Client{Transport: transport}
+	var groups []string
+	query := url.Values{"userKey": {email}, "maxResults": {"200"}}
+
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, googleDirectoryGroupsURL+"?"+query.Encode(), nil)
+		if err != nil {
+			return nil, fmt.Errorf("could not create Directory API request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("could not call Directory API: %v", err)
+		}
+
+		var page struct {
+			Groups []struct {
+				Email string `json:"email"`
+			} `json:"groups"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("Directory API: %s", resp.Status)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not decode Directory API response: %v", err)
+		}
+
+		for _, group := range page.Groups {
+			groups = append(groups, group.Email)
+		}
+
+		if page.NextPageToken == "" {
+			return groups, nil
+		}
+		query.Set("pageToken", page.NextPageToken)
+	}
+}
+
+// RequireGroups allows only members of at least one of groups, compared
+// case insensitively. It must run after IsAuthenticated.
+func (s *Server) RequireGroups(groups ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		memberOf, err := s.groups.Groups(ctx)
+		if err != nil {
+			slog.ErrorContext(ctx, "could not resolve groups", "err", err)
+		}
+
+		for _, group := range groups {
+			for _, member := range memberOf {
+				if strings.EqualFold(group, member) {
+					ctx.Next()
+					return
+				}
+			}
+		}
+
+		sub := ""
+		if u, ok := currentUser(ctx); ok {
+			sub = u.Sub
+		}
+		s.audit(ctx, auditAccessDenied, sub, map[string]string{"reason": "not_in_group"})
+		renderError(ctx, http.StatusForbidden, "Access denied", "You are not a member of a group allowed to access this page.")
+	}
+}
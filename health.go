@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// readinessCacheTTL is how long the result of the readiness checks is
+	// reused, so that frequent probes do not load the provider.
+	readinessCacheTTL = 10 * time.Second
+	// readinessCheckTimeout bounds each readiness check.
+	readinessCheckTimeout = 3 * time.Second
+)
+
+// probePaths are the paths of the health probes, served without sessions
+// and during maintenance.
+var probePaths = map[string]bool{"/healthz": true, "/livez": true, "/readyz": true}
+
+// readinessCheck checks that a dependency needed to serve requests is
+// reachable.
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// Readiness runs the readiness checks of the dependencies, caching their
+// results for readinessCacheTTL.
+type Readiness struct {
+	checks []readinessCheck
+
+	mu        sync.Mutex
+	checkedAt time.Time
+	ready     bool
+	results   map[string]string // "ok" or "unavailable" for each check
+}
+
+// NewReadiness creates a Readiness without checks, always ready.
+func NewReadiness() *Readiness {
+	return &Readiness{}
+}
+
+// Add registers the check of the dependency name.
+func (r *Readiness) Add(name string, check func(ctx context.Context) error) {
+	r.checks = append(r.checks, readinessCheck{name: name, check: check})
+}
+
+// Check runs the checks concurrently, or returns their results when they
+// ran less than readinessCacheTTL ago. It reports whether every check
+// passed.
+func (r *Readiness) Check(ctx context.Context) (bool, map[string]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.checkedAt.IsZero() && time.Since(r.checkedAt) < readinessCacheTTL {
+		return r.ready, r.results
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, readinessCheckTimeout)
+	defer cancel()
+
+	errs := make([]error, len(r.checks))
+	var wg sync.WaitGroup
+	for i, c := range r.checks {
+		wg.Add(1)
+		go func(i int, c readinessCheck) {
+			defer wg.Done()
+			errs[i] = c.check(ctx)
+		}(i, c)
+	}
+	wg.Wait()
+
+	// the errors are only logged, as the probes are not authenticated
+	ready, results := true, map[string]string{}
+	for i, c := range r.checks {
+		results[c.name] = "ok"
+		if errs[i] != nil {
+			slog.WarnContext(ctx, "readiness check failed", "check", c.name, "err", errs[i])
+			ready = false
+			results[c.name] = "unavailable"
+		}
+	}
+	r.checkedAt, r.ready, r.results = time.Now(), ready, results
+	return ready, results
+}
+
+// livenessHandler answers the liveness probes: the process is up and
+// serving requests. It checks no dependency, so that an outage of one does
+// not get the instances restarted.
+func livenessHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// readinessHandler answers the readiness probes with 503 while a dependency
+// is unreachable, so that load balancers send the requests to other
+// instances.
+func (s *Server) readinessHandler(ctx *gin.Context) {
+	ready, checks := s.readiness.Check(ctx)
+	if !ready {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "checks": checks})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"status": "ok", "checks": checks})
+}
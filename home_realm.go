@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+// homeRealmRule sends the users whose email address belongs to domain, or
+// one of its subdomains, to a connection and optionally an organization.
+type homeRealmRule struct {
+	domain       string
+	connection   string
+	organization string
+}
+
+// HomeRealm maps email domains to the connection users sign in with, so
+// corporate users go straight to their SSO.
+type HomeRealm struct {
+	rules             []homeRealmRule
+	defaultConnection string
+}
+
+// NewHomeRealm parses rules, a comma separated list of
+// domain=connection[@organization] entries. Users matching no rule sign in
+// with defaultConnection, or pick one in the auth0 login page when empty.
+func NewHomeRealm(rules, defaultConnection string) (*HomeRealm, error) {
+	realm := &HomeRealm{defaultConnection: defaultConnection}
+	for _, entry := range splitList(rules) {
+		domain, target, ok := strings.Cut(entry, "=")
+		if !ok || domain == "" || target == "" {
+			return nil, fmt.Errorf("invalid home realm rule %q, expected domain=connection[@organization]", entry)
+		}
+
+		connection, organization, _ := strings.Cut(target, "@")
+		realm.rules = append(realm.rules, homeRealmRule{
+			domain:       strings.ToLower(strings.TrimSpace(domain)),
+			connection:   strings.TrimSpace(connection),
+			organization: strings.TrimSpace(organization),
+		})
+	}
+	return realm, nil
+}
+
+// match returns the connection and organization of email.
+func (r *HomeRealm) match(email string) (connection, organization string) {
+	at := strings.LastIndex(email, "@")
+	domain := strings.ToLower(email[at+1:])
+
+	for _, rule := range r.rules {
+		if domain == rule.domain || strings.HasSuffix(domain, "."+rule.domain) {
+			return rule.connection, rule.organization
+		}
+	}
+	return r.defaultConnection, ""
+}
+
+// identifyPage renders the pre-login screen asking for the email address.
+func (s *Server) identifyPage(ctx *gin.Context) {
+	renderHTML(ctx, http.StatusOK, "identify.html", nil)
+}
+
+// identifyHandler starts the login with the connection matching the email
+// address entered in the pre-login screen.
+func (s *Server) identifyHandler(ctx *gin.Context) {
+	email := strings.TrimSpace(ctx.PostForm("email"))
+	if at := strings.LastIndex(email, "@"); at <= 0 || at == len(email)-1 {
+		renderHTML(ctx, http.StatusBadRequest, "identify.html", gin.H{
+			"Email": email,
+			"Error": "Please enter a valid email address.",
+		})
+		return
+	}
+
+	if s.throttleLogin(ctx, email) {
+		return
+	}
+	opts := []oauth2.AuthCodeOption{oauth2.SetAuthURLParam("login_hint", email)}
+	setLoginHint(ctx, email)
+	connection, organization := s.homeRealm.match(email)
+	if connection == "" {
+		connection = s.connection
+	}
+	if connection != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("connection", connection))
+		setLoginConnection(ctx, connection)
+	}
+	setLoginOrganization(ctx, organization)
+
+	s.startLogin(ctx, s.loginTenant(ctx), opts...)
+}
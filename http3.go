@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// newHTTP3Server creates the HTTP/3 server of handler, with the certificate
+// of the HTTPS listener.
+func (s *Server) newHTTP3Server(handler http.Handler) *http3.Server {
+	return &http3.Server{
+		Handler:   handler,
+		TLSConfig: http3.ConfigureTLSConfig(s.tlsConfig),
+	}
+}
+
+// advertiseHTTP3 announces with the Alt-Svc header of the responses of next
+// that the same origin is served over HTTP/3, so browsers switch to it.
+func advertiseHTTP3(server *http3.Server, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the header is only known once the UDP socket is open
+		server.SetQUICHeaders(w.Header())
+		next.ServeHTTP(w, r)
+	})
+}
+
+// listenPacket opens the UDP socket of addr, sharing the port with other
+// processes when reusePort is set.
+func listenPacket(addr string, reusePort bool) (net.PacketConn, error) {
+	config := net.ListenConfig{}
+	if reusePort {
+		config.Control = reusePortControl
+	}
+
+	return config.ListenPacket(context.Background(), "udp", addr)
+}
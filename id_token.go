@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/coreos/go-oidc"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+// idTokenProtocolClaims are the ID token claims which only serve its
+// validation, left out of the user information saved at login.
+var idTokenProtocolClaims = []string{"iss", "aud", "azp", "exp", "iat", "nbf", "nonce", "at_hash", "c_hash"}
+
+// verifyIDToken verifies the signature, issuer, audience and time claims of
+// the ID token returned with accessToken by the tenant of the login, that
+// it carries the nonce of the login and that its at_hash, when present,
+// matches the access token.
+func (s *Server) verifyIDToken(ctx *gin.Context, rawIDToken, accessToken, nonce string) (*oidc.IDToken, error) {
+	tenant := s.tenant(ctx)
+	verifier := tenant.provider.Verifier(&oidc.Config{ClientID: tenant.oauth2config.ClientID}, s.clockSkew)
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+
+	// no nonce is expected from the logins in flight during an upgrade
+	if nonce != "" && subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(nonce)) != 1 {
+		return nil, errors.New("id token nonce does not match the login")
+	}
+
+	if idToken.AccessTokenHash != "" {
+		if err := idToken.VerifyAccessToken(accessToken); err != nil {
+			return nil, err
+		}
+	}
+	return idToken, nil
+}
+
+// callbackIDToken returns the ID token returned with token by the code
+// exchange of the callback, decrypted when needed, and the profile its
+// verified claims describe. It responds and reports false when the token is
+// missing or invalid.
+func (s *Server) callbackIDToken(ctx *gin.Context, tenant *tenant, token *oauth2.Token) (string, []byte, bool) {
+	// keep the raw id token in session so it can be sent as id_token_hint
+	// on logout
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, "no id_token field in oauth2 token")
+		return "", nil, false
+	}
+
+	// encrypted ID tokens are decrypted before they are used, the signed
+	// token they hold being kept
+	var err error
+	if s.idTokenKeys != nil && tenant.isAuth0() {
+		rawIDToken, err = s.idTokenKeys.decrypt(rawIDToken)
+		if err != nil {
+			slog.ErrorContext(ctx, "could not decrypt ID token", "err", err)
+			ctx.JSON(http.StatusInternalServerError, "could not decrypt id token")
+			return "", nil, false
+		}
+	} else if isEncryptedJWT(rawIDToken) {
+		slog.ErrorContext(ctx, "ID token is encrypted but AUTH0_ID_TOKEN_DECRYPTION_KEY_FILE is not set")
+		ctx.JSON(http.StatusInternalServerError, "could not decrypt id token")
+		return "", nil, false
+	}
+
+	// the user is only known from a verified ID token
+	nonce, _ := sessions.Default(ctx).Get("nonce").(string)
+	idToken, err := s.verifyIDToken(ctx, rawIDToken, token.AccessToken, nonce)
+	if err != nil {
+		slog.WarnContext(ctx, "invalid ID token", "err", err)
+		s.audit(ctx, auditLoginFailure, "", map[string]string{"reason": "invalid_id_token"})
+		s.loginFailed(ctx)
+		ctx.JSON(http.StatusInternalServerError, "could not verify id token")
+		return "", nil, false
+	}
+
+	// GOOGLE_HOSTED_DOMAINS only lets the accounts of the organization in
+	if err := checkHostedDomain(tenant, idToken); err != nil {
+		slog.WarnContext(ctx, "account not allowed", "err", err)
+		s.audit(ctx, auditLoginFailure, idToken.Subject, map[string]string{"reason": "hosted_domain_not_allowed"})
+		s.loginFailed(ctx)
+		renderError(ctx, http.StatusForbidden, "Account not allowed", "Please sign in with an account of your organization.")
+		return "", nil, false
+	}
+
+	// the organization the auth0 login was started with must be the one
+	// the token is issued for
+	session := sessions.Default(ctx)
+	var organization Organization
+	if tenant.isAuth0() {
+		expected, _ := session.Get("login_organization").(string)
+		if organization, err = checkOrganization(expected, idToken); err != nil {
+			slog.WarnContext(ctx, "organization not allowed", "err", err)
+			s.audit(ctx, auditLoginFailure, idToken.Subject, map[string]string{"reason": "organization_mismatch"})
+			s.loginFailed(ctx)
+			renderError(ctx, http.StatusForbidden, "Organization not allowed", "Please sign in to the organization you were invited to.")
+			return "", nil, false
+		}
+	}
+	saveOrganization(session, organization)
+
+	b, err := idTokenProfile(idToken)
+	if err == nil && tenant.kind == providerEntra {
+		b, err = entraProfile(b)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "could not parse user information", "err", err)
+		ctx.JSON(http.StatusInternalServerError, "could not parse user information")
+		return "", nil, false
+	}
+	return rawIDToken, b, true
+}
+
+// idTokenProfile returns the claims of a verified ID token describing the
+// user, standard and custom, as a JSON object.
+func idTokenProfile(idToken *oidc.IDToken) ([]byte, error) {
+	var claims map[string]json.RawMessage
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("could not parse id token claims: %v", err)
+	}
+	for _, name := range idTokenProtocolClaims {
+		delete(claims, name)
+	}
+	return json.Marshal(claims)
+}
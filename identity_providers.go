@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/coreos/go-oidc"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+// Kinds of identity providers.
+const (
+	providerAuth0  = "auth0"
+	providerGoogle = "google"
+	providerGitHub = "github"
+	providerOIDC   = "oidc"
+	providerEntra  = "entra"
+)
+
+// googleIssuer is the issuer of the Google ID tokens, serving the discovery
+// document.
+const googleIssuer = "accounts.google.com"
+
+// defaultProviderScopes are the scopes requested from each kind of provider
+// when PROVIDER_<NAME>_SCOPES is not set.
+var defaultProviderScopes = map[string][]string{
+	providerGoogle: {oidc.ScopeOpenID, "profile", "email"},
+	providerGitHub: {"read:user", "user:email"},
+	providerOIDC:   {oidc.ScopeOpenID, "profile", "email"},
+	providerEntra:  {oidc.ScopeOpenID, "profile", "email", "offline_access"},
+}
+
+// providerLabels are the names shown on the sign in buttons of the
+// providers without PROVIDER_<NAME>_LABEL.
+var providerLabels = map[string]string{
+	providerGoogle: "Google",
+	providerGitHub: "GitHub",
+	providerEntra:  "Microsoft",
+}
+
+// LoginProvider is a provider listed on the home page.
+type LoginProvider struct {
+	Name  string // name in the login URL
+	Kind  string
+	Label string
+}
+
+// loadIdentityProviders creates the providers of configs, by name. The
+// OpenID Connect ones are discovered, GitHub signing in with plain OAuth2.
+func loadIdentityProviders(ctx context.Context, configs []ProviderConfig) (map[string]*tenant, error) {
+	providers := make(map[string]*tenant, len(configs))
+	for _, c := range configs {
+		var provider *Provider
+		var err error
+		switch c.kind() {
+		case providerGoogle:
+			provider, err = NewProvider(ctx, googleIssuer, "")
+		case providerOIDC:
+			provider, err = NewProvider(ctx, strings.TrimPrefix(c.Issuer, "https://"), "")
+		case providerEntra:
+			provider, err = newEntraProvider(ctx, c.Tenant)
+		case providerGitHub:
+			provider = newGitHubProvider()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not create provider %s: %v", c.Name, err)
+		}
+
+		scopes := c.Scopes
+		if len(scopes) == 0 {
+			scopes = defaultProviderScopes[c.kind()]
+		}
+		label := c.Label
+		if label == "" {
+			label = providerLabels[c.kind()]
+		}
+		if label == "" {
+			label = c.Name
+		}
+
+		providers[c.Name] = &tenant{
+			name:     c.Name,
+			kind:     c.kind(),
+			label:    label,
+			hosted:   hostedDomains(c.HostedDomains),
+			provider: provider,
+			oauth2config: &oauth2.Config{
+				ClientID:     c.ClientID,
+				ClientSecret: c.ClientSecret,
+				Endpoint:     provider.Endpoint(),
+				Scopes:       scopes,
+			},
+			healthy: 1,
+		}
+	}
+	return providers, nil
+}
+
+// hostedDomains returns the Google Workspace domains of domains in lower
+// case.
+func hostedDomains(domains []string) []string {
+	var hosted []string
+	for _, domain := range domains {
+		hosted = append(hosted, strings.ToLower(domain))
+	}
+	return hosted
+}
+
+// hostedDomainOptions returns the hd parameter asking Google to only offer
+// the accounts of t's hosted domains: the domain when there is one, any
+// Workspace account otherwise. The hd claim is still checked.
+func hostedDomainOptions(t *tenant) []oauth2.AuthCodeOption {
+	switch len(t.hosted) {
+	case 0:
+		return nil
+	case 1:
+		return []oauth2.AuthCodeOption{oauth2.SetAuthURLParam("hd", t.hosted[0])}
+	default:
+		return []oauth2.AuthCodeOption{oauth2.SetAuthURLParam("hd", "*")}
+	}
+}
+
+// checkHostedDomain checks that the hd claim of idToken, the Google
+// Workspace domain of the account, is one of t's hosted domains, if any.
+func checkHostedDomain(t *tenant, idToken *oidc.IDToken) error {
+	if len(t.hosted) == 0 {
+		return nil
+	}
+
+	var claims struct {
+		HostedDomain string `json:"hd"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return fmt.Errorf("could not parse id token claims: %v", err)
+	}
+	if !containsString(t.hosted, strings.ToLower(claims.HostedDomain)) {
+		return fmt.Errorf("hosted domain %q is not allowed", claims.HostedDomain)
+	}
+	return nil
+}
+
+// isAuth0 reports whether t is an auth0 tenant, the only one supporting the
+// auth0 specific features: JARM, DPoP, resources and encrypted ID tokens.
+func (t *tenant) isAuth0() bool {
+	return t.kind == providerAuth0
+}
+
+// hasIDToken reports whether the logins with t return an ID token.
+func (t *tenant) hasIDToken() bool {
+	return t.kind != providerGitHub
+}
+
+// revalidates reports whether the claims of the sessions of t can be
+// fetched again from its userinfo endpoint. GitHub has none, and the one of
+// Entra ID returns the sub of the application instead of the object ID.
+func (t *tenant) revalidates() bool {
+	return t.kind != providerGitHub && t.kind != providerEntra
+}
+
+// loginProviders returns the providers other than the primary one, sorted
+// by name. The sites only sign in with their tenant.
+func (s *Server) loginProviders(ctx *gin.Context) []LoginProvider {
+	if s.site(ctx) != nil {
+		return nil
+	}
+	var providers []LoginProvider
+	for name, t := range s.providers {
+		if !t.primary {
+			providers = append(providers, LoginProvider{Name: name, Kind: t.kind, Label: t.label})
+		}
+	}
+	sort.Slice(providers, func(i, j int) bool { return providers[i].Name < providers[j].Name })
+	return providers
+}
+
+// providerLoginHandler starts the login with the provider named in the path,
+// /login/auth0 (or /login/google without auth0) being the default login.
+func (s *Server) providerLoginHandler(ctx *gin.Context) {
+	t, ok := s.providers[ctx.Param("provider")]
+	if ok && t.primary {
+		s.loginHandler(ctx)
+		return
+	}
+	if !ok || s.site(ctx) != nil {
+		renderError(ctx, http.StatusNotFound, "Unknown sign in method", "This sign in method is not available.")
+		return
+	}
+	setLoginHint(ctx, "")
+
+	if raw := ctx.Query("returnTo"); raw != "" {
+		if returnTo, ok := s.allowedReturnTo(raw); ok {
+			sessions.Default(ctx).Set("return_to", returnTo)
+		}
+	}
+
+	s.startLogin(ctx, t)
+}
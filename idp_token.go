@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// googleConnection is the auth0 connection of Google accounts.
+	googleConnection = "google-oauth2"
+	// idpTokenTTL is how long upstream tokens are cached, to spare the
+	// Management API rate limit.
+	idpTokenTTL = 5 * time.Minute
+)
+
+// userIdentity is an identity linked to an auth0 user, holding the tokens
+// issued by the upstream identity provider at login.
+type userIdentity struct {
+	Provider    string `json:"provider"`
+	Connection  string `json:"connection"`
+	UserID      string `json:"user_id"`
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// IdentityProviderToken returns the access token issued to the user by the
+// upstream identity provider of connection. The Management API only returns
+// it to clients granted the read:user_idp_tokens scope.
+func (m *ManagementClient) IdentityProviderToken(ctx context.Context, userID, connection string) (*oauth2.Token, error) {
+	var user struct {
+		Identities []userIdentity `json:"identities"`
+	}
+	query := url.Values{"fields": {"identities"}, "include_fields": {"true"}}
+	if err := m.get(ctx, "/users/"+url.PathEscape(userID), query, &user); err != nil {
+		return nil, err
+	}
+
+	for _, identity := range user.Identities {
+		if identity.Connection != connection {
+			continue
+		}
+		if identity.AccessToken == "" {
+			return nil, fmt.Errorf("no %s access token returned, is read:user_idp_tokens granted?", connection)
+		}
+
+		token := &oauth2.Token{AccessToken: identity.AccessToken, TokenType: "Bearer"}
+		if identity.ExpiresIn > 0 {
+			token.Expiry = time.Now().Add(time.Duration(identity.ExpiresIn) * time.Second)
+		}
+		return token, nil
+	}
+
+	return nil, fmt.Errorf("user has no %s identity", connection)
+}
+
+// idpTokenCache caches the upstream tokens of the users by user and
+// connection.
+type idpTokenCache struct {
+	mu     sync.Mutex
+	tokens map[string]cachedIDPToken
+}
+
+// cachedIDPToken is an upstream token cached until expires.
+type cachedIDPToken struct {
+	token   *oauth2.Token
+	expires time.Time
+}
+
+// newIDPTokenCache creates an empty cache.
+func newIDPTokenCache() *idpTokenCache {
+	return &idpTokenCache{tokens: make(map[string]cachedIDPToken)}
+}
+
+// get returns the cached token for key, if still valid.
+func (c *idpTokenCache) get(key string) (*oauth2.Token, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.tokens[key]
+	if !ok || time.Now().After(cached.expires) || !cached.token.Valid() {
+		delete(c.tokens, key)
+		return nil, false
+	}
+	return cached.token, true
+}
+
+// put caches token for key for up to idpTokenTTL.
+func (c *idpTokenCache) put(key string, token *oauth2.Token) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for k, cached := range c.tokens {
+		if now.After(cached.expires) {
+			delete(c.tokens, k)
+		}
+	}
+
+	expires := now.Add(idpTokenTTL)
+	if !token.Expiry.IsZero() && token.Expiry.Before(expires) {
+		expires = token.Expiry
+	}
+	c.tokens[key] = cachedIDPToken{token: token, expires: expires}
+}
+
+// GoogleToken returns the Google access token of the signed in user, to call
+// Google APIs on their behalf.
+func (s *Server) GoogleToken(ctx *gin.Context) (*oauth2.Token, error) {
+	u, ok := currentUser(ctx)
+	if !ok {
+		return nil, fmt.Errorf("user is not signed in")
+	}
+
+	// the users signed in with Google directly have its token in the session
+	if s.tenant(ctx).kind == providerGoogle {
+		tokens, ok := sessionTokens(ctx)
+		if !ok || tokens.AccessToken == "" {
+			return nil, fmt.Errorf("session has no Google token")
+		}
+		return &oauth2.Token{AccessToken: tokens.AccessToken, TokenType: "Bearer", Expiry: tokens.Expiry}, nil
+	}
+
+	key := googleConnection + "\x00" + u.Sub
+	if token, ok := s.idpTokens.get(key); ok {
+		return token, nil
+	}
+
+	result, err := s.calls.Do(ctx, "idp_token\x00"+key, func() (interface{}, error) {
+		return s.management.IdentityProviderToken(ctx, u.Sub, googleConnection)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not get Google token: %w", err)
+	}
+	token := result.(*oauth2.Token)
+
+	s.idpTokens.put(key, token)
+	return token, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// requestObjectSigner signs the authorization parameters as a request object
+// (JAR, RFC 9101).
+//
+// The key file is expected to be kept up to date by a secret manager, such
+// as a mounted Kubernetes secret or a Vault agent: it is reloaded when it
+// changes and its key ID is the RFC 7638 thumbprint of the key, so a rotated
+// key is advertised under a new kid without further configuration.
+type requestObjectSigner struct {
+	file string
+
+	mu      sync.Mutex
+	modTime time.Time
+	signer  jose.Signer
+}
+
+// newRequestObjectSigner creates a signer using the PEM encoded RSA or EC
+// private key in file.
+func newRequestObjectSigner(file string) (*requestObjectSigner, error) {
+	r := &requestObjectSigner{file: file}
+	if _, err := r.current(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// current returns the signer of the current key, reloading the key file
+// when it was modified.
+func (r *requestObjectSigner) current() (jose.Signer, error) {
+	info, err := os.Stat(r.file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read request object key: %v", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.signer != nil && info.ModTime().Equal(r.modTime) {
+		return r.signer, nil
+	}
+
+	key, algorithm, err := loadSigningKey(r.file)
+	if err != nil {
+		// keep signing with the previous key while a new one is written
+		if r.signer != nil {
+			return r.signer, nil
+		}
+		return nil, fmt.Errorf("could not load request object key: %v", err)
+	}
+
+	thumbprint, err := (&jose.JSONWebKey{Key: key.Public()}).Thumbprint(crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("could not compute request object key ID: %v", err)
+	}
+	keyID := base64.RawURLEncoding.EncodeToString(thumbprint)
+
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: algorithm, Key: key},
+		(&jose.SignerOptions{}).WithType("oauth-authz-req+jwt").WithHeader("kid", keyID),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not create request object signer: %v", err)
+	}
+
+	r.modTime, r.signer = info.ModTime(), signer
+	return signer, nil
+}
+
+// wrap returns the authorization parameters replacing params: the
+// request object holding them, along with the client_id, response_type and
+// scope which OpenID Connect requires outside of it.
+func (r *requestObjectSigner) wrap(params url.Values, clientID, audience string) (url.Values, error) {
+	signer, err := r.current()
+	if err != nil {
+		return nil, err
+	}
+
+	jti, err := generateRandomString()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss": clientID,
+		"aud": audience,
+		"jti": jti,
+		"iat": now.Unix(),
+		"nbf": now.Unix(),
+		"exp": now.Add(5 * time.Minute).Unix(),
+	}
+	for name, values := range params {
+		if len(values) == 1 {
+			claims[name] = values[0]
+		} else {
+			claims[name] = values
+		}
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	signed, err := signer.Sign(payload)
+	if err != nil {
+		return nil, fmt.Errorf("could not sign request object: %v", err)
+	}
+
+	request, err := signed.CompactSerialize()
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped := url.Values{}
+	wrapped.Set("client_id", clientID)
+	wrapped.Set("response_type", params.Get("response_type"))
+	wrapped.Set("scope", params.Get("scope"))
+	wrapped.Set("request", request)
+	return wrapped, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/coreos/go-oidc"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+// jarmResponseMode asks the provider to return the authorization response as
+// a signed JWT in the response query parameter (JARM).
+const jarmResponseMode = "query.jwt"
+
+// jarmAuthCodeOption requests a JWT secured authorization response.
+var jarmAuthCodeOption = oauth2.SetAuthURLParam("response_mode", jarmResponseMode)
+
+// jarmResponse holds the authorization response parameters carried by a JARM
+// response JWT.
+type jarmResponse struct {
+	Code             string `json:"code"`
+	State            string `json:"state"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// unwrapJARMResponse verifies the response JWT of the callback against the
+// provider keys, the issuer, the client ID and the expiry, and replaces the
+// callback query with the authorization response it carries, so code and
+// state are only trusted once the JWT is verified.
+func (s *Server) unwrapJARMResponse(ctx *gin.Context) error {
+	raw := ctx.Request.URL.Query().Get("response")
+	if raw == "" {
+		return fmt.Errorf("callback has no response parameter")
+	}
+
+	tenant := s.tenant(ctx)
+	verifier := tenant.provider.Verifier(&oidc.Config{ClientID: tenant.oauth2config.ClientID}, s.clockSkew)
+	token, err := verifier.Verify(ctx, raw)
+	if err != nil {
+		return fmt.Errorf("could not verify authorization response: %v", err)
+	}
+
+	var response jarmResponse
+	if err := token.Claims(&response); err != nil {
+		return fmt.Errorf("could not parse authorization response: %v", err)
+	}
+
+	query := url.Values{}
+	for name, value := range map[string]string{
+		"code":              response.Code,
+		"state":             response.State,
+		"error":             response.Error,
+		"error_description": response.ErrorDescription,
+	} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	ctx.Request.URL.RawQuery = query.Encode()
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestUnwrapJARMResponse(t *testing.T) {
+	provider := newTestProvider(t)
+	server := newTestServer(t, testConfig(provider), "memstore")
+	response := func(change func(claims map[string]interface{})) string {
+		now := time.Now()
+		claims := map[string]interface{}{
+			"iss":   provider.URL + "/",
+			"aud":   testClientID,
+			"exp":   now.Add(time.Minute).Unix(),
+			"code":  "test-code",
+			"state": testState,
+		}
+		if change != nil {
+			change(claims)
+		}
+		return provider.sign(t, claims)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		query   url.Values
+		want    url.Values // the callback query once unwrapped
+		wantErr bool
+	}{
+		{"code", url.Values{"response": {response(nil)}}, url.Values{"code": {"test-code"}, "state": {testState}}, false},
+		{"error", url.Values{"response": {response(func(c map[string]interface{}) {
+			delete(c, "code")
+			c["error"] = "access_denied"
+			c["error_description"] = "denied"
+		})}}, url.Values{"error": {"access_denied"}, "error_description": {"denied"}, "state": {testState}}, false},
+		{"parameters outside the JWT", url.Values{"response": {response(nil)}, "code": {"other-code"}, "state": {"other-state"}},
+			url.Values{"code": {"test-code"}, "state": {testState}}, false},
+		{"no response", url.Values{"code": {"test-code"}, "state": {testState}}, nil, true},
+		{"not a JWT", url.Values{"response": {"not-a-jwt"}}, nil, true},
+		{"tampered", url.Values{"response": {response(nil) + "x"}}, nil, true},
+		{"other audience", url.Values{"response": {response(func(c map[string]interface{}) { c["aud"] = "other-client" })}}, nil, true},
+		{"other issuer", url.Values{"response": {response(func(c map[string]interface{}) { c["iss"] = "https://evil.example/" })}}, nil, true},
+		{"expired", url.Values{"response": {response(func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() })}}, nil, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+			ctx.Request = httptest.NewRequest(http.MethodGet, "/callback?"+tt.query.Encode(), nil)
+
+			err := server.unwrapJARMResponse(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unwrapJARMResponse = %v, want error %t", err, tt.wantErr)
+			}
+			if err == nil && ctx.Request.URL.RawQuery != tt.want.Encode() {
+				t.Errorf("query = %q, want %q", ctx.Request.URL.RawQuery, tt.want.Encode())
+			}
+		})
+	}
+}
+
+func TestCallbackRejectsUnsecuredResponse(t *testing.T) {
+	provider := newTestProvider(t)
+	server := newTestServer(t, testConfig(provider), "memstore")
+	server.jarm = true
+	startTestLogin(server)
+	server.router.GET("/callback", server.callbackHandler)
+
+	// the plain code and state of the callback are not trusted
+	w := serve(server, "/test/login")
+	req := httptest.NewRequest(http.MethodGet, callbackURL(), nil)
+	req.Header.Set("Accept", "application/json")
+	req.AddCookie(responseCookie(w, sessionCookieName))
+	w = httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("callback = %d %s, want %d", w.Code, w.Body, http.StatusBadRequest)
+	}
+}
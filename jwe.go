@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// idTokenDecrypter decrypts ID tokens issued as nested JWTs, signed and then
+// encrypted to the client (JWE).
+type idTokenDecrypter struct {
+	keys []jose.JSONWebKey
+}
+
+// newIDTokenDecrypter loads the decryption keys from file, holding either a
+// PEM encoded RSA or EC private key or a JWKS of private keys. alg is the key
+// management algorithm of a PEM key, RSA-OAEP-256 or ECDH-ES by default.
+func newIDTokenDecrypter(file, alg string) (*idTokenDecrypter, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read ID token decryption key: %v", err)
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		var keySet jose.JSONWebKeySet
+		if err := json.Unmarshal(data, &keySet); err != nil {
+			return nil, fmt.Errorf("could not parse ID token decryption JWKS: %v", err)
+		}
+		if len(keySet.Keys) == 0 {
+			return nil, fmt.Errorf("ID token decryption JWKS has no keys")
+		}
+		return &idTokenDecrypter{keys: keySet.Keys}, nil
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("ID token decryption key is neither a JWKS nor PEM encoded")
+	}
+
+	key, err := parsePrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse ID token decryption key: %v", err)
+	}
+
+	if alg == "" {
+		switch key.(type) {
+		case *rsa.PrivateKey:
+			alg = string(jose.RSA_OAEP_256)
+		case *ecdsa.PrivateKey:
+			alg = string(jose.ECDH_ES)
+		default:
+			return nil, fmt.Errorf("unsupported ID token decryption key type %T", key)
+		}
+	}
+
+	return &idTokenDecrypter{keys: []jose.JSONWebKey{{Key: key, Algorithm: alg}}}, nil
+}
+
+// isEncryptedJWT reports whether raw uses the JWE compact serialization,
+// which has five parts where a signed JWT has three.
+func isEncryptedJWT(raw string) bool {
+	return strings.Count(raw, ".") == 4
+}
+
+// decrypt decrypts the encrypted ID token raw and returns the signed JWT it
+// holds, to be verified as any other ID token.
+func (d *idTokenDecrypter) decrypt(raw string) (string, error) {
+	if !isEncryptedJWT(raw) {
+		return "", fmt.Errorf("ID token is not encrypted but a decryption key is configured")
+	}
+
+	jwe, err := jose.ParseEncrypted(raw)
+	if err != nil {
+		return "", fmt.Errorf("could not parse encrypted ID token: %v", err)
+	}
+
+	header := jwe.Header
+	var algorithms []string
+	for _, key := range d.keys {
+		if header.KeyID != "" && key.KeyID != "" && key.KeyID != header.KeyID {
+			continue
+		}
+		if key.Algorithm != "" && key.Algorithm != header.Algorithm {
+			algorithms = append(algorithms, key.Algorithm)
+			continue
+		}
+
+		payload, err := jwe.Decrypt(key.Key)
+		if err != nil {
+			return "", fmt.Errorf("could not decrypt ID token: %v", err)
+		}
+		return string(payload), nil
+	}
+
+	if len(algorithms) > 0 {
+		return "", fmt.Errorf("ID token is encrypted with %s but the decryption key expects %s", header.Algorithm, strings.Join(algorithms, ", "))
+	}
+	return "", fmt.Errorf("no decryption key matches the ID token key ID %q", header.KeyID)
+}
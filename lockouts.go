@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Lockout reasons reported by the admin API.
+const (
+	lockoutBruteForce   = "brute_force"   // too many failed logins for an identifier from an IP
+	lockoutSuspiciousIP = "suspicious_ip" // too many failed logins from an IP for any identifier
+)
+
+// Lockout is a login block set by the attack protection of auth0. auth0
+// does not report when blocks expire, they last until the user resets their
+// password or the block is cleared, so ExpiresAt is null.
+type Lockout struct {
+	Reason     string     `json:"reason"`
+	Identifier string     `json:"identifier,omitempty"` // email or username
+	IP         string     `json:"ip"`
+	Connection string     `json:"connection,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
+
+// userBlocks is the response of the user blocks endpoints.
+type userBlocks struct {
+	BlockedFor []struct {
+		Identifier string `json:"identifier"`
+		IP         string `json:"ip"`
+		Connection string `json:"connection"`
+	} `json:"blocked_for"`
+}
+
+// blockPath returns the user blocks path and query for a user ID or an
+// identifier.
+func blockPath(userID, identifier string) (string, url.Values) {
+	if userID != "" {
+		return "/user-blocks/" + url.PathEscape(userID), nil
+	}
+	return "/user-blocks", url.Values{"identifier": {identifier}}
+}
+
+// UserLockouts lists the brute force blocks of the user userID or, when empty,
+// of the login identifier.
+func (m *ManagementClient) UserLockouts(ctx context.Context, userID, identifier string) ([]Lockout, error) {
+	path, query := blockPath(userID, identifier)
+	var blocks userBlocks
+	if err := m.get(ctx, path, query, &blocks); err != nil {
+		return nil, err
+	}
+
+	lockouts := make([]Lockout, 0, len(blocks.BlockedFor))
+	for _, block := range blocks.BlockedFor {
+		lockouts = append(lockouts, Lockout{
+			Reason:     lockoutBruteForce,
+			Identifier: block.Identifier,
+			IP:         block.IP,
+			Connection: block.Connection,
+		})
+	}
+	return lockouts, nil
+}
+
+// ClearUserLockouts removes the brute force blocks of the user userID or,
+// when empty, of the login identifier.
+func (m *ManagementClient) ClearUserLockouts(ctx context.Context, userID, identifier string) error {
+	path, query := blockPath(userID, identifier)
+	return m.do(ctx, http.MethodDelete, path, query, nil, nil)
+}
+
+// IPLockout returns the suspicious IP block of ip, or nil when the IP is not
+// blocked.
+func (m *ManagementClient) IPLockout(ctx context.Context, ip string) (*Lockout, error) {
+	err := m.get(ctx, "/anomaly/blocks/ips/"+url.PathEscape(ip), nil, nil)
+	var managementErr *ManagementError
+	if errors.As(err, &managementErr) && managementErr.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Lockout{Reason: lockoutSuspiciousIP, IP: ip}, nil
+}
+
+// ClearIPLockout removes the suspicious IP block of ip.
+func (m *ManagementClient) ClearIPLockout(ctx context.Context, ip string) error {
+	return m.do(ctx, http.MethodDelete, "/anomaly/blocks/ips/"+url.PathEscape(ip), nil, nil, nil)
+}
+
+// lockoutSubject reads the user (a user ID) or identifier (an email or
+// username) query parameter lockouts are looked up by.
+func lockoutSubject(ctx *gin.Context) (userID, identifier string, ok bool) {
+	userID, identifier = ctx.Query("user"), ctx.Query("identifier")
+	if (userID == "") == (identifier == "") {
+		abortWithError(ctx, http.StatusBadRequest, "invalid_request", "exactly one of user or identifier is required")
+		return "", "", false
+	}
+	return userID, identifier, true
+}
+
+// lockoutIP reads the ip path parameter.
+func lockoutIP(ctx *gin.Context) (string, bool) {
+	ip := ctx.Param("ip")
+	if net.ParseIP(ip) == nil {
+		abortWithError(ctx, http.StatusBadRequest, "invalid_request", "ip must be an IPv4 or IPv6 address")
+		return "", false
+	}
+	return ip, true
+}
+
+// managementFailure responds to a failed Management API call.
+func managementFailure(ctx *gin.Context, message string, err error) {
+	slog.ErrorContext(ctx, message, "err", err)
+	abortWithError(ctx, http.StatusBadGateway, "upstream_error", message)
+}
+
+// lockoutsHandler lists the brute force blocks of a user or identifier,
+// along with the suspicious IP block of each of the IPs.
+func (s *Server) lockoutsHandler(ctx *gin.Context) {
+	userID, identifier, ok := lockoutSubject(ctx)
+	if !ok {
+		return
+	}
+
+	lockouts, err := s.management.UserLockouts(ctx, userID, identifier)
+	if err != nil {
+		managementFailure(ctx, "could not list lockouts", err)
+		return
+	}
+
+	seen := make(map[string]bool)
+	for _, lockout := range lockouts {
+		if lockout.IP == "" || seen[lockout.IP] {
+			continue
+		}
+		seen[lockout.IP] = true
+
+		ipLockout, err := s.management.IPLockout(ctx, lockout.IP)
+		if err != nil {
+			managementFailure(ctx, "could not check IP lockout", err)
+			return
+		}
+		if ipLockout != nil {
+			lockouts = append(lockouts, *ipLockout)
+		}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"lockouts": lockouts})
+}
+
+// clearLockoutsHandler removes the brute force blocks of a user or identifier.
+func (s *Server) clearLockoutsHandler(ctx *gin.Context) {
+	userID, identifier, ok := lockoutSubject(ctx)
+	if !ok {
+		return
+	}
+
+	if err := s.management.ClearUserLockouts(ctx, userID, identifier); err != nil {
+		managementFailure(ctx, "could not clear lockouts", err)
+		return
+	}
+
+	details := map[string]string{"reason": lockoutBruteForce}
+	if identifier != "" {
+		details["identifier"] = identifier
+	}
+	s.audit(ctx, auditLockoutCleared, userID, details)
+	ctx.Status(http.StatusNoContent)
+}
+
+// ipLockoutHandler reports the suspicious IP block of an IP.
+func (s *Server) ipLockoutHandler(ctx *gin.Context) {
+	ip, ok := lockoutIP(ctx)
+	if !ok {
+		return
+	}
+
+	lockout, err := s.management.IPLockout(ctx, ip)
+	if err != nil {
+		managementFailure(ctx, "could not check IP lockout", err)
+		return
+	}
+	if lockout == nil {
+		abortWithError(ctx, http.StatusNotFound, "not_found", "the IP is not locked out")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, lockout)
+}
+
+// clearIPLockoutHandler removes the suspicious IP block of an IP.
+func (s *Server) clearIPLockoutHandler(ctx *gin.Context) {
+	ip, ok := lockoutIP(ctx)
+	if !ok {
+		return
+	}
+
+	if err := s.management.ClearIPLockout(ctx, ip); err != nil {
+		managementFailure(ctx, "could not clear IP lockout", err)
+		return
+	}
+
+	s.audit(ctx, auditLockoutCleared, "", map[string]string{"reason": lockoutSuspiciousIP, "ip": ip})
+	ctx.Status(http.StatusNoContent)
+}
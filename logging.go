@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// redacted replaces the sensitive values in the logs.
+const redacted = "[REDACTED]"
+
+// sensitiveLogKeys are the attribute keys whose values are never logged.
+var sensitiveLogKeys = []string{"token", "secret", "password", "cookie", "authorization"}
+
+// sensitiveLogPatterns match the credentials which may end up in the
+// messages, such as provider responses quoted by errors: JWTs, personal
+// access tokens, authorization header values and credential parameters.
+// The first group, such as the parameter name, is kept.
+var sensitiveLogPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`()eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]*\.[A-Za-z0-9_-]*`),
+	regexp.MustCompile(`()` + personalAccessTokenPrefix + `[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`),
+	regexp.MustCompile(`(?i)(\b(?:bearer|dpop)\s+)[A-Za-z0-9._~+/-]+=*`),
+	regexp.MustCompile(`(?i)("?\b(?:access_token|refresh_token|id_token|client_secret|client_assertion|code_verifier|password)"?\s*[:=]\s*"?)[^"&\s,}]+`),
+	regexp.MustCompile(`([?&]code=)[^&\s]+`),
+}
+
+// logRedactor removes the credentials from the log lines.
+var logRedactor = &redactor{}
+
+// redactor replaces the secrets of the configuration and the values looking
+// like credentials with [REDACTED].
+type redactor struct {
+	mu      sync.RWMutex
+	secrets []string
+}
+
+// add registers secrets, removed wherever they appear in the logs.
+func (r *redactor) add(secrets ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, secret := range secrets {
+		// short values would redact unrelated text
+		if len(secret) >= 8 {
+			r.secrets = append(r.secrets, secret)
+		}
+	}
+}
+
+// redact returns s without its secrets and credentials.
+func (r *redactor) redact(s string) string {
+	r.mu.RLock()
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	r.mu.RUnlock()
+
+	for _, pattern := range sensitiveLogPatterns {
+		s = pattern.ReplaceAllString(s, "${1}"+redacted)
+	}
+	return s
+}
+
+// replaceAttr redacts the attributes named after credentials and the
+// credentials held by the string values, messages included.
+func (r *redactor) replaceAttr(groups []string, a slog.Attr) slog.Attr {
+	key := strings.ToLower(a.Key)
+	for _, sensitive := range sensitiveLogKeys {
+		if strings.Contains(key, sensitive) {
+			return slog.String(a.Key, redacted)
+		}
+	}
+	if a.Value.Kind() == slog.KindString {
+		return slog.String(a.Key, r.redact(a.Value.String()))
+	}
+	if err, ok := a.Value.Any().(error); ok {
+		return slog.String(a.Key, r.redact(err.Error()))
+	}
+	return a
+}
+
+// configSecrets returns the secrets of config, removed from the logs.
+func configSecrets(config *Config) []string {
+	secrets := []string{config.Auth0.ClientSecret, config.Auth0.ClientSecretSecondary, config.Auth0.WebhookSecret, config.Google.ClientSecret, config.Server.AdminToken, config.Server.SignedURLSecret, config.Debug.Token}
+	secrets = append(secrets, config.Notify.SMTPPassword, config.Notify.AWSSecretAccessKey, config.Notify.AWSSessionToken, config.Notify.SendGridAPIKey)
+	for _, tenant := range config.Auth0.Failover {
+		secrets = append(secrets, tenant.ClientSecret)
+	}
+	for _, provider := range config.Providers {
+		secrets = append(secrets, provider.ClientSecret)
+	}
+	for _, site := range config.Sites {
+		secrets = append(secrets, site.ClientSecret)
+	}
+	secrets = append(secrets, config.Sessions.AuthKeys...)
+	return append(secrets, config.Sessions.EncryptionKeys...)
+}
+
+// contextHandler adds the attributes of the request, such as its ID and
+// user, to the lines logged with its context.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	r.AddAttrs(requestLogAttrs(ctx)...)
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// newLogger creates the logger writing to w in format, text or json, the
+// lines below level being dropped. Credentials are redacted by redactor.
+func newLogger(w io.Writer, format, level string, redactor *redactor) (*slog.Logger, error) {
+	var minLevel slog.Level
+	if level != "" {
+		if err := minLevel.UnmarshalText([]byte(level)); err != nil {
+			return nil, fmt.Errorf("invalid LOG_LEVEL %q, expected debug, info, warn or error", level)
+		}
+	}
+
+	options := &slog.HandlerOptions{Level: minLevel, ReplaceAttr: redactor.replaceAttr}
+	switch format {
+	case "", "text":
+		return slog.New(contextHandler{slog.NewTextHandler(w, options)}), nil
+	case "json":
+		return slog.New(contextHandler{slog.NewJSONHandler(w, options)}), nil
+	default:
+		return nil, fmt.Errorf("invalid LOG_FORMAT %q, expected text or json", format)
+	}
+}
+
+// setupLogging makes the logger configured by LOG_FORMAT and LOG_LEVEL the
+// default one, also used by the log package, and silences the debug output
+// of gin unless GIN_MODE is set.
+func setupLogging(config LogConfig) error {
+	logger, err := newLogger(os.Stderr, config.Format, config.Level, logRedactor)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+
+	if os.Getenv(gin.EnvGinMode) == "" {
+		gin.SetMode(gin.ReleaseMode)
+	}
+	return nil
+}
+
+// fatal logs msg with err and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
+}
+
+// AccessLog logs every request once served, with its route, status and
+// duration, and the attributes of the request such as its ID and the user
+// signed in. The query string is left out, as it carries the authorization
+// codes of the callbacks.
+func AccessLog() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+		ctx.Next()
+
+		status := ctx.Writer.Status()
+		level := slog.LevelInfo
+		if status >= 500 {
+			level = slog.LevelError
+		}
+
+		attrs := []slog.Attr{
+			slog.String("method", ctx.Request.Method),
+			slog.String("route", ctx.FullPath()),
+			slog.String("path", ctx.Request.URL.Path),
+			slog.Int("status", status),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("ip", ctx.ClientIP()),
+		}
+		slog.LogAttrs(ctx, level, "request", attrs...)
+	}
+}
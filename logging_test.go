@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLoggerRequestAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "json", "", &redactor{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.Use(RequestID())
+	router.GET("/", func(ctx *gin.Context) {
+		addLogAttrs(ctx, slog.String("sub", ctx.Query("sub")))
+		// lines logged with the gin context or the context of the request
+		// both carry the attributes
+		logger.ErrorContext(ctx, "from the gin context", "err", errors.New("failed"))
+		logger.ErrorContext(ctx.Request.Context(), "from the request context")
+	})
+	for _, sub := range []string{"auth0|alice", "auth0|bob"} {
+		req := httptest.NewRequest(http.MethodGet, "/?sub="+sub, nil)
+		req.Header.Set(requestIDHeader, "request-"+sub)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	logger.Error("without a request")
+
+	var lines []map[string]interface{}
+	for _, raw := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		var line map[string]interface{}
+		if err := json.Unmarshal(raw, &line); err != nil {
+			t.Fatalf("invalid line %q: %v", raw, err)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 5 {
+		t.Fatalf("%d lines logged, want 5", len(lines))
+	}
+	for i, sub := range []string{"auth0|alice", "auth0|alice", "auth0|bob", "auth0|bob"} {
+		if lines[i]["request_id"] != "request-"+sub || lines[i]["sub"] != sub {
+			t.Errorf("line %d = %v, want the attributes of the request of %s", i, lines[i], sub)
+		}
+	}
+	if lines[0]["err"] != "failed" {
+		t.Errorf("err = %v, want the error", lines[0]["err"])
+	}
+	if _, ok := lines[4]["request_id"]; ok {
+		t.Errorf("line without a request = %v, want no request attributes", lines[4])
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// lastConnectionCookie remembers the connection the user last signed in
+	// with. It only holds the connection name, which is not sensitive.
+	lastConnectionCookie = "last_connection"
+	// lastConnectionMaxAge is how long the last connection is remembered.
+	lastConnectionMaxAge = 365 * 24 * time.Hour
+	// loggedOutCookie pauses the automatic redirection to the last
+	// connection right after a logout, which would sign the user in again.
+	loggedOutCookie = "logged_out"
+	// loggedOutMaxAge is how long the automatic redirection is paused.
+	loggedOutMaxAge = 10 * time.Minute
+)
+
+// setLoginConnection records in the session the connection a login is
+// started with, to be remembered once it succeeds. The session is saved by
+// startLogin.
+func setLoginConnection(ctx *gin.Context, connection string) {
+	sessions.Default(ctx).Set("login_connection", connection)
+}
+
+// rememberLoginConnection moves the connection of a successful login from
+// the session to the long-lived cookie. The session is saved by the caller.
+func rememberLoginConnection(ctx *gin.Context, session sessions.Session) {
+	connection, _ := session.Get("login_connection").(string)
+	if connection == "" {
+		return
+	}
+
+	session.Delete("login_connection")
+	ctx.SetCookie(lastConnectionCookie, connection, int(lastConnectionMaxAge.Seconds()), "/", "", true, true)
+	ctx.SetCookie(loggedOutCookie, "", -1, "/", "", true, true)
+}
+
+// lastConnection returns the connection the user last signed in with when
+// it is still offered by the login chooser.
+func lastConnection(ctx *gin.Context, connections []Connection) (*Connection, bool) {
+	name, err := ctx.Cookie(lastConnectionCookie)
+	if err != nil || name == "" {
+		return nil, false
+	}
+
+	for i := range connections {
+		if connections[i].Name == name {
+			return &connections[i], true
+		}
+	}
+	return nil, false
+}
+
+// autoLogin reports whether the home page should send the user straight to
+// the last connection: not after a logout, nor when the user asked to pick
+// another method with ?choose.
+func autoLogin(ctx *gin.Context) bool {
+	if _, ok := ctx.GetQuery("choose"); ok {
+		return false
+	}
+	_, err := ctx.Cookie(loggedOutCookie)
+	return err == http.ErrNoCookie
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// ThrottleLimit allows up to Attempts login attempts for an identifier in a
+// Window.
+type ThrottleLimit struct {
+	Attempts int64
+	Window   time.Duration
+}
+
+// parseThrottleLimits parses a comma separated list of attempts/window
+// pairs such as "5/15m,20/24h", the window being a Go duration.
+func parseThrottleLimits(raw string) ([]ThrottleLimit, error) {
+	var limits []ThrottleLimit
+	for _, item := range splitList(raw) {
+		attempts, window, ok := strings.Cut(item, "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid login throttle %q, expected attempts/window", item)
+		}
+		n, err := strconv.ParseInt(attempts, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid login throttle attempts %q", item)
+		}
+		d, err := time.ParseDuration(window)
+		if err != nil || d < time.Second {
+			return nil, fmt.Errorf("invalid login throttle window %q", item)
+		}
+		limits = append(limits, ThrottleLimit{Attempts: n, Window: d})
+	}
+	return limits, nil
+}
+
+// LoginThrottle limits the login attempts targeting the same identifier,
+// whatever IP they come from, to blunt credential stuffing rotating IPs.
+// The attempts are the failed callbacks of the logins started with a login
+// hint, counted in fixed windows. Starting a login is not an attempt, so
+// that anyone cannot lock a user out by only starting logins for them.
+type LoginThrottle struct {
+	store  UsageStore
+	limits []ThrottleLimit
+}
+
+// NewLoginThrottle creates a throttle counting attempts in store.
+func NewLoginThrottle(store UsageStore, limits []ThrottleLimit) *LoginThrottle {
+	return &LoginThrottle{store: store, limits: limits}
+}
+
+// loginIdentifier normalizes the email address a login targets.
+func loginIdentifier(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// windowKey returns the counter of identifier for the window of limit
+// holding now, and when the window ends. Identifiers are hashed so that
+// email addresses are not written to the store.
+func (l ThrottleLimit) windowKey(identifier string, now time.Time) (string, time.Time) {
+	sum := sha256.Sum256([]byte(identifier))
+	start := now.Truncate(l.Window)
+	return fmt.Sprintf("login_throttle:%s:%d:%d", hex.EncodeToString(sum[:16]), int64(l.Window.Seconds()), start.Unix()),
+		start.Add(l.Window)
+}
+
+// check returns the limit identifier exceeded, if any, and when its window
+// ends, after adding an attempt when count is set.
+func (t *LoginThrottle) check(ctx context.Context, identifier string, count bool) (*ThrottleLimit, time.Time, error) {
+	now := time.Now()
+	var exceeded *ThrottleLimit
+	var reset time.Time
+	for i, limit := range t.limits {
+		key, end := limit.windowKey(identifier, now)
+
+		var attempts int64
+		var err error
+		if count {
+			attempts, err = t.store.Increment(ctx, key, end.Sub(now)+time.Minute)
+		} else {
+			attempts, err = t.store.Count(ctx, key)
+		}
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		if attempts > limit.Attempts && (exceeded == nil || end.After(reset)) {
+			exceeded, reset = &t.limits[i], end
+		}
+	}
+	return exceeded, reset, nil
+}
+
+// setLoginHint records in the session the email address a login is started
+// for, if any, to throttle its failed callbacks. The session is saved by
+// startLogin.
+func setLoginHint(ctx *gin.Context, email string) {
+	session := sessions.Default(ctx)
+	if email == "" {
+		session.Delete("login_hint")
+		return
+	}
+	session.Set("login_hint", loginIdentifier(email))
+}
+
+// throttleLogin responds with 429 and reports that the login must not
+// start when the failed logins for email exceeded a limit. The login start
+// itself is not counted. Logins are let through when the store cannot be
+// reached.
+func (s *Server) throttleLogin(ctx *gin.Context, email string) bool {
+	if s.loginThrottle == nil || email == "" {
+		return false
+	}
+
+	identifier := loginIdentifier(email)
+	limit, reset, err := s.loginThrottle.check(ctx, identifier, false)
+	if err != nil {
+		slog.ErrorContext(ctx, "could not check login attempts", "err", err)
+		return false
+	}
+	if limit == nil {
+		return false
+	}
+
+	s.rejectThrottledLogin(ctx, identifier, limit, reset)
+	return true
+}
+
+// throttleCallback reports whether the callback must be rejected, the
+// identifier of its login having exceeded a limit meanwhile.
+func (s *Server) throttleCallback(ctx *gin.Context, session sessions.Session) bool {
+	identifier, _ := session.Get("login_hint").(string)
+	if s.loginThrottle == nil || identifier == "" {
+		return false
+	}
+
+	limit, reset, err := s.loginThrottle.check(ctx, identifier, false)
+	if err != nil {
+		slog.ErrorContext(ctx, "could not check login attempts", "err", err)
+		return false
+	}
+	if limit == nil {
+		return false
+	}
+
+	s.rejectThrottledLogin(ctx, identifier, limit, reset)
+	return true
+}
+
+// loginFailed counts the failed callback of a login against the identifier
+// it was started for.
+func (s *Server) loginFailed(ctx *gin.Context) {
+	session := defaultSession(ctx)
+	if s.loginThrottle == nil || session == nil {
+		return
+	}
+	identifier, _ := session.Get("login_hint").(string)
+	if identifier == "" {
+		return
+	}
+
+	limit, _, err := s.loginThrottle.check(ctx, identifier, true)
+	if err != nil {
+		slog.ErrorContext(ctx, "could not count failed login", "err", err)
+		return
+	}
+	if limit != nil {
+		s.audit(ctx, auditLoginThrottled, "", map[string]string{
+			"reason":     "too_many_failures",
+			"identifier": identifier,
+			"window":     limit.Window.String(),
+		})
+	}
+}
+
+// rejectThrottledLogin responds to a login or callback over the limit of
+// identifier, whose window ends at reset.
+func (s *Server) rejectThrottledLogin(ctx *gin.Context, identifier string, limit *ThrottleLimit, reset time.Time) {
+	s.audit(ctx, auditLoginThrottled, "", map[string]string{
+		"reason":     "too_many_attempts",
+		"identifier": identifier,
+		"window":     limit.Window.String(),
+	})
+
+	retryAfter := time.Until(reset)
+	ctx.Header("Retry-After", strconv.FormatInt(int64(retryAfter.Seconds())+1, 10))
+	renderError(ctx, http.StatusTooManyRequests, "Too many sign in attempts",
+		"There were too many attempts to sign in to this account, please try again in "+formatWait(retryAfter)+".")
+	ctx.Abort()
+}
+
+// formatWait describes a wait in whole minutes or hours, rounded up.
+func formatWait(d time.Duration) string {
+	if d > time.Hour {
+		hours := int64((d + time.Hour - 1) / time.Hour)
+		return strconv.FormatInt(hours, 10) + " hours"
+	}
+	minutes := int64((d + time.Minute - 1) / time.Minute)
+	if minutes <= 1 {
+		return "a minute"
+	}
+	return strconv.FormatInt(minutes, 10) + " minutes"
+}
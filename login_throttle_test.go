@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseThrottleLimits(t *testing.T) {
+	for _, tt := range []struct {
+		raw     string
+		want    []ThrottleLimit
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"5/15m", []ThrottleLimit{{5, 15 * time.Minute}}, false},
+		{"5/15m, 20/24h", []ThrottleLimit{{5, 15 * time.Minute}, {20, 24 * time.Hour}}, false},
+		{"5", nil, true},
+		{"0/15m", nil, true},
+		{"-1/15m", nil, true},
+		{"five/15m", nil, true},
+		{"5/fifteen", nil, true},
+		{"5/500ms", nil, true},
+	} {
+		t.Run(tt.raw, func(t *testing.T) {
+			limits, err := parseThrottleLimits(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseThrottleLimits(%q) = %v, want error %t", tt.raw, err, tt.wantErr)
+			}
+			if len(limits) != len(tt.want) {
+				t.Fatalf("limits = %v, want %v", limits, tt.want)
+			}
+			for i := range limits {
+				if limits[i] != tt.want[i] {
+					t.Errorf("limit %d = %v, want %v", i, limits[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// failingUsageStore is a usage store which cannot be reached.
+type failingUsageStore struct{}
+
+func (failingUsageStore) Increment(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return 0, errors.New("connection refused")
+}
+
+func (failingUsageStore) Count(ctx context.Context, key string) (int64, error) {
+	return 0, errors.New("connection refused")
+}
+
+// newThrottledServer returns a server throttling the logins with limits in
+// store, with the /test/start?email= route starting a login, /test/fail
+// failing its callback and /test/callback checking it.
+func newThrottledServer(t *testing.T, store UsageStore, limits []ThrottleLimit) *Server {
+	t.Helper()
+
+	provider := newTestProvider(t)
+	server := newTestServer(t, testConfig(provider), "memstore")
+	server.loginThrottle = NewLoginThrottle(store, limits)
+	server.router.GET("/test/start", func(ctx *gin.Context) {
+		if server.throttleLogin(ctx, ctx.Query("email")) {
+			return
+		}
+		setLoginHint(ctx, ctx.Query("email"))
+		if err := sessions.Default(ctx).Save(); err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		ctx.Status(http.StatusNoContent)
+	})
+	server.router.GET("/test/fail", func(ctx *gin.Context) {
+		server.loginFailed(ctx)
+		ctx.Status(http.StatusNoContent)
+	})
+	server.router.GET("/test/callback", func(ctx *gin.Context) {
+		if server.throttleCallback(ctx, sessions.Default(ctx)) {
+			return
+		}
+		ctx.Status(http.StatusNoContent)
+	})
+	return server
+}
+
+// serveJSON serves target as serve does, for a client asking for JSON so
+// that the rejections are rendered without the templates.
+func serveJSON(server *Server, target string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.Header.Set("Accept", "application/json")
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestLoginThrottle(t *testing.T) {
+	server := newThrottledServer(t, newMemoryUsageStore(), []ThrottleLimit{{Attempts: 2, Window: time.Hour}})
+	start := func(email string) *httptest.ResponseRecorder {
+		return serveJSON(server, "/test/start?"+url.Values{"email": {email}}.Encode())
+	}
+
+	// a login started before the failures, whose callback comes after them
+	pending := responseCookie(start("alice@example.com"), sessionCookieName)
+	for i := 0; i < 3; i++ {
+		w := start("alice@example.com")
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("login %d = %d, want the attempts under the limit let through", i, w.Code)
+		}
+		serveJSON(server, "/test/fail", responseCookie(w, sessionCookieName))
+	}
+
+	for _, tt := range []struct {
+		email string
+		want  int
+	}{
+		{"alice@example.com", http.StatusTooManyRequests},
+		{" Alice@Example.COM ", http.StatusTooManyRequests},
+		{"bob@example.com", http.StatusNoContent},
+		{"", http.StatusNoContent},
+	} {
+		t.Run(tt.email, func(t *testing.T) {
+			w := start(tt.email)
+			if w.Code != tt.want {
+				t.Fatalf("login = %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+			if tt.want == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+				t.Error("no Retry-After header")
+			}
+		})
+	}
+
+	if w := serveJSON(server, "/test/callback", pending); w.Code != http.StatusTooManyRequests {
+		t.Errorf("callback of the pending login = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestLoginThrottleStartsAreNotAttempts(t *testing.T) {
+	server := newThrottledServer(t, newMemoryUsageStore(), []ThrottleLimit{{Attempts: 1, Window: time.Hour}})
+	for i := 0; i < 5; i++ {
+		if w := serveJSON(server, "/test/start?email=alice@example.com"); w.Code != http.StatusNoContent {
+			t.Fatalf("login %d = %d, want the starts not counted", i, w.Code)
+		}
+	}
+}
+
+func TestLoginThrottleStoreUnreachable(t *testing.T) {
+	server := newThrottledServer(t, failingUsageStore{}, []ThrottleLimit{{Attempts: 1, Window: time.Hour}})
+	for i := 0; i < 3; i++ {
+		w := serveJSON(server, "/test/start?email=alice@example.com")
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("login %d = %d, want the logins let through", i, w.Code)
+		}
+		serveJSON(server, "/test/fail", responseCookie(w, sessionCookieName))
+		if w := serveJSON(server, "/test/callback", responseCookie(w, sessionCookieName)); w.Code != http.StatusNoContent {
+			t.Fatalf("callback %d = %d, want the callbacks let through", i, w.Code)
+		}
+	}
+}
+
+func TestFormatWait(t *testing.T) {
+	for _, tt := range []struct {
+		wait time.Duration
+		want string
+	}{
+		{0, "a minute"},
+		{30 * time.Second, "a minute"},
+		{time.Minute, "a minute"},
+		{time.Minute + time.Second, "2 minutes"},
+		{time.Hour, "60 minutes"},
+		{time.Hour + time.Second, "2 hours"},
+		{24 * time.Hour, "24 hours"},
+	} {
+		if got := formatWait(tt.wait); got != tt.want {
+			t.Errorf("formatWait(%s) = %q, want %q", tt.wait, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// m2mTokenLeeway is how long before their expiry the cached machine to
+// machine tokens are renewed, so that they do not expire in flight.
+const m2mTokenLeeway = time.Minute
+
+// M2MTokens obtains the machine to machine access tokens of the application
+// for its own calls, such as the Management API, with the client
+// credentials grant. Tokens are cached by audience until shortly before they
+// expire, and the concurrent requests needing a new one share a single
+// token request.
+type M2MTokens struct {
+	tokenURL  string
+	clientID  string
+	secrets   *clientSecrets         // client secrets, nil with private_key_jwt
+	assertion *clientAssertionSigner // private_key_jwt client authentication, if set
+	client    *http.Client
+	calls     *callGroup
+
+	mu     sync.Mutex
+	tokens map[string]*oauth2.Token // by audience
+}
+
+// NewM2MTokens creates the token cache of the application clientID, whose
+// tokens are requested from tokenURL through transport with the client
+// secrets of secrets, shared with the logins so that the rotations of the
+// secret apply to both.
+func NewM2MTokens(tokenURL, clientID string, secrets *clientSecrets, transport http.RoundTripper) *M2MTokens {
+	return &M2MTokens{
+		tokenURL: tokenURL,
+		clientID: clientID,
+		secrets:  secrets,
+		client:   &http.Client{Transport: transport, Timeout: 30 * time.Second},
+		calls:    newCallGroup(),
+		tokens:   map[string]*oauth2.Token{},
+	}
+}
+
+// useClientAssertion authenticates the token requests with the client
+// assertions of signer (private_key_jwt) instead of the client secrets.
+func (m *M2MTokens) useClientAssertion(signer *clientAssertionSigner) {
+	m.assertion = signer
+	m.secrets = nil
+}
+
+// cached returns the token of audience while it is not about to expire.
+func (m *M2MTokens) cached(audience string) (*oauth2.Token, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	token, ok := m.tokens[audience]
+	if !ok || (!token.Expiry.IsZero() && time.Until(token.Expiry) < m2mTokenLeeway) {
+		return nil, false
+	}
+	return token, true
+}
+
+// Token returns an access token for audience, from the cache or requested
+// once for the concurrent callers.
+func (m *M2MTokens) Token(ctx context.Context, audience string) (*oauth2.Token, error) {
+	if token, ok := m.cached(audience); ok {
+		return token, nil
+	}
+
+	v, err := m.calls.Do(ctx, audience, func() (interface{}, error) {
+		// renewed by the call which completed meanwhile
+		if token, ok := m.cached(audience); ok {
+			return token, nil
+		}
+
+		token, err := m.request(ctx, audience)
+		if err != nil {
+			return nil, fmt.Errorf("could not get token for %s: %w", audience, err)
+		}
+
+		m.mu.Lock()
+		m.tokens[audience] = token
+		m.mu.Unlock()
+		return token, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*oauth2.Token), nil
+}
+
+// request requests a token for audience, with a client assertion or with
+// the client secret in use and, if the provider rejects it with
+// invalid_client, again with the other secret, as withClientSecret does for
+// the logins.
+func (m *M2MTokens) request(ctx context.Context, audience string) (*oauth2.Token, error) {
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, m.client)
+	config := clientcredentials.Config{
+		ClientID:       m.clientID,
+		TokenURL:       m.tokenURL,
+		EndpointParams: url.Values{"audience": {audience}},
+	}
+
+	if m.assertion != nil {
+		params, err := m.assertion.params()
+		if err != nil {
+			return nil, err
+		}
+		for name, value := range params {
+			config.EndpointParams.Set(name, value)
+		}
+		config.AuthStyle = oauth2.AuthStyleInParams
+		return config.Token(ctx)
+	}
+
+	var err error
+	for i, secret := range m.secrets.ordered() {
+		config.ClientSecret = secret
+		var token *oauth2.Token
+		if token, err = config.Token(ctx); !isInvalidClient(err) {
+			if err == nil {
+				m.secrets.use(secret, i > 0)
+			}
+			return token, err
+		}
+
+		slog.WarnContext(ctx, "client secret rejected, trying the next one", "err", err)
+	}
+
+	return nil, err
+}
+
+// Forget drops the cached token of audience, rejected by the API before its
+// expiry, so that the next call gets a new one.
+func (m *M2MTokens) Forget(audience string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.tokens, audience)
+}
+
+// Transport returns a transport authenticating the requests sent through
+// base with a token for audience.
+func (m *M2MTokens) Transport(audience string, base http.RoundTripper) http.RoundTripper {
+	return &m2mTransport{tokens: m, audience: audience, base: base}
+}
+
+// m2mTransport adds a machine to machine token to the requests.
+type m2mTransport struct {
+	tokens   *M2MTokens
+	audience string
+	base     http.RoundTripper
+}
+
+func (t *m2mTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.tokens.Token(req.Context(), t.audience)
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+
+	// the request of the caller must not be modified
+	req = req.Clone(req.Context())
+	token.SetAuthHeader(req)
+	return t.base.RoundTrip(req)
+}
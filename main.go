@@ -3,19 +3,22 @@ package main
 import (
 	"context"
 	"crypto/rand"
+	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/coreos/go-oidc"
 	"github.com/gin-contrib/sessions"
-	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/gin"
 	"golang.org/x/oauth2"
 )
@@ -36,123 +39,885 @@ type UserInfo struct {
 
 // Server represents the HTTP server.
 type Server struct {
-	router       *gin.Engine    // Gin router instance
-	oauth2config *oauth2.Config // OAuth2 configuration
+	config         *Config           // configuration loaded on startup
+	router         *gin.Engine       // Gin router instance
+	provider       *Provider         // OpenID Connect provider
+	oauth2config   *oauth2.Config    // OAuth2 configuration
+	callbackURLs   []*url.URL        // registered callback URLs
+	sites          []*site           // auth0 applications served on their own host names
+	callbacks      *callbackGuard    // recently handled callbacks
+	clockSkew      time.Duration     // leeway applied to token time claims
+	reusePort      bool              // listen with SO_REUSEPORT for binary upgrades
+	users          UserStore         // local user records
+	webhookSecret  string            // secret signing the auth0 user events
+	m2m            *M2MTokens        // machine to machine tokens of the application
+	management     *ManagementClient // auth0 Management API client
+	scheduler      *Scheduler        // background jobs
+	auditor        *Auditor          // audit event recorder
+	adminRouter    *gin.Engine       // admin API router
+	adminAddr      string            // admin API listen address, disabled when empty
+	adminToken     string            // bearer token required by the admin API
+	jarm           bool              // request JWT secured authorization responses
+	connection     string            // auth0 connection of the logins not picking one, empty for the auth0 login page
+	signedUserInfo bool              // require signed userinfo responses
+	dpopKeys       *dpopKeyStore     // session proof keys, DPoP disabled when nil
+	transport      http.RoundTripper // transport of the requests to the provider
+	outbound       http.RoundTripper // transport of the other outbound requests
+	certThumbprint string            // thumbprint of the client certificate, if any
+	revocations    *RevocationList   // users whose earlier sessions are revoked
+
+	shutdownTimeout time.Duration          // how long in-flight requests are drained on shutdown
+	tlsConfig       *tls.Config            // certificate served to the browsers, plain HTTP when nil
+	tlsRedirectAddr string                 // plain HTTP listener redirecting to HTTPS, disabled when empty
+	http3           bool                   // also serve HTTP/3 on the UDP port of the HTTPS listener
+	trustedProxies  []*net.IPNet           // reverse proxies whose forwarded headers are applied
+	returnToOrigins []*url.URL             // other sites users may be sent back to after login
+	rolesClaim      string                 // ID token claim holding the roles of the users
+	apiAudience     string                 // audience of the JWT access tokens accepted by the API
+	tracer          *Tracer                // exports the spans of the requests, nil when tracing is off
+	readiness       *Readiness             // checks of the dependencies answered by /readyz
+	errorReporter   ErrorReporter          // error tracker receiving recovered panics
+	securityEvents  *SecurityEventReceiver // shared signals receiver, disabled when nil
+	deviceFlow      *DeviceFlow            // device authorization grant of the CLIs, disabled when nil
+	clientAssertion *clientAssertionSigner // private_key_jwt client authentication, if set
+	clientSecrets   *clientSecrets         // client secrets, nil with private_key_jwt
+	idTokenKeys     *idTokenDecrypter      // decrypts encrypted ID tokens, if set
+	tenants         []*tenant              // tenants in failover order, the primary one first
+	providers       map[string]*tenant     // identity providers by login name, auth0 being the primary tenant
+	requestObjects  *requestObjectSigner   // signs authorization requests (JAR), if set
+	resources       []string               // APIs access tokens are requested for
+	idpTokens       *idpTokenCache         // upstream identity provider tokens
+	people          *PeopleClient          // Google People API client, disabled when nil
+	groups          *GroupResolver         // Google Workspace groups of the users
+	homeRealm       *HomeRealm             // email domain to connection rules, disabled when nil
+	connections     *ConnectionList        // connections shown in the login chooser, disabled when nil
+	profileForm     *ProfileForm           // profile fields asked after login, disabled when nil
+	terms           *Terms                 // documents users must accept, disabled when nil
+	notifications   *Notifications         // email notifications, disabled when nil
+	redis           *RedisClient           // state shared between instances, disabled when nil
+	quotas          *Quotas                // API quotas and usage of the users
+	urlSigner       *URLSigner             // signs the URLs of protected resources
+	region          string                 // region of the instance in active-active deployments
+	loginThrottle   *LoginThrottle         // login attempts per identifier, disabled when nil
+	webhooks        *Webhooks              // verifies the incoming webhook deliveries
+	idpHealth       *IdPHealth             // outages of the identity provider
+	calls           *callGroup             // coalesces identical provider calls
+	maintenance     *Maintenance           // maintenance mode, toggled from the admin API
+	tokens          TokenStore             // tokens of the browser sessions
+	adminAPI        *apiRoutes             // routes of the admin API, for the OpenAPI document
+	publicAPI       *apiRoutes             // routes of the JSON API, for the OpenAPI document
 }
 
 // NewOauth2Config creates a new OAuth2 configuration.
-// It retrieves the necessary environment variables and initializes the configuration.
-func NewOauth2Config() (*oauth2.Config, error) {
-	// Create a new OpenID Connect provider using the AUTH0_DOMAIN environment variable.
-	provider, err := oidc.NewProvider(
-		context.Background(),
-		"https://"+os.Getenv("AUTH0_DOMAIN")+"/",
-	)
-	if err != nil {
-		return nil, fmt.Errorf("could not create new provider: %v", err)
-	}
-
-	// Initialize the OAuth2 configuration using the environment variables.
-	oauthConfig := &oauth2.Config{
-		ClientID:     os.Getenv("AUTH0_CLIENT_ID"),
-		ClientSecret: os.Getenv("AUTH0_CLIENT_SECRET"),
-		RedirectURL:  os.Getenv("AUTH0_CALLBACK_URL"),
+// It initializes the configuration with the client of the auth0 application.
+func NewOauth2Config(provider *Provider, config Auth0Config) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     config.ClientID,
+		ClientSecret: config.ClientSecret,
 		Scopes:       []string{oidc.ScopeOpenID, "profile", "email", "picture"},
 		Endpoint:     provider.Endpoint(),
 	}
+}
 
-	return oauthConfig, nil
+// NewGoogleOauth2Config creates the OAuth2 configuration of the Google
+// client users sign in with directly.
+// GOOGLE_SCOPES replaces the default scopes, to call Google APIs.
+func NewGoogleOauth2Config(provider *Provider, config GoogleConfig) *oauth2.Config {
+	scopes := config.Scopes
+	if len(scopes) == 0 {
+		scopes = defaultProviderScopes[providerGoogle]
+	}
+	return &oauth2.Config{
+		ClientID:     config.ClientID,
+		ClientSecret: config.ClientSecret,
+		Scopes:       scopes,
+		Endpoint:     provider.Endpoint(),
+	}
 }
 
-// NewServer creates a new instance of Server.
-func NewServer() (*Server, error) {
+// NewServer creates a new instance of Server from config.
+func NewServer(config *Config) (*Server, error) {
 	router := gin.New()
+	// let gin.Context carry the request deadline and cancellation to the
+	// provider calls made with it
+	router.ContextWithFallback = true
+
+	// OTEL_EXPORTER_OTLP_ENDPOINT exports traces of the requests and of the
+	// calls they make, outbound being the transport of the calls to the
+	// provider discovery and JWKS, the Management API and the Google APIs
+	tracer, err := loadTracer(config.Tracing)
+	if err != nil {
+		return nil, err
+	}
+	outbound := newTracingTransport(tracer, http.DefaultTransport)
+	outboundCtx := oidc.ClientContext(context.Background(), &http.Client{Transport: outbound})
+
+	// Create a new OpenID Connect provider for AUTH0_DOMAIN. AUTH0_ISSUER
+	// overrides the expected token issuer when AUTH0_DOMAIN is a custom
+	// domain. Without AUTH0_DOMAIN, users sign in with Google directly.
+	primaryKind, primaryName := providerAuth0, config.Auth0.Domain
+	if config.googleMode() {
+		primaryKind, primaryName = providerGoogle, providerGoogle
+	}
+	var provider *Provider
+	if primaryKind == providerGoogle {
+		provider, err = NewProvider(outboundCtx, googleIssuer, "")
+	} else {
+		provider, err = NewProvider(outboundCtx, config.Auth0.Domain, config.Auth0.Issuer)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not create new provider: %v", err)
+	}
+
+	// AUTH0_CLIENT_CERT_FILE presents a client certificate to the provider
+	// so that the issued tokens are bound to it
+	transport, certThumbprint := http.DefaultTransport, ""
+	if certFile := config.Auth0.ClientCertFile; certFile != "" {
+		transport, certThumbprint, err = newMTLSTransport(certFile, config.Auth0.ClientKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		provider.useMTLSEndpoints()
+	}
+	transport = newTracingTransport(tracer, transport)
+
+	// AUTH0_CALLBACK_URL may hold several comma separated callback URLs, the
+	// one matching the request host is used as redirect URL.
+	callbackURLs, err := parseCallbackURLs(config.Auth0.CallbackURLs)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse callback URLs: %v", err)
+	}
+
+	// DATABASE_URL selects a postgres user store, users are kept in memory
+	// otherwise
+	users, err := NewUserStore(config.Server.DatabaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("could not create user store: %v", err)
+	}
+
+	audit, err := NewAuditStore(config.Server.DatabaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("could not create audit store: %v", err)
+	}
+
+	// AUDIT_SYSLOG_URL forwards the audit events to a syslog collector and
+	// AUDIT_STDOUT prints them, each sink using its own format
+	auditSinks := make(map[string]AuditSink)
+	if syslogURL := config.Audit.SyslogURL; syslogURL != "" {
+		formatter, err := NewAuditFormatter(config.Audit.SyslogFormat)
+		if err != nil {
+			return nil, fmt.Errorf("could not create syslog audit formatter: %v", err)
+		}
+
+		sink, err := newSyslogSink(syslogURL, config.Audit.SyslogAppName, formatter)
+		if err != nil {
+			return nil, fmt.Errorf("could not create syslog audit sink: %v", err)
+		}
+		auditSinks["syslog"] = sink
+	}
+	if config.Audit.Stdout {
+		formatter, err := NewAuditFormatter(config.Audit.StdoutFormat)
+		if err != nil {
+			return nil, fmt.Errorf("could not create stdout audit formatter: %v", err)
+		}
+		auditSinks["stdout"] = newWriterSink(os.Stdout, formatter)
+	}
+
+	// ALERT_SLACK_WEBHOOK_URL and ALERT_DISCORD_WEBHOOK_URL post the high
+	// severity events to a chat channel
+	alertTypes := config.Alerts.EventTypes
+	if len(alertTypes) == 0 {
+		alertTypes = defaultAlertEventTypes
+	}
+	for name, webhook := range map[string]struct{ env, url string }{
+		"slack":   {"ALERT_SLACK_WEBHOOK_URL", config.Alerts.SlackWebhookURL},
+		"discord": {"ALERT_DISCORD_WEBHOOK_URL", config.Alerts.DiscordWebhookURL},
+	} {
+		if webhook.url != "" {
+			sink, err := newChatSink(webhook.url, alertTypes, config.Alerts.RateLimit)
+			if err != nil {
+				return nil, fmt.Errorf("could not create %s alerts: %v", webhook.env, err)
+			}
+			auditSinks[name] = sink
+		}
+	}
+
+	// the events are forwarded through an outbox, persisted with
+	// DATABASE_URL, and dead-lettered after AUDIT_OUTBOX_MAX_ATTEMPTS
+	outbox, err := NewOutboxStore(config.Server.DatabaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("could not create audit outbox: %v", err)
+	}
+
+	// the Management API is not available on custom domains, so
+	// AUTH0_MANAGEMENT_DOMAIN may point at the canonical tenant domain
+	managementDomain := config.Auth0.ManagementDomain
+	if managementDomain == "" {
+		managementDomain = config.Auth0.Domain
+	}
+	// AUTH0_CLIENT_SECRET_SECONDARY is accepted while rotating the secret
+	auth0Secrets := &clientSecrets{
+		primary:   config.Auth0.ClientSecret,
+		secondary: config.Auth0.ClientSecretSecondary,
+	}
+	// the machine to machine tokens of the application, such as the ones of
+	// the Management API, are cached until shortly before they expire
+	m2m := NewM2MTokens("https://"+managementDomain+"/oauth/token", config.Auth0.ClientID, auth0Secrets, outbound)
 
-	oauth2Config, err := NewOauth2Config()
+	// the background jobs, run from Start
+	scheduler := NewScheduler()
+	auditor, err := NewAuditor(audit, outbox, auditSinks, config.Audit.OutboxMaxAttempts, scheduler)
 	if err != nil {
-		return nil, fmt.Errorf("could not create new oauth config: %v", err)
+		return nil, err
 	}
 
 	server := &Server{
-		router:       router,
-		oauth2config: oauth2Config,
+		config:         config,
+		router:         router,
+		provider:       provider,
+		oauth2config:   NewOauth2Config(provider, config.Auth0),
+		callbackURLs:   callbackURLs,
+		callbacks:      newCallbackGuard(5 * time.Minute),
+		clockSkew:      time.Duration(config.Server.TokenClockSkew),
+		reusePort:      config.Server.ReusePort,
+		users:          users,
+		webhookSecret:  config.Auth0.WebhookSecret,
+		scheduler:      scheduler,
+		auditor:        auditor,
+		adminAddr:      config.Server.AdminListenAddr,
+		adminToken:     config.Server.AdminToken,
+		m2m:            m2m,
+		management:     NewManagementClient(managementDomain, m2m, outbound),
+		revocations:    NewRevocationList(),
+		idpTokens:      newIDPTokenCache(),
+		calls:          newCallGroup(),
+		jarm:           config.Auth0.JARM,
+		connection:     config.Auth0.Connection,
+		signedUserInfo: config.Auth0.UserInfoSigned,
+		transport:      transport,
+		outbound:       outbound,
+		certThumbprint: certThumbprint,
+		tracer:         tracer,
+		clientSecrets:  auth0Secrets,
+	}
+	var hosted []string
+	if primaryKind == providerGoogle {
+		server.oauth2config = NewGoogleOauth2Config(provider, config.Google)
+		server.clientSecrets = &clientSecrets{primary: config.Google.ClientSecret}
+		// the machine to machine tokens and the Management API need an
+		// Auth0 tenant, the features using them are refused by validate
+		server.m2m, server.management = nil, nil
+		// GOOGLE_HOSTED_DOMAINS restricts the logins to Workspace domains
+		hosted = hostedDomains(config.Google.HostedDomains)
+	}
+
+	// SHUTDOWN_TIMEOUT is how long in-flight requests are drained on
+	// shutdown
+	server.shutdownTimeout = time.Duration(config.Server.ShutdownTimeout)
+
+	// TLS_CERT_FILE and TLS_KEY_FILE serve HTTPS without a proxy in front,
+	// TLS_REDIRECT_ADDR redirecting plain HTTP to it and TLS_HTTP3 serving
+	// HTTP/3 next to it
+	if config.Server.TLSCertFile != "" {
+		server.tlsConfig, err = newServerTLSConfig(config.Server.TLSCertFile, config.Server.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		server.tlsRedirectAddr = config.Server.TLSRedirectAddr
+		server.http3 = config.Server.TLSHTTP3
+	}
+
+	// TRUSTED_PROXIES lists the reverse proxies whose X-Forwarded-* headers
+	// give the client address, scheme and host of the requests
+	server.trustedProxies, err = parseTrustedProxies(config.Server.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	if err := router.SetTrustedProxies(config.Server.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("could not set trusted proxies: %v", err)
+	}
+
+	// RETURN_TO_ALLOWLIST lists the origins, besides this site, the returnTo
+	// parameter of the login may send users back to
+	server.returnToOrigins, err = parseReturnToOrigins(config.Access.ReturnToAllowlist)
+	if err != nil {
+		return nil, err
+	}
+
+	// ROLES_CLAIM names the namespaced ID token claim holding the roles that
+	// RequireRole checks
+	server.rolesClaim = config.Access.RolesClaim
+
+	// API_AUDIENCE is the identifier of the auth0 API whose JWT access
+	// tokens authenticate the API calls made without a session
+	server.apiAudience = config.API.Audience
+
+	if config.Auth0.DPoP {
+		server.dpopKeys = newDPoPKeyStore()
+	}
+
+	// AUTH0_CLIENT_ASSERTION_KEY_FILE authenticates to the token endpoint
+	// with signed client assertions (private_key_jwt) instead of the secret
+	if keyFile := config.Auth0.ClientAssertionKeyFile; keyFile != "" {
+		signer, err := newClientAssertionSigner(keyFile, config.Auth0.ClientAssertionKeyID, server.oauth2config.ClientID, provider.issuer)
+		if err != nil {
+			return nil, err
+		}
+		server.clientAssertion = signer
+		server.clientSecrets = nil
+		server.oauth2config.ClientSecret = ""
+		server.oauth2config.Endpoint.AuthStyle = oauth2.AuthStyleInParams
+
+		// the machine to machine tokens are requested from the management
+		// domain, the audience of their assertions
+		m2mSigner, err := newClientAssertionSigner(keyFile, config.Auth0.ClientAssertionKeyID, config.Auth0.ClientID, "https://"+managementDomain+"/")
+		if err != nil {
+			return nil, err
+		}
+		m2m.useClientAssertion(m2mSigner)
 	}
 
+	// the refresh token renews the access token of the session before it
+	// expires, google issuing it to the offline logins instead
+	if primaryKind == providerAuth0 {
+		server.oauth2config.Scopes = append(server.oauth2config.Scopes, "offline_access")
+	}
+
+	// standby tenants take over new logins when the ones before them are
+	// unavailable
+	failover, err := loadFailoverTenants(outboundCtx, server.oauth2config, config.Auth0.Failover)
+	if err != nil {
+		return nil, err
+	}
+	server.tenants = append([]*tenant{{
+		name:         primaryName,
+		kind:         primaryKind,
+		hosted:       hosted,
+		organization: config.Auth0.Organization,
+		primary:      true,
+		provider:     provider,
+		oauth2config: server.oauth2config,
+		healthy:      1,
+	}}, failover...)
+
+	// PROVIDERS lists the other identity providers users sign in with at
+	// /login/<name>
+	server.providers, err = loadIdentityProviders(outboundCtx, config.Providers)
+	if err != nil {
+		return nil, err
+	}
+	server.providers[primaryKind] = server.tenants[0]
+
+	// SITES serve other auth0 applications on their own host names
+	server.sites, err = loadSites(outboundCtx, server.oauth2config, config.Sites)
+	if err != nil {
+		return nil, err
+	}
+
+	// HOME_REALM_DISCOVERY asks for the email address before login to send
+	// the user to the connection of their domain
+	if config.Login.HomeRealmDiscovery {
+		server.homeRealm, err = NewHomeRealm(config.Login.HomeRealmRules, config.Login.HomeRealmDefaultConnection)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// LOGIN_CHOOSER lists the connections enabled for the application on the
+	// home page
+	if config.Login.Chooser {
+		server.connections = NewConnectionList(server.management, server.oauth2config.ClientID)
+	}
+
+	// DEVICE_CLIENT_ID is the native application the CLIs sign in with the
+	// device authorization grant
+	if clientID := config.Device.ClientID; clientID != "" {
+		scopes := config.Device.Scopes
+		if len(scopes) == 0 {
+			scopes = []string{oidc.ScopeOpenID, "profile", "email", "offline_access"}
+		}
+		server.deviceFlow = NewDeviceFlow(provider, clientID, scopes, config.Device.Audience, transport)
+	}
+
+	// REDIS_URL shares the state of the instances, such as the API usage
+	if redisURL := config.Server.RedisURL; redisURL != "" {
+		server.redis, err = NewRedisClient(redisURL)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// API_QUOTA limits the API requests of every user, such as 100/m,5000/d
+	quotas, err := parseQuotas(config.API.Quota)
+	if err != nil {
+		return nil, err
+	}
+	server.quotas = NewQuotas(NewUsageStore(server.redis), quotas)
+
+	// LOGIN_THROTTLE limits the login attempts targeting the same email
+	// address, such as 5/15m,20/24h
+	throttleLimits, err := parseThrottleLimits(config.Login.Throttle)
+	if err != nil {
+		return nil, err
+	}
+	if len(throttleLimits) > 0 {
+		server.loginThrottle = NewLoginThrottle(NewUsageStore(server.redis), throttleLimits)
+	}
+
+	// WEBHOOK_TOLERANCE is how old webhook deliveries can be, replays
+	// being rejected meanwhile, and WEBHOOK_REPLAY_RETENTION how long the
+	// deliveries without a timestamp are remembered
+	server.webhooks = NewWebhooks(time.Duration(config.Server.WebhookTolerance), time.Duration(config.Server.WebhookReplayRetention), newReplayCache(server.redis))
+	server.maintenance = NewMaintenance(server.redis)
+	server.tokens = NewTokenStore(server.redis)
+	server.readiness = NewReadiness()
+
+	// IDP_MAX_STALENESS caps how long sessions are served from cached
+	// claims while the identity provider is unavailable
+	server.idpHealth = NewIdPHealth(time.Duration(config.Server.IdPMaxStaleness))
+
+	// REGION names the region of the instance, sessions remembering the
+	// region they were created in
+	server.region = config.Server.Region
+
+	// SENTRY_DSN reports the recovered panics to Sentry, SENTRY_ENVIRONMENT
+	// naming the deployment
+	if config.Errors.SentryDSN != "" {
+		server.errorReporter, err = newSentryReporter(config.Errors.SentryDSN, config.Errors.Environment, server.region)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SENTRY_DSN: %v", err)
+		}
+	}
+
+	// SIGNED_URL_SECRET keys the signed URLs, which otherwise stop working
+	// when the instance restarts
+	server.urlSigner, err = NewURLSigner(config.Server.SignedURLSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	// TERMS_* and PRIVACY_POLICY_* set the documents users must accept
+	server.terms, err = loadTerms(config.Terms)
+	if err != nil {
+		return nil, err
+	}
+
+	// PROFILE_FIELDS lists the user_metadata fields users are asked to fill
+	// in after login
+	if fields := config.Users.ProfileFields; fields != "" {
+		server.profileForm, err = NewProfileForm(server.management, fields)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse profile fields: %v", err)
+		}
+	}
+
+	// GROUPS_CLAIM reads the groups of the users from a claim instead of the
+	// Directory API
+	server.groups = NewGroupResolver(server, config.Access.GroupsClaim)
+
+	// GOOGLE_PEOPLE_API enriches the profile with Google People API data
+	if config.Google.PeopleAPI {
+		server.people = NewPeopleClient(outbound)
+	}
+
+	// AUTH0_RESOURCES lists the APIs called on behalf of the user, a token
+	// being kept for each of them. Tokens for the ones after the first are
+	// obtained with the refresh token.
+	server.resources = config.Auth0.Resources
+
+	// AUTH0_REQUEST_OBJECT_KEY_FILE sends the authorization parameters as a
+	// signed request object
+	if keyFile := config.Auth0.RequestObjectKeyFile; keyFile != "" {
+		server.requestObjects, err = newRequestObjectSigner(keyFile)
+		if err != nil {
+			return nil, err
+		}
+	} else if provider.Metadata().RequireSignedRequestObject {
+		return nil, fmt.Errorf("the provider requires signed request objects, set AUTH0_REQUEST_OBJECT_KEY_FILE")
+	}
+
+	// AUTH0_ID_TOKEN_DECRYPTION_KEY_FILE decrypts ID tokens issued encrypted
+	// to the client
+	if keyFile := config.Auth0.IDTokenDecryptionKeyFile; keyFile != "" {
+		server.idTokenKeys, err = newIDTokenDecrypter(keyFile, config.Auth0.IDTokenEncryptionAlg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if ssf := config.SecurityEvents; ssf.JWKSURL != "" {
+		server.securityEvents = NewSecurityEventReceiver(outboundCtx, ssf.Issuer, ssf.Audience, ssf.JWKSURL, server.clockSkew)
+	}
+
+	server.adminRouter = server.newAdminRouter()
+
 	return server, nil
 }
 
 // loginHandler handles the login route.
 func (s *Server) loginHandler(ctx *gin.Context) {
+	var opts []oauth2.AuthCodeOption
+
+	// with the login chooser, the connection picked on the home page is
+	// passed to auth0 once checked to be enabled
+	if connection := ctx.Query("connection"); connection != "" && s.connections != nil {
+		if !s.connections.Enabled(ctx, connection) {
+			renderError(ctx, http.StatusBadRequest, "Unknown sign in method", "This sign in method is not available.")
+			return
+		}
+		opts = append(opts, oauth2.SetAuthURLParam("connection", connection))
+		setLoginConnection(ctx, connection)
+	} else if s.connection != "" {
+		// AUTH0_CONNECTION skips the auth0 login page
+		opts = append(opts, oauth2.SetAuthURLParam("connection", s.connection))
+	}
+
+	// the organization to sign in to, by ID or name, the ID token being
+	// checked to be issued for it
+	organization := ctx.Query("organization")
+	if organization != "" && !organizationPattern.MatchString(organization) {
+		renderError(ctx, http.StatusBadRequest, "Unknown organization", "This organization is not available.")
+		return
+	}
+	setLoginOrganization(ctx, organization)
+
+	// the email address to sign in with, prefilled by auth0
+	hint := ctx.Query("login_hint")
+	if s.throttleLogin(ctx, hint) {
+		return
+	}
+	if hint != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("login_hint", hint))
+	}
+	setLoginHint(ctx, hint)
+
+	// the page to land on after the login, dropped unless allowed to avoid
+	// redirecting to other sites
+	if raw := ctx.Query("returnTo"); raw != "" {
+		if returnTo, ok := s.allowedReturnTo(raw); ok {
+			sessions.Default(ctx).Set("return_to", returnTo)
+		}
+	}
+
+	s.startLogin(ctx, s.loginTenant(ctx), opts...)
+}
+
+// startLogin redirects the user to the provider of t to sign in, opts adding
+// parameters to the authorization request.
+func (s *Server) startLogin(ctx *gin.Context, t *tenant, opts ...oauth2.AuthCodeOption) {
 	state, err := generateRandomString()
 	if err != nil {
 		ctx.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	// Save state value in session storage
+	// the code verifier proves the code is exchanged by the login which
+	// requested it, without relying on the client secret
+	verifier, err := newCodeVerifier()
+	if err != nil {
+		ctx.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// the nonce binds the ID token to this login, so a token issued for
+	// another one is not accepted
+	nonce, err := generateRandomString()
+	if err != nil {
+		ctx.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Save state value in session storage, along with the tenant the
+	// callback is expected from, the code verifier and the nonce
 	session := sessions.Default(ctx)
 	session.Set("state", state)
+	session.Set("tenant", t.name)
+	session.Set("code_verifier", verifier)
+	session.Set("nonce", nonce)
+	if t.isAuth0() {
+		opts = append(opts, organizationOptions(ctx, t)...)
+	}
 
 	if err := session.Save(); err != nil {
 		ctx.JSON(http.StatusInternalServerError, "could not login")
 		return
 	}
 
-	ctx.Redirect(http.StatusTemporaryRedirect, s.oauth2config.AuthCodeURL(state))
+	opts = append(opts, oidc.Nonce(nonce))
+	opts = append(opts, codeChallengeOptions(verifier)...)
+	if s.jarm && t.isAuth0() {
+		opts = append(opts, jarmAuthCodeOption)
+	}
+	// google only issues refresh tokens to the offline logins
+	if t.kind == providerGoogle {
+		opts = append(opts, oauth2.AccessTypeOffline)
+		opts = append(opts, hostedDomainOptions(t)...)
+	}
+
+	authURL, err := s.authorizationURL(ctx, s.oauth2Config(ctx), state, opts...)
+	if err != nil {
+		slog.ErrorContext(ctx, "could not build authorization URL", "err", err)
+		ctx.JSON(http.StatusInternalServerError, "could not login")
+		return
+	}
+
+	// single page apps send the user to the provider themselves
+	if wantsJSON(ctx) {
+		ctx.JSON(http.StatusOK, gin.H{"authorization_url": authURL})
+		return
+	}
+
+	ctx.Redirect(http.StatusTemporaryRedirect, authURL)
+}
+
+// logoutPage asks the user to confirm the logout, which is only done on POST
+// so that other sites cannot log users out.
+func (s *Server) logoutPage(ctx *gin.Context) {
+	federated, _ := strconv.ParseBool(ctx.Query("federated"))
+	renderHTML(ctx, http.StatusOK, "logout.html", gin.H{"Federated": federated})
 }
 
 // logoutHandler
 func (s *Server) logoutHandler(ctx *gin.Context) {
-	// delete all the cookies and session values
-	// Set cookie timestamp as negative
-	ctx.SetCookie("at", "", -1, "/", "", false, true)
-	ctx.SetCookie("u", "", -1, "/", "", false, true)
-	ctx.SetCookie("auth-sessions", "", -1, "/", "", false, true)
+	// Read the raw id token before the session is destroyed so it can be
+	// passed as id_token_hint to the provider
+	var idToken string
+	if tokens, ok := sessionTokens(ctx); ok {
+		idToken = tokens.IDToken
+	}
+	tenant := s.tenant(ctx)
 
-	// Call auth0 logout endpoint to clear session and tokens from auth0 side
-	logoutURL, err := url.Parse("https://" + os.Getenv("AUTH0_DOMAIN") + "/v2/logout")
+	s.endLocalSession(ctx, nil)
+
+	// redirecting user back to homepage
+	redirectionURL, err := url.Parse(requestScheme(ctx) + "://" + ctx.Request.Host)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, "could not logout")
+		ctx.JSON(http.StatusInternalServerError, "could not parse URL")
 		return
 	}
 
-	// Check if request was performed via http or https
-	scheme := "http"
-	if ctx.Request.TLS != nil {
-		scheme = "https"
+	logoutURL, err := s.logoutURL(ctx, tenant, idToken, redirectionURL.String())
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, "could not logout")
+		return
 	}
 
-	// redirecting user back to homepage
-	redirectionURL, err := url.Parse(scheme + "://" + ctx.Request.Host)
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, "could not parse URL")
+	if wantsJSON(ctx) {
+		ctx.JSON(http.StatusOK, gin.H{"logout_url": logoutURL})
 		return
 	}
 
-	// add url params
+	ctx.Redirect(http.StatusSeeOther, logoutURL)
+}
+
+// endLocalSession logs the user out of this application: the session
+// record is revoked, the tokens and the session deleted. The logout is
+// audited with details.
+func (s *Server) endLocalSession(ctx *gin.Context, details map[string]string) {
+	session := sessions.Default(ctx)
+	id, _ := session.Get("sid").(string)
+	// the tokens of the session may have expired from their store, the
+	// user is then the one of the session record
+	sub := ""
+	if u, ok := currentUser(ctx); ok {
+		sub = u.Sub
+	} else if id != "" {
+		if record, err := s.users.GetSession(ctx, id); err == nil {
+			sub = record.Sub
+		}
+	}
+	if sub != "" {
+		s.audit(ctx, auditLogout, sub, details)
+		if id != "" {
+			if err := s.users.RevokeSession(ctx, sub, id, time.Now().UTC()); err != nil && !errors.Is(err, ErrSessionNotFound) {
+				slog.ErrorContext(ctx, "could not revoke session", "err", err)
+			}
+		}
+	}
+
+	if id, ok := session.Get("dpop_key").(string); ok && s.dpopKeys != nil {
+		s.dpopKeys.delete(id)
+	}
+	s.deleteSessionTokens(ctx)
+
+	// delete all the cookies and session values
+	// Set cookie timestamp as negative
+	ctx.SetCookie("u", "", -1, "/", "", false, true)
+	session.Clear()
+	session.Options(sessions.Options{Path: "/", MaxAge: -1})
+	if err := session.Save(); err != nil {
+		slog.ErrorContext(ctx, "could not delete session", "err", err)
+	}
+	ctx.SetCookie(regionCookie, "", -1, "/", "", false, true)
+	ctx.SetCookie(loggedOutCookie, "1", int(loggedOutMaxAge.Seconds()), "/", "", true, true)
+}
+
+// logoutURL returns the URL ending the session of the user at the provider
+// of tenant, sending the user back to returnTo. It is the OIDC RP-initiated
+// logout endpoint advertised by the provider, the end_session_endpoint of
+// its discovery document, with the ID token as id_token_hint so that the
+// provider can skip its confirmation. Auth0 tenants not advertising it use
+// /v2/logout, and the users of the other providers without one are sent
+// back to returnTo, their session at the provider being left as is.
+func (s *Server) logoutURL(ctx *gin.Context, tenant *tenant, idToken, returnTo string) (string, error) {
+	endpoint := tenant.provider.Metadata().EndSessionEndpoint
+	if endpoint == "" && !tenant.isAuth0() {
+		return returnTo, nil
+	}
+
 	parameters := url.Values{}
-	parameters.Add("returnTo", redirectionURL.String())
-	parameters.Add("client_id", os.Getenv("AUTH0_CLIENT_ID"))
+	if endpoint != "" {
+		if idToken != "" {
+			parameters.Set("id_token_hint", idToken)
+		}
+		parameters.Set("post_logout_redirect_uri", returnTo)
+	} else {
+		endpoint = tenant.provider.baseURL + "/v2/logout"
+		parameters.Set("returnTo", returnTo)
+	}
+	parameters.Set("client_id", tenant.oauth2config.ClientID)
+
+	logoutURL, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	// the endpoint may have parameters of its own
+	for name, values := range logoutURL.Query() {
+		if _, ok := parameters[name]; !ok {
+			parameters[name] = values
+		}
+	}
 	logoutURL.RawQuery = parameters.Encode()
 
-	ctx.Redirect(http.StatusTemporaryRedirect, logoutURL.String())
+	// federated logout also ends the upstream google session, auth0 only
+	// checks for the presence of the parameter so it is appended without value
+	if tenant.isAuth0() && s.isFederatedLogout(ctx) {
+		logoutURL.RawQuery += "&federated"
+	}
+	return logoutURL.String(), nil
+}
+
+// isFederatedLogout reports whether logout should also terminate the session
+// at the upstream identity provider. It is enabled for every request by
+// setting AUTH0_FEDERATED_LOGOUT=true, or per request with ?federated=true.
+func (s *Server) isFederatedLogout(ctx *gin.Context) bool {
+	if s.config.Auth0.FederatedLogout {
+		return true
+	}
+
+	enabled, err := strconv.ParseBool(ctx.Query("federated"))
+	return err == nil && enabled
 }
 
 // callbackHandler handles the callback route.
 func (s *Server) callbackHandler(ctx *gin.Context) {
+	// with JARM the response parameters are only read from the verified
+	// response JWT
+	if s.jarm && s.tenant(ctx).isAuth0() {
+		if err := s.unwrapJARMResponse(ctx); err != nil {
+			slog.WarnContext(ctx, "invalid authorization response", "err", err)
+			s.audit(ctx, auditLoginFailure, "", map[string]string{"reason": "invalid_authorization_response"})
+			s.loginFailed(ctx)
+			renderError(ctx, http.StatusBadRequest, "Sign in failed", "The sign in response could not be verified, please sign in again.")
+			return
+		}
+	}
+
+	// auth0 redirects back with an error when the user cancelled the sign in,
+	// denied consent or is blocked
+	if s.handleCallbackError(ctx) {
+		return
+	}
+
+	// A repeated callback for the same code waits for the first one to
+	// complete and lands on the profile page instead of exchanging the code
+	// again
+	entry, first := s.callbacks.claim(ctx.Query("state"), ctx.Query("code"))
+	if !first {
+		select {
+		case <-entry.done:
+		case <-ctx.Done():
+			renderError(ctx, http.StatusGatewayTimeout, "Sign in is taking too long", "Please try signing in again.")
+			return
+		}
+
+		if !entry.ok {
+			renderError(ctx, http.StatusConflict, "Sign in link already used", "This sign in attempt failed earlier, please sign in again.")
+			return
+		}
+
+		if wantsJSON(ctx) {
+			ctx.JSON(http.StatusOK, gin.H{"return_to": defaultReturnTo})
+			return
+		}
+		ctx.Redirect(http.StatusTemporaryRedirect, defaultReturnTo)
+		return
+	}
+
+	signedIn := false
+	defer func() { entry.finish(signedIn) }()
 
 	// Checking if state param passed from callback matches what's stored in
 	// memory
 	session := sessions.Default(ctx)
 	if session.Get("state") != ctx.Query("state") {
+		s.audit(ctx, auditLoginFailure, "", map[string]string{"reason": "invalid_state"})
+		s.loginFailed(ctx)
 		ctx.JSON(http.StatusInternalServerError, "invalid state param")
 		return
 	}
 
-	// get authorization code
+	// the email address of the login may have been targeted by other
+	// attempts meanwhile
+	if s.throttleCallback(ctx, session) {
+		return
+	}
+
+	// get authorization code, exchanged with the provider the login was
+	// started with
 	code := ctx.Query("code")
-	token, err := s.oauth2config.Exchange(ctx, code)
+	tenant := s.tenant(ctx)
+	oauth2Config := s.oauth2Config(ctx)
+
+	// with DPoP the tokens are bound to a proof key generated for the
+	// session, used for the token request and the requests made with them
+	clientCtx := s.clientContext(ctx)
+	var dpopKeyID string
+	if s.dpopKeys != nil && tenant.isAuth0() {
+		id, key, err := s.dpopKeys.generate()
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, "could not generate proof key")
+			return
+		}
+		dpopKeyID = id
+		clientCtx = dpopClientContext(ctx, key, s.transport)
+	}
+
+	authOpts, err := s.tokenRequestOptions(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, "could not authenticate client")
+		return
+	}
+
+	// logins started before an upgrade have no verifier
+	if verifier, ok := session.Get("code_verifier").(string); ok {
+		authOpts = append(authOpts, codeVerifierOption(verifier))
+	}
+
+	// the token returned by the code exchange is for the first resource
+	if len(s.resources) > 0 && tenant.isAuth0() {
+		authOpts = append(authOpts, oauth2.SetAuthURLParam("resource", s.resources[0]))
+	}
+
+	var token *oauth2.Token
+	err = s.withClientSecret(ctx, func(secret string) error {
+		oauth2Config.ClientSecret = secret
+		token, err = oauth2Config.Exchange(clientCtx, code, authOpts...)
+		return err
+	})
 	if err != nil {
+		s.audit(ctx, auditLoginFailure, "", map[string]string{"reason": "code_exchange_failed"})
+		s.loginFailed(ctx)
 		ctx.JSON(http.StatusInternalServerError, "could not exchange oauth code")
 		return
 	}
@@ -162,97 +927,532 @@ func (s *Server) callbackHandler(ctx *gin.Context) {
 		return
 	}
 
-	// get user information to display in profile
-	client := s.oauth2config.Client(ctx, token)
-	resp, err := client.Get("https://" + os.Getenv("AUTH0_DOMAIN") + "/userinfo")
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, "could not fetch user information")
+	if dpopKeyID != "" && !strings.EqualFold(token.TokenType, "DPoP") {
+		ctx.JSON(http.StatusInternalServerError, "access token is not bound to the proof key")
 		return
 	}
 
-	// parse response body
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, "could not parse response body")
+	if s.certThumbprint != "" && tenant.isAuth0() {
+		if err := checkCertificateBinding(token.AccessToken, s.certThumbprint); err != nil {
+			ctx.JSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	// the user is known from the verified ID token, or from the API of the
+	// providers without one
+	var rawIDToken string
+	var b []byte
+	if tenant.hasIDToken() {
+		var ok bool
+		if rawIDToken, b, ok = s.callbackIDToken(ctx, tenant, token); !ok {
+			return
+		}
+	} else {
+		saveOrganization(session, Organization{})
+		b, err = fetchGitHubProfile(clientCtx, oauth2Config.Client(clientCtx, token))
+		if err != nil {
+			slog.ErrorContext(ctx, "could not fetch user profile", "err", err)
+			s.audit(ctx, auditLoginFailure, "", map[string]string{"reason": "profile_unavailable"})
+			s.loginFailed(ctx)
+			ctx.JSON(http.StatusInternalServerError, "could not fetch user information")
+			return
+		}
+	}
+
+	// the state and nonce matched: the signed in session gets an ID of its
+	// own rather than the one the browser came with
+	if err := regenerateSessionID(ctx, session); err != nil {
+		slog.ErrorContext(ctx, "could not regenerate session", "err", err)
+		ctx.JSON(http.StatusInternalServerError, "could not save session")
+		return
+	}
+
+	session.Delete("state")
+	session.Delete("code_verifier")
+	session.Delete("nonce")
+	session.Delete("login_hint")
+	rememberLoginConnection(ctx, session)
+	session.Delete("profile_complete")
+	session.Delete("terms_accepted")
+	session.Set("login_at", strconv.FormatInt(time.Now().UnixNano(), 10))
+	if s.region != "" {
+		session.Set("region", s.region)
+		ctx.SetCookie(regionCookie, s.region, 0, "/", "", true, true)
+	}
+	if dpopKeyID != "" {
+		session.Set("dpop_key", dpopKeyID)
+	}
+
+	// the tokens are kept server-side, the session only referencing them,
+	// under a new ID for every login
+	tokens := &SessionTokens{
+		AccessToken:  token.AccessToken,
+		TokenType:    token.Type(),
+		Expiry:       token.Expiry,
+		Scope:        grantedScope(token, strings.Join(oauth2Config.Scopes, " ")),
+		RefreshToken: token.RefreshToken,
+		IDToken:      rawIDToken,
+		Profile:      b,
+	}
+	if len(s.resources) > 0 && tenant.isAuth0() {
+		cacheResourceToken(tokens, s.resources[0], token)
+	}
+	s.deleteSessionTokens(ctx)
+	if err := s.saveSessionTokens(ctx, tokens); err != nil {
+		slog.ErrorContext(ctx, "could not save session tokens", "err", err)
+		ctx.JSON(http.StatusInternalServerError, "could not save session")
+		return
+	}
+	if err := session.Save(); err != nil {
+		ctx.JSON(http.StatusInternalServerError, "could not save session")
+		return
 	}
 
-	// TODO: cookie should be encrypted before storing.
-	// save access token and response body in cookie
-	// u => userInfo
-	ctx.SetCookie("u", string(b), int(time.Now().Add(1*time.Hour).Unix()), "/", "localhost", true, true)
-	// at => accessToken
-	ctx.SetCookie("at", token.AccessToken, int(time.Now().Add(1*time.Hour).Unix()), "/", "localhost", true, true)
+	// the user information displayed in the profile comes from the claims
+	// of the verified ID token, or from the GitHub API
+	var u UserInfo
+	if err := json.Unmarshal(b, &u); err != nil {
+		ctx.JSON(http.StatusInternalServerError, "could not parse user information")
+		return
+	}
+
+	// look the user record up before it is updated, a first login not
+	// being a new device
+	if s.notifications != nil {
+		s.alertNewDevice(ctx, &u)
+	}
+
+	// keep the local user record in sync with auth0
+	if err := s.users.UpsertUser(ctx, &User{
+		Sub:           u.Sub,
+		Email:         u.Email,
+		EmailVerified: u.EmailVerified,
+		Name:          u.Name,
+		Picture:       u.Picture,
+		LastLoginAt:   time.Now(),
+	}); err != nil {
+		slog.ErrorContext(ctx, "could not save user", "err", err)
+	}
+
+	// back to the page requested before the login
+	returnTo := s.popReturnTo(session)
+
+	// record the session for the sessions page, now that the user is known,
+	// with the auth0 session back-channel logouts end
+	var providerSession struct {
+		SID string `json:"sid"`
+	}
+	_ = json.Unmarshal(b, &providerSession)
+	s.startUserSession(ctx, session, u.Sub, providerSession.SID)
+	if err := session.Save(); err != nil {
+		ctx.JSON(http.StatusInternalServerError, "could not save session")
+		return
+	}
 
-	ctx.Redirect(http.StatusTemporaryRedirect, "/profile")
+	// the user is only read from the claims kept server-side, the cookies
+	// of the sessions from before are deleted
+	ctx.SetCookie("u", "", -1, "/", "", false, true)
+	ctx.SetCookie("at", "", -1, "/", "", false, true)
+
+	s.audit(ctx, auditLoginSuccess, u.Sub, nil)
+
+	signedIn = true
+	// single page apps forward the callback parameters and navigate
+	// themselves once the session is set
+	if wantsJSON(ctx) {
+		ctx.JSON(http.StatusOK, gin.H{"user": u, "return_to": returnTo})
+		return
+	}
+	ctx.Redirect(http.StatusTemporaryRedirect, returnTo)
 }
 
 func main() {
-	server, err := NewServer()
+	if len(os.Args) > 1 && os.Args[1] == "admin" {
+		os.Exit(runAdminCLI(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
+	// CONFIG_FILE is an optional YAML or TOML file holding the settings,
+	// which the environment variables override
+	config, err := LoadConfig(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		fatal("could not load config", err)
+	}
+
+	// LOG_FORMAT and LOG_LEVEL configure the logs
+	if err := setupLogging(config.Log); err != nil {
+		fatal("could not set up logging", err)
+	}
+	logRedactor.add(configSecrets(config)...)
+
+	server, err := NewServer(config)
 	if err != nil {
-		log.Fatalf("could not create new server: %v", err)
+		fatal("could not create new server", err)
+	}
+	defer server.tracer.Close()
+
+	// BRAND_* white-label the pages
+	brand, err := loadBrand(config.Brand)
+	if err != nil {
+		fatal("could not load branding", err)
+	}
+
+	// CORS_* let single page apps hosted on other origins call the server
+	cors, err := loadCORSPolicy(config.CORS)
+	if err != nil {
+		fatal("could not load CORS policy", err)
+	}
+	server.router.Use(Tracing(server.tracer), ForwardedHeaders(server.trustedProxies), RequestID(), TraceID(), AccessLog(), Recovery(server.errorReporter), Branding(brand), CORS(cors), server.MaintenanceMode())
+
+	// the probes are registered before the session middleware, so that they
+	// do not create sessions
+	server.router.GET("/healthz", livenessHandler)
+	server.router.GET("/livez", livenessHandler)
+	server.router.GET("/readyz", server.readinessHandler)
+
+	// NOTIFY_PROVIDER sends the new device alerts, terms receipts and admin
+	// alerts by email
+	server.notifications, err = newNotifications(config.Notify, brand.AppName)
+	if err != nil {
+		fatal("could not create notifications", err)
+	}
+	if server.notifications != nil {
+		defer server.notifications.close()
+		server.scheduler.OnFailure(server.notifyJobFailure)
+	}
+
+	// Periodically reconcile the local user records with the Management API
+	if userSyncInterval := time.Duration(config.Users.SyncInterval); userSyncInterval > 0 {
+		userSync, err := NewUserSync(server.management, server.users, config.Users.SyncCheckpointFile)
+		if err != nil {
+			fatal("could not create user sync", err)
+		}
+		if err := server.scheduler.Add(Job{
+			Name:     "user_sync",
+			Interval: userSyncInterval,
+			Jitter:   userSyncInterval / 10,
+			Run:      userSync.Run,
+		}); err != nil {
+			fatal("could not schedule user sync", err)
+		}
+	}
+
+	// Periodically discover the provider metadata again so that rotated
+	// endpoints and keys are picked up without a restart
+	if discoveryInterval := time.Duration(config.Server.ProviderRefreshInterval); discoveryInterval > 0 {
+		if err := server.scheduler.Add(Job{
+			Name:     "provider_refresh",
+			Interval: discoveryInterval,
+			Jitter:   discoveryInterval / 10,
+			Run:      server.refreshProviders,
+		}); err != nil {
+			fatal("could not schedule provider refresh", err)
+		}
+	}
+
+	// health checks pick the tenant new logins are sent to
+	if len(server.tenants) > 1 {
+		if err := server.scheduler.Add(Job{
+			Name:     "tenant_health",
+			Interval: time.Duration(config.Server.TenantHealthInterval),
+			Run:      server.checkTenants,
+		}); err != nil {
+			fatal("could not schedule tenant health", err)
+		}
+	}
+
+	// SESSION_REPLICATION shares the session revocations with the instances
+	// of the other regions, through SESSION_REPLICATION_REDIS_URL or the
+	// shared REDIS_URL
+	if config.Sessions.Replication {
+		redis := server.redis
+		if redisURL := config.Sessions.ReplicationRedisURL; redisURL != "" {
+			redis, err = NewRedisClient(redisURL)
+			if err != nil {
+				fatal("could not create session replication client", err)
+			}
+		}
+		if redis == nil {
+			fatal("could not create session replication", fmt.Errorf("SESSION_REPLICATION needs REDIS_URL or SESSION_REPLICATION_REDIS_URL"))
+		}
+
+		replicator, err := NewSessionReplicator(redis, server.region, server.revocations)
+		if err != nil {
+			fatal("could not create session replication", err)
+		}
+		if err := server.scheduler.Add(Job{
+			Name:     "session_replication",
+			Interval: time.Duration(config.Sessions.ReplicationInterval),
+			Run:      replicator.Run,
+		}); err != nil {
+			fatal("could not schedule session replication", err)
+		}
 	}
 
 	// Define session storage
-	// TODO: pass this secret from env variable
-	store := cookie.NewStore([]byte("superSecretValue"))
-	server.router.Use(sessions.Sessions("auth-sessions", store))
+	codec, err := NewSessionCodec(config.Sessions.Codec)
+	if err != nil {
+		fatal("could not create session codec", err)
+	}
+	// SESSION_AUTH_KEYS and SESSION_ENCRYPTION_KEYS sign and encrypt the
+	// session cookies
+	sessionKeys, err := sessionKeyPairs(config.Sessions)
+	if err != nil {
+		fatal("could not load session keys", err)
+	}
 
-	server.router.Static("/public", "web/static")
-	server.router.LoadHTMLGlob("web/template/*")
+	// SESSION_BACKEND picks where the session values live: in the cookie,
+	// in redis, postgres or memory, the cookie then only holding the ID
+	store, err := NewSessionStore(config.Sessions.Backend, codec, config.Server.RedisURL, config.Server.DatabaseURL, sessionKeys...)
+	if err != nil {
+		fatal("could not create session store", err)
+	}
+	// SecureSessionCookie marks the session cookie Secure on HTTPS requests
+	// SESSION_COOKIE_SAMESITE=none sends the session cookie to the frames
+	// of the front-channel logouts
+	sessionCookieOptions.SameSite = http.SameSite(config.Sessions.CookieSameSite)
+	store.Options(sessionCookieOptions)
+
+	// /readyz checks the provider and the stores of the sessions
+	server.readiness.Add("provider", server.checkTenants)
+	if server.redis != nil {
+		server.readiness.Add("redis", server.redis.Ping)
+	}
+	if pinger, ok := store.(interface{ Ping(context.Context) error }); ok {
+		server.readiness.Add("session_store", pinger.Ping)
+	}
+	// CSRF checks the token of the forms and API calls made with the session
+	// SITES get a session cookie of their own
+	server.router.Use(server.Sessions(store), SecureSessionCookie(), server.LoadSessionTokens(), server.CSRF())
+	if server.region != "" {
+		server.router.Use(SessionRegion(server.region))
+	}
+
+	// TEMPLATE_DIR may point at a directory whose templates replace the
+	// embedded ones with the same name
+	renderer, err := loadTemplates(config.Server.TemplateDir)
+	if err != nil {
+		fatal("could not load templates", err)
+	}
+	server.router.HTMLRender = renderer
+	server.router.StaticFS("/public", staticFS())
 
 	server.router.GET("/ping", func(ctx *gin.Context) {
 		ctx.JSON(http.StatusOK, "pong")
 	})
 
+	loginAutoRedirect := config.Login.AutoRedirect
 	server.router.GET("/", func(ctx *gin.Context) {
-		ctx.HTML(http.StatusOK, "home.html", nil)
+		data := gin.H{"Providers": server.loginProviders(ctx)}
+		if server.homeRealm != nil {
+			data["LoginURL"] = "/login/identify"
+		}
+		if server.connections != nil {
+			connections, err := server.connections.List(ctx)
+			if err != nil {
+				slog.ErrorContext(ctx, "could not list connections", "err", err)
+			}
+
+			// the connection used last time is suggested first, and
+			// LOGIN_AUTO_REDIRECT sends the user straight to it
+			if last, ok := lastConnection(ctx, connections); ok {
+				if _, loggedIn := currentUser(ctx); !loggedIn && loginAutoRedirect && autoLogin(ctx) {
+					ctx.Redirect(http.StatusTemporaryRedirect, "/login?connection="+url.QueryEscape(last.Name))
+					return
+				}
+				data["LastConnection"] = last
+				ordered := []Connection{*last}
+				for _, connection := range connections {
+					if connection.Name != last.Name {
+						ordered = append(ordered, connection)
+					}
+				}
+				connections = ordered
+			}
+			data["Connections"] = connections
+		}
+		renderHTML(ctx, http.StatusOK, "home.html", data)
 	})
 
-	server.router.GET("/profile", IsAuthenticated(), func(ctx *gin.Context) {
-		// Show user information in profile
+	// Deadlines for the routes, the callback calls auth0 twice so it gets a
+	// longer one
+	requestTimeout := time.Duration(config.Server.RequestTimeout)
+	callbackTimeout := time.Duration(config.Server.CallbackTimeout)
 
-		userInfo, err := ctx.Cookie("u")
-		if err != nil {
+	// log lines of signed in users name them, LOG_HASH_SUB logging a hash
+	// of the sub instead and LOG_ROLES_CLAIM naming the claim with the roles
+	requestLogger := NewRequestLogger(config.Log.HashSub, config.Log.RolesClaim)
+
+	// pages for signed in users, REQUIRED_GROUPS restricting them to the
+	// members of the listed Google Workspace groups
+	signedIn := []gin.HandlerFunc{Timeout(requestTimeout), server.RefreshAccessTokens(), IsAuthenticated(server.idpHealth.maxStaleness), requestLogger.LogUser(), server.RejectBlockedUsers(), server.RejectRevokedSessions(), server.TrackSessions(), server.LoadPreferences()}
+
+	// SESSION_REVALIDATE_INTERVAL fetches the claims of signed in users
+	// again, ending the sessions whose access token is revoked
+	if revalidateInterval := time.Duration(config.Sessions.RevalidateInterval); revalidateInterval > 0 {
+		signedIn = append(signedIn, server.RevalidateSessions(revalidateInterval))
+	}
+	if groups := config.Access.RequiredGroups; len(groups) > 0 {
+		signedIn = append(signedIn, server.RequireGroups(groups...))
+	}
+	// REQUIRED_ROLES restricts them to the users having one of the roles of
+	// ROLES_CLAIM
+	if roles := config.Access.RequiredRoles; len(roles) > 0 {
+		signedIn = append(signedIn, server.RequireRole(roles...))
+	}
+
+	// the terms page is the only page reachable before the current terms
+	// are accepted
+	if server.terms != nil {
+		acceptance := signedIn[:len(signedIn):len(signedIn)]
+		server.router.GET("/terms", append(acceptance, server.termsPage)...)
+		server.router.POST("/terms", append(acceptance, server.termsHandler)...)
+		signedIn = append(signedIn, server.RequireAcceptedTerms())
+	}
+
+	// the profile form is the only page reachable before the profile is
+	// complete
+	if server.profileForm != nil {
+		completion := signedIn[:len(signedIn):len(signedIn)]
+		server.router.GET("/profile/complete", append(completion, server.profileFormPage)...)
+		server.router.POST("/profile/complete", append(completion, server.profileFormHandler)...)
+		signedIn = append(signedIn, server.RequireCompleteProfile())
+	}
+
+	server.router.GET("/profile", append(signedIn, func(ctx *gin.Context) {
+		// Show user information in profile
+		u, ok := currentUser(ctx)
+		if !ok {
 			// if user info cookie does not exists, then we redirect user back to home page
+			addFlash(ctx, flashError, "Something went wrong, please try logging in again.")
 			ctx.Redirect(http.StatusTemporaryRedirect, "/")
 			return
 		}
 
-		var u UserInfo
-		if err := json.Unmarshal([]byte(userInfo), &u); err != nil {
-			ctx.JSON(http.StatusInternalServerError, "something wrong. Please try logging in again")
-		}
-
-		ctx.HTML(http.StatusOK, "profile.html", gin.H{
-			"Profile": u,
+		organization, _ := sessionOrganization(ctx)
+		renderHTML(ctx, http.StatusOK, "profile.html", gin.H{
+			"Profile":      u,
+			"Organization": organization,
+			"People":       server.peopleProfile(ctx),
+			"Acceptances":  server.acceptedDocuments(ctx, u.Sub),
 		})
-	})
+	})...)
 
-	server.router.GET("/login", server.loginHandler)
-	server.router.GET("/logout", server.logoutHandler)
+	// JSON API for signed in users and personal access tokens
+	api := newAPIRoutes(server.router.Group("/api/v1", Timeout(requestTimeout), server.APIAuth(), requestLogger.LogUser(), server.RejectBlockedUsers(), server.RejectRevokedSessions(), server.TrackSessions(), server.LoadPreferences(), server.APIQuota()), securitySession, securityAccessToken)
+	api.handle(apiOperation{method: http.MethodGet, path: "/me", summary: "Show the signed in user", scope: "profile:read"}, server.meHandler)
+	api.handle(apiOperation{method: http.MethodGet, path: "/preferences", summary: "Show the preferences of the user", scope: "preferences:read"}, server.getPreferencesHandler)
+	api.handle(apiOperation{method: http.MethodPut, path: "/preferences", summary: "Update the preferences of the user", scope: "preferences:write", body: "timezone, locale and notifications"}, server.putPreferencesHandler)
+	api.handle(apiOperation{method: http.MethodGet, path: "/usage", summary: "Show the API usage of the user", scope: "usage:read"}, server.usageHandler)
+	api.handle(apiOperation{method: http.MethodGet, path: "/sessions", summary: "List the sessions of the user", scope: "sessions:read"}, server.sessionsHandler)
+	api.handle(apiOperation{method: http.MethodDelete, path: "/sessions", summary: "Sign out everywhere", scope: "sessions:write", status: http.StatusNoContent}, server.revokeSessionsAPIHandler)
+	api.handle(apiOperation{method: http.MethodDelete, path: "/sessions/:id", summary: "Revoke a session of the user", scope: "sessions:write", status: http.StatusNoContent}, server.revokeSessionAPIHandler)
+	server.publicAPI = api
 
-	server.router.GET("/callback", server.callbackHandler)
+	// personal access tokens are managed with the session only
+	server.router.GET("/account/tokens", append(signedIn, server.accessTokensPage)...)
+	server.router.POST("/account/tokens", append(signedIn, server.createAccessTokenHandler)...)
+	server.router.POST("/account/tokens/:id/revoke", append(signedIn, server.revokeAccessTokenHandler)...)
+	server.router.GET("/account/sessions", append(signedIn, server.accountSessionsPage)...)
+	server.router.POST("/account/sessions/:id/revoke", append(signedIn, server.revokeAccountSessionHandler)...)
+	server.router.POST("/account/sessions/revoke", append(signedIn, server.revokeAllAccountSessionsHandler)...)
 
-	if err := server.router.Run(":9090"); err != nil {
-		log.Fatalf("could not run server: %v", err)
+	// DEBUG_TOKEN and DEBUG_ROLES let the operators, and the signed in users
+	// having one of the roles, collect profiles from production
+	debugToken, debugRoles := config.Debug.Token, config.Debug.Roles
+	if debugToken != "" || len(debugRoles) > 0 {
+		debugRoutes(server.router, server.DebugAuth(debugToken, debugRoles))
+	}
+
+	// FILES_DIR holds files only downloaded with signed URLs
+	if dir := config.Server.FilesDir; dir != "" {
+		files := server.router.Group("/files", Timeout(requestTimeout), server.RequireSignedURL())
+		files.StaticFS("/", gin.Dir(dir, false))
+	}
+
+	server.router.GET("/login", Timeout(requestTimeout), server.loginHandler)
+	server.router.GET("/login/:provider", Timeout(requestTimeout), server.providerLoginHandler)
+	if server.homeRealm != nil {
+		server.router.GET("/login/identify", Timeout(requestTimeout), server.identifyPage)
+		server.router.POST("/login/identify", Timeout(requestTimeout), server.identifyHandler)
+	}
+	server.router.GET("/logout", Timeout(requestTimeout), server.logoutPage)
+	server.router.POST("/logout", Timeout(requestTimeout), server.logoutHandler)
+
+	server.router.GET("/callback", Timeout(callbackTimeout), server.callbackHandler)
+
+	// the CLIs sign in with the device authorization grant (RFC 8628), the
+	// user confirming the code from the verification page
+	if server.deviceFlow != nil {
+		server.router.POST("/device/code", Timeout(requestTimeout), server.deviceCodeHandler)
+		server.router.POST("/device/token", Timeout(requestTimeout), server.deviceTokenHandler)
+		server.router.GET("/device", Timeout(requestTimeout), server.devicePage)
+		server.router.GET("/device/status", Timeout(requestTimeout), server.deviceStatusHandler)
+	}
+
+	// user events sent by an auth0 action keep the local user records up to
+	// date between logins
+	if server.webhookSecret != "" {
+		verifier := server.webhooks.HMACVerifier(server.webhookSecret, config.Auth0.WebhookRequireTimestamp)
+		server.router.POST("/webhooks/auth0/users", Timeout(requestTimeout),
+			server.VerifyWebhook("auth0_users", verifier, rejectWebhook), server.userEventsHandler)
+	}
+
+	// security event tokens pushed by a shared signals transmitter revoke
+	// the sessions of disabled or compromised accounts
+	if server.securityEvents != nil {
+		server.router.POST("/ssf/events", Timeout(requestTimeout),
+			server.VerifyWebhook("ssf", server.securityEvents, rejectSecurityEvent), server.securityEventsHandler)
+	}
+
+	// logout tokens posted by auth0 end the sessions of the users logged out
+	// elsewhere
+	server.router.POST("/oidc/backchannel-logout", Timeout(requestTimeout),
+		server.VerifyWebhook("backchannel_logout", &backChannelLogoutVerifier{server: server}, rejectLogoutToken), server.backChannelLogoutHandler)
+	// as do the frames of the logout pages of the providers which
+	// broadcast logouts in the browser
+	server.router.GET("/oidc/frontchannel-logout", Timeout(requestTimeout), server.frontChannelLogoutHandler)
+
+	server.scheduler.Start()
+	defer server.scheduler.Stop()
+
+	if err := server.Run(config.Server.ListenAddr); err != nil {
+		fatal("could not run server", err)
 	}
 }
 
 // Implement authenticaton middleware to make sure user is authenticated before taking to
-// protected endpoint
-func IsAuthenticated() gin.HandlerFunc {
+// protected endpoint. When the access token could not be renewed during an
+// outage of the identity provider, the request being flagged as degraded,
+// the session goes on with its expired access token until its claims are
+// older than maxStaleness.
+func IsAuthenticated(maxStaleness time.Duration) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		accessToken, err := ctx.Cookie("at")
-		if err != nil {
-			// Cookie does not exists, hence abort and redirect user to home page or login page
-			ctx.Redirect(http.StatusTemporaryRedirect, "/")
-			ctx.Abort()
-			return
+		tokens, ok := sessionTokens(ctx)
+		expired := ok && !tokens.Expiry.IsZero() && time.Now().After(tokens.Expiry)
+		if expired && ctx.GetBool("degraded") && time.Since(claimsCheckedAt(ctx)) < maxStaleness {
+			expired = false
 		}
-
-		// Check if token is empty
-		if accessToken == "" {
-			// Cookie does not exists, hence abort and redirect user to home page or login page
+		if !ok || tokens.AccessToken == "" || expired {
+			// Tokens do not exist or the access token expired, hence abort
+			// and redirect user to home page or login page. A session still
+			// referencing tokens means they expired.
+			if wantsJSON(ctx) {
+				abortWithError(ctx, http.StatusUnauthorized, "unauthorized", "sign in to continue")
+				return
+			}
+			session := defaultSession(ctx)
+			// the page is shown again after the login, saved with the flash
+			if session != nil && (ctx.Request.Method == http.MethodGet || ctx.Request.Method == http.MethodHead) {
+				if path, ok := localPath(ctx.Request.URL.RequestURI()); ok {
+					session.Set("return_to", path)
+				}
+			}
+			if session != nil && session.Get("tid") != nil {
+				addFlash(ctx, flashWarning, "Your session expired, please log in again.")
+			} else {
+				addFlash(ctx, flashWarning, "Please sign in to continue.")
+			}
 			ctx.Redirect(http.StatusTemporaryRedirect, "/")
 			ctx.Abort()
 			return
@@ -263,6 +1463,17 @@ func IsAuthenticated() gin.HandlerFunc {
 	}
 }
 
+// splitList splits a comma separated list, dropping empty values.
+func splitList(raw string) []string {
+	var values []string
+	for _, value := range strings.Split(raw, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
 func generateRandomString() (string, error) {
 	b := make([]byte, 32)
 	_, err := rand.Read(b)
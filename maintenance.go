@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maintenanceKey is the Redis key holding the maintenance state shared
+	// by the instances.
+	maintenanceKey = "go-auth0:maintenance"
+	// maintenanceCacheTTL is how long an instance caches the shared state.
+	maintenanceCacheTTL = 5 * time.Second
+	// defaultMaintenanceMessage is shown when no message is set.
+	defaultMaintenanceMessage = "The service is undergoing maintenance, please try again in a few minutes."
+)
+
+// MaintenanceState is whether the site is in maintenance mode.
+type MaintenanceState struct {
+	Enabled bool       `json:"enabled"`
+	Message string     `json:"message,omitempty"`
+	Since   *time.Time `json:"since,omitempty"`
+}
+
+// Maintenance holds the maintenance mode, in Redis when set so that every
+// instance follows it, and in memory otherwise.
+type Maintenance struct {
+	redis *RedisClient
+
+	mu      sync.Mutex
+	state   MaintenanceState
+	fetched time.Time
+}
+
+// NewMaintenance creates a maintenance mode shared through redisClient when
+// set.
+func NewMaintenance(redisClient *RedisClient) *Maintenance {
+	return &Maintenance{redis: redisClient}
+}
+
+// State returns the current maintenance state. The last known state is kept
+// when Redis cannot be reached.
+func (m *Maintenance) State(ctx context.Context) MaintenanceState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.redis == nil || time.Since(m.fetched) < maintenanceCacheTTL {
+		return m.state
+	}
+
+	reply, err := m.redis.Do(ctx, "GET", maintenanceKey)
+	if err != nil {
+		slog.ErrorContext(ctx, "could not read maintenance state", "err", err)
+		return m.state
+	}
+
+	state := MaintenanceState{}
+	if value, ok := reply.(string); ok {
+		if err := json.Unmarshal([]byte(value), &state); err != nil {
+			slog.ErrorContext(ctx, "could not decode maintenance state", "err", err)
+			return m.state
+		}
+	}
+	m.state, m.fetched = state, time.Now()
+	return state
+}
+
+// Set changes the maintenance state.
+func (m *Maintenance) Set(ctx context.Context, state MaintenanceState) error {
+	if m.redis != nil {
+		value, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		if _, err := m.redis.Do(ctx, "SET", maintenanceKey, string(value)); err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state, m.fetched = state, time.Now()
+	return nil
+}
+
+// MaintenanceMode answers the requests with 503 while the site is in
+// maintenance mode. Webhooks are still received so that no event is lost,
+// and the static files are served for the maintenance page.
+func (s *Server) MaintenanceMode() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		path := ctx.Request.URL.Path
+		if strings.HasPrefix(path, "/webhooks/") || strings.HasPrefix(path, "/ssf/") || strings.HasPrefix(path, "/public/") || probePaths[path] {
+			ctx.Next()
+			return
+		}
+
+		state := s.maintenance.State(ctx)
+		if !state.Enabled {
+			ctx.Next()
+			return
+		}
+
+		message := state.Message
+		if message == "" {
+			message = defaultMaintenanceMessage
+		}
+		ctx.Header("Retry-After", "300")
+		if strings.HasPrefix(path, "/api/") {
+			abortWithError(ctx, http.StatusServiceUnavailable, "maintenance", message)
+			return
+		}
+		renderError(ctx, http.StatusServiceUnavailable, "Down for maintenance", message)
+	}
+}
+
+// maintenanceHandler reports the maintenance state for the admin API.
+func (s *Server) maintenanceHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, s.maintenance.State(ctx))
+}
+
+// setMaintenanceHandler turns the maintenance mode on or off for the admin
+// API.
+func (s *Server) setMaintenanceHandler(ctx *gin.Context) {
+	var req struct {
+		Enabled bool   `json:"enabled"`
+		Message string `json:"message"`
+	}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "invalid_request", "could not parse request body")
+		return
+	}
+
+	state := MaintenanceState{Enabled: req.Enabled}
+	if req.Enabled {
+		now := time.Now().UTC()
+		state.Message, state.Since = req.Message, &now
+	}
+	if err := s.maintenance.Set(ctx, state); err != nil {
+		slog.ErrorContext(ctx, "could not set maintenance state", "err", err)
+		abortWithError(ctx, http.StatusInternalServerError, "server_error", "could not set maintenance state")
+		return
+	}
+
+	slog.InfoContext(ctx, "maintenance mode set", "enabled", state.Enabled)
+	ctx.JSON(http.StatusOK, state)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// managementMaxAttempts is how many times a rate limited Management API
+// request is attempted.
+const managementMaxAttempts = 5
+
+// ManagementClient calls the auth0 Management API with a machine to machine
+// token, pacing requests according to the rate limit headers.
+type ManagementClient struct {
+	baseURL  string
+	audience string
+	tokens   *M2MTokens
+	client   *http.Client
+
+	mu          sync.Mutex
+	nextRequest time.Time // requests wait until then once the limit is reached
+}
+
+// NewManagementClient creates a client for the Management API of the tenant
+// at domain, authenticated with the tokens of the application, which must
+// be authorized to call the Management API. Requests are sent through
+// transport.
+func NewManagementClient(domain string, tokens *M2MTokens, transport http.RoundTripper) *ManagementClient {
+	audience := "https://" + domain + "/api/v2/"
+	return &ManagementClient{
+		baseURL:  "https://" + domain + "/api/v2",
+		audience: audience,
+		tokens:   tokens,
+		client:   &http.Client{Transport: tokens.Transport(audience, transport)},
+	}
+}
+
+// get sends a GET request to path with query and decodes the JSON response
+// into v.
+func (m *ManagementClient) get(ctx context.Context, path string, query url.Values, v interface{}) error {
+	return m.do(ctx, http.MethodGet, path, query, nil, v)
+}
+
+// ManagementError is returned when the Management API answers with an error
+// status.
+type ManagementError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Status     string
+	Body       []byte
+}
+
+func (e *ManagementError) Error() string {
+	return fmt.Sprintf("management API %s %s: %s: %s", e.Method, e.Path, e.Status, e.Body)
+}
+
+// do sends a request to the Management API, retrying when rate limited, and
+// decodes the JSON response into v when set.
+func (m *ManagementClient) do(ctx context.Context, method, path string, query url.Values, body []byte, v interface{}) error {
+	requestURL := m.baseURL + path
+	if len(query) > 0 {
+		requestURL += "?" + query.Encode()
+	}
+
+	for attempt := 1; ; attempt++ {
+		if err := m.wait(ctx); err != nil {
+			return err
+		}
+
+		var reqBody io.Reader
+		if body != nil {
+			reqBody = bytes.NewReader(body)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, requestURL, reqBody)
+		if err != nil {
+			return fmt.Errorf("could not create management request: %v", err)
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		resp, err := m.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("could not call management API: %w", err)
+		}
+
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("could not read management API response: %v", err)
+		}
+
+		m.updateRateLimit(resp)
+
+		if resp.StatusCode == http.StatusTooManyRequests && attempt < managementMaxAttempts {
+			continue
+		}
+		// a token revoked or issued before the grant changed is renewed once
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 1 {
+			m.tokens.Forget(m.audience)
+			continue
+		}
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return &ManagementError{Method: method, Path: path, StatusCode: resp.StatusCode, Status: resp.Status, Body: b}
+		}
+
+		if v == nil || len(b) == 0 {
+			return nil
+		}
+		if err := json.Unmarshal(b, v); err != nil {
+			return fmt.Errorf("could not decode management API response: %v", err)
+		}
+		return nil
+	}
+}
+
+// wait blocks until the rate limit allows another request.
+func (m *ManagementClient) wait(ctx context.Context) error {
+	m.mu.Lock()
+	delay := time.Until(m.nextRequest)
+	m.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// updateRateLimit delays the next request until the limit resets when the
+// request was rate limited or no request is left in the current window.
+func (m *ManagementClient) updateRateLimit(resp *http.Response) {
+	limited := resp.StatusCode == http.StatusTooManyRequests
+	if remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining")); err == nil && remaining <= 0 {
+		limited = true
+	}
+	if !limited {
+		return
+	}
+
+	next := time.Now().Add(time.Second)
+	if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		next = time.Unix(reset, 0)
+	}
+
+	m.mu.Lock()
+	if next.After(m.nextRequest) {
+		m.nextRequest = next
+	}
+	m.mu.Unlock()
+}
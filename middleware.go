@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestIDHeader is the header carrying the request ID.
+const requestIDHeader = "X-Request-ID"
+
+// RequestID makes sure every request has an ID, reusing the one sent by a
+// proxy in the X-Request-ID header when present. The ID is stored in the
+// context under "request_id", added to the log lines of the request and
+// echoed in the response.
+func RequestID() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		requestID := ctx.GetHeader(requestIDHeader)
+		if requestID == "" || len(requestID) > 128 {
+			id, err := generateRandomString()
+			if err != nil {
+				ctx.String(http.StatusInternalServerError, err.Error())
+				ctx.Abort()
+				return
+			}
+			requestID = id
+		}
+
+		ctx.Set("request_id", requestID)
+		addLogAttrs(ctx, slog.String("request_id", requestID))
+		ctx.Header(requestIDHeader, requestID)
+		ctx.Next()
+	}
+}
+
+// ErrorReporter forwards unexpected errors to an error tracker.
+type ErrorReporter interface {
+	Report(ctx *gin.Context, err error, stack []byte)
+}
+
+// Recovery recovers from panics in handlers, logs the stack trace with the
+// request ID, reports the panic to reporter when set and renders the error
+// page.
+func Recovery(reporter ErrorReporter) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+
+			// http.ErrAbortHandler is used to abort a response on purpose
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			err, ok := recovered.(error)
+			if !ok {
+				err = fmt.Errorf("%v", recovered)
+			}
+
+			stack := debug.Stack()
+			slog.ErrorContext(ctx, "panic recovered", "method", ctx.Request.Method, "path", ctx.Request.URL.Path, "err", err, "stack", string(stack))
+
+			if reporter != nil {
+				reporter.Report(ctx, err, stack)
+			}
+
+			renderError(ctx, http.StatusInternalServerError, "Something went wrong", "An unexpected error occurred, please try again.")
+		}()
+
+		ctx.Next()
+	}
+}
+
+// renderError renders the error page and aborts the request.
+func renderError(ctx *gin.Context, status int, title, message string) {
+	// headers may already be sent if the handler wrote a response
+	if ctx.Writer.Written() {
+		ctx.Abort()
+		return
+	}
+
+	if wantsJSON(ctx) {
+		abortWithError(ctx, status, statusErrorCode(status), message)
+		return
+	}
+
+	renderHTML(ctx, status, "error.html", gin.H{
+		"Title":     title,
+		"Message":   message,
+		"RequestID": ctx.GetString("request_id"),
+	})
+	ctx.Abort()
+}
+
+// Timeout sets a deadline of d on the request context. Handlers and outbound
+// calls using the request context are cancelled once it is exceeded, and a
+// 504 error is returned in place of whatever the handler responds with
+// afterwards.
+func Timeout(d time.Duration) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		timeoutCtx, cancel := context.WithTimeout(ctx.Request.Context(), d)
+		defer cancel()
+
+		writer := &timeoutWriter{ResponseWriter: ctx.Writer, ctx: timeoutCtx}
+		ctx.Request = ctx.Request.WithContext(timeoutCtx)
+		ctx.Writer = writer
+
+		ctx.Next()
+
+		ctx.Writer = writer.ResponseWriter
+		if writer.timeout() {
+			ctx.Writer.Header().Del("Location")
+			abortWithError(ctx, http.StatusGatewayTimeout, "timeout", "the request took too long to complete")
+		}
+	}
+}
+
+// timeoutWriter drops the response written by a handler once the request
+// deadline is exceeded, so that Timeout can send a 504 instead.
+type timeoutWriter struct {
+	gin.ResponseWriter
+	ctx      context.Context
+	timedOut bool
+}
+
+// timeout reports whether the deadline was exceeded before anything was
+// written to the client.
+func (w *timeoutWriter) timeout() bool {
+	if !w.timedOut && !w.ResponseWriter.Written() && w.ctx.Err() == context.DeadlineExceeded {
+		w.timedOut = true
+	}
+	return w.timedOut
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	if w.timeout() {
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	if w.timeout() {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *timeoutWriter) WriteString(s string) (int, error) {
+	if w.timeout() {
+		return len(s), nil
+	}
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// mtlsEndpointAliases are the endpoints a provider serves with mutual TLS
+// (RFC 8705), which may differ from the regular ones.
+type mtlsEndpointAliases struct {
+	TokenURL      string `json:"token_endpoint"`
+	UserInfoURL   string `json:"userinfo_endpoint"`
+	PushedAuthURL string `json:"pushed_authorization_request_endpoint"`
+}
+
+// useMTLSEndpoints replaces the provider endpoints with their mutual TLS
+// aliases, when advertised, including after a refresh.
+func (p *Provider) useMTLSEndpoints() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.mtls = true
+	p.metadata.useMTLSEndpoints()
+}
+
+// useMTLSEndpoints replaces the endpoints with their mutual TLS aliases.
+func (m *providerMetadata) useMTLSEndpoints() {
+	aliases := m.MTLSEndpointAliases
+	if aliases == nil {
+		return
+	}
+
+	if aliases.TokenURL != "" {
+		m.TokenURL = aliases.TokenURL
+	}
+	if aliases.UserInfoURL != "" {
+		m.UserInfoURL = aliases.UserInfoURL
+	}
+	if aliases.PushedAuthURL != "" && m.PushedAuthURL != "" {
+		m.PushedAuthURL = aliases.PushedAuthURL
+	}
+}
+
+// newMTLSTransport returns a transport presenting the client certificate
+// found in certFile and keyFile, and the certificate thumbprint expected in
+// the tokens bound to it.
+func newMTLSTransport(certFile, keyFile string) (http.RoundTripper, string, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not load client certificate: %v", err)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	sum := sha256.Sum256(cert.Certificate[0])
+	return transport, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// clientContext returns a context whose OAuth2 HTTP client uses the
+// transport of the server, presenting the client certificate if any.
+func (s *Server) clientContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: s.transport})
+}
+
+// checkCertificateBinding checks that a JWT access token is bound to the
+// certificate with the given thumbprint by its cnf claim. Opaque access
+// tokens can only be checked by the resource server and are accepted.
+func checkCertificateBinding(accessToken, thumbprint string) error {
+	parts := strings.Split(accessToken, ".")
+	if len(parts) != 3 {
+		return nil
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("could not decode access token: %v", err)
+	}
+
+	var claims struct {
+		Confirmation struct {
+			Thumbprint string `json:"x5t#S256"`
+		} `json:"cnf"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return fmt.Errorf("could not parse access token: %v", err)
+	}
+
+	if claims.Confirmation.Thumbprint != thumbprint {
+		return fmt.Errorf("access token is not bound to the client certificate")
+	}
+	return nil
+}
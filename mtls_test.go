@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testCertThumbprint = "bwcK0esc3ACC3DB2Y5_lESsXE8o9ltc05O89jdN-dg2"
+
+func TestCheckCertificateBinding(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		accessToken string
+		wantErr     bool
+	}{
+		{"bound", unsignedJWT(`{"cnf":{"x5t#S256":"` + testCertThumbprint + `"}}`), false},
+		{"opaque", "opaque-access-token", false},
+		{"not bound", unsignedJWT(`{"sub":"auth0|test"}`), true},
+		{"other certificate", unsignedJWT(`{"cnf":{"x5t#S256":"other"}}`), true},
+		{"invalid payload", "header.!!!.signature", true},
+		{"not JSON", unsignedJWT(`not json`), true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkCertificateBinding(tt.accessToken, testCertThumbprint); (err != nil) != tt.wantErr {
+				t.Errorf("checkCertificateBinding = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRefreshChecksCertificateBinding(t *testing.T) {
+	bound := unsignedJWT(`{"cnf":{"x5t#S256":"` + testCertThumbprint + `"}}`)
+	for _, tt := range []struct {
+		name        string
+		accessToken string // renewed access token
+		renewed     bool
+	}{
+		{"bound", unsignedJWT(`{"cnf":{"x5t#S256":"` + testCertThumbprint + `"},"renewed":true}`), true},
+		{"not bound", unsignedJWT(`{"renewed":true}`), false},
+		{"other certificate", unsignedJWT(`{"cnf":{"x5t#S256":"other"},"renewed":true}`), false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newTestProvider(t)
+			provider.accessToken.Store(bound)
+			server := newTestServer(t, testConfig(provider), "memstore")
+			server.certThumbprint = testCertThumbprint
+			startTestLogin(server)
+			expireAccessToken(t, server)
+			server.router.GET("/callback", server.callbackHandler)
+			server.router.GET("/test/token", server.RefreshAccessTokens(), func(ctx *gin.Context) {
+				if tokens, ok := sessionTokens(ctx); ok {
+					ctx.String(http.StatusOK, tokens.AccessToken)
+				}
+			})
+
+			cookie := signIn(t, server)
+			if w := serve(server, "/test/expire", cookie); w.Code != http.StatusNoContent {
+				t.Fatalf("expire = %d", w.Code)
+			}
+			provider.accessToken.Store(tt.accessToken)
+
+			serve(server, "/test/token", cookie)
+			// the session holds the token of the login unless it was renewed
+			w := serve(server, "/test/token", cookie)
+			if renewed := w.Body.String() == tt.accessToken; renewed != tt.renewed {
+				t.Errorf("access token renewed = %t, want %t", renewed, tt.renewed)
+			}
+			if w.Body.String() != tt.accessToken && w.Body.String() != bound {
+				t.Errorf("access token = %q, want the one of the login", w.Body)
+			}
+		})
+	}
+}
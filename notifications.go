@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"go-auth0/notify"
+)
+
+// knownDevicesCookie lists hashes of the users who signed in with the
+// browser, so that signing in from another device can be reported.
+const (
+	knownDevicesCookie = "devices"
+	maxKnownDevices    = 10
+)
+
+// Notifications sends the emails of the server: new device alerts, terms
+// acceptance receipts and admin alerts.
+type Notifications struct {
+	queue     *notify.Queue
+	templates *notify.Templates
+	appName   string
+	admins    []string // addresses receiving the admin alerts
+}
+
+// newNotifications creates the notifications configured with NOTIFY_PROVIDER
+// (smtp, ses or sendgrid), or returns nil when it is not set.
+func newNotifications(config NotifyConfig, appName string) (*Notifications, error) {
+	name := config.Provider
+	if name == "" {
+		return nil, nil
+	}
+
+	from := config.From
+	if from == "" {
+		return nil, fmt.Errorf("NOTIFY_FROM must be set with NOTIFY_PROVIDER")
+	}
+
+	var provider notify.Provider
+	var err error
+	switch name {
+	case "smtp":
+		provider, err = notify.NewSMTP(config.SMTPAddr, config.SMTPUsername, config.SMTPPassword, from)
+	case "ses":
+		provider, err = notify.NewSES(config.AWSRegion, config.AWSAccessKeyID,
+			config.AWSSecretAccessKey, config.AWSSessionToken, from)
+	case "sendgrid":
+		provider, err = notify.NewSendGrid(config.SendGridAPIKey, from)
+	default:
+		return nil, fmt.Errorf("unknown notification provider %q", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	templates, err := notify.LoadTemplates(config.TemplateDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Notifications{
+		queue:     notify.NewQueue(provider),
+		templates: templates,
+		appName:   appName,
+		admins:    config.AdminEmails,
+	}, nil
+}
+
+// send renders the message template name with data and queues it for to.
+// Failures are logged, notifications never fail a request.
+func (n *Notifications) send(name string, data map[string]interface{}, to ...string) {
+	data["AppName"] = n.appName
+
+	message, err := n.templates.Render(name, data, to...)
+	if err == nil {
+		err = n.queue.Enqueue(message)
+	}
+	if err != nil {
+		slog.Error("could not send notification", "notification", name, "err", err)
+	}
+}
+
+// close sends the queued notifications, waiting for up to 10 seconds.
+func (n *Notifications) close() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	n.queue.Close(ctx)
+}
+
+// deviceHash identifies the user in the known devices cookie.
+func deviceHash(sub string) string {
+	sum := sha256.Sum256([]byte(sub))
+	return hex.EncodeToString(sum[:8])
+}
+
+// alertNewDevice emails the user when they sign in from a browser they never
+// signed in with, unless they opted out of security alerts. The browser is
+// remembered for a year.
+func (s *Server) alertNewDevice(ctx *gin.Context, u *UserInfo) {
+	hash := deviceHash(u.Sub)
+	cookie, _ := ctx.Cookie(knownDevicesCookie)
+
+	var known []string
+	for _, device := range strings.Split(cookie, ".") {
+		if device == hash {
+			return
+		}
+		if device != "" {
+			known = append(known, device)
+		}
+	}
+
+	// the first browser of a user is not a new device
+	firstLogin := false
+	if user, err := s.users.GetUser(ctx, u.Sub); err == nil {
+		firstLogin = user.LastLoginAt.IsZero()
+	}
+
+	known = append(known, hash)
+	if len(known) > maxKnownDevices {
+		known = known[len(known)-maxKnownDevices:]
+	}
+	ctx.SetCookie(knownDevicesCookie, strings.Join(known, "."), 365*24*60*60, "/", "", true, true)
+
+	if firstLogin || !u.EmailVerified || u.Email == "" {
+		return
+	}
+
+	preferences, err := s.users.GetPreferences(ctx, u.Sub)
+	if err != nil {
+		slog.ErrorContext(ctx, "could not get preferences", "err", err)
+		preferences = defaultPreferences()
+	}
+	if !preferences.OptedIn(notifySecurityAlerts) {
+		return
+	}
+
+	s.notifications.send("new_device", map[string]interface{}{
+		"Name":      u.Name,
+		"Time":      time.Now().In(preferences.Location()).Format(time.RFC1123),
+		"IP":        ctx.ClientIP(),
+		"UserAgent": ctx.Request.UserAgent(),
+	}, u.Email)
+}
+
+// notifyTermsAccepted emails the user a copy of the document they accepted.
+func (s *Server) notifyTermsAccepted(ctx *gin.Context, u *UserInfo, doc LegalDocument, at time.Time) {
+	if s.notifications == nil || !u.EmailVerified || u.Email == "" {
+		return
+	}
+
+	s.notifications.send("terms_accepted", map[string]interface{}{
+		"Name":    u.Name,
+		"Title":   doc.Title,
+		"Version": doc.Version,
+		"Text":    doc.Text,
+		"Time":    at.In(currentPreferences(ctx).Location()).Format(time.RFC1123),
+	}, u.Email)
+}
+
+// notifyJobFailure emails the admins when a background job starts failing.
+func (s *Server) notifyJobFailure(job string, err error) {
+	if s.notifications == nil || len(s.notifications.admins) == 0 {
+		return
+	}
+
+	s.notifications.send("job_failed", map[string]interface{}{
+		"Job":   job,
+		"Error": err.Error(),
+		"Time":  time.Now().UTC().Format(time.RFC1123),
+	}, s.notifications.admins...)
+}
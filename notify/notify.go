@@ -0,0 +1,161 @@
+// Package notify sends email notifications through SMTP, Amazon SES or
+// SendGrid, from templates and with retries in the background.
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/mail"
+	"sync"
+	"time"
+)
+
+// Message is an email to send.
+type Message struct {
+	To      []string
+	Subject string
+	Text    string // plain text body
+	HTML    string // HTML body, optional
+}
+
+// validate checks the recipients of the message.
+func (m *Message) validate() error {
+	if len(m.To) == 0 {
+		return fmt.Errorf("message has no recipient")
+	}
+	for _, to := range m.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			return fmt.Errorf("invalid recipient %q: %v", to, err)
+		}
+	}
+	return nil
+}
+
+// Provider delivers messages.
+type Provider interface {
+	// Send delivers the message. Errors that retrying cannot fix are wrapped
+	// with Permanent.
+	Send(ctx context.Context, message *Message) error
+}
+
+// permanentError is an error that retrying cannot fix.
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not worth retrying, such as a rejected recipient.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err was marked with Permanent.
+func IsPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
+
+// Queue settings.
+const (
+	queueSize      = 1000
+	maxAttempts    = 5
+	initialBackoff = time.Second
+	sendTimeout    = 30 * time.Second
+)
+
+// Queue sends messages from a background goroutine so that a slow provider
+// never delays requests. Failed sends are retried with exponential backoff.
+type Queue struct {
+	provider Provider
+	messages chan *Message
+	backoff  time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	once   sync.Once
+}
+
+// NewQueue creates a queue delivering messages with provider.
+func NewQueue(provider Provider) *Queue {
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &Queue{
+		provider: provider,
+		messages: make(chan *Message, queueSize),
+		backoff:  initialBackoff,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+
+	q.wg.Add(1)
+	go q.run()
+	return q
+}
+
+// Enqueue schedules message for delivery. It returns an error when the
+// message is invalid or the queue is full, messages are not delivered then.
+func (q *Queue) Enqueue(message *Message) error {
+	if err := message.validate(); err != nil {
+		return err
+	}
+
+	select {
+	case q.messages <- message:
+		return nil
+	default:
+		return fmt.Errorf("notification queue full")
+	}
+}
+
+// Close stops accepting messages and waits for the queued ones to be sent
+// until ctx is done, the remaining ones being dropped.
+func (q *Queue) Close(ctx context.Context) {
+	q.once.Do(func() { close(q.messages) })
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		q.cancel()
+		<-done
+	}
+}
+
+// run delivers the queued messages until the queue is closed.
+func (q *Queue) run() {
+	defer q.wg.Done()
+
+	for message := range q.messages {
+		if err := q.deliver(message); err != nil {
+			slog.Error("could not send notification", "subject", message.Subject, "err", err)
+		}
+	}
+}
+
+// deliver sends message, retrying temporary failures.
+func (q *Queue) deliver(message *Message) error {
+	backoff := q.backoff
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(q.ctx, sendTimeout)
+		err := q.provider.Send(ctx, message)
+		cancel()
+
+		if err == nil || IsPermanent(err) || attempt == maxAttempts {
+			return err
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-q.ctx.Done():
+			return err
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,88 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/mail"
+)
+
+// sendGridEndpoint is the SendGrid v3 mail send endpoint.
+const sendGridEndpoint = "https://api.sendgrid.com/v3/mail/send"
+
+// SendGrid sends messages with the SendGrid v3 API.
+type SendGrid struct {
+	apiKey string
+	from   mail.Address
+	client *http.Client
+}
+
+// NewSendGrid creates a provider sending messages from from with an API key
+// allowed to send mail.
+func NewSendGrid(apiKey, from string) (*SendGrid, error) {
+	if apiKey == "" {
+		return nil, fmt.Errorf("SendGrid needs an API key")
+	}
+
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender %q: %v", from, err)
+	}
+
+	return &SendGrid{apiKey: apiKey, from: *sender, client: &http.Client{Timeout: sendTimeout}}, nil
+}
+
+// sendGridAddress is an address of a SendGrid message.
+type sendGridAddress struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
+
+// sendGridContent is a body of a SendGrid message, plain text first.
+type sendGridContent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// Send implements Provider.
+func (s *SendGrid) Send(ctx context.Context, message *Message) error {
+	to := make([]sendGridAddress, len(message.To))
+	for i, recipient := range message.To {
+		to[i] = sendGridAddress{Email: recipient}
+		if address, err := mail.ParseAddress(recipient); err == nil {
+			to[i] = sendGridAddress{Email: address.Address, Name: address.Name}
+		}
+	}
+
+	content := []sendGridContent{{Type: "text/plain", Value: message.Text}}
+	if message.HTML != "" {
+		content = append(content, sendGridContent{Type: "text/html", Value: message.HTML})
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"personalizations": []map[string]interface{}{{"to": to}},
+		"from":             sendGridAddress{Email: s.from.Address, Name: s.from.Name},
+		"subject":          message.Subject,
+		"content":          content,
+	})
+	if err != nil {
+		return Permanent(err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sendGridEndpoint, bytes.NewReader(payload))
+	if err != nil {
+		return Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+s.apiKey)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not call SendGrid: %v", err)
+	}
+	defer resp.Body.Close()
+
+	return httpError("SendGrid", resp)
+}
@@ -0,0 +1,170 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SES sends messages with the Amazon SES v2 API, signing requests with AWS
+// Signature Version 4.
+type SES struct {
+	region          string
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+	from            string
+	endpoint        string
+	client          *http.Client
+}
+
+// NewSES creates a provider sending messages from from in region, with the
+// credentials of an IAM user or role allowed to call ses:SendEmail.
+// sessionToken is only set for temporary credentials.
+func NewSES(region, accessKeyID, secretAccessKey, sessionToken, from string) (*SES, error) {
+	if region == "" || accessKeyID == "" || secretAccessKey == "" {
+		return nil, fmt.Errorf("SES needs a region and credentials")
+	}
+
+	return &SES{
+		region:          region,
+		accessKeyID:     accessKeyID,
+		secretAccessKey: secretAccessKey,
+		sessionToken:    sessionToken,
+		from:            from,
+		endpoint:        "https://email." + region + ".amazonaws.com/v2/email/outbound-emails",
+		client:          &http.Client{Timeout: sendTimeout},
+	}, nil
+}
+
+// sesContent is a text part of a SES message.
+type sesContent struct {
+	Data    string `json:"Data"`
+	Charset string `json:"Charset"`
+}
+
+// Send implements Provider.
+func (s *SES) Send(ctx context.Context, message *Message) error {
+	body := map[string]*sesContent{"Text": {Data: message.Text, Charset: "UTF-8"}}
+	if message.HTML != "" {
+		body["Html"] = &sesContent{Data: message.HTML, Charset: "UTF-8"}
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"FromEmailAddress": s.from,
+		"Destination":      map[string][]string{"ToAddresses": message.To},
+		"Content": map[string]interface{}{
+			"Simple": map[string]interface{}{
+				"Subject": &sesContent{Data: message.Subject, Charset: "UTF-8"},
+				"Body":    body,
+			},
+		},
+	})
+	if err != nil {
+		return Permanent(err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	s.sign(req, payload, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not call SES: %v", err)
+	}
+	defer resp.Body.Close()
+
+	return httpError("SES", resp)
+}
+
+// sign adds the AWS Signature Version 4 headers to req.
+func (s *SES) sign(req *http.Request, payload []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	scope := date + "/" + s.region + "/ses/aws4_request"
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	signedHeaders := []string{"content-type", "host", "x-amz-content-sha256", "x-amz-date"}
+	if s.sessionToken != "" {
+		signedHeaders = append(signedHeaders, "x-amz-security-token")
+	}
+
+	var canonicalHeaders strings.Builder
+	for _, name := range signedHeaders {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretAccessKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "ses")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKeyID, scope, strings.Join(signedHeaders, ";"), signature))
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// httpError returns nil for a 2xx response and an error naming the provider
+// otherwise, permanent unless the request may succeed later (429 and 5xx).
+func httpError(provider string, resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return nil
+	}
+
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4<<10))
+	err := fmt.Errorf("%s: %s: %s", provider, resp.Status, bytes.TrimSpace(body))
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return err
+	}
+	return Permanent(err)
+}
@@ -0,0 +1,119 @@
+package notify
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	texttemplate "text/template"
+)
+
+// templateFS holds the message templates shipped with the binary.
+//
+//go:embed templates
+var templateFS embed.FS
+
+// message is a parsed message template. Every template file defines a
+// "subject", a "text" and optionally an "html" template.
+type message struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// Templates renders messages from templates.
+type Templates struct {
+	messages map[string]message
+}
+
+// LoadTemplates parses the embedded message templates. Files found in dir,
+// when set, replace the embedded file with the same name (e.g.
+// dir/new_device.tmpl).
+func LoadTemplates(dir string) (*Templates, error) {
+	embedded, err := fs.Sub(templateFS, "templates")
+	if err != nil {
+		return nil, err
+	}
+
+	sources := []fs.FS{embedded}
+	if dir != "" {
+		sources = append(sources, os.DirFS(dir))
+	}
+
+	files := make(map[string]string)
+	for _, source := range sources {
+		entries, err := fs.ReadDir(source, ".")
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read message template directory: %v", err)
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() || path.Ext(entry.Name()) != ".tmpl" {
+				continue
+			}
+
+			b, err := fs.ReadFile(source, entry.Name())
+			if err != nil {
+				return nil, fmt.Errorf("could not read message template %s: %v", entry.Name(), err)
+			}
+			files[strings.TrimSuffix(entry.Name(), ".tmpl")] = string(b)
+		}
+	}
+
+	t := &Templates{messages: make(map[string]message, len(files))}
+	for name, src := range files {
+		text, err := texttemplate.New(name).Parse(src)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse message template %s: %v", name, err)
+		}
+		if text.Lookup("subject") == nil || text.Lookup("text") == nil {
+			return nil, fmt.Errorf("message template %s must define subject and text", name)
+		}
+
+		var html *htmltemplate.Template
+		if strings.Contains(src, `define "html"`) {
+			html, err = htmltemplate.New(name).Parse(src)
+			if err != nil {
+				return nil, fmt.Errorf("could not parse message template %s: %v", name, err)
+			}
+		}
+
+		t.messages[name] = message{text: text, html: html}
+	}
+
+	return t, nil
+}
+
+// Render renders the message template name with data for the recipients.
+func (t *Templates) Render(name string, data interface{}, to ...string) (*Message, error) {
+	tmpl, ok := t.messages[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown message template %q", name)
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := tmpl.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, fmt.Errorf("could not render subject of %s: %v", name, err)
+	}
+	if err := tmpl.text.ExecuteTemplate(&text, "text", data); err != nil {
+		return nil, fmt.Errorf("could not render text of %s: %v", name, err)
+	}
+	if tmpl.html != nil {
+		if err := tmpl.html.ExecuteTemplate(&html, "html", data); err != nil {
+			return nil, fmt.Errorf("could not render html of %s: %v", name, err)
+		}
+	}
+
+	return &Message{
+		To:      to,
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+		HTML:    html.String(),
+	}, nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiVersion is the version of the contract of the /api/v1 and /admin/api/v1
+// paths.
+const apiVersion = "1.0.0"
+
+// security schemes of the OpenAPI document
+const (
+	securityAdminToken  = "adminToken"
+	securityAccessToken = "accessToken"
+	securitySession     = "session"
+)
+
+// apiParam is a query parameter of an API operation.
+type apiParam struct {
+	name        string
+	description string
+}
+
+// apiOperation describes a route of the JSON API for the OpenAPI document.
+type apiOperation struct {
+	method  string
+	path    string // gin path, the parameters written :name
+	summary string
+	scope   string // scope required with RequireScope, if any
+	query   []apiParam
+	body    string // description of the JSON request body, if any
+	status  int    // status of the successful responses, 200 when zero
+}
+
+// apiRoutes registers the routes of an API group and records them, so that
+// the OpenAPI document describes the routes actually served.
+type apiRoutes struct {
+	group      *gin.RouterGroup
+	security   []string // security schemes accepted by the operations
+	operations []apiOperation
+}
+
+// newAPIRoutes creates the registry of the routes of group, authenticated
+// with one of the security schemes.
+func newAPIRoutes(group *gin.RouterGroup, security ...string) *apiRoutes {
+	return &apiRoutes{group: group, security: security}
+}
+
+// handle registers the route of op, requiring its scope when it has one.
+func (r *apiRoutes) handle(op apiOperation, handlers ...gin.HandlerFunc) {
+	if op.scope != "" {
+		handlers = append([]gin.HandlerFunc{RequireScope(op.scope)}, handlers...)
+	}
+	r.group.Handle(op.method, op.path, handlers...)
+
+	op.path = strings.TrimSuffix(r.group.BasePath(), "/") + op.path
+	r.operations = append(r.operations, op)
+}
+
+// openAPIPath converts a gin path to an OpenAPI path template, returning
+// the names of its parameters.
+func openAPIPath(path string) (string, []string) {
+	var params []string
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			params = append(params, segment[1:])
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// jsonContent is the content of a JSON request or response.
+func jsonContent(schema gin.H) gin.H {
+	return gin.H{"application/json": gin.H{"schema": schema}}
+}
+
+// operation returns the OpenAPI operation object of op.
+func (r *apiRoutes) operation(op apiOperation) gin.H {
+	path, pathParams := openAPIPath(op.path)
+
+	var parameters []gin.H
+	for _, name := range pathParams {
+		parameters = append(parameters, gin.H{"name": name, "in": "path", "required": true, "schema": gin.H{"type": "string"}})
+	}
+	for _, param := range op.query {
+		parameters = append(parameters, gin.H{"name": param.name, "in": "query", "description": param.description, "schema": gin.H{"type": "string"}})
+	}
+
+	status := op.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := gin.H{"description": http.StatusText(status)}
+	if status != http.StatusNoContent {
+		success["content"] = jsonContent(gin.H{"type": "object"})
+	}
+
+	var security []gin.H
+	for _, scheme := range r.security {
+		security = append(security, gin.H{scheme: []string{}})
+	}
+
+	operation := gin.H{
+		"operationId": strings.ToLower(op.method) + strings.NewReplacer("/", "_", "{", "", "}", "", "-", "_").Replace(path),
+		"summary":     op.summary,
+		"security":    security,
+		"responses": gin.H{
+			strconv.Itoa(status): success,
+			"default": gin.H{
+				"description": "Error",
+				"content":     jsonContent(gin.H{"$ref": "#/components/schemas/Error"}),
+			},
+		},
+	}
+	if len(parameters) > 0 {
+		operation["parameters"] = parameters
+	}
+	if op.body != "" {
+		operation["requestBody"] = gin.H{
+			"required":    true,
+			"description": op.body,
+			"content":     jsonContent(gin.H{"type": "object"}),
+		}
+	}
+	if op.scope != "" {
+		operation["description"] = "Requires the " + op.scope + " scope."
+	}
+	return operation
+}
+
+// addPaths adds the operations of the registry to the paths of a document.
+func (r *apiRoutes) addPaths(paths gin.H, servers []gin.H) {
+	if r == nil {
+		return
+	}
+	for _, op := range r.operations {
+		path, _ := openAPIPath(op.path)
+		item, ok := paths[path].(gin.H)
+		if !ok {
+			item = gin.H{}
+			if len(servers) > 0 {
+				item["servers"] = servers
+			}
+			paths[path] = item
+		}
+		item[strings.ToLower(op.method)] = r.operation(op)
+	}
+}
+
+// openAPIDocument returns the OpenAPI 3 document of the admin API and of the
+// JSON API. The JSON API being served on the public listener, its paths name
+// the public URL of the application as their server.
+func (s *Server) openAPIDocument() gin.H {
+	var publicServers []gin.H
+	if len(s.callbackURLs) > 0 {
+		base := *s.callbackURLs[0]
+		base.Path, base.RawQuery, base.Fragment = "", "", ""
+		publicServers = []gin.H{{"url": base.String(), "description": "public listener"}}
+	}
+
+	paths := gin.H{}
+	s.adminAPI.addPaths(paths, nil)
+	s.publicAPI.addPaths(paths, publicServers)
+
+	return gin.H{
+		"openapi": "3.0.3",
+		"info": gin.H{
+			"title":   "go-auth0 API",
+			"version": apiVersion,
+		},
+		"paths": paths,
+		"components": gin.H{
+			"securitySchemes": gin.H{
+				securityAdminToken:  gin.H{"type": "http", "scheme": "bearer", "description": "ADMIN_TOKEN"},
+				securityAccessToken: gin.H{"type": "http", "scheme": "bearer", "description": "personal access token or access token of the API"},
+				securitySession:     gin.H{"type": "apiKey", "in": "cookie", "name": sessionCookieName},
+			},
+			"schemas": gin.H{
+				"Error": gin.H{
+					"type":     "object",
+					"required": []string{"error", "message"},
+					"properties": gin.H{
+						"error":   gin.H{"type": "string"},
+						"message": gin.H{"type": "string"},
+					},
+				},
+			},
+		},
+	}
+}
+
+// openAPIHandler serves the OpenAPI document.
+func (s *Server) openAPIHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, s.openAPIDocument())
+}
+
+// swaggerUIPage loads Swagger UI from its CDN with the OpenAPI document.
+var swaggerUIPage = template.Must(template.New("docs").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>go-auth0 API</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js" crossorigin></script>
+<script>
+window.onload = function () {
+  window.ui = SwaggerUIBundle({url: {{.}}, dom_id: "#swagger-ui"});
+};
+</script>
+</body>
+</html>
+`))
+
+// swaggerUIHandler serves Swagger UI, to read and try the API.
+func swaggerUIHandler(ctx *gin.Context) {
+	ctx.Header("Content-Type", "text/html; charset=utf-8")
+	ctx.Status(http.StatusOK)
+	swaggerUIPage.Execute(ctx.Writer, "/admin/openapi.json")
+}
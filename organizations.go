@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/coreos/go-oidc"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+// organizationPattern matches the auth0 organization IDs, such as
+// org_W30GzekVGRy8eMB1, and names.
+var organizationPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,50}$`)
+
+// Organization is the auth0 organization a user signed in to.
+type Organization struct {
+	ID   string
+	Name string
+}
+
+// setLoginOrganization records in the session the organization a login is
+// started with, by ID or name, to check the ID token against it. An empty
+// organization signs in with the organization of the tenant, if any. The
+// session is saved by startLogin.
+func setLoginOrganization(ctx *gin.Context, organization string) {
+	session := sessions.Default(ctx)
+	if organization == "" {
+		session.Delete("login_organization")
+		return
+	}
+	session.Set("login_organization", organization)
+}
+
+// organizationOptions returns the organization parameter of a login with t,
+// the one recorded by setLoginOrganization or else the one of the tenant,
+// which is recorded in turn.
+func organizationOptions(ctx *gin.Context, t *tenant) []oauth2.AuthCodeOption {
+	session := sessions.Default(ctx)
+	organization, _ := session.Get("login_organization").(string)
+	if organization == "" {
+		organization = t.organization
+	}
+	if organization == "" {
+		return nil
+	}
+
+	session.Set("login_organization", organization)
+	return []oauth2.AuthCodeOption{oauth2.SetAuthURLParam("organization", organization)}
+}
+
+// checkOrganization checks that idToken was issued for the organization the
+// login was started with: the org_id claim for an ID, the org_name claim,
+// in lower case, for a name. It returns the organization of the token, if
+// any, accepted as is when the login named none.
+func checkOrganization(expected string, idToken *oidc.IDToken) (Organization, error) {
+	var claims struct {
+		OrgID   string `json:"org_id"`
+		OrgName string `json:"org_name"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return Organization{}, fmt.Errorf("could not parse id token claims: %v", err)
+	}
+	organization := Organization{ID: claims.OrgID, Name: claims.OrgName}
+
+	switch {
+	case expected == "":
+	case strings.HasPrefix(expected, "org_"):
+		if claims.OrgID != expected {
+			return Organization{}, fmt.Errorf("organization %q is not the expected %q", claims.OrgID, expected)
+		}
+	case claims.OrgName != strings.ToLower(expected):
+		return Organization{}, fmt.Errorf("organization %q is not the expected %q", claims.OrgName, expected)
+	}
+	return organization, nil
+}
+
+// saveOrganization keeps the organization of the login in the session,
+// replacing the one of a previous login. The session is saved by the caller.
+func saveOrganization(session sessions.Session, organization Organization) {
+	session.Delete("login_organization")
+	if organization.ID == "" {
+		session.Delete("org_id")
+		session.Delete("org_name")
+		return
+	}
+	session.Set("org_id", organization.ID)
+	session.Set("org_name", organization.Name)
+}
+
+// sessionOrganization returns the organization the user signed in to, if
+// any.
+func sessionOrganization(ctx *gin.Context) (Organization, bool) {
+	session := defaultSession(ctx)
+	if session == nil {
+		return Organization{}, false
+	}
+	id, _ := session.Get("org_id").(string)
+	name, _ := session.Get("org_name").(string)
+	return Organization{ID: id, Name: name}, id != ""
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultOutboxMaxAttempts is how many times a delivery is attempted
+	// before it is dead-lettered, about an hour with the backoff.
+	defaultOutboxMaxAttempts = 15
+	// outboxMaxBackoff caps the wait between two attempts.
+	outboxMaxBackoff = 10 * time.Minute
+	// outboxLease is how long claimed entries are reserved to a dispatcher.
+	// The entries left when a delivery fails are attempted again once it
+	// elapses.
+	outboxLease = 30 * time.Second
+	// outboxBatchSize is the number of entries claimed at once.
+	outboxBatchSize = 50
+	// outboxPollInterval is how often the outbox is polled for entries
+	// due, besides the wake-ups on new events.
+	outboxPollInterval = time.Second
+	// memoryOutboxCapacity is the number of entries kept by the in memory
+	// outbox, pending and dead-lettered.
+	memoryOutboxCapacity = 10000
+)
+
+var (
+	// ErrOutboxEntryNotFound is returned when no dead-lettered entry matches.
+	ErrOutboxEntryNotFound = errors.New("outbox entry not found")
+	// errOutboxFull is returned when the in memory outbox is full.
+	errOutboxFull = errors.New("outbox is full")
+)
+
+// OutboxEntry is the delivery of an audit event to a sink.
+type OutboxEntry struct {
+	ID            int64      `json:"id"`
+	Sink          string     `json:"sink"`
+	Event         AuditEvent `json:"event"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	LastError     string     `json:"last_error,omitempty"`
+	DeadAt        *time.Time `json:"dead_at,omitempty"` // set once dead-lettered
+}
+
+// OutboxStore keeps the deliveries to the sinks until they succeed, so that
+// events are not lost while a sink is down.
+type OutboxStore interface {
+	// Enqueue adds the delivery of event to sink.
+	Enqueue(ctx context.Context, sink string, event *AuditEvent) error
+	// Claim reserves for lease up to limit deliveries to sink which are
+	// due, oldest first.
+	Claim(ctx context.Context, sink string, lease time.Duration, limit int) ([]OutboxEntry, error)
+	// Delete removes a delivery which succeeded.
+	Delete(ctx context.Context, id int64) error
+	// Retry counts a failed attempt, the next one being made at.
+	Retry(ctx context.Context, id int64, at time.Time, lastError string) error
+	// Bury dead-letters a delivery which failed too many times.
+	Bury(ctx context.Context, id int64, lastError string) error
+	// DeadLetters returns up to limit dead-lettered deliveries, the latest
+	// first.
+	DeadLetters(ctx context.Context, limit int) ([]OutboxEntry, error)
+	// Requeue schedules a dead-lettered delivery again or returns
+	// ErrOutboxEntryNotFound.
+	Requeue(ctx context.Context, id int64) error
+}
+
+// NewOutboxStore returns a Postgres outbox when dsn is set, and an in memory
+// one otherwise, which retries deliveries but loses them on restart.
+func NewOutboxStore(dsn string) (OutboxStore, error) {
+	if dsn == "" {
+		return newMemoryOutbox(memoryOutboxCapacity), nil
+	}
+	return newPostgresOutbox(dsn)
+}
+
+// memoryOutbox keeps the deliveries in memory.
+type memoryOutbox struct {
+	mu       sync.Mutex
+	capacity int
+	nextID   int64
+	entries  map[int64]*OutboxEntry
+}
+
+func newMemoryOutbox(capacity int) *memoryOutbox {
+	return &memoryOutbox{capacity: capacity, entries: make(map[int64]*OutboxEntry)}
+}
+
+func (o *memoryOutbox) Enqueue(ctx context.Context, sink string, event *AuditEvent) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.entries) >= o.capacity {
+		// make room by forgetting the oldest dead letter
+		var oldest *OutboxEntry
+		for _, entry := range o.entries {
+			if entry.DeadAt != nil && (oldest == nil || entry.ID < oldest.ID) {
+				oldest = entry
+			}
+		}
+		if oldest == nil {
+			return errOutboxFull
+		}
+		delete(o.entries, oldest.ID)
+	}
+
+	o.nextID++
+	o.entries[o.nextID] = &OutboxEntry{ID: o.nextID, Sink: sink, Event: *event, NextAttemptAt: time.Now()}
+	return nil
+}
+
+func (o *memoryOutbox) Claim(ctx context.Context, sink string, lease time.Duration, limit int) ([]OutboxEntry, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	now := time.Now()
+	var due []*OutboxEntry
+	for _, entry := range o.entries {
+		if entry.Sink == sink && entry.DeadAt == nil && !entry.NextAttemptAt.After(now) {
+			due = append(due, entry)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].ID < due[j].ID })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	claimed := make([]OutboxEntry, 0, len(due))
+	for _, entry := range due {
+		entry.NextAttemptAt = now.Add(lease)
+		claimed = append(claimed, *entry)
+	}
+	return claimed, nil
+}
+
+func (o *memoryOutbox) Delete(ctx context.Context, id int64) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	delete(o.entries, id)
+	return nil
+}
+
+func (o *memoryOutbox) Retry(ctx context.Context, id int64, at time.Time, lastError string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if entry, ok := o.entries[id]; ok {
+		entry.Attempts++
+		entry.NextAttemptAt = at
+		entry.LastError = lastError
+	}
+	return nil
+}
+
+func (o *memoryOutbox) Bury(ctx context.Context, id int64, lastError string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if entry, ok := o.entries[id]; ok {
+		now := time.Now()
+		entry.Attempts++
+		entry.LastError = lastError
+		entry.DeadAt = &now
+	}
+	return nil
+}
+
+func (o *memoryOutbox) DeadLetters(ctx context.Context, limit int) ([]OutboxEntry, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var dead []OutboxEntry
+	for _, entry := range o.entries {
+		if entry.DeadAt != nil {
+			dead = append(dead, *entry)
+		}
+	}
+	sort.Slice(dead, func(i, j int) bool { return dead[i].ID > dead[j].ID })
+	if len(dead) > limit {
+		dead = dead[:limit]
+	}
+	return dead, nil
+}
+
+func (o *memoryOutbox) Requeue(ctx context.Context, id int64) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	entry, ok := o.entries[id]
+	if !ok || entry.DeadAt == nil {
+		return ErrOutboxEntryNotFound
+	}
+	entry.DeadAt = nil
+	entry.Attempts = 0
+	entry.NextAttemptAt = time.Now()
+	return nil
+}
+
+// outboxDispatcher delivers the outbox entries of a sink, retrying the
+// failed deliveries with an exponential backoff until maxAttempts, after
+// which they are dead-lettered.
+type outboxDispatcher struct {
+	name        string
+	sink        AuditSink
+	outbox      OutboxStore
+	maxAttempts int
+	wake        chan struct{}
+}
+
+// notify wakes the dispatcher up after an event was enqueued.
+func (d *outboxDispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// deliver delivers the entries due, stopping at the first failure as the
+// sink is likely down. It is run by the scheduler, every outboxPollInterval
+// and when notified.
+func (d *outboxDispatcher) deliver(ctx context.Context) error {
+	for {
+		entries, err := d.outbox.Claim(ctx, d.name, outboxLease, outboxBatchSize)
+		if err != nil {
+			return fmt.Errorf("could not claim audit events for %s: %w", d.name, err)
+		}
+
+		for _, entry := range entries {
+			if err := d.sink.WriteEvent(&entry.Event); err != nil {
+				d.failed(ctx, &entry, err)
+				return nil
+			}
+			if err := d.outbox.Delete(ctx, entry.ID); err != nil {
+				slog.ErrorContext(ctx, "could not delete delivered audit event", "event_id", entry.Event.ID, "sink", d.name, "err", err)
+			}
+		}
+
+		if len(entries) < outboxBatchSize {
+			return nil
+		}
+	}
+}
+
+// failed schedules the next attempt of a failed delivery, or dead-letters
+// it after too many attempts.
+func (d *outboxDispatcher) failed(ctx context.Context, entry *OutboxEntry, err error) {
+	attempts := entry.Attempts + 1
+	if attempts >= d.maxAttempts {
+		slog.ErrorContext(ctx, "could not forward audit event, dead-lettered", "event_id", entry.Event.ID, "sink", d.name, "attempts", attempts, "err", err)
+		if err := d.outbox.Bury(ctx, entry.ID, err.Error()); err != nil {
+			slog.ErrorContext(ctx, "could not dead-letter audit event", "event_id", entry.Event.ID, "sink", d.name, "err", err)
+		}
+		return
+	}
+
+	slog.WarnContext(ctx, "could not forward audit event", "event_id", entry.Event.ID, "sink", d.name, "attempt", attempts, "err", err)
+	if err := d.outbox.Retry(ctx, entry.ID, time.Now().Add(outboxBackoff(attempts)), err.Error()); err != nil {
+		slog.ErrorContext(ctx, "could not reschedule audit event", "event_id", entry.Event.ID, "sink", d.name, "err", err)
+	}
+}
+
+// outboxBackoff returns the wait before the attempt following the given
+// number of failed ones: 1s doubling up to outboxMaxBackoff, with up to 10%
+// jitter.
+func outboxBackoff(attempts int) time.Duration {
+	backoff := outboxMaxBackoff
+	if attempts < 20 {
+		if d := time.Second << (attempts - 1); d < backoff {
+			backoff = d
+		}
+	}
+	return backoff + time.Duration(rand.Int63n(int64(backoff/10)+1))
+}
+
+// deadLettersHandler lists the audit events which could not be forwarded
+// to a sink, for the admin API.
+func (s *Server) deadLettersHandler(ctx *gin.Context) {
+	limit := 100
+	if raw := ctx.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 || n > 1000 {
+			abortWithError(ctx, http.StatusBadRequest, "invalid_request", "limit must be between 1 and 1000")
+			return
+		}
+		limit = n
+	}
+
+	entries, err := s.auditor.outbox.DeadLetters(ctx, limit)
+	if err != nil {
+		slog.ErrorContext(ctx, "could not list dead letters", "err", err)
+		abortWithError(ctx, http.StatusInternalServerError, "server_error", "could not list dead letters")
+		return
+	}
+	if entries == nil {
+		entries = []OutboxEntry{}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"dead_letters": entries})
+}
+
+// retryDeadLetterHandler schedules the delivery of a dead-lettered event
+// again, for the admin API.
+func (s *Server) retryDeadLetterHandler(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "invalid_request", "invalid dead letter id")
+		return
+	}
+
+	err = s.auditor.outbox.Requeue(ctx, id)
+	if errors.Is(err, ErrOutboxEntryNotFound) {
+		abortWithError(ctx, http.StatusNotFound, "not_found", "no such dead letter")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "could not requeue dead letter", "err", err)
+		abortWithError(ctx, http.StatusInternalServerError, "server_error", "could not requeue dead letter")
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
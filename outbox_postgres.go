@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// postgresOutboxSchema creates the audit outbox table when missing.
+const postgresOutboxSchema = `
+CREATE TABLE IF NOT EXISTS audit_outbox (
+	id              BIGSERIAL PRIMARY KEY,
+	sink            TEXT NOT NULL,
+	event           JSONB NOT NULL,
+	attempts        INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMPTZ NOT NULL,
+	last_error      TEXT NOT NULL DEFAULT '',
+	dead_at         TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS audit_outbox_due_idx ON audit_outbox (sink, next_attempt_at) WHERE dead_at IS NULL`
+
+// postgresOutbox keeps the deliveries in a Postgres table, shared by the
+// instances: claimed entries are skipped by the other dispatchers until
+// their lease expires.
+type postgresOutbox struct {
+	db *sql.DB
+}
+
+func newPostgresOutbox(dsn string) (*postgresOutbox, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("could not open database: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := db.ExecContext(ctx, postgresOutboxSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not create audit outbox table: %v", err)
+	}
+
+	return &postgresOutbox{db: db}, nil
+}
+
+func (o *postgresOutbox) Enqueue(ctx context.Context, sink string, event *AuditEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("could not encode audit event: %v", err)
+	}
+
+	if _, err := o.db.ExecContext(ctx, `
+		INSERT INTO audit_outbox (sink, event, next_attempt_at) VALUES ($1, $2, now())`, sink, payload); err != nil {
+		return fmt.Errorf("could not enqueue audit event: %v", err)
+	}
+	return nil
+}
+
+// postgresOutboxColumns are the columns read by scanOutboxEntries.
+const postgresOutboxColumns = `id, sink, event, attempts, next_attempt_at, last_error, dead_at`
+
+// scanOutboxEntries reads the entries selected with postgresOutboxColumns.
+func scanOutboxEntries(rows *sql.Rows) ([]OutboxEntry, error) {
+	defer rows.Close()
+
+	var entries []OutboxEntry
+	for rows.Next() {
+		var entry OutboxEntry
+		var payload []byte
+		var dead sql.NullTime
+		if err := rows.Scan(&entry.ID, &entry.Sink, &payload, &entry.Attempts, &entry.NextAttemptAt, &entry.LastError, &dead); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(payload, &entry.Event); err != nil {
+			return nil, fmt.Errorf("could not decode audit event: %v", err)
+		}
+		if dead.Valid {
+			entry.DeadAt = &dead.Time
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (o *postgresOutbox) Claim(ctx context.Context, sink string, lease time.Duration, limit int) ([]OutboxEntry, error) {
+	rows, err := o.db.QueryContext(ctx, `
+		UPDATE audit_outbox SET next_attempt_at = now() + $2 * interval '1 millisecond'
+		WHERE id IN (
+			SELECT id FROM audit_outbox
+			WHERE sink = $1 AND dead_at IS NULL AND next_attempt_at <= now()
+			ORDER BY id LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+postgresOutboxColumns, sink, lease.Milliseconds(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("could not claim audit events: %v", err)
+	}
+
+	entries, err := scanOutboxEntries(rows)
+	if err != nil {
+		return nil, fmt.Errorf("could not claim audit events: %v", err)
+	}
+
+	// RETURNING does not keep the order of the subquery
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ID < entries[j].ID })
+	return entries, nil
+}
+
+func (o *postgresOutbox) Delete(ctx context.Context, id int64) error {
+	if _, err := o.db.ExecContext(ctx, `DELETE FROM audit_outbox WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("could not delete audit outbox entry: %v", err)
+	}
+	return nil
+}
+
+func (o *postgresOutbox) Retry(ctx context.Context, id int64, at time.Time, lastError string) error {
+	if _, err := o.db.ExecContext(ctx, `
+		UPDATE audit_outbox SET attempts = attempts + 1, next_attempt_at = $2, last_error = $3
+		WHERE id = $1`, id, at, lastError); err != nil {
+		return fmt.Errorf("could not reschedule audit outbox entry: %v", err)
+	}
+	return nil
+}
+
+func (o *postgresOutbox) Bury(ctx context.Context, id int64, lastError string) error {
+	if _, err := o.db.ExecContext(ctx, `
+		UPDATE audit_outbox SET attempts = attempts + 1, last_error = $2, dead_at = now()
+		WHERE id = $1`, id, lastError); err != nil {
+		return fmt.Errorf("could not dead-letter audit outbox entry: %v", err)
+	}
+	return nil
+}
+
+func (o *postgresOutbox) DeadLetters(ctx context.Context, limit int) ([]OutboxEntry, error) {
+	rows, err := o.db.QueryContext(ctx, `
+		SELECT `+postgresOutboxColumns+` FROM audit_outbox
+		WHERE dead_at IS NOT NULL ORDER BY id DESC LIMIT $1`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("could not list dead letters: %v", err)
+	}
+
+	entries, err := scanOutboxEntries(rows)
+	if err != nil {
+		return nil, fmt.Errorf("could not list dead letters: %v", err)
+	}
+	return entries, nil
+}
+
+func (o *postgresOutbox) Requeue(ctx context.Context, id int64) error {
+	result, err := o.db.ExecContext(ctx, `
+		UPDATE audit_outbox SET dead_at = NULL, attempts = 0, next_attempt_at = now()
+		WHERE id = $1 AND dead_at IS NOT NULL`, id)
+	if err != nil {
+		return fmt.Errorf("could not requeue dead letter: %v", err)
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrOutboxEntryNotFound
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+// parResponse is the response of a pushed authorization request (RFC 9126).
+type parResponse struct {
+	RequestURI string `json:"request_uri"`
+	ExpiresIn  int    `json:"expires_in"`
+}
+
+// authorizationURL returns the URL the browser is redirected to for login.
+//
+// When the provider advertises a pushed authorization request endpoint, the
+// authorization parameters are sent to it directly and the returned URL only
+// carries the client_id and the request_uri referencing them, keeping them
+// out of the front channel.
+//
+// When a request object key is configured, the parameters are first signed
+// into a request object (JAR).
+func (s *Server) authorizationURL(ctx *gin.Context, config *oauth2.Config, state string, opts ...oauth2.AuthCodeOption) (string, error) {
+	authURL := config.AuthCodeURL(state, opts...)
+	tenant := s.tenant(ctx)
+	parURL := tenant.provider.Metadata().PushedAuthURL
+	signed := s.requestObjects != nil && tenant.primary
+	// only auth0 issues tokens for the resources
+	var resources []string
+	if tenant.isAuth0() {
+		resources = s.resources
+	}
+	if parURL == "" && !signed && len(resources) == 0 {
+		return authURL, nil
+	}
+
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		return "", fmt.Errorf("could not parse authorization URL: %v", err)
+	}
+
+	// tokens may be requested for each of the resources
+	params := parsed.Query()
+	for _, resource := range resources {
+		params.Add("resource", resource)
+	}
+	if signed {
+		params, err = s.requestObjects.wrap(params, config.ClientID, tenant.provider.issuer)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if parURL == "" {
+		parsed.RawQuery = params.Encode()
+		return parsed.String(), nil
+	}
+
+	auth, err := s.tokenRequestParams(ctx)
+	if err != nil {
+		return "", err
+	}
+	for name, value := range auth {
+		params.Set(name, value)
+	}
+
+	var par *parResponse
+	err = s.withClientSecret(ctx, func(secret string) error {
+		if secret != "" {
+			params.Set("client_secret", secret)
+		}
+		par, err = s.pushAuthorizationRequest(ctx, parURL, params)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("client_id", config.ClientID)
+	query.Set("request_uri", par.RequestURI)
+	parsed.RawQuery = query.Encode()
+
+	return parsed.String(), nil
+}
+
+// pushAuthorizationRequest sends the authorization parameters to the pushed
+// authorization request endpoint at parURL. Errors returned by the provider are
+// reported as *oauth2.RetrieveError.
+func (s *Server) pushAuthorizationRequest(ctx context.Context, parURL string, params url.Values) (*parResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, parURL, strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("could not create pushed authorization request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := (&http.Client{Transport: s.transport}).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not push authorization request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("could not read pushed authorization response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		retrieveErr := &oauth2.RetrieveError{Response: resp, Body: body}
+		var errorBody struct {
+			Error            string `json:"error"`
+			ErrorDescription string `json:"error_description"`
+		}
+		if json.Unmarshal(body, &errorBody) == nil {
+			retrieveErr.ErrorCode = errorBody.Error
+			retrieveErr.ErrorDescription = errorBody.ErrorDescription
+		}
+		return nil, retrieveErr
+	}
+
+	var par parResponse
+	if err := json.Unmarshal(body, &par); err != nil {
+		return nil, fmt.Errorf("could not decode pushed authorization response: %v", err)
+	}
+	if par.RequestURI == "" {
+		return nil, fmt.Errorf("pushed authorization response has no request_uri")
+	}
+
+	return &par, nil
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// personalAccessTokenPrefix starts every personal access token, so that
+// leaked tokens are easy to find with secret scanners.
+const personalAccessTokenPrefix = "pat_"
+
+// maxAccessTokensPerUser is the number of active tokens a user may hold.
+const maxAccessTokensPerUser = 20
+
+// ErrAccessTokenNotFound is returned by a UserStore when no token matches.
+var ErrAccessTokenNotFound = errors.New("access token not found")
+
+// tokenScopes are the API scopes personal access tokens can be granted,
+// with their description.
+var tokenScopes = []struct{ Name, Description string }{
+	{"profile:read", "Read your profile"},
+	{"preferences:read", "Read your preferences"},
+	{"preferences:write", "Change your preferences"},
+	{"usage:read", "Read your API usage"},
+	{"sessions:read", "Read your active sessions"},
+	{"sessions:write", "Revoke your sessions"},
+}
+
+// tokenLifetimes are the lifetimes users pick from, in days.
+var tokenLifetimes = []int{7, 30, 90, 365}
+
+// AccessToken is a personal access token. Only the hash of its secret is
+// stored.
+type AccessToken struct {
+	ID         string     `json:"id"`
+	Sub        string     `json:"sub"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	Hash       string     `json:"-"` // hex SHA-256 of the secret
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Active reports whether the token can be used at now.
+func (t *AccessToken) Active(now time.Time) bool {
+	return t.RevokedAt == nil && now.Before(t.ExpiresAt)
+}
+
+// HasScope reports whether the token was granted scope.
+func (t *AccessToken) HasScope(scope string) bool {
+	for _, granted := range t.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// hashTokenSecret returns the stored hash of a token secret.
+func hashTokenSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// newAccessToken creates a token for sub and returns it with its value,
+// formatted as pat_<id>.<secret>.
+func newAccessToken(sub, name string, scopes []string, lifetime time.Duration) (*AccessToken, string, error) {
+	id := make([]byte, 9)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return nil, "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+
+	encodedID := base64.RawURLEncoding.EncodeToString(id)
+	encodedSecret := base64.RawURLEncoding.EncodeToString(secret)
+	now := time.Now().UTC()
+
+	token := &AccessToken{
+		ID:        encodedID,
+		Sub:       sub,
+		Name:      name,
+		Scopes:    scopes,
+		Hash:      hashTokenSecret(encodedSecret),
+		CreatedAt: now,
+		ExpiresAt: now.Add(lifetime),
+	}
+	return token, personalAccessTokenPrefix + encodedID + "." + encodedSecret, nil
+}
+
+// parseAccessToken splits a token value into its ID and secret.
+func parseAccessToken(value string) (id, secret string, ok bool) {
+	if !strings.HasPrefix(value, personalAccessTokenPrefix) {
+		return "", "", false
+	}
+	id, secret, ok = strings.Cut(strings.TrimPrefix(value, personalAccessTokenPrefix), ".")
+	return id, secret, ok && id != "" && secret != ""
+}
+
+// authenticateAccessToken returns the active token matching value.
+func (s *Server) authenticateAccessToken(ctx context.Context, value string) (*AccessToken, error) {
+	id, secret, ok := parseAccessToken(value)
+	if !ok {
+		return nil, ErrAccessTokenNotFound
+	}
+
+	token, err := s.users.GetAccessToken(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := hashTokenSecret(secret)
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(token.Hash)) != 1 || !token.Active(time.Now()) {
+		return nil, ErrAccessTokenNotFound
+	}
+
+	// tokens created before the sessions of the user were revoked are
+	// revoked with them
+	if s.revocations.Revoked(token.Sub, token.CreatedAt) {
+		return nil, ErrAccessTokenNotFound
+	}
+	return token, nil
+}
+
+// APIAuth authenticates API requests with the session cookies, a personal
+// access token sent as a bearer token or, when API_AUDIENCE is set, a JWT
+// access token issued by auth0 for the API. The user of a personal access
+// token is stored in the context under "user" and the token under
+// "access_token".
+func (s *Server) APIAuth() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		value := strings.TrimPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+		if s.apiAudience != "" && isJWT(value) {
+			s.authenticateBearerToken(ctx, value)
+			return
+		}
+		if !strings.HasPrefix(value, personalAccessTokenPrefix) {
+			RequireAPIUser()(ctx)
+			return
+		}
+
+		token, err := s.authenticateAccessToken(ctx, value)
+		if err != nil {
+			if !errors.Is(err, ErrAccessTokenNotFound) {
+				slog.ErrorContext(ctx, "could not check access token", "err", err)
+			}
+			ctx.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			abortWithError(ctx, http.StatusUnauthorized, "invalid_token", "the access token is invalid, expired or revoked")
+			return
+		}
+
+		user, err := s.users.GetUser(ctx, token.Sub)
+		if err != nil {
+			abortWithError(ctx, http.StatusUnauthorized, "invalid_token", "the user of the access token no longer exists")
+			return
+		}
+
+		if err := s.users.TouchAccessToken(ctx, token.ID, time.Now().UTC()); err != nil {
+			slog.ErrorContext(ctx, "could not update access token", "err", err)
+		}
+
+		ctx.Set("user", &UserInfo{
+			Sub:           user.Sub,
+			Name:          user.Name,
+			Email:         user.Email,
+			EmailVerified: user.EmailVerified,
+			Picture:       user.Picture,
+		})
+		ctx.Set("access_token", token)
+		ctx.Next()
+	}
+}
+
+// accessTokensPage renders the account page listing the tokens of the user.
+func (s *Server) accessTokensPage(ctx *gin.Context) {
+	s.renderAccessTokens(ctx, http.StatusOK, gin.H{})
+}
+
+// renderAccessTokens renders the tokens page with data.
+func (s *Server) renderAccessTokens(ctx *gin.Context, status int, data gin.H) {
+	u, _ := currentUser(ctx)
+	tokens, err := s.users.ListAccessTokens(ctx, u.Sub)
+	if err != nil {
+		slog.ErrorContext(ctx, "could not list access tokens", "err", err)
+		renderError(ctx, http.StatusInternalServerError, "Something went wrong", "An unexpected error occurred, please try again.")
+		return
+	}
+
+	now := time.Now()
+	location := currentPreferences(ctx).Location()
+	var rows []gin.H
+	for _, token := range tokens {
+		row := gin.H{
+			"ID":        token.ID,
+			"Name":      token.Name,
+			"Scopes":    strings.Join(token.Scopes, ", "),
+			"CreatedAt": token.CreatedAt.In(location).Format(time.RFC1123),
+			"ExpiresAt": token.ExpiresAt.In(location).Format(time.RFC1123),
+			"Active":    token.Active(now),
+			"LastUsed":  "never",
+		}
+		if token.LastUsedAt != nil {
+			row["LastUsed"] = token.LastUsedAt.In(location).Format(time.RFC1123)
+		}
+		if token.RevokedAt != nil {
+			row["Status"] = "revoked"
+		} else if !token.Active(now) {
+			row["Status"] = "expired"
+		}
+		rows = append(rows, row)
+	}
+
+	data["Tokens"] = rows
+	data["Scopes"] = tokenScopes
+	data["Lifetimes"] = tokenLifetimes
+	renderHTML(ctx, status, "tokens.html", data)
+}
+
+// createAccessTokenHandler creates a token from the form of the tokens page
+// and shows its value once.
+func (s *Server) createAccessTokenHandler(ctx *gin.Context) {
+	u, _ := currentUser(ctx)
+
+	name := strings.TrimSpace(ctx.PostForm("name"))
+	if name == "" || len(name) > 100 {
+		s.renderAccessTokens(ctx, http.StatusBadRequest, gin.H{"Error": "Please name the token (up to 100 characters)."})
+		return
+	}
+
+	var scopes []string
+	for _, scope := range tokenScopes {
+		if ctx.PostForm(scope.Name) != "" {
+			scopes = append(scopes, scope.Name)
+		}
+	}
+	if len(scopes) == 0 {
+		s.renderAccessTokens(ctx, http.StatusBadRequest, gin.H{"Error": "Please pick at least one scope."})
+		return
+	}
+
+	days, err := strconv.Atoi(ctx.PostForm("lifetime"))
+	valid := false
+	for _, lifetime := range tokenLifetimes {
+		valid = valid || (err == nil && days == lifetime)
+	}
+	if !valid {
+		s.renderAccessTokens(ctx, http.StatusBadRequest, gin.H{"Error": "Please pick an expiration."})
+		return
+	}
+
+	tokens, err := s.users.ListAccessTokens(ctx, u.Sub)
+	if err != nil {
+		slog.ErrorContext(ctx, "could not list access tokens", "err", err)
+		renderError(ctx, http.StatusInternalServerError, "Something went wrong", "An unexpected error occurred, please try again.")
+		return
+	}
+	active := 0
+	for _, token := range tokens {
+		if token.Active(time.Now()) {
+			active++
+		}
+	}
+	if active >= maxAccessTokensPerUser {
+		s.renderAccessTokens(ctx, http.StatusBadRequest, gin.H{"Error": "You have too many active tokens, please revoke one first."})
+		return
+	}
+
+	token, value, err := newAccessToken(u.Sub, name, scopes, time.Duration(days)*24*time.Hour)
+	if err == nil {
+		err = s.users.CreateAccessToken(ctx, token)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "could not create access token", "err", err)
+		renderError(ctx, http.StatusInternalServerError, "Something went wrong", "The token could not be created, please try again.")
+		return
+	}
+
+	s.audit(ctx, auditTokenCreated, u.Sub, map[string]string{"token_id": token.ID, "scopes": strings.Join(scopes, " ")})
+	s.renderAccessTokens(ctx, http.StatusOK, gin.H{"NewToken": value, "NewTokenName": name})
+}
+
+// revokeAccessTokenHandler revokes a token of the signed in user.
+func (s *Server) revokeAccessTokenHandler(ctx *gin.Context) {
+	u, _ := currentUser(ctx)
+	id := ctx.Param("id")
+
+	err := s.users.RevokeAccessToken(ctx, u.Sub, id, time.Now().UTC())
+	if err != nil && !errors.Is(err, ErrAccessTokenNotFound) {
+		slog.ErrorContext(ctx, "could not revoke access token", "err", err)
+		renderError(ctx, http.StatusInternalServerError, "Something went wrong", "The token could not be revoked, please try again.")
+		return
+	}
+
+	if err == nil {
+		s.audit(ctx, auditTokenRevoked, u.Sub, map[string]string{"token_id": id})
+		addFlash(ctx, flashSuccess, "The token was revoked.")
+	}
+	ctx.Redirect(http.StatusSeeOther, "/account/tokens")
+}
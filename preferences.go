@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Notification topics users can opt in to.
+const (
+	notifySecurityAlerts = "security_alerts"
+	notifyProductUpdates = "product_updates"
+	notifyNewsletter     = "newsletter"
+)
+
+// notificationDefaults are the opt-ins of users who never saved their
+// preferences.
+var notificationDefaults = map[string]bool{
+	notifySecurityAlerts: true,
+	notifyProductUpdates: false,
+	notifyNewsletter:     false,
+}
+
+// localePattern matches BCP 47 language tags such as "en" or "pt-BR".
+var localePattern = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+// Preferences are the settings users pick for themselves.
+type Preferences struct {
+	Timezone      string          `json:"timezone,omitempty"`      // IANA time zone, UTC when empty
+	Locale        string          `json:"locale,omitempty"`        // overrides the locale of the identity provider
+	Notifications map[string]bool `json:"notifications,omitempty"` // opt-in by topic
+	UpdatedAt     *time.Time      `json:"updated_at,omitempty"`    // nil until saved
+}
+
+// defaultPreferences returns the preferences of users who never saved any.
+func defaultPreferences() *Preferences {
+	notifications := make(map[string]bool, len(notificationDefaults))
+	for topic, enabled := range notificationDefaults {
+		notifications[topic] = enabled
+	}
+	return &Preferences{Notifications: notifications}
+}
+
+// validate checks the preferences and fills in the opt-in of the topics
+// left out.
+func (p *Preferences) validate() error {
+	if p.Timezone != "" {
+		if _, err := time.LoadLocation(p.Timezone); err != nil {
+			return fmt.Errorf("unknown timezone %q", p.Timezone)
+		}
+	}
+
+	if p.Locale != "" && (len(p.Locale) > 35 || !localePattern.MatchString(p.Locale)) {
+		return fmt.Errorf("invalid locale %q", p.Locale)
+	}
+
+	if p.Notifications == nil {
+		p.Notifications = make(map[string]bool, len(notificationDefaults))
+	}
+	for topic := range p.Notifications {
+		if _, ok := notificationDefaults[topic]; !ok {
+			return fmt.Errorf("unknown notification topic %q", topic)
+		}
+	}
+	for topic, enabled := range notificationDefaults {
+		if _, ok := p.Notifications[topic]; !ok {
+			p.Notifications[topic] = enabled
+		}
+	}
+
+	return nil
+}
+
+// Location returns the time zone of the user, UTC when unset.
+func (p *Preferences) Location() *time.Location {
+	if p.Timezone == "" {
+		return time.UTC
+	}
+	location, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+// OptedIn reports whether the user wants notifications about topic.
+func (p *Preferences) OptedIn(topic string) bool {
+	if enabled, ok := p.Notifications[topic]; ok {
+		return enabled
+	}
+	return notificationDefaults[topic]
+}
+
+// LoadPreferences stores the preferences of the signed in user in the
+// context under "preferences", for the pages and the API.
+func (s *Server) LoadPreferences() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if u, ok := currentUser(ctx); ok {
+			preferences, err := s.users.GetPreferences(ctx, u.Sub)
+			if err != nil {
+				slog.ErrorContext(ctx, "could not get preferences", "err", err)
+				preferences = defaultPreferences()
+			}
+			ctx.Set("preferences", preferences)
+		}
+		ctx.Next()
+	}
+}
+
+// currentPreferences returns the preferences stored by LoadPreferences, or
+// the default ones.
+func currentPreferences(ctx *gin.Context) *Preferences {
+	if preferences, ok := ctx.Get("preferences"); ok {
+		return preferences.(*Preferences)
+	}
+	return defaultPreferences()
+}
+
+// currentLocale returns the locale pages are rendered in: the preference of
+// the user, the locale of the identity provider or English.
+func currentLocale(ctx *gin.Context) string {
+	if locale := currentPreferences(ctx).Locale; locale != "" {
+		return locale
+	}
+	if u, ok := currentUser(ctx); ok && localePattern.MatchString(u.Locale) {
+		return u.Locale
+	}
+	return "en"
+}
+
+// RequireAPIUser rejects API requests of users who are not signed in.
+func RequireAPIUser() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if _, ok := currentUser(ctx); !ok {
+			abortWithError(ctx, http.StatusUnauthorized, "unauthorized", "sign in to use the API")
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// getPreferencesHandler returns the preferences of the signed in user.
+func (s *Server) getPreferencesHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, currentPreferences(ctx))
+}
+
+// putPreferencesHandler replaces the preferences of the signed in user.
+// Notification topics left out keep their default.
+func (s *Server) putPreferencesHandler(ctx *gin.Context) {
+	u, _ := currentUser(ctx)
+
+	var preferences Preferences
+	decoder := json.NewDecoder(io.LimitReader(ctx.Request.Body, 64<<10))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&preferences); err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "invalid_request", "could not parse preferences")
+		return
+	}
+
+	if err := preferences.validate(); err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	now := time.Now().UTC()
+	preferences.UpdatedAt = &now
+	if err := s.users.SavePreferences(ctx, u.Sub, &preferences); err != nil {
+		slog.ErrorContext(ctx, "could not save preferences", "err", err)
+		abortWithError(ctx, http.StatusInternalServerError, "server_error", "could not save preferences")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &preferences)
+}
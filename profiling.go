@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// profileFieldMaxLength is the maximum length of a profile field value.
+const profileFieldMaxLength = 200
+
+// ProfileField is a user_metadata field users are asked to fill in after
+// login.
+type ProfileField struct {
+	Name  string // user_metadata key
+	Label string // label shown in the form
+}
+
+// ProfileForm asks signed in users for the configured profile fields missing
+// from their user_metadata and stores them with the Management API, which
+// needs the read:users and update:users scopes.
+type ProfileForm struct {
+	management *ManagementClient
+	fields     []ProfileField
+}
+
+// NewProfileForm creates a form for fields, a comma separated list of
+// name=label pairs such as "company=Company,phone=Phone number". The label
+// defaults to the name.
+func NewProfileForm(management *ManagementClient, fields string) (*ProfileForm, error) {
+	form := &ProfileForm{management: management}
+	for _, field := range splitList(fields) {
+		name, label, _ := strings.Cut(field, "=")
+		name, label = strings.TrimSpace(name), strings.TrimSpace(label)
+		if name == "" {
+			return nil, fmt.Errorf("invalid profile field %q", field)
+		}
+		if label == "" {
+			label = name
+		}
+		form.fields = append(form.fields, ProfileField{Name: name, Label: label})
+	}
+
+	if len(form.fields) == 0 {
+		return nil, fmt.Errorf("no profile field")
+	}
+	return form, nil
+}
+
+// userMetadata returns the user_metadata of the user.
+func (m *ManagementClient) userMetadata(ctx context.Context, userID string) (map[string]interface{}, error) {
+	var user struct {
+		UserMetadata map[string]interface{} `json:"user_metadata"`
+	}
+	query := url.Values{"fields": {"user_metadata"}}
+	if err := m.get(ctx, "/users/"+url.PathEscape(userID), query, &user); err != nil {
+		return nil, err
+	}
+	return user.UserMetadata, nil
+}
+
+// updateUserMetadata merges metadata into the user_metadata of the user.
+func (m *ManagementClient) updateUserMetadata(ctx context.Context, userID string, metadata map[string]string) error {
+	body, err := json.Marshal(map[string]interface{}{"user_metadata": metadata})
+	if err != nil {
+		return err
+	}
+	return m.do(ctx, http.MethodPatch, "/users/"+url.PathEscape(userID), nil, body, nil)
+}
+
+// missing returns the fields without a value in metadata.
+func (f *ProfileForm) missing(metadata map[string]interface{}) []ProfileField {
+	var missing []ProfileField
+	for _, field := range f.fields {
+		value, _ := metadata[field.Name].(string)
+		if strings.TrimSpace(value) == "" {
+			missing = append(missing, field)
+		}
+	}
+	return missing
+}
+
+// missingFields returns the fields the signed in user still has to fill in.
+func (f *ProfileForm) missingFields(ctx *gin.Context) ([]ProfileField, error) {
+	u, ok := currentUser(ctx)
+	if !ok {
+		return nil, fmt.Errorf("user is not signed in")
+	}
+
+	metadata, err := f.management.userMetadata(ctx, u.Sub)
+	if err != nil {
+		return nil, err
+	}
+	return f.missing(metadata), nil
+}
+
+// RequireCompleteProfile redirects signed in users to the profile form until
+// every configured field is filled in. Completion is remembered in the
+// session so the Management API is only called until then.
+func (s *Server) RequireCompleteProfile() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		session := sessions.Default(ctx)
+		if session.Get("profile_complete") == "true" {
+			ctx.Next()
+			return
+		}
+
+		missing, err := s.profileForm.missingFields(ctx)
+		if err != nil {
+			// a Management API outage must not lock users out
+			slog.ErrorContext(ctx, "could not check profile completion", "err", err)
+			ctx.Next()
+			return
+		}
+
+		if len(missing) > 0 {
+			ctx.Redirect(http.StatusSeeOther, "/profile/complete")
+			ctx.Abort()
+			return
+		}
+
+		session.Set("profile_complete", "true")
+		if err := session.Save(); err != nil {
+			slog.ErrorContext(ctx, "could not save session", "err", err)
+		}
+		ctx.Next()
+	}
+}
+
+// profileFormPage renders the form asking for the missing profile fields.
+func (s *Server) profileFormPage(ctx *gin.Context) {
+	missing, err := s.profileForm.missingFields(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "could not read profile", "err", err)
+		renderError(ctx, http.StatusBadGateway, "Profile unavailable", "Your profile could not be loaded, please try again.")
+		return
+	}
+
+	if len(missing) == 0 {
+		ctx.Redirect(http.StatusSeeOther, "/profile")
+		return
+	}
+
+	renderHTML(ctx, http.StatusOK, "complete_profile.html", gin.H{"Fields": missing})
+}
+
+// profileFormHandler stores the profile fields posted by the form.
+func (s *Server) profileFormHandler(ctx *gin.Context) {
+	u, ok := currentUser(ctx)
+	if !ok {
+		ctx.Redirect(http.StatusSeeOther, "/")
+		return
+	}
+
+	missing, err := s.profileForm.missingFields(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "could not read profile", "err", err)
+		renderError(ctx, http.StatusBadGateway, "Profile unavailable", "Your profile could not be loaded, please try again.")
+		return
+	}
+
+	values := make(map[string]string, len(missing))
+	for _, field := range missing {
+		value := strings.TrimSpace(ctx.PostForm(field.Name))
+		if value == "" || len(value) > profileFieldMaxLength {
+			renderHTML(ctx, http.StatusBadRequest, "complete_profile.html", gin.H{
+				"Fields": missing,
+				"Values": ctx.Request.PostForm,
+				"Error":  fmt.Sprintf("Please fill in %s (up to %d characters).", field.Label, profileFieldMaxLength),
+			})
+			return
+		}
+		values[field.Name] = value
+	}
+
+	if len(values) > 0 {
+		if err := s.management.updateUserMetadata(ctx, u.Sub, values); err != nil {
+			slog.ErrorContext(ctx, "could not update profile", "err", err)
+			renderError(ctx, http.StatusBadGateway, "Profile not saved", "Your profile could not be saved, please try again.")
+			return
+		}
+	}
+
+	session := sessions.Default(ctx)
+	session.Set("profile_complete", "true")
+	if err := session.Save(); err != nil {
+		ctx.JSON(http.StatusInternalServerError, "could not save session")
+		return
+	}
+
+	ctx.Redirect(http.StatusSeeOther, "/profile")
+}
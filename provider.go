@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc"
+	"golang.org/x/oauth2"
+)
+
+// providerMetadata holds the fields of the OpenID Connect discovery document
+// used by the server.
+type providerMetadata struct {
+	Issuer                     string               `json:"issuer"`
+	AuthURL                    string               `json:"authorization_endpoint"`
+	TokenURL                   string               `json:"token_endpoint"`
+	JWKSURL                    string               `json:"jwks_uri"`
+	UserInfoURL                string               `json:"userinfo_endpoint"`
+	EndSessionEndpoint         string               `json:"end_session_endpoint"`
+	PushedAuthURL              string               `json:"pushed_authorization_request_endpoint"`
+	DeviceAuthURL              string               `json:"device_authorization_endpoint"`
+	RequireSignedRequestObject bool                 `json:"require_signed_request_object"`
+	MTLSEndpointAliases        *mtlsEndpointAliases `json:"mtls_endpoint_aliases"`
+}
+
+// Provider represents the OpenID Connect provider the server talks to.
+//
+// The host used for browser redirects and API calls (a custom domain, for
+// example) may differ from the issuer found in tokens, so both are kept.
+type Provider struct {
+	baseURL     string       // scheme and host used to reach the provider
+	issuer      string       // expected iss claim
+	checkIssuer bool         // whether the advertised issuer must match baseURL
+	client      *http.Client // client of the discovery and JWKS requests
+
+	mu       sync.RWMutex
+	metadata providerMetadata // discovered provider metadata
+	keySet   oidc.KeySet      // provider signing keys
+	mtls     bool             // whether the mutual TLS endpoint aliases are used
+}
+
+// NewProvider discovers the provider metadata served under host.
+//
+// When issuer is empty, the issuer advertised by the discovery document must
+// match host. Otherwise issuer overrides the expected iss claim, which is
+// needed when login happens on a custom domain but tokens are issued by the
+// canonical tenant domain.
+//
+// The discovery and JWKS requests are sent with the client of ctx, set with
+// oidc.ClientContext, or http.DefaultClient.
+func NewProvider(ctx context.Context, host, issuer string) (*Provider, error) {
+	p := &Provider{
+		baseURL:     "https://" + strings.TrimSuffix(host, "/"),
+		issuer:      issuer,
+		checkIssuer: issuer == "",
+		client:      contextClient(ctx),
+	}
+
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.checkIssuer {
+		p.issuer = metadata.Issuer
+	}
+	p.metadata = metadata
+	p.keySet = p.newKeySet(metadata.JWKSURL)
+
+	return p, nil
+}
+
+// contextClient returns the HTTP client of ctx, set with oidc.ClientContext,
+// or http.DefaultClient.
+func contextClient(ctx context.Context) *http.Client {
+	if client, ok := ctx.Value(oauth2.HTTPClient).(*http.Client); ok {
+		return client
+	}
+	return http.DefaultClient
+}
+
+// newKeySet returns the key set of the JWKS at jwksURL, fetched with the
+// client of the provider.
+func (p *Provider) newKeySet(jwksURL string) oidc.KeySet {
+	return oidc.NewRemoteKeySet(oidc.ClientContext(context.Background(), p.client), jwksURL)
+}
+
+// discover fetches the discovery document of the provider.
+func (p *Provider) discover(ctx context.Context) (providerMetadata, error) {
+	var metadata providerMetadata
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return metadata, fmt.Errorf("could not create discovery request: %v", err)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return metadata, fmt.Errorf("could not fetch discovery document: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return metadata, fmt.Errorf("could not read discovery document: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return metadata, fmt.Errorf("could not fetch discovery document: %s: %s", resp.Status, body)
+	}
+
+	if err := json.Unmarshal(body, &metadata); err != nil {
+		return metadata, fmt.Errorf("could not decode discovery document: %v", err)
+	}
+
+	if p.checkIssuer && strings.TrimSuffix(metadata.Issuer, "/") != p.baseURL {
+		return metadata, fmt.Errorf("issuer %q does not match provider host %q", metadata.Issuer, p.baseURL)
+	}
+
+	return metadata, nil
+}
+
+// Refresh discovers the provider metadata again, so that changed endpoints
+// or a rotated jwks_uri are picked up without a restart. The current
+// metadata is kept when discovery fails.
+func (p *Provider) Refresh(ctx context.Context) error {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return err
+	}
+
+	if p.checkIssuer && metadata.Issuer != p.issuer {
+		return fmt.Errorf("provider issuer changed from %q to %q", p.issuer, metadata.Issuer)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.mtls {
+		metadata.useMTLSEndpoints()
+	}
+	if metadata.JWKSURL != p.metadata.JWKSURL {
+		slog.InfoContext(ctx, "provider jwks_uri changed", "from", p.metadata.JWKSURL, "to", metadata.JWKSURL)
+		p.keySet = p.newKeySet(metadata.JWKSURL)
+	}
+	p.metadata = metadata
+
+	return nil
+}
+
+// Metadata returns the current provider metadata.
+func (p *Provider) Metadata() providerMetadata {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.metadata
+}
+
+// KeySet returns the current provider signing keys.
+func (p *Provider) KeySet() oidc.KeySet {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.keySet
+}
+
+// Endpoint returns the OAuth2 endpoints of the provider.
+func (p *Provider) Endpoint() oauth2.Endpoint {
+	metadata := p.Metadata()
+	return oauth2.Endpoint{
+		AuthURL:  metadata.AuthURL,
+		TokenURL: metadata.TokenURL,
+	}
+}
+
+// Verifier returns an ID token verifier checking tokens against the expected
+// issuer and the provider signing keys. Time based claims are checked with a
+// tolerance of leeway instead of the fixed go-oidc expiry check.
+func (p *Provider) Verifier(config *oidc.Config, leeway time.Duration) *IDTokenVerifier {
+	config.SkipExpiryCheck = true
+
+	return &IDTokenVerifier{
+		verifier: oidc.NewVerifier(p.issuer, p.KeySet(), config),
+		leeway:   leeway,
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// usageWindows are the quota windows, by the suffix used to configure them.
+var usageWindows = map[string]time.Duration{
+	"m": time.Minute,
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+}
+
+// Quota limits the API requests of a user in a window. A zero Limit only
+// tracks usage.
+type Quota struct {
+	Window string // m, h or d
+	Limit  int64
+}
+
+// parseQuotas parses a comma separated list of limit/window pairs such as
+// "100/m,5000/d". Usage is tracked per day even without a daily quota.
+func parseQuotas(raw string) ([]Quota, error) {
+	var quotas []Quota
+	daily := false
+	for _, item := range splitList(raw) {
+		limit, window, ok := strings.Cut(item, "/")
+		if _, known := usageWindows[window]; !ok || !known {
+			return nil, fmt.Errorf("invalid quota %q, expected limit/window with window m, h or d", item)
+		}
+		n, err := strconv.ParseInt(limit, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid quota limit %q", item)
+		}
+		quotas = append(quotas, Quota{Window: window, Limit: n})
+		daily = daily || window == "d"
+	}
+
+	if !daily {
+		quotas = append(quotas, Quota{Window: "d"})
+	}
+
+	// the shortest window first
+	sort.Slice(quotas, func(i, j int) bool {
+		return usageWindows[quotas[i].Window] < usageWindows[quotas[j].Window]
+	})
+	return quotas, nil
+}
+
+// UsageStore counts the requests of the users in fixed windows.
+type UsageStore interface {
+	// Increment adds a request to the counter key, which expires after ttl,
+	// and returns the new count.
+	Increment(ctx context.Context, key string, ttl time.Duration) (int64, error)
+	// Count returns the value of the counter key, 0 when it does not exist.
+	Count(ctx context.Context, key string) (int64, error)
+}
+
+// NewUsageStore returns a store shared between instances in Redis when
+// redisClient is set, and an in memory store otherwise.
+func NewUsageStore(redisClient *RedisClient) UsageStore {
+	if redisClient == nil {
+		return newMemoryUsageStore()
+	}
+	return &redisUsageStore{client: redisClient}
+}
+
+// memoryUsageStore keeps the counters in memory, per instance.
+type memoryUsageStore struct {
+	mu        sync.Mutex
+	counters  map[string]*usageCounter
+	lastPrune time.Time
+}
+
+// usageCounter is a counter of a memoryUsageStore.
+type usageCounter struct {
+	count   int64
+	expires time.Time
+}
+
+func newMemoryUsageStore() *memoryUsageStore {
+	return &memoryUsageStore{counters: make(map[string]*usageCounter)}
+}
+
+func (s *memoryUsageStore) Increment(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastPrune) > time.Minute {
+		for k, counter := range s.counters {
+			if now.After(counter.expires) {
+				delete(s.counters, k)
+			}
+		}
+		s.lastPrune = now
+	}
+
+	counter, ok := s.counters[key]
+	if !ok || now.After(counter.expires) {
+		counter = &usageCounter{expires: now.Add(ttl)}
+		s.counters[key] = counter
+	}
+	counter.count++
+	return counter.count, nil
+}
+
+func (s *memoryUsageStore) Count(ctx context.Context, key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counter, ok := s.counters[key]
+	if !ok || time.Now().After(counter.expires) {
+		return 0, nil
+	}
+	return counter.count, nil
+}
+
+// redisUsageStore keeps the counters in Redis.
+type redisUsageStore struct {
+	client *RedisClient
+}
+
+func (s *redisUsageStore) Increment(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	reply, err := s.client.Do(ctx, "INCR", key)
+	if err != nil {
+		return 0, err
+	}
+	count, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("unexpected INCR reply %v", reply)
+	}
+
+	// the first request of the window sets its expiry
+	if count == 1 {
+		if _, err := s.client.Do(ctx, "PEXPIRE", key, strconv.FormatInt(ttl.Milliseconds(), 10)); err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
+}
+
+func (s *redisUsageStore) Count(ctx context.Context, key string) (int64, error) {
+	reply, err := s.client.Do(ctx, "GET", key)
+	if err != nil || reply == nil {
+		return 0, err
+	}
+	value, _ := reply.(string)
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// WindowUsage is the usage of a user in the current window of a quota.
+type WindowUsage struct {
+	Window    string    `json:"window"`
+	Limit     int64     `json:"limit,omitempty"` // unlimited when 0
+	Used      int64     `json:"used"`
+	Remaining *int64    `json:"remaining,omitempty"`
+	Reset     time.Time `json:"reset"`
+}
+
+// Quotas enforces the API quotas of the users.
+type Quotas struct {
+	store  UsageStore
+	quotas []Quota
+}
+
+// NewQuotas creates quotas counting requests in store.
+func NewQuotas(store UsageStore, quotas []Quota) *Quotas {
+	return &Quotas{store: store, quotas: quotas}
+}
+
+// windowKey returns the counter of sub for the window of quota holding now,
+// and when the window ends.
+func (q Quota) windowKey(sub string, now time.Time) (string, time.Time) {
+	window := usageWindows[q.Window]
+	start := now.Truncate(window)
+	return fmt.Sprintf("usage:%s:%s:%d", sub, q.Window, start.Unix()), start.Add(window)
+}
+
+// usage returns the usage of sub in every window, after adding a request
+// when count is set.
+func (q *Quotas) usage(ctx context.Context, sub string, count bool) ([]WindowUsage, error) {
+	now := time.Now()
+	usage := make([]WindowUsage, 0, len(q.quotas))
+	for _, quota := range q.quotas {
+		key, reset := quota.windowKey(sub, now)
+
+		var used int64
+		var err error
+		if count {
+			used, err = q.store.Increment(ctx, key, reset.Sub(now)+time.Minute)
+		} else {
+			used, err = q.store.Count(ctx, key)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		window := WindowUsage{Window: quota.Window, Limit: quota.Limit, Used: used, Reset: reset.UTC()}
+		if quota.Limit > 0 {
+			remaining := quota.Limit - used
+			if remaining < 0 {
+				remaining = 0
+			}
+			window.Remaining = &remaining
+		}
+		usage = append(usage, window)
+	}
+	return usage, nil
+}
+
+// APIQuota counts the API requests of the signed in user and rejects them
+// with 429 once a quota is exceeded. The X-RateLimit headers describe the
+// quota closest to being exceeded. Requests are let through when the usage
+// store cannot be reached.
+func (s *Server) APIQuota() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		u, ok := currentUser(ctx)
+		if !ok {
+			ctx.Next()
+			return
+		}
+
+		usage, err := s.quotas.usage(ctx, u.Sub, true)
+		if err != nil {
+			slog.ErrorContext(ctx, "could not count API usage", "err", err)
+			ctx.Next()
+			return
+		}
+
+		var tightest *WindowUsage
+		var exceeded *WindowUsage
+		for i := range usage {
+			window := &usage[i]
+			if window.Remaining == nil {
+				continue
+			}
+			if tightest == nil || *window.Remaining < *tightest.Remaining {
+				tightest = window
+			}
+			if window.Used > window.Limit && (exceeded == nil || window.Reset.After(exceeded.Reset)) {
+				exceeded = window
+			}
+		}
+
+		if exceeded != nil {
+			tightest = exceeded
+		}
+		if tightest != nil {
+			ctx.Header("X-RateLimit-Limit", strconv.FormatInt(tightest.Limit, 10))
+			ctx.Header("X-RateLimit-Remaining", strconv.FormatInt(*tightest.Remaining, 10))
+			ctx.Header("X-RateLimit-Reset", strconv.FormatInt(tightest.Reset.Unix(), 10))
+		}
+
+		if exceeded != nil {
+			retryAfter := int64(time.Until(exceeded.Reset).Seconds()) + 1
+			ctx.Header("Retry-After", strconv.FormatInt(retryAfter, 10))
+			abortWithError(ctx, http.StatusTooManyRequests, "quota_exceeded",
+				fmt.Sprintf("the API quota of %d requests per %s is exceeded", exceeded.Limit, windowName(exceeded.Window)))
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// windowName returns the name of a quota window.
+func windowName(window string) string {
+	switch window {
+	case "m":
+		return "minute"
+	case "h":
+		return "hour"
+	default:
+		return "day"
+	}
+}
+
+// usageHandler returns the API usage of the signed in user.
+func (s *Server) usageHandler(ctx *gin.Context) {
+	u, _ := currentUser(ctx)
+	s.writeUsage(ctx, u.Sub)
+}
+
+// adminUsageHandler returns the API usage of the user given in the path.
+func (s *Server) adminUsageHandler(ctx *gin.Context) {
+	s.writeUsage(ctx, ctx.Param("sub"))
+}
+
+// writeUsage responds with the usage of sub in every window.
+func (s *Server) writeUsage(ctx *gin.Context, sub string) {
+	usage, err := s.quotas.usage(ctx, sub, false)
+	if err != nil {
+		slog.ErrorContext(ctx, "could not read API usage", "err", err)
+		abortWithError(ctx, http.StatusInternalServerError, "server_error", "could not read API usage")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"sub": sub, "usage": usage})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+// parseCallbackURLs parses the registered callback URLs.
+func parseCallbackURLs(values []string) ([]*url.URL, error) {
+	var callbackURLs []*url.URL
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		callbackURL, err := url.Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse callback URL %q: %v", value, err)
+		}
+		callbackURLs = append(callbackURLs, callbackURL)
+	}
+
+	return callbackURLs, nil
+}
+
+// requestScheme returns the scheme the request was performed with, the one
+// forwarded by a trusted proxy when set.
+func requestScheme(ctx *gin.Context) string {
+	if scheme := ctx.GetString("request_scheme"); scheme != "" {
+		return scheme
+	}
+	if ctx.Request.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// callbackURL picks the registered callback URL matching the host and scheme
+// of the incoming request. A callback URL matching only the host is used when
+// none matches both, and the first registered URL otherwise. The sites have
+// their own.
+func (s *Server) callbackURL(ctx *gin.Context) string {
+	if site := s.site(ctx); site != nil {
+		return site.callbackURL.String()
+	}
+	if len(s.callbackURLs) == 0 {
+		return ""
+	}
+
+	var hostMatch *url.URL
+	for _, callbackURL := range s.callbackURLs {
+		if !strings.EqualFold(callbackURL.Host, ctx.Request.Host) {
+			continue
+		}
+		if callbackURL.Scheme == requestScheme(ctx) {
+			return callbackURL.String()
+		}
+		if hostMatch == nil {
+			hostMatch = callbackURL
+		}
+	}
+
+	if hostMatch != nil {
+		return hostMatch.String()
+	}
+	return s.callbackURLs[0].String()
+}
+
+// oauth2Config returns a copy of the OAuth2 configuration whose RedirectURL
+// matches the incoming request, with the current provider endpoints.
+func (s *Server) oauth2Config(ctx *gin.Context) *oauth2.Config {
+	tenant := s.tenant(ctx)
+	config := *tenant.oauth2config
+	config.RedirectURL = s.callbackURL(ctx)
+
+	// the endpoints may have changed since startup
+	endpoint := tenant.provider.Endpoint()
+	config.Endpoint.AuthURL, config.Endpoint.TokenURL = endpoint.AuthURL, endpoint.TokenURL
+	return &config
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisMaxIdleConns is the number of idle connections kept open.
+const redisMaxIdleConns = 10
+
+// redisIdempotentCommands are the commands sent again on a new connection
+// after an I/O error: running them twice has the effect of running them once.
+var redisIdempotentCommands = map[string]bool{
+	"GET":     true,
+	"PING":    true,
+	"DEL":     true,
+	"PEXPIRE": true,
+	"XREAD":   true,
+}
+
+// redisError is an error reply of the Redis server.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// RedisClient is a minimal Redis client speaking RESP, enough for the
+// counters and keys shared between instances.
+type RedisClient struct {
+	addr      string
+	password  string
+	username  string
+	db        int
+	tlsConfig *tls.Config
+	timeout   time.Duration
+
+	idle chan *redisConn
+}
+
+// redisConn is a connection to the Redis server.
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// NewRedisClient creates a client for redis://[user:password@]host:port/db,
+// rediss:// connecting with TLS.
+func NewRedisClient(rawURL string) (*RedisClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse Redis URL: %v", err)
+	}
+
+	c := &RedisClient{
+		addr:    u.Host,
+		timeout: 5 * time.Second,
+		idle:    make(chan *redisConn, redisMaxIdleConns),
+	}
+
+	switch u.Scheme {
+	case "redis":
+	case "rediss":
+		c.tlsConfig = &tls.Config{ServerName: u.Hostname()}
+	default:
+		return nil, fmt.Errorf("unsupported Redis scheme %q", u.Scheme)
+	}
+
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+
+	if u.User != nil {
+		c.username = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		c.db, err = strconv.Atoi(db)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Redis database %q", db)
+		}
+	}
+
+	return c, nil
+}
+
+// Do sends a command and returns its reply: a string, an int64, nil or a
+// []interface{} of those. Error replies are returned as errors.
+func (c *RedisClient) Do(ctx context.Context, args ...string) (interface{}, error) {
+	conn, pooled, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, sent, err := conn.do(ctx, c.timeout, args...)
+	if isRedisIOError(err) {
+		// the connection state is unknown after an I/O error
+		conn.conn.Close()
+
+		// the server, or a proxy in front of it, closes the connections
+		// idle for too long, which is only noticed when they are used:
+		// the command is sent again once on a new connection, when it did
+		// not reach the server or running it twice is harmless. It is not
+		// after a timeout, as the server may then still be running it.
+		var netErr net.Error
+		if !pooled || (sent && !redisIdempotentCommands[strings.ToUpper(args[0])]) || (errors.As(err, &netErr) && netErr.Timeout()) || ctx.Err() != nil {
+			return nil, err
+		}
+		if conn, err = c.dial(ctx); err != nil {
+			return nil, err
+		}
+		if reply, _, err = conn.do(ctx, c.timeout, args...); isRedisIOError(err) {
+			conn.conn.Close()
+			return nil, err
+		}
+	}
+
+	c.put(conn)
+	return reply, err
+}
+
+// isRedisIOError reports whether err is an error other than an error reply,
+// after which the connection cannot be used anymore.
+func isRedisIOError(err error) bool {
+	_, ok := err.(redisError)
+	return err != nil && !ok
+}
+
+// Ping checks that the server answers.
+func (c *RedisClient) Ping(ctx context.Context) error {
+	_, err := c.Do(ctx, "PING")
+	return err
+}
+
+// get returns an idle connection, and true, or opens a new one.
+func (c *RedisClient) get(ctx context.Context) (*redisConn, bool, error) {
+	select {
+	case conn := <-c.idle:
+		return conn, true, nil
+	default:
+	}
+
+	conn, err := c.dial(ctx)
+	return conn, false, err
+}
+
+// dial opens a new connection, authenticated and on the database of the
+// client.
+func (c *RedisClient) dial(ctx context.Context) (*redisConn, error) {
+	dialer := &net.Dialer{Timeout: c.timeout}
+	var conn net.Conn
+	var err error
+	if c.tlsConfig != nil {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: c.tlsConfig}).DialContext(ctx, "tcp", c.addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", c.addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to Redis: %v", err)
+	}
+
+	rc := &redisConn{conn: conn, r: bufio.NewReader(conn)}
+	if c.password != "" {
+		args := []string{"AUTH", c.password}
+		if c.username != "" {
+			args = []string{"AUTH", c.username, c.password}
+		}
+		if _, _, err := rc.do(ctx, c.timeout, args...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.db != 0 {
+		if _, _, err := rc.do(ctx, c.timeout, "SELECT", strconv.Itoa(c.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return rc, nil
+}
+
+// put returns conn to the idle connections, closing it when there are
+// enough.
+func (c *RedisClient) put(conn *redisConn) {
+	select {
+	case c.idle <- conn:
+	default:
+		conn.conn.Close()
+	}
+}
+
+// do writes a command and reads its reply, reporting whether any of the
+// command was written, and may have reached the server.
+func (rc *redisConn) do(ctx context.Context, timeout time.Duration, args ...string) (interface{}, bool, error) {
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	rc.conn.SetDeadline(deadline)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if n, err := io.WriteString(rc.conn, b.String()); err != nil {
+		return nil, n > 0, fmt.Errorf("could not send Redis command: %w", err)
+	}
+
+	reply, err := rc.read()
+	return reply, true, err
+}
+
+// read reads a RESP reply.
+func (rc *redisConn) read() (interface{}, error) {
+	line, err := rc.r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("could not read Redis reply: %w", err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("invalid Redis reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid Redis bulk length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(rc.r, buf); err != nil {
+			return nil, fmt.Errorf("could not read Redis reply: %w", err)
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid Redis array length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			values[i], err = rc.read()
+			if isRedisIOError(err) {
+				return nil, err
+			}
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("unsupported Redis reply %q", line)
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRedis is a Redis server answering every command with the reply of
+// its handler, written verbatim as RESP.
+type fakeRedis struct {
+	net.Listener
+	handle func(conn int, args []string) string // the reply, or "" to close the connection
+
+	mu       sync.Mutex
+	conns    int
+	commands []string // the commands received, as sent by the client
+}
+
+// newFakeRedis starts a fake server until the test ends.
+func newFakeRedis(t *testing.T, handle func(conn int, args []string) string) *fakeRedis {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeRedis{Listener: l, handle: handle}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns++
+			n := s.conns
+			s.mu.Unlock()
+			go s.serve(n, conn)
+		}
+	}()
+	return s
+}
+
+// client returns a client of the server.
+func (s *fakeRedis) client(t *testing.T) *RedisClient {
+	t.Helper()
+
+	c, err := NewRedisClient("redis://" + s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// connections returns the number of connections accepted.
+func (s *fakeRedis) connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns
+}
+
+// received returns the commands received, and resets them.
+func (s *fakeRedis) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	commands := s.commands
+	s.commands = nil
+	return commands
+}
+
+func (s *fakeRedis) serve(n int, conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readRedisCommand(r)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.commands = append(s.commands, strings.Join(args, " "))
+		s.mu.Unlock()
+
+		reply := s.handle(n, args)
+		if reply == "" {
+			return
+		}
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// readRedisCommand reads a command sent as a RESP array of bulk strings.
+func readRedisCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(line[1:], "\r\n"))
+	if err != nil || line[0] != '*' {
+		return nil, fmt.Errorf("invalid command %q", line)
+	}
+	args := make([]string, n)
+	for i := range args {
+		if line, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSuffix(line[1:], "\r\n"))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+// newMemoryRedis starts a fake server keeping the keys in memory, answering
+// the commands of the session store.
+func newMemoryRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+
+	var mu sync.Mutex
+	keys := map[string]string{}
+	return newFakeRedis(t, func(conn int, args []string) string {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch strings.ToUpper(args[0]) {
+		case "PING":
+			return "+PONG\r\n"
+		case "GET":
+			value, ok := keys[args[1]]
+			if !ok {
+				return "$-1\r\n"
+			}
+			return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+		case "SETEX":
+			keys[args[1]] = args[3]
+			return "+OK\r\n"
+		case "DEL":
+			_, ok := keys[args[1]]
+			delete(keys, args[1])
+			return map[bool]string{true: ":1\r\n", false: ":0\r\n"}[ok]
+		default:
+			return "-ERR unknown command '" + args[0] + "'\r\n"
+		}
+	})
+}
+
+func TestRedisClientReplies(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		reply string
+		want  interface{}
+	}{
+		{"simple string", "+OK\r\n", "OK"},
+		{"integer", ":42\r\n", int64(42)},
+		{"bulk string", "$5\r\nhello\r\n", "hello"},
+		{"bulk string with CRLF", "$4\r\na\r\nb\r\n", "a\r\nb"},
+		{"nil bulk string", "$-1\r\n", nil},
+		{"nil array", "*-1\r\n", nil},
+		{"nested array", "*2\r\n*2\r\n$6\r\nstream\r\n*1\r\n:1\r\n$-1\r\n", []interface{}{[]interface{}{"stream", []interface{}{int64(1)}}, nil}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeRedis(t, func(conn int, args []string) string { return tt.reply })
+			reply, err := server.client(t).Do(context.Background(), "GET", "key")
+			if err != nil {
+				t.Fatalf("Do = %v", err)
+			}
+			if !reflect.DeepEqual(reply, tt.want) {
+				t.Errorf("reply = %#v, want %#v", reply, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedisClientSequentialReplies(t *testing.T) {
+	// the replies, larger than the read buffer for the last ones, are read
+	// in order on the pooled connection
+	server := newFakeRedis(t, func(conn int, args []string) string {
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(args[1]), args[1])
+	})
+	client := server.client(t)
+	for i := 0; i < 20; i++ {
+		key := strings.Repeat("k", i*300)
+		reply, err := client.Do(context.Background(), "GET", key)
+		if err != nil || reply != key {
+			t.Fatalf("GET %d = %v, %v", i, reply, err)
+		}
+	}
+	if n := server.connections(); n != 1 {
+		t.Errorf("%d connections opened, want the pooled one", n)
+	}
+}
+
+func TestRedisClientErrorReplies(t *testing.T) {
+	server := newFakeRedis(t, func(conn int, args []string) string {
+		if args[0] == "INCR" {
+			return "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"
+		}
+		return "*2\r\n:1\r\n-ERR in array\r\n"
+	})
+	client := server.client(t)
+
+	_, err := client.Do(context.Background(), "INCR", "key")
+	var replyErr redisError
+	if !errors.As(err, &replyErr) || !strings.HasPrefix(string(replyErr), "WRONGTYPE") {
+		t.Fatalf("INCR = %v, want the error reply", err)
+	}
+
+	// an error reply leaves the connection usable
+	reply, err := client.Do(context.Background(), "EXEC")
+	if err != nil {
+		t.Fatalf("EXEC = %v", err)
+	}
+	if values := reply.([]interface{}); len(values) != 2 || values[0] != int64(1) {
+		t.Errorf("EXEC = %#v, want the values of the array", reply)
+	}
+	if n := server.connections(); n != 1 {
+		t.Errorf("%d connections opened, want the pooled one", n)
+	}
+}
+
+func TestRedisClientPoolAfterReset(t *testing.T) {
+	server := newFakeRedis(t, func(conn int, args []string) string {
+		if conn == 1 && args[0] == "INCR" {
+			return "" // the connection is closed by the server
+		}
+		return ":1\r\n"
+	})
+	client := server.client(t)
+
+	if _, err := client.Do(context.Background(), "GET", "key"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Do(context.Background(), "INCR", "key"); err == nil {
+		t.Fatal("INCR succeeded on the closed connection")
+	}
+	// the closed connection is not pooled, the next commands use a new one
+	for i := 0; i < 3; i++ {
+		if _, err := client.Do(context.Background(), "INCR", "key"); err != nil {
+			t.Fatalf("INCR after the reset = %v", err)
+		}
+	}
+	if n := server.connections(); n != 2 {
+		t.Errorf("%d connections opened, want 2", n)
+	}
+}
+
+func TestRedisClientRedialsStaleConnections(t *testing.T) {
+	for _, tt := range []struct {
+		command []string
+		retried bool
+	}{
+		{[]string{"GET", "key"}, true},
+		{[]string{"DEL", "key"}, true},
+		{[]string{"INCR", "key"}, false},
+		{[]string{"SET", "key", "1", "NX"}, false},
+		{[]string{"XADD", "stream", "*", "field", "1"}, false},
+	} {
+		t.Run(tt.command[0], func(t *testing.T) {
+			// the first connection is closed by the server after the PING
+			// pooling it, as an idle timeout would
+			server := newFakeRedis(t, func(conn int, args []string) string {
+				if conn == 1 && args[0] != "PING" {
+					return ""
+				}
+				return "+OK\r\n"
+			})
+			client := server.client(t)
+			if err := client.Ping(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			server.received()
+
+			_, err := client.Do(context.Background(), tt.command...)
+			if tt.retried && err != nil {
+				t.Errorf("Do(%v) = %v, want the command sent again", tt.command, err)
+			}
+			if !tt.retried && err == nil {
+				t.Errorf("Do(%v) succeeded, want the error of the closed connection", tt.command)
+			}
+			if got, want := len(server.received()), map[bool]int{true: 2, false: 1}[tt.retried]; got != want {
+				t.Errorf("command received %d times, want %d", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// logAttrsKey is the context key of the attributes identifying the request
+// in its log lines.
+type logAttrsKey struct{}
+
+// addLogAttrs adds attrs to the lines logged with the context of the
+// request, by contextHandler.
+func addLogAttrs(ctx *gin.Context, attrs ...slog.Attr) {
+	parent := ctx.Request.Context()
+	existing, _ := parent.Value(logAttrsKey{}).([]slog.Attr)
+	// the attributes of the parent are shared, they must not be appended to
+	attrs = append(existing[:len(existing):len(existing)], attrs...)
+	ctx.Request = ctx.Request.WithContext(context.WithValue(parent, logAttrsKey{}, attrs))
+}
+
+// requestLogAttrs returns the attributes added by addLogAttrs to the
+// request of ctx, a gin context or the context of the request.
+func requestLogAttrs(ctx context.Context) []slog.Attr {
+	// gin contexts do not fall back to the context of their request
+	if c, ok := ctx.(*gin.Context); ok {
+		if c.Request == nil {
+			return nil
+		}
+		ctx = c.Request.Context()
+	}
+	attrs, _ := ctx.Value(logAttrsKey{}).([]slog.Attr)
+	return attrs
+}
+
+// RequestLogger adds the authenticated user to the log context of the
+// requests.
+type RequestLogger struct {
+	hashSub    bool   // log a hash of the sub instead of the sub
+	rolesClaim string // claim holding the roles of the user, if any
+}
+
+// NewRequestLogger creates a RequestLogger. When hashSub is set, users are
+// logged with a SHA-256 hash of their sub, which still correlates the lines
+// of a user without naming them.
+func NewRequestLogger(hashSub bool, rolesClaim string) *RequestLogger {
+	return &RequestLogger{hashSub: hashSub, rolesClaim: rolesClaim}
+}
+
+// sub returns the sub as logged.
+func (l *RequestLogger) sub(sub string) string {
+	if !l.hashSub {
+		return sub
+	}
+	sum := sha256.Sum256([]byte(sub))
+	return hex.EncodeToString(sum[:16])
+}
+
+// LogUser adds the sub, organization and roles of the signed in user to the
+// log lines of the request. It must run after the user is authenticated.
+func (l *RequestLogger) LogUser() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		u, ok := currentUser(ctx)
+		if !ok {
+			ctx.Next()
+			return
+		}
+
+		attrs := []slog.Attr{slog.String("sub", l.sub(u.Sub))}
+
+		// the organization and roles are read from the verified claims
+		// saved server-side at login, users of access tokens have none
+		_, tokenUser := ctx.Get("access_token")
+		var claims map[string]json.RawMessage
+		if profile, ok := sessionProfile(ctx); ok && !tokenUser && json.Unmarshal(profile, &claims) == nil {
+			var orgID string
+			if json.Unmarshal(claims["org_id"], &orgID) == nil && orgID != "" {
+				attrs = append(attrs, slog.String("org", orgID))
+			}
+
+			var roles []string
+			if l.rolesClaim != "" && json.Unmarshal(claims[l.rolesClaim], &roles) == nil && len(roles) > 0 {
+				attrs = append(attrs, slog.String("roles", strings.Join(roles, ",")))
+			}
+		}
+
+		addLogAttrs(ctx, attrs...)
+		ctx.Next()
+	}
+}
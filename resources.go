@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+// resourceToken is an access token cached with the session tokens for a
+// resource.
+type resourceToken struct {
+	AccessToken string    `json:"access_token"`
+	TokenType   string    `json:"token_type"`
+	Expiry      time.Time `json:"expiry"`
+}
+
+// cacheResourceToken caches token for resource in the session tokens, which
+// still need to be saved.
+func cacheResourceToken(tokens *SessionTokens, resource string, token *oauth2.Token) {
+	if tokens.Resources == nil {
+		tokens.Resources = make(map[string]resourceToken)
+	}
+	tokens.Resources[resource] = resourceToken{
+		AccessToken: token.AccessToken,
+		TokenType:   token.Type(),
+		Expiry:      token.Expiry,
+	}
+}
+
+// ResourceToken returns an access token whose audience is resource, from
+// the session cache or obtained with the refresh token of the session, for
+// calling a configured API on behalf of the user.
+func (s *Server) ResourceToken(ctx *gin.Context, resource string) (*oauth2.Token, error) {
+	tokens, ok := sessionTokens(ctx)
+	if !ok {
+		return nil, fmt.Errorf("session is not signed in")
+	}
+
+	if cached, ok := tokens.Resources[resource]; ok {
+		token := &oauth2.Token{AccessToken: cached.AccessToken, TokenType: cached.TokenType, Expiry: cached.Expiry}
+		if token.Valid() {
+			return token, nil
+		}
+	}
+
+	refreshToken := tokens.RefreshToken
+	if refreshToken == "" {
+		return nil, fmt.Errorf("session has no refresh token to request a token for %s", resource)
+	}
+
+	// concurrent requests share the refresh, which may rotate the refresh
+	// token and would otherwise be made with a token already used
+	result, err := s.calls.Do(ctx, "refresh\x00"+resource+"\x00"+hashTokenSecret(refreshToken), func() (interface{}, error) {
+		return s.refreshForResource(ctx, refreshToken, resource)
+	})
+	token, _ := result.(*oauth2.Token)
+	if err != nil {
+		// the session is kept during an outage, only the call needing the
+		// token fails
+		if isIdPOutage(err) {
+			s.idpHealth.failed(err)
+			markDegraded(ctx)
+		}
+		return nil, err
+	}
+	s.idpHealth.succeeded()
+
+	updated := *tokens
+	cacheResourceToken(&updated, resource, token)
+	if token.RefreshToken != "" {
+		updated.RefreshToken = token.RefreshToken
+	}
+	if err := s.saveSessionTokens(ctx, &updated); err != nil {
+		return nil, fmt.Errorf("could not save session tokens: %v", err)
+	}
+
+	return token, nil
+}
+
+// refreshForResource exchanges refreshToken for an access token whose
+// audience is resource, or the default audience when resource is empty.
+func (s *Server) refreshForResource(ctx *gin.Context, refreshToken, resource string) (*oauth2.Token, error) {
+	config := s.oauth2Config(ctx)
+
+	params := url.Values{}
+	params.Set("grant_type", "refresh_token")
+	params.Set("refresh_token", refreshToken)
+	if resource != "" {
+		params.Set("resource", resource)
+	}
+	params.Set("client_id", config.ClientID)
+
+	authOpts, err := s.tokenRequestParams(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range authOpts {
+		params.Set(name, value)
+	}
+
+	client := &http.Client{Transport: s.transport}
+	if key, ok := s.sessionDPoPKey(ctx); ok {
+		client.Transport = &dpopTransport{key: key, base: s.transport}
+	}
+
+	var token *oauth2.Token
+	err = s.withClientSecret(ctx, func(secret string) error {
+		if secret != "" {
+			params.Set("client_secret", secret)
+		}
+		token, err = postTokenRequest(ctx, client, config.Endpoint.TokenURL, params)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// as at login, the renewed access tokens must be bound to the client
+	// certificate before they are stored or sent to the resources
+	if s.certThumbprint != "" && s.tenant(ctx).isAuth0() {
+		if err := checkCertificateBinding(token.AccessToken, s.certThumbprint); err != nil {
+			return nil, err
+		}
+	}
+	return token, nil
+}
+
+// postTokenRequest sends a token request. Errors returned by the provider
+// are reported as *oauth2.RetrieveError.
+func postTokenRequest(ctx *gin.Context, client *http.Client, tokenURL string, params url.Values) (*oauth2.Token, error) {
+	// the context of the request rather than ctx, which gin reuses for the
+	// next requests while the transport may still be watching it
+	req, err := http.NewRequestWithContext(ctx.Request.Context(), http.MethodPost, tokenURL, strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("could not create token request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not request token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("could not read token response: %v", err)
+	}
+
+	var response struct {
+		AccessToken      string `json:"access_token"`
+		TokenType        string `json:"token_type"`
+		RefreshToken     string `json:"refresh_token"`
+		ExpiresIn        int64  `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	_ = json.Unmarshal(body, &response)
+
+	if resp.StatusCode != http.StatusOK || response.AccessToken == "" {
+		return nil, &oauth2.RetrieveError{
+			Response:         resp,
+			Body:             body,
+			ErrorCode:        response.Error,
+			ErrorDescription: response.ErrorDescription,
+		}
+	}
+
+	token := &oauth2.Token{
+		AccessToken:  response.AccessToken,
+		TokenType:    response.TokenType,
+		RefreshToken: response.RefreshToken,
+	}
+	if response.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(response.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/gin-contrib/sessions"
+)
+
+// defaultReturnTo is where users land after login when no page was
+// requested.
+const defaultReturnTo = "/profile"
+
+// localPath returns raw when it is a path of this site, such as
+// /files/report?page=2, and false for anything a browser could resolve to
+// another site, such as //evil.example or /\evil.example.
+func localPath(raw string) (string, bool) {
+	if !strings.HasPrefix(raw, "/") || strings.HasPrefix(raw, "//") || strings.HasPrefix(raw, "/\\") {
+		return "", false
+	}
+	if strings.ContainsAny(raw, "\r\n\t") {
+		return "", false
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil {
+		return "", false
+	}
+	return u.RequestURI(), true
+}
+
+// parseReturnToOrigins parses the origins, such as https://app.example.com,
+// users may be sent back to after login besides the paths of this site.
+func parseReturnToOrigins(values []string) ([]*url.URL, error) {
+	var origins []*url.URL
+	for _, value := range values {
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || strings.Trim(u.Path, "/") != "" {
+			return nil, fmt.Errorf("invalid return to origin %q, expected a scheme and host such as https://app.example.com", value)
+		}
+		origins = append(origins, &url.URL{Scheme: u.Scheme, Host: strings.ToLower(u.Host)})
+	}
+	return origins, nil
+}
+
+// allowedReturnTo returns raw when users may be sent there after login: a
+// path of this site or a URL on one of the RETURN_TO_ALLOWLIST origins.
+func (s *Server) allowedReturnTo(raw string) (string, bool) {
+	if path, ok := localPath(raw); ok {
+		return path, true
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || u.User != nil {
+		return "", false
+	}
+	for _, origin := range s.returnToOrigins {
+		if u.Scheme == origin.Scheme && strings.ToLower(u.Host) == origin.Host {
+			return u.String(), true
+		}
+	}
+	return "", false
+}
+
+// popReturnTo returns the page remembered for after the login, checked
+// again in case the allowlist changed meanwhile, and forgets it. The session
+// still needs to be saved.
+func (s *Server) popReturnTo(session sessions.Session) string {
+	raw, _ := session.Get("return_to").(string)
+	session.Delete("return_to")
+
+	if returnTo, ok := s.allowedReturnTo(raw); ok {
+		return returnTo
+	}
+	return defaultReturnTo
+}
@@ -0,0 +1,92 @@
+package main
+
+import "testing"
+
+func TestLocalPath(t *testing.T) {
+	for _, tt := range []struct {
+		raw    string
+		want   string
+		wantOK bool
+	}{
+		{"/files/report", "/files/report", true},
+		{"/files/report?page=2", "/files/report?page=2", true},
+		{"/", "/", true},
+		{"/files/report#top", "/files/report", true},
+		{"/%2F%2Fevil.example", "/%2F%2Fevil.example", true},
+		{"", "", false},
+		{"files/report", "", false},
+		{"//evil.example", "", false},
+		{"/\\evil.example", "", false},
+		{"https://evil.example/files", "", false},
+		{"javascript:alert(1)", "", false},
+		{"/files\r\nLocation: https://evil.example", "", false},
+		{"/\t/evil.example", "", false},
+		{"/files/%zz", "", false},
+	} {
+		t.Run(tt.raw, func(t *testing.T) {
+			got, ok := localPath(tt.raw)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("localPath(%q) = %q, %t, want %q, %t", tt.raw, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestParseReturnToOrigins(t *testing.T) {
+	for _, tt := range []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"https://app.example.com", "https://app.example.com", false},
+		{"https://App.Example.com/", "https://app.example.com", false},
+		{"http://localhost:3000", "http://localhost:3000", false},
+		{"app.example.com", "", true},
+		{"ftp://app.example.com", "", true},
+		{"https://", "", true},
+		{"https://app.example.com/callback", "", true},
+	} {
+		t.Run(tt.value, func(t *testing.T) {
+			origins, err := parseReturnToOrigins([]string{tt.value})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseReturnToOrigins(%q) = %v, want error %t", tt.value, err, tt.wantErr)
+			}
+			if err == nil && origins[0].String() != tt.want {
+				t.Errorf("origin = %q, want %q", origins[0], tt.want)
+			}
+		})
+	}
+}
+
+func TestAllowedReturnTo(t *testing.T) {
+	origins, err := parseReturnToOrigins([]string{"https://app.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{returnToOrigins: origins}
+
+	for _, tt := range []struct {
+		raw    string
+		want   string
+		wantOK bool
+	}{
+		{"/files/report?page=2", "/files/report?page=2", true},
+		{"https://app.example.com/orders?id=1", "https://app.example.com/orders?id=1", true},
+		{"https://APP.example.com/orders", "https://APP.example.com/orders", true},
+		{"https://app.example.com.evil.example/orders", "", false},
+		{"https://evil.example/orders", "", false},
+		{"http://app.example.com/orders", "", false},
+		{"https://app.example.com:8443/orders", "", false},
+		{"https://user@app.example.com/orders", "", false},
+		{"//app.example.com/orders", "", false},
+		{"//evil.example", "", false},
+		{"", "", false},
+	} {
+		t.Run(tt.raw, func(t *testing.T) {
+			got, ok := server.allowedReturnTo(tt.raw)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("allowedReturnTo(%q) = %q, %t, want %q, %t", tt.raw, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
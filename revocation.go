@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RevocationList records, per user, the time before which sessions are no
+// longer valid. Sessions live in cookies, so revoking them means refusing
+// the ones created before the revocation.
+type RevocationList struct {
+	mu            sync.RWMutex
+	revokedBefore map[string]time.Time
+	onRevoke      func(sub string, at time.Time)
+}
+
+// NewRevocationList creates an empty revocation list.
+func NewRevocationList() *RevocationList {
+	return &RevocationList{revokedBefore: make(map[string]time.Time)}
+}
+
+// OnRevoke sets a function called with every revocation made with
+// RevokeUser, such as the session replication.
+func (r *RevocationList) OnRevoke(fn func(sub string, at time.Time)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.onRevoke = fn
+}
+
+// RevokeUser revokes every session of the user created until now.
+func (r *RevocationList) RevokeUser(sub string) {
+	now := time.Now()
+	r.RevokeUserAt(sub, now)
+
+	r.mu.RLock()
+	onRevoke := r.onRevoke
+	r.mu.RUnlock()
+	if onRevoke != nil {
+		onRevoke(sub, now)
+	}
+}
+
+// RevokeUserAt revokes every session of the user created until at, unless
+// a later revocation is already recorded.
+func (r *RevocationList) RevokeUserAt(sub string, at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if revokedBefore, ok := r.revokedBefore[sub]; !ok || at.After(revokedBefore) {
+		r.revokedBefore[sub] = at
+	}
+}
+
+// Revoked reports whether a session of the user created at loginAt is
+// revoked.
+func (r *RevocationList) Revoked(sub string, loginAt time.Time) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	revokedBefore, ok := r.revokedBefore[sub]
+	return ok && !loginAt.After(revokedBefore)
+}
+
+// sessionLoginTime returns when the session of the request was created, or
+// the zero time when unknown.
+func sessionLoginTime(ctx *gin.Context) time.Time {
+	session := defaultSession(ctx)
+	if session == nil {
+		return time.Time{}
+	}
+
+	value, _ := session.Get("login_at").(string)
+	nanos, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// RejectRevokedSessions logs out users whose session was revoked. It must
+// run after IsAuthenticated. Access tokens are checked by APIAuth.
+func (s *Server) RejectRevokedSessions() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		// tokens are checked when they are authenticated
+		_, pat := ctx.Get("access_token")
+		_, bearer := ctx.Get("bearer_token")
+		if pat || bearer {
+			ctx.Next()
+			return
+		}
+
+		u, ok := currentUser(ctx)
+		if !ok || !s.revocations.Revoked(u.Sub, sessionLoginTime(ctx)) {
+			ctx.Next()
+			return
+		}
+
+		s.audit(ctx, auditAccessDenied, u.Sub, map[string]string{"reason": "session_revoked"})
+		s.endSession(ctx)
+	}
+}
+
+// endSession logs out the user of a revoked session and sends them to the
+// home page, the API requests getting a 401 instead.
+func (s *Server) endSession(ctx *gin.Context) {
+	s.deleteSessionTokens(ctx)
+	ctx.SetCookie("u", "", -1, "/", "", false, true)
+	if wantsJSON(ctx) {
+		abortWithError(ctx, http.StatusUnauthorized, "unauthorized", "your session has ended, please log in again")
+		return
+	}
+	addFlash(ctx, flashWarning, "Your session has ended, please log in again.")
+	ctx.Redirect(http.StatusTemporaryRedirect, "/")
+	ctx.Abort()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// userRoles returns the roles of the signed in user, read from the claim
+// named by ROLES_CLAIM in the ID token of the session. An auth0 action adds
+// them under a namespaced claim such as https://example.com/roles. The ID
+// token was verified at login and is kept server-side, so its claims are
+// trusted as is.
+func (s *Server) userRoles(ctx *gin.Context) []string {
+	if value, ok := ctx.Get("roles"); ok {
+		roles, _ := value.([]string)
+		return roles
+	}
+
+	var roles []string
+	if tokens, ok := sessionTokens(ctx); ok && s.rolesClaim != "" {
+		roles = rolesFromIDToken(tokens.IDToken, s.rolesClaim)
+	}
+	ctx.Set("roles", roles)
+	return roles
+}
+
+// rolesFromIDToken reads the roles in claim of the raw ID token, a list or a
+// single role.
+func rolesFromIDToken(rawIDToken, claim string) []string {
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return nil
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil
+	}
+
+	var claims map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil
+	}
+
+	var roles []string
+	if json.Unmarshal(claims[claim], &roles) == nil {
+		return roles
+	}
+	var role string
+	if json.Unmarshal(claims[claim], &role) == nil && role != "" {
+		return []string{role}
+	}
+	return nil
+}
+
+// RequireRole lets through the users having one of roles, and answers the
+// others with 403: a JSON error for API requests, an access denied page
+// otherwise.
+// It must run after IsAuthenticated.
+func (s *Server) RequireRole(roles ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		for _, role := range roles {
+			for _, userRole := range s.userRoles(ctx) {
+				if role == userRole {
+					ctx.Next()
+					return
+				}
+			}
+		}
+
+		sub := ""
+		if u, ok := currentUser(ctx); ok {
+			sub = u.Sub
+		}
+		s.audit(ctx, auditAccessDenied, sub, map[string]string{"reason": "missing_role", "required": strings.Join(roles, ",")})
+
+		renderError(ctx, http.StatusForbidden, "Access denied", "You do not have a role allowed to access this resource.")
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// defaultShutdownTimeout is how long in-flight requests are given to
+// complete once the server is asked to stop, unless SHUTDOWN_TIMEOUT is set.
+const defaultShutdownTimeout = 30 * time.Second
+
+// readHeaderTimeout is how long clients are given to send the headers of a
+// request, so that slow clients cannot hold connections open forever.
+const readHeaderTimeout = 10 * time.Second
+
+// Run serves HTTP requests on addr, over TLS when a certificate is
+// configured, and the admin API on the admin address when configured, until
+// SIGINT or SIGTERM is received. With HTTP/3 enabled, the same port is also
+// served over QUIC and the HTTPS responses advertise it with Alt-Svc. It then stops
+// accepting connections and waits up to the shutdown timeout for in-flight
+// requests, such as callbacks exchanging their code, to complete.
+//
+// With reusePort set, the listening sockets are opened with SO_REUSEPORT so
+// a new version of the binary can bind the same addresses while the old one
+// is still running. Sending SIGTERM to the old process once the new one is
+// ready hands over the traffic without dropping connections.
+func (s *Server) Run(addr string) error {
+	var h3 *http3.Server
+	listeners := []listenerConfig{{name: "LISTEN_ADDR", addr: addr, handler: s.router, tlsConfig: s.tlsConfig}}
+	if s.tlsConfig != nil && s.http3 {
+		h3 = s.newHTTP3Server(s.router)
+		listeners[0].handler = advertiseHTTP3(h3, s.router)
+	}
+	if s.adminAddr != "" {
+		listeners = append(listeners, listenerConfig{name: "ADMIN_LISTEN_ADDR", addr: s.adminAddr, handler: s.adminRouter})
+	}
+	if s.tlsRedirectAddr != "" {
+		listeners = append(listeners, listenerConfig{name: "TLS_REDIRECT_ADDR", addr: s.tlsRedirectAddr, handler: redirectToHTTPS(addr)})
+	}
+
+	// the admin API or the redirect would otherwise silently replace the
+	// site, or fail to bind once the other one is listening
+	for i, a := range listeners {
+		for _, b := range listeners[:i] {
+			if sameListenAddr(a.addr, b.addr) {
+				return fmt.Errorf("%s %s conflicts with %s %s", a.name, a.addr, b.name, b.addr)
+			}
+		}
+	}
+
+	var httpServers []*http.Server
+	errs := make(chan error, len(listeners)+1)
+	for _, l := range listeners {
+		listener, err := listen(l.addr, s.reusePort)
+		if err != nil {
+			for _, httpServer := range httpServers {
+				httpServer.Close()
+			}
+			return fmt.Errorf("could not listen on %s: %v", l.addr, err)
+		}
+
+		httpServer := &http.Server{Handler: l.handler, TLSConfig: l.tlsConfig, ReadHeaderTimeout: readHeaderTimeout}
+		httpServers = append(httpServers, httpServer)
+		go func() {
+			if httpServer.TLSConfig != nil {
+				errs <- httpServer.ServeTLS(listener, "", "")
+				return
+			}
+			errs <- httpServer.Serve(listener)
+		}()
+	}
+
+	var packetConn net.PacketConn
+	if h3 != nil {
+		var err error
+		packetConn, err = listenPacket(addr, s.reusePort)
+		if err != nil {
+			for _, httpServer := range httpServers {
+				httpServer.Close()
+			}
+			return fmt.Errorf("could not listen on %s/udp: %v", addr, err)
+		}
+		go func() {
+			errs <- h3.Serve(packetConn)
+		}()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	var serveErr error
+	select {
+	case serveErr = <-errs:
+	case sig := <-signals:
+		slog.Info("shutting down", "signal", sig.String())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+
+	for _, httpServer := range httpServers {
+		if err := httpServer.Shutdown(ctx); err != nil && serveErr == nil {
+			serveErr = fmt.Errorf("could not shut down gracefully: %v", err)
+		}
+	}
+	if h3 != nil {
+		if err := h3.Shutdown(ctx); err != nil && serveErr == nil {
+			serveErr = fmt.Errorf("could not shut down gracefully: %v", err)
+		}
+		packetConn.Close()
+	}
+	if serveErr == nil {
+		slog.Info("in-flight requests completed, stopped")
+	}
+
+	if serveErr != nil && !errors.Is(serveErr, http.ErrServerClosed) {
+		return serveErr
+	}
+
+	return nil
+}
+
+// listenerConfig is a TCP address served by Run.
+type listenerConfig struct {
+	name      string // setting of the address, for the errors
+	addr      string
+	handler   http.Handler
+	tlsConfig *tls.Config // nil for plain HTTP
+}
+
+// sameListenAddr reports whether listening on a and b would bind the same
+// port of an interface, the empty, 0.0.0.0 and :: hosts binding all of them.
+func sameListenAddr(a, b string) bool {
+	hostA, portA, errA := net.SplitHostPort(a)
+	hostB, portB, errB := net.SplitHostPort(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	if portA != portB {
+		return false
+	}
+
+	wildcard := func(host string) bool {
+		return host == "" || host == "0.0.0.0" || host == "::"
+	}
+	return hostA == hostB || wildcard(hostA) || wildcard(hostB)
+}
+
+// listen opens a TCP listener on addr, sharing the port with other
+// processes when reusePort is set.
+func listen(addr string, reusePort bool) (net.Listener, error) {
+	config := net.ListenConfig{}
+	if reusePort {
+		config.Control = reusePortControl
+	}
+
+	return config.Listen(context.Background(), "tcp", addr)
+}
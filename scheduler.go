@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Job is a task run periodically by the Scheduler.
+type Job struct {
+	Name     string
+	Interval time.Duration                   // time between two runs
+	Jitter   time.Duration                   // random delay of up to Jitter added to every run
+	Run      func(ctx context.Context) error // the task
+	Wake     <-chan struct{}                 // optional, runs the job early when signaled
+}
+
+// JobStats are the metrics collected for a job.
+type JobStats struct {
+	Runs         int64         `json:"runs"`
+	Failures     int64         `json:"failures"`
+	Skipped      int64         `json:"skipped"` // runs skipped as the previous one was still running
+	LastRun      time.Time     `json:"last_run"`
+	LastDuration time.Duration `json:"last_duration"`
+	LastError    string        `json:"last_error,omitempty"`
+}
+
+// scheduledJob is a job registered with the scheduler.
+type scheduledJob struct {
+	Job
+
+	running int32 // set while the job runs
+
+	mu    sync.Mutex
+	stats JobStats
+}
+
+// Scheduler runs the background jobs of the server, such as the user
+// synchronization, so that every feature does not need its own goroutine.
+//
+// A job is never run concurrently with itself: a run due while the previous
+// one is still running is skipped.
+type Scheduler struct {
+	mu        sync.Mutex
+	jobs      []*scheduledJob
+	onFailure func(job string, err error)
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	started   bool
+}
+
+// NewScheduler creates a scheduler without jobs.
+func NewScheduler() *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{ctx: ctx, cancel: cancel}
+}
+
+// OnFailure sets fn to be called when a job fails after succeeding, or on
+// its first run, so that alerts are not repeated on every run of a failing
+// job.
+func (s *Scheduler) OnFailure(fn func(job string, err error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onFailure = fn
+}
+
+// Add registers a job. Jobs added after Start are started immediately.
+func (s *Scheduler) Add(job Job) error {
+	if job.Interval <= 0 {
+		return fmt.Errorf("job %s: interval must be positive, got %v", job.Name, job.Interval)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	scheduled := &scheduledJob{Job: job}
+	s.jobs = append(s.jobs, scheduled)
+
+	if s.started {
+		s.start(scheduled)
+	}
+	return nil
+}
+
+// Start starts running the jobs, the first run of each job happening after
+// its jitter.
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started {
+		return
+	}
+	s.started = true
+
+	for _, job := range s.jobs {
+		s.start(job)
+	}
+}
+
+// Stop stops scheduling jobs, cancels the context of the running ones and
+// waits for them to return.
+func (s *Scheduler) Stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// Stats returns the metrics of every job by name.
+func (s *Scheduler) Stats() map[string]JobStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := make(map[string]JobStats, len(s.jobs))
+	for _, job := range s.jobs {
+		job.mu.Lock()
+		stats[job.Name] = job.stats
+		job.mu.Unlock()
+	}
+	return stats
+}
+
+// start runs job in the background until the scheduler is stopped.
+func (s *Scheduler) start(job *scheduledJob) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		timer := time.NewTimer(jitter(job.Jitter))
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-timer.C:
+			case <-job.Wake:
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+			}
+
+			if atomic.CompareAndSwapInt32(&job.running, 0, 1) {
+				s.mu.Lock()
+				onFailure := s.onFailure
+				s.mu.Unlock()
+
+				s.wg.Add(1)
+				go func() {
+					defer s.wg.Done()
+					defer atomic.StoreInt32(&job.running, 0)
+					job.run(s.ctx, onFailure)
+				}()
+			} else {
+				job.mu.Lock()
+				job.stats.Skipped++
+				job.mu.Unlock()
+			}
+
+			timer.Reset(job.Interval + jitter(job.Jitter))
+		}
+	}()
+}
+
+// run runs the job once and records its metrics, calling onFailure when set
+// and the job starts failing.
+func (j *scheduledJob) run(ctx context.Context, onFailure func(job string, err error)) {
+	start := time.Now()
+	err := j.Run(ctx)
+	duration := time.Since(start)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	wasFailing := j.stats.LastError != ""
+	if err != nil && !wasFailing && onFailure != nil && ctx.Err() == nil {
+		go onFailure(j.Name, err)
+	}
+
+	j.stats.Runs++
+	j.stats.LastRun = start
+	j.stats.LastDuration = duration
+	j.stats.LastError = ""
+	if err != nil {
+		j.stats.Failures++
+		j.stats.LastError = err.Error()
+		slog.ErrorContext(ctx, "job failed", "job", j.Name, "duration", duration, "err", err)
+	}
+}
+
+// jitterRand is seeded per process so that instances started together do
+// not run their jobs in lockstep.
+var (
+	jitterMu   sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// jitter returns a random duration in [0, max).
+func jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+	return time.Duration(jitterRand.Int63n(int64(max)))
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSchedulerAddRejectsIntervals(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		interval time.Duration
+		wantErr  bool
+	}{
+		{"positive", time.Minute, false},
+		{"zero", 0, true},
+		{"negative", -time.Second, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewScheduler()
+			err := s.Add(Job{Name: "job", Interval: tt.interval, Run: func(context.Context) error { return nil }})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Add(%v) = %v, want error %v", tt.interval, err, tt.wantErr)
+			}
+			if _, ok := s.Stats()["job"]; ok == tt.wantErr {
+				t.Errorf("job registered = %v, want %v", ok, !tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSchedulerWake(t *testing.T) {
+	s := NewScheduler()
+	wake := make(chan struct{}, 1)
+	runs := make(chan struct{}, 1)
+	if err := s.Add(Job{Name: "job", Interval: time.Hour, Wake: wake, Run: func(context.Context) error {
+		runs <- struct{}{}
+		return nil
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	s.Start()
+	defer s.Stop()
+
+	// the first run happens right away without jitter
+	<-runs
+	wake <- struct{}{}
+	select {
+	case <-runs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("job was not run when woken up")
+	}
+}
+
+func TestSchedulerOnFailure(t *testing.T) {
+	s := NewScheduler()
+	failures := make(chan string, 10)
+	s.OnFailure(func(job string, err error) { failures <- job })
+
+	wake := make(chan struct{}, 1)
+	runs := make(chan struct{}, 10)
+	if err := s.Add(Job{Name: "failing", Interval: time.Hour, Wake: wake, Run: func(context.Context) error {
+		defer func() { runs <- struct{}{} }()
+		return errors.New("down")
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	s.Start()
+	defer s.Stop()
+
+	<-runs
+	if job := <-failures; job != "failing" {
+		t.Errorf("OnFailure got %q, want failing", job)
+	}
+
+	// a job still failing is not reported again
+	wake <- struct{}{}
+	<-runs
+	select {
+	case job := <-failures:
+		t.Errorf("OnFailure called again for %q", job)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
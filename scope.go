@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+// grantedScope returns the scope granted with token, which the provider may
+// omit when it is the requested one.
+func grantedScope(token *oauth2.Token, requested string) string {
+	if scope, _ := token.Extra("scope").(string); scope != "" {
+		return scope
+	}
+	return requested
+}
+
+// accessTokenScopes returns the scopes of the scope claim and the
+// permissions of the permissions claim, added by auth0 when RBAC is enabled
+// for the API, of a JWT access token. It reports false for opaque tokens.
+// The token was issued to this server by the provider, so its claims are
+// read without verifying the signature.
+func accessTokenScopes(accessToken string) ([]string, bool) {
+	parts := strings.Split(accessToken, ".")
+	if len(parts) != 3 {
+		return nil, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, false
+	}
+
+	var claims struct {
+		Scope       string   `json:"scope"`
+		Permissions []string `json:"permissions"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, false
+	}
+	return append(strings.Fields(claims.Scope), claims.Permissions...), true
+}
+
+// grantedScopes returns the scopes of the credentials of the request: those
+// of the personal access token or JWT access token sent, or those of the
+// access token of the session, falling back to the scope granted at login
+// for opaque access tokens. It reports false when the request has no
+// credentials.
+func grantedScopes(ctx *gin.Context) ([]string, bool) {
+	if value, ok := ctx.Get("access_token"); ok {
+		return value.(*AccessToken).Scopes, true
+	}
+	if value, ok := ctx.Get("bearer_token"); ok {
+		return value.(*BearerToken).Scopes, true
+	}
+
+	tokens, ok := sessionTokens(ctx)
+	if !ok || tokens.AccessToken == "" {
+		return nil, false
+	}
+	if scopes, ok := accessTokenScopes(tokens.AccessToken); ok {
+		return scopes, true
+	}
+	return strings.Fields(tokens.Scope), true
+}
+
+// containsString reports whether values holds value.
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireScope rejects the requests whose access token was not granted all
+// of scopes, as a scope or as an auth0 permission. It applies to personal
+// access tokens, bearer access tokens and session requests, checking the
+// access token of the session. It must run after APIAuth.
+func RequireScope(scopes ...string) gin.HandlerFunc {
+	required := strings.Join(scopes, " ")
+	return func(ctx *gin.Context) {
+		granted, ok := grantedScopes(ctx)
+		if !ok {
+			ctx.Header("WWW-Authenticate", `Bearer`)
+			abortWithError(ctx, http.StatusUnauthorized, "unauthorized", "a valid session or access token is required")
+			return
+		}
+
+		for _, scope := range scopes {
+			if !containsString(granted, scope) {
+				ctx.Header("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+required+`"`)
+				abortWithError(ctx, http.StatusForbidden, "insufficient_scope", "the access token needs the "+required+" scope")
+				return
+			}
+		}
+		ctx.Next()
+	}
+}
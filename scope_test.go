@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// unsignedJWT returns a JWT with payload, whose signature is not checked
+// when reading the scopes of an access token.
+func unsignedJWT(payload string) string {
+	return "eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".c2ln"
+}
+
+func TestRequireScopeSession(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		tokens *SessionTokens
+		want   int
+	}{
+		{"scope claim", &SessionTokens{AccessToken: unsignedJWT(`{"scope":"openid profile:read"}`)}, http.StatusOK},
+		{"permissions claim", &SessionTokens{AccessToken: unsignedJWT(`{"scope":"openid","permissions":["profile:read"]}`)}, http.StatusOK},
+		{"opaque token", &SessionTokens{AccessToken: "opaque", Scope: "openid profile:read"}, http.StatusOK},
+		{"JWT without the scope", &SessionTokens{AccessToken: unsignedJWT(`{"scope":"openid profile email"}`), Scope: "profile:read"}, http.StatusForbidden},
+		{"opaque token without the scope", &SessionTokens{AccessToken: "opaque", Scope: "openid profile email"}, http.StatusForbidden},
+		{"no access token", &SessionTokens{}, http.StatusUnauthorized},
+		{"signed out", nil, http.StatusUnauthorized},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.GET("/api/v1/me", func(ctx *gin.Context) {
+				if tt.tokens != nil {
+					ctx.Set("session_tokens", tt.tokens)
+				}
+			}, RequireScope("profile:read"), func(ctx *gin.Context) {
+				ctx.Status(http.StatusOK)
+			})
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/me", nil))
+			if w.Code != tt.want {
+				t.Errorf("status = %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+const (
+	testClientID = "test-client"
+	testNonce    = "test-nonce"
+	testState    = "test-state"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// testProvider is an OpenID Connect provider issuing signed ID tokens, whose
+// token endpoint answers every code.
+type testProvider struct {
+	*httptest.Server
+	signer      jose.Signer
+	key         *rsa.PrivateKey
+	claims      map[string]interface{} // claims of the ID tokens issued, besides the standard ones
+	down        int32                  // set to answer the token requests with 503, as during an outage
+	accessToken atomic.Value           // string access token issued, test-access-token when unset
+}
+
+// newTestProvider starts a provider over TLS, trusted by the default
+// transport until the test ends.
+func newTestProvider(t *testing.T) *testProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key}, (&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &testProvider{signer: signer, key: key, claims: map[string]interface{}{"sub": "auth0|test", "name": "Test User"}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.URL + "/",
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/oauth/token",
+			"jwks_uri":               p.URL + "/.well-known/jwks.json",
+			"userinfo_endpoint":      p.URL + "/userinfo",
+			"end_session_endpoint":   p.URL + "/oidc/logout",
+		})
+	})
+	mux.HandleFunc("/.well-known/jwks.json", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &key.PublicKey, KeyID: "test", Algorithm: "RS256", Use: "sig"}}})
+	})
+	mux.HandleFunc("/oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if atomic.LoadInt32(&p.down) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error":"temporarily_unavailable"}`))
+			return
+		}
+		accessToken, _ := p.accessToken.Load().(string)
+		if accessToken == "" {
+			accessToken = "test-access-token"
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  accessToken,
+			"token_type":    "Bearer",
+			"expires_in":    3600,
+			"refresh_token": "test-refresh-token",
+			"id_token":      p.sign(t, p.idTokenClaims()),
+		})
+	})
+	p.Server = httptest.NewTLSServer(mux)
+	t.Cleanup(p.Close)
+
+	transport := http.DefaultTransport
+	http.DefaultTransport = p.Client().Transport
+	t.Cleanup(func() { http.DefaultTransport = transport })
+	return p
+}
+
+// host is the host name of the provider, its AUTH0_DOMAIN.
+func (p *testProvider) host() string {
+	return strings.TrimPrefix(p.URL, "https://")
+}
+
+// idTokenClaims returns the claims of an ID token of the test client.
+func (p *testProvider) idTokenClaims() map[string]interface{} {
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":   p.URL + "/",
+		"aud":   testClientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+		"nonce": testNonce,
+	}
+	for k, v := range p.claims {
+		claims[k] = v
+	}
+	return claims
+}
+
+// sign returns the compact JWS of claims signed by the provider.
+func (p *testProvider) sign(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := p.signer.Sign(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := signed.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+// testConfig returns the configuration of a server signing in with p.
+func testConfig(p *testProvider) *Config {
+	config := defaultConfig()
+	config.Auth0.Domain = p.host()
+	config.Auth0.ClientID = testClientID
+	config.Auth0.ClientSecret = "test-secret"
+	config.Auth0.CallbackURLs = []string{"http://example.com/callback"}
+	return config
+}
+
+// newTestServer creates the server of config, with a router serving the
+// sessions of the session backend. The redis backend uses a fake server
+// keeping the keys in memory.
+func newTestServer(t *testing.T, config *Config, backend string) *Server {
+	t.Helper()
+
+	var redisURL string
+	if backend == "redis" {
+		redisURL = "redis://" + newMemoryRedis(t).Addr().String()
+	}
+
+	server, err := NewServer(config)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	t.Cleanup(server.tracer.Close)
+
+	codec, err := NewSessionCodec("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewSessionStore(backend, codec, redisURL, "", []byte("0123456789abcdef0123456789abcdef"), []byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Options(sessions.Options{Path: "/", MaxAge: defaultSessionMaxAge, HttpOnly: true})
+	server.router.Use(server.Sessions(store), server.LoadSessionTokens())
+	return server
+}
+
+// startTestLogin adds the /test/login route, which stores the state and
+// nonce of a login in the session as the login handler does.
+func startTestLogin(server *Server) {
+	server.router.GET("/test/login", func(ctx *gin.Context) {
+		session := sessions.Default(ctx)
+		session.Set("state", testState)
+		session.Set("nonce", testNonce)
+		if err := session.Save(); err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		ctx.Status(http.StatusNoContent)
+	})
+}
+
+// serve sends a GET request for target to the router of server with the
+// cookies, and returns the response.
+func serve(server *Server, target string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	return w
+}
+
+// responseCookie returns the cookie name set by the response, or nil.
+func responseCookie(w *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+// signIn signs in through the callback of server, which must have the
+// routes of startTestLogin and /callback, and returns the session cookie.
+func signIn(t *testing.T, server *Server) *http.Cookie {
+	t.Helper()
+
+	w := serve(server, "/test/login")
+	w = serve(server, callbackURL(), responseCookie(w, sessionCookieName))
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("callback = %d %s, want a redirect", w.Code, w.Body)
+	}
+	cookie := responseCookie(w, sessionCookieName)
+	if cookie == nil {
+		t.Fatalf("callback set no session cookie")
+	}
+	return cookie
+}
+
+// callbackURL returns the callback target of the test login.
+func callbackURL() string {
+	return "/callback?" + url.Values{"state": {testState}, "code": {"test-code"}}.Encode()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gorilla/securecookie"
+	gsessions "github.com/gorilla/sessions"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// SessionCodec serializes the values stored in a session.
+//
+// Session values written by the handlers are limited to strings, booleans
+// and numbers so that every codec round-trips them.
+type SessionCodec interface {
+	Serialize(src interface{}) ([]byte, error)
+	Deserialize(src []byte, dst interface{}) error
+}
+
+// NewSessionCodec returns the session codec registered under name. JSON is
+// used when name is empty.
+func NewSessionCodec(name string) (SessionCodec, error) {
+	switch name {
+	case "", "json":
+		return jsonSessionCodec{}, nil
+	case "gob":
+		return securecookie.GobEncoder{}, nil
+	case "msgpack":
+		return msgpackSessionCodec{}, nil
+	default:
+		return nil, fmt.Errorf("unknown session codec %q", name)
+	}
+}
+
+// jsonSessionCodec encodes session values as a JSON object. It is the
+// default as the values stay readable once the cookie is decrypted.
+type jsonSessionCodec struct{}
+
+func (jsonSessionCodec) Serialize(src interface{}) ([]byte, error) {
+	values, ok := src.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unsupported session values type %T", src)
+	}
+
+	// encoding/json only supports string keys
+	object := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		name, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("unsupported session key type %T", key)
+		}
+		object[name] = value
+	}
+
+	return json.Marshal(object)
+}
+
+func (jsonSessionCodec) Deserialize(src []byte, dst interface{}) error {
+	values, ok := dst.(*map[interface{}]interface{})
+	if !ok {
+		return fmt.Errorf("unsupported session values type %T", dst)
+	}
+
+	var object map[string]interface{}
+	if err := json.Unmarshal(src, &object); err != nil {
+		return err
+	}
+
+	for key, value := range object {
+		(*values)[key] = value
+	}
+
+	return nil
+}
+
+// msgpackSessionCodec encodes session values with MessagePack, which keeps
+// cookies smaller than JSON or gob.
+type msgpackSessionCodec struct{}
+
+func (msgpackSessionCodec) Serialize(src interface{}) ([]byte, error) {
+	return msgpack.Marshal(src)
+}
+
+func (msgpackSessionCodec) Deserialize(src []byte, dst interface{}) error {
+	return msgpack.Unmarshal(src, dst)
+}
+
+// cookieStore is a cookie based session store with a configurable codec.
+type cookieStore struct {
+	*gsessions.CookieStore
+}
+
+// newCookieStore creates a cookie session store encoding values with codec.
+// keyPairs are used the same way as cookie.NewStore.
+func newCookieStore(codec SessionCodec, keyPairs ...[]byte) sessions.Store {
+	store := gsessions.NewCookieStore(keyPairs...)
+	for _, c := range store.Codecs {
+		if secureCookie, ok := c.(*securecookie.SecureCookie); ok {
+			secureCookie.SetSerializer(codec)
+		}
+	}
+
+	return &cookieStore{store}
+}
+
+func (c *cookieStore) Options(options sessions.Options) {
+	c.CookieStore.Options = options.ToGorillaOptions()
+}
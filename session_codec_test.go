@@ -0,0 +1,99 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+)
+
+// sessionValues holds a value of every type the handlers store in sessions:
+// strings, the flash messages of gorilla/sessions and booleans.
+func sessionValues() map[interface{}]interface{} {
+	return map[interface{}]interface{}{
+		"state":            "c3RhdGU",
+		"nonce":            "bm9uY2U",
+		"code_verifier":    "dmVyaWZpZXI",
+		"tid":              "dG9rZW5z",
+		"sid":              "c2Vzc2lvbg",
+		"login_at":         "1700000000",
+		"return_to":        "/profile?tab=security",
+		"profile_complete": "true",
+		"region":           "",
+		"flash_error":      []interface{}{"Something went wrong, please try logging in again."},
+		"remember":         true,
+	}
+}
+
+func TestSessionCodecRoundTrip(t *testing.T) {
+	for _, name := range []string{"json", "gob", "msgpack"} {
+		t.Run(name, func(t *testing.T) {
+			codec, err := NewSessionCodec(name)
+			if err != nil {
+				t.Fatalf("NewSessionCodec(%q): %v", name, err)
+			}
+
+			want := sessionValues()
+			encoded, err := codec.Serialize(want)
+			if err != nil {
+				t.Fatalf("Serialize: %v", err)
+			}
+
+			got := map[interface{}]interface{}{}
+			if err := codec.Deserialize(encoded, &got); err != nil {
+				t.Fatalf("Deserialize: %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("round trip = %#v, want %#v", got, want)
+			}
+		})
+	}
+}
+
+func TestSessionCodecSecureCookie(t *testing.T) {
+	for _, name := range []string{"json", "gob", "msgpack"} {
+		t.Run(name, func(t *testing.T) {
+			codec, err := NewSessionCodec(name)
+			if err != nil {
+				t.Fatalf("NewSessionCodec(%q): %v", name, err)
+			}
+
+			cookie := securecookie.New([]byte("0123456789abcdef0123456789abcdef"), []byte("0123456789abcdef"))
+			cookie.SetSerializer(codec)
+
+			want := sessionValues()
+			encoded, err := cookie.Encode(sessionCookieName, want)
+			if err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+
+			got := map[interface{}]interface{}{}
+			if err := cookie.Decode(sessionCookieName, encoded, &got); err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("round trip = %#v, want %#v", got, want)
+			}
+		})
+	}
+}
+
+func TestSessionCodecDefault(t *testing.T) {
+	codec, err := NewSessionCodec("")
+	if err != nil {
+		t.Fatalf("NewSessionCodec: %v", err)
+	}
+	if _, ok := codec.(jsonSessionCodec); !ok {
+		t.Errorf("default codec = %T, want jsonSessionCodec", codec)
+	}
+
+	if _, err := NewSessionCodec("xml"); err == nil {
+		t.Error("NewSessionCodec(xml) did not fail")
+	}
+}
+
+func TestJSONSessionCodecRejectsNonStringKeys(t *testing.T) {
+	if _, err := (jsonSessionCodec{}).Serialize(map[interface{}]interface{}{1: "one"}); err == nil {
+		t.Error("Serialize did not fail on an int key")
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+)
+
+// sessionKeyPairs returns the key pairs of the session cookies, an
+// authentication key followed by an encryption key, from the base64 keys of
+// SESSION_AUTH_KEYS and SESSION_ENCRYPTION_KEYS. The first pair encodes the
+// cookies and the others still decode them, so keys can be rotated by
+// prepending the new ones.
+func sessionKeyPairs(config SessionConfig) ([][]byte, error) {
+	if len(config.AuthKeys) == 0 || len(config.EncryptionKeys) == 0 {
+		return nil, fmt.Errorf("SESSION_AUTH_KEYS and SESSION_ENCRYPTION_KEYS are required")
+	}
+	authKeys, err := decodeSessionKeys("SESSION_AUTH_KEYS", config.AuthKeys, 32, 64)
+	if err != nil {
+		return nil, err
+	}
+	encryptionKeys, err := decodeSessionKeys("SESSION_ENCRYPTION_KEYS", config.EncryptionKeys, 16, 24, 32)
+	if err != nil {
+		return nil, err
+	}
+	if len(authKeys) != len(encryptionKeys) {
+		return nil, fmt.Errorf("SESSION_AUTH_KEYS has %d keys and SESSION_ENCRYPTION_KEYS %d, expected as many", len(authKeys), len(encryptionKeys))
+	}
+
+	pairs := make([][]byte, 0, 2*len(authKeys))
+	for i := range authKeys {
+		pairs = append(pairs, authKeys[i], encryptionKeys[i])
+	}
+	return pairs, nil
+}
+
+// decodeSessionKeys decodes the base64 keys of the setting name, each of one
+// of the given sizes in bytes.
+func decodeSessionKeys(name string, values []string, sizes ...int) ([][]byte, error) {
+	var keys [][]byte
+	for i, raw := range values {
+		key, err := base64.StdEncoding.DecodeString(raw)
+		if err != nil {
+			key, err = base64.RawURLEncoding.DecodeString(raw)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: key %d is not base64", name, i+1)
+		}
+
+		valid := false
+		for _, size := range sizes {
+			valid = valid || len(key) == size
+		}
+		if !valid {
+			return nil, fmt.Errorf("%s: key %d is %d bytes, expected one of %v", name, i+1, len(key), sizes)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
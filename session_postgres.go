@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// postgresHTTPSessionSchema creates the table of session values when missing.
+const postgresHTTPSessionSchema = `
+CREATE TABLE IF NOT EXISTS http_sessions (
+	id         TEXT PRIMARY KEY,
+	data       BYTEA NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS http_sessions_expires_at ON http_sessions (expires_at)`
+
+// postgresSessionBackend keeps the session values in a Postgres table,
+// shared by the instances. Expired rows are swept at most hourly.
+type postgresSessionBackend struct {
+	db *sql.DB
+
+	mu    sync.Mutex
+	swept time.Time
+}
+
+func newPostgresSessionBackend(dsn string) (*postgresSessionBackend, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("could not open database: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := db.ExecContext(ctx, postgresHTTPSessionSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not create http sessions table: %v", err)
+	}
+
+	return &postgresSessionBackend{db: db}, nil
+}
+
+// Ping checks that the database answers.
+func (b *postgresSessionBackend) Ping(ctx context.Context) error {
+	return b.db.PingContext(ctx)
+}
+
+func (b *postgresSessionBackend) load(ctx context.Context, id string) ([]byte, bool, error) {
+	var values []byte
+	err := b.db.QueryRowContext(ctx, `
+		SELECT data FROM http_sessions WHERE id = $1 AND expires_at > now()`, id).Scan(&values)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return values, true, nil
+}
+
+func (b *postgresSessionBackend) save(ctx context.Context, id string, values []byte, ttl time.Duration) error {
+	b.sweep(ctx)
+
+	_, err := b.db.ExecContext(ctx, `
+		INSERT INTO http_sessions (id, data, expires_at) VALUES ($1, $2, now() + $3 * interval '1 millisecond')
+		ON CONFLICT (id) DO UPDATE SET data = EXCLUDED.data, expires_at = EXCLUDED.expires_at`,
+		id, values, ttl.Milliseconds())
+	return err
+}
+
+func (b *postgresSessionBackend) delete(ctx context.Context, id string) error {
+	_, err := b.db.ExecContext(ctx, `DELETE FROM http_sessions WHERE id = $1`, id)
+	return err
+}
+
+// sweep deletes the expired sessions when they were not swept in the last
+// hour.
+func (b *postgresSessionBackend) sweep(ctx context.Context) {
+	b.mu.Lock()
+	if time.Since(b.swept) < time.Hour {
+		b.mu.Unlock()
+		return
+	}
+	b.swept = time.Now()
+	b.mu.Unlock()
+
+	if _, err := b.db.ExecContext(ctx, `DELETE FROM http_sessions WHERE expires_at <= now()`); err != nil {
+		slog.ErrorContext(ctx, "could not sweep expired sessions", "err", err)
+	}
+}
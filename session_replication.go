@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// sessionEventsStream is the Redis stream session events are replicated
+	// through.
+	sessionEventsStream = "go-auth0:session-events"
+	// sessionEventsMaxLen is about the number of events kept in the stream,
+	// which new instances replay.
+	sessionEventsMaxLen = 100000
+	// sessionEventsBatch is the number of events read at once.
+	sessionEventsBatch = 500
+	// regionCookie names the home region of the session, for load balancers
+	// routing users to it.
+	regionCookie = "region"
+)
+
+// SessionReplicator replicates the session revocations between the
+// instances of every region through a Redis stream, so that a session
+// revoked in one region is refused in the others.
+//
+// Sessions live in cookies, so a login in one region is usable in another
+// as long as every region shares the cookie keys. Revocations are the only
+// session state kept by the server.
+type SessionReplicator struct {
+	redis       *RedisClient
+	region      string
+	instance    string
+	revocations *RevocationList
+
+	mu     sync.Mutex
+	lastID string // the last event applied, "0" to replay the stream
+}
+
+// NewSessionReplicator creates a replicator for the instance of region,
+// publishing the revocations of revocations and applying the ones of the
+// other instances to them.
+func NewSessionReplicator(redis *RedisClient, region string, revocations *RevocationList) (*SessionReplicator, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("could not generate instance id: %v", err)
+	}
+
+	r := &SessionReplicator{
+		redis:       redis,
+		region:      region,
+		instance:    hex.EncodeToString(b),
+		revocations: revocations,
+		lastID:      "0",
+	}
+	revocations.OnRevoke(r.publish)
+	return r, nil
+}
+
+// publish appends the revocation of the sessions of sub created until at to
+// the stream.
+func (r *SessionReplicator) publish(sub string, at time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := r.redis.Do(ctx, "XADD", sessionEventsStream, "MAXLEN", "~", strconv.Itoa(sessionEventsMaxLen), "*",
+		"type", "revoke", "sub", sub, "at", strconv.FormatInt(at.UnixNano(), 10),
+		"region", r.region, "instance", r.instance)
+	if err != nil {
+		slog.Error("could not replicate session revocation", "sub", sub, "err", err)
+	}
+}
+
+// Run applies the events published by the other instances since the last
+// run. It is run by the scheduler.
+func (r *SessionReplicator) Run(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for {
+		reply, err := r.redis.Do(ctx, "XREAD", "COUNT", strconv.Itoa(sessionEventsBatch), "STREAMS", sessionEventsStream, r.lastID)
+		if err != nil {
+			return fmt.Errorf("could not read session events: %v", err)
+		}
+
+		entries := streamEntries(reply)
+		for _, entry := range entries {
+			r.apply(entry.fields)
+			r.lastID = entry.id
+		}
+		if len(entries) < sessionEventsBatch {
+			return nil
+		}
+	}
+}
+
+// apply applies an event published by another instance.
+func (r *SessionReplicator) apply(fields map[string]string) {
+	if fields["instance"] == r.instance || fields["type"] != "revoke" || fields["sub"] == "" {
+		return
+	}
+
+	nanos, err := strconv.ParseInt(fields["at"], 10, 64)
+	if err != nil {
+		slog.Warn("invalid session event", "region", fields["region"], "err", err)
+		return
+	}
+	r.revocations.RevokeUserAt(fields["sub"], time.Unix(0, nanos))
+}
+
+// streamEntry is an entry of a Redis stream.
+type streamEntry struct {
+	id     string
+	fields map[string]string
+}
+
+// streamEntries returns the entries of the XREAD reply for a single stream,
+// [[stream, [[id, [field, value, ...]], ...]]], or nil when there are none.
+func streamEntries(reply interface{}) []streamEntry {
+	streams, _ := reply.([]interface{})
+	if len(streams) == 0 {
+		return nil
+	}
+	stream, _ := streams[0].([]interface{})
+	if len(stream) != 2 {
+		return nil
+	}
+	items, _ := stream[1].([]interface{})
+
+	entries := make([]streamEntry, 0, len(items))
+	for _, item := range items {
+		pair, _ := item.([]interface{})
+		if len(pair) != 2 {
+			continue
+		}
+		id, _ := pair[0].(string)
+		values, _ := pair[1].([]interface{})
+
+		entry := streamEntry{id: id, fields: make(map[string]string, len(values)/2)}
+		for i := 0; i+1 < len(values); i += 2 {
+			name, _ := values[i].(string)
+			value, _ := values[i+1].(string)
+			entry.fields[name] = value
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// SessionRegion reports the region serving the request in the X-Region
+// header and, for sessions created in another region, their home region in
+// X-Session-Region. Load balancers may route users to their home region
+// with the region cookie set at login.
+func SessionRegion(region string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Header("X-Region", region)
+		if session := defaultSession(ctx); session != nil {
+			if home, _ := session.Get("region").(string); home != "" && home != region {
+				ctx.Header("X-Session-Region", home)
+			}
+		}
+		ctx.Next()
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boj/redistore"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/redis"
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/gorilla/securecookie"
+	gsessions "github.com/gorilla/sessions"
+)
+
+// defaultSessionMaxAge is how long sessions are kept, as for cookie sessions.
+const defaultSessionMaxAge = 86400 * 30
+
+// NewSessionStore returns the session store named by backend: "cookie"
+// (the default) keeps the values in the session cookie, while "redis",
+// "postgres" and "memstore" keep them server-side, the cookie holding only
+// the session ID. Redis uses the server of redisURL and Postgres the
+// database of dsn. keyPairs sign and encrypt the cookie.
+func NewSessionStore(backend string, codec SessionCodec, redisURL, dsn string, keyPairs ...[]byte) (sessions.Store, error) {
+	switch backend {
+	case "", "cookie":
+		return newCookieStore(codec, keyPairs...), nil
+	case "redis":
+		if redisURL == "" {
+			return nil, errors.New("the redis session backend needs REDIS_URL")
+		}
+		return newRedisSessionStore(redisURL, codec, keyPairs...)
+	case "postgres":
+		if dsn == "" {
+			return nil, errors.New("the postgres session backend needs DATABASE_URL")
+		}
+		sessionBackend, err := newPostgresSessionBackend(dsn)
+		if err != nil {
+			return nil, err
+		}
+		return newServerSessionStore(sessionBackend, codec, keyPairs...), nil
+	case "memstore":
+		return newServerSessionStore(&memorySessionBackend{values: make(map[string]memorySession)}, codec, keyPairs...), nil
+	default:
+		return nil, fmt.Errorf("unknown session backend %q", backend)
+	}
+}
+
+// sessionBackend keeps the encoded values of server-side sessions.
+type sessionBackend interface {
+	// load returns the values of session id, or false when it has none.
+	load(ctx context.Context, id string) ([]byte, bool, error)
+	// save stores the values of session id for ttl.
+	save(ctx context.Context, id string, values []byte, ttl time.Duration) error
+	// delete removes the values of session id.
+	delete(ctx context.Context, id string) error
+}
+
+// serverSessionStore keeps the session values in a backend, the cookie
+// holding only the signed session ID.
+type serverSessionStore struct {
+	backend sessionBackend
+	codec   SessionCodec
+	codecs  []securecookie.Codec
+	options *gsessions.Options
+}
+
+func newServerSessionStore(backend sessionBackend, codec SessionCodec, keyPairs ...[]byte) sessions.Store {
+	return &serverSessionStore{
+		backend: backend,
+		codec:   codec,
+		codecs:  securecookie.CodecsFromPairs(keyPairs...),
+		options: &gsessions.Options{Path: "/", MaxAge: defaultSessionMaxAge},
+	}
+}
+
+// Ping checks that the backend answers, when it is a remote one.
+func (s *serverSessionStore) Ping(ctx context.Context) error {
+	if backend, ok := s.backend.(interface{ Ping(context.Context) error }); ok {
+		return backend.Ping(ctx)
+	}
+	return nil
+}
+
+func (s *serverSessionStore) Options(options sessions.Options) {
+	s.options = options.ToGorillaOptions()
+}
+
+func (s *serverSessionStore) Get(r *http.Request, name string) (*gsessions.Session, error) {
+	return gsessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns the session of the request, or a new session when its cookie
+// is missing, invalid or references expired values.
+func (s *serverSessionStore) New(r *http.Request, name string) (*gsessions.Session, error) {
+	session := gsessions.NewSession(s, name)
+	options := *s.options
+	session.Options = &options
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	if err := securecookie.DecodeMulti(name, cookie.Value, &session.ID, s.codecs...); err != nil {
+		// a new session replaces the cookies encoded with retired keys
+		session.ID = ""
+		return session, nil
+	}
+
+	values, ok, err := s.backend.load(r.Context(), session.ID)
+	if err != nil {
+		return session, fmt.Errorf("could not load session: %v", err)
+	}
+	if ok {
+		if err := s.codec.Deserialize(values, &session.Values); err != nil {
+			return session, fmt.Errorf("could not decode session: %v", err)
+		}
+		session.IsNew = false
+	}
+	return session, nil
+}
+
+// Save writes the session values to the backend and the session ID cookie,
+// or deletes both when the session has a negative MaxAge.
+func (s *serverSessionStore) Save(r *http.Request, w http.ResponseWriter, session *gsessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := s.backend.delete(r.Context(), session.ID); err != nil {
+				return fmt.Errorf("could not delete session: %v", err)
+			}
+		}
+		http.SetCookie(w, gsessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		session.ID = strings.TrimRight(base32.StdEncoding.EncodeToString(b), "=")
+	}
+
+	values, err := s.codec.Serialize(session.Values)
+	if err != nil {
+		return fmt.Errorf("could not encode session: %v", err)
+	}
+	maxAge := session.Options.MaxAge
+	if maxAge == 0 {
+		maxAge = defaultSessionMaxAge
+	}
+	if err := s.backend.save(r.Context(), session.ID, values, time.Duration(maxAge)*time.Second); err != nil {
+		return fmt.Errorf("could not save session: %v", err)
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, gsessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// regenerateSessionID deletes the values of the server-side session of
+// session under its current ID and clears the ID, so that the next Save
+// stores them under a new one and rewrites the cookie. A session ID planted
+// in the browser before the login is then not the one of the signed in
+// user. Cookie sessions have no ID to regenerate.
+func regenerateSessionID(ctx context.Context, session sessions.Session) error {
+	wrapped, ok := session.(interface{ Session() *gsessions.Session })
+	if !ok {
+		return nil
+	}
+	gs := wrapped.Session()
+	if gs.ID == "" {
+		return nil
+	}
+
+	switch store := gs.Store().(type) {
+	case *serverSessionStore:
+		if err := store.backend.delete(ctx, gs.ID); err != nil {
+			return fmt.Errorf("could not delete session: %v", err)
+		}
+	case *redistore.RediStore:
+		conn, err := store.Pool.GetContext(ctx)
+		if err != nil {
+			return fmt.Errorf("could not delete session: %v", err)
+		}
+		defer conn.Close()
+		if _, err := conn.Do("DEL", redisSessionKeyPrefix+gs.ID); err != nil {
+			return fmt.Errorf("could not delete session: %v", err)
+		}
+	default:
+		return nil
+	}
+	gs.ID = ""
+	return nil
+}
+
+// redisSessionKeyPrefix prefixes the keys of the sessions in Redis.
+const redisSessionKeyPrefix = "session:"
+
+// redisSessionStore is the store of gin-contrib/sessions/redis, keeping the
+// session values in Redis, shared by the instances.
+type redisSessionStore struct {
+	redis.Store
+	pool *redigo.Pool
+}
+
+// newRedisSessionStore returns a store keeping the sessions in the Redis of
+// redisURL, encoded with codec.
+func newRedisSessionStore(redisURL string, codec SessionCodec, keyPairs ...[]byte) (sessions.Store, error) {
+	pool, err := newRedisPool(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	store, err := redis.NewStoreWithPool(pool, keyPairs...)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to Redis: %v", err)
+	}
+	_, rediStore := redis.GetRedisStore(store)
+	rediStore.SetKeyPrefix(redisSessionKeyPrefix)
+	rediStore.SetSerializer(redisSessionSerializer{codec})
+	// the values are not limited in size, as in the other backends
+	rediStore.SetMaxLength(0)
+	rediStore.DefaultMaxAge = defaultSessionMaxAge
+	return &redisSessionStore{Store: store, pool: pool}, nil
+}
+
+// Ping checks that Redis answers.
+func (s *redisSessionStore) Ping(ctx context.Context) error {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Do("PING")
+	return err
+}
+
+// newRedisPool returns a pool of connections to the Redis of
+// redis://[user:password@]host:port/db, rediss:// connecting with TLS. The
+// connections idle for a while are checked before they are used, as the
+// server or a proxy in front of it may have closed them.
+func newRedisPool(redisURL string) (*redigo.Pool, error) {
+	u, err := url.Parse(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse Redis URL: %v", err)
+	}
+	// redigo only authenticates with a password, the ACL user is sent with
+	// AUTH once connected
+	var username, password string
+	if u.User != nil && u.User.Username() != "" {
+		username = u.User.Username()
+		password, _ = u.User.Password()
+		u.User = nil
+	}
+	timeout := 5 * time.Second
+
+	return &redigo.Pool{
+		MaxIdle:     redisMaxIdleConns,
+		IdleTimeout: 5 * time.Minute,
+		Dial: func() (redigo.Conn, error) {
+			conn, err := redigo.DialURL(u.String(), redigo.DialConnectTimeout(timeout), redigo.DialReadTimeout(timeout), redigo.DialWriteTimeout(timeout))
+			if err != nil {
+				return nil, err
+			}
+			if username != "" {
+				if _, err := conn.Do("AUTH", username, password); err != nil {
+					conn.Close()
+					return nil, err
+				}
+			}
+			return conn, nil
+		},
+		TestOnBorrow: func(conn redigo.Conn, idleSince time.Time) error {
+			if time.Since(idleSince) < time.Minute {
+				return nil
+			}
+			_, err := conn.Do("PING")
+			return err
+		},
+	}, nil
+}
+
+// redisSessionSerializer encodes the session values with the codec of
+// SESSION_CODEC.
+type redisSessionSerializer struct {
+	codec SessionCodec
+}
+
+func (s redisSessionSerializer) Serialize(session *gsessions.Session) ([]byte, error) {
+	return s.codec.Serialize(session.Values)
+}
+
+func (s redisSessionSerializer) Deserialize(values []byte, session *gsessions.Session) error {
+	return s.codec.Deserialize(values, &session.Values)
+}
+
+// memorySession are session values kept in memory until expires.
+type memorySession struct {
+	values  []byte
+	expires time.Time
+}
+
+// memorySessionBackend keeps the session values in memory, per instance.
+// Sessions are lost on restart.
+type memorySessionBackend struct {
+	mu     sync.Mutex
+	values map[string]memorySession
+	swept  time.Time
+}
+
+func (b *memorySessionBackend) load(ctx context.Context, id string) ([]byte, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	session, ok := b.values[id]
+	if !ok || time.Now().After(session.expires) {
+		return nil, false, nil
+	}
+	return session.values, true, nil
+}
+
+func (b *memorySessionBackend) save(ctx context.Context, id string, values []byte, ttl time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(b.swept) > time.Hour {
+		for k, session := range b.values {
+			if now.After(session.expires) {
+				delete(b.values, k)
+			}
+		}
+		b.swept = now
+	}
+
+	b.values[id] = memorySession{values: values, expires: now.Add(ttl)}
+	return nil
+}
+
+func (b *memorySessionBackend) delete(ctx context.Context, id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.values, id)
+	return nil
+}
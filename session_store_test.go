@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/securecookie"
+)
+
+// sessionID decodes the session ID of a server-side session cookie.
+func sessionID(t *testing.T, cookie *http.Cookie) string {
+	t.Helper()
+
+	var id string
+	codecs := securecookie.CodecsFromPairs([]byte("0123456789abcdef0123456789abcdef"), []byte("0123456789abcdef"))
+	if err := securecookie.DecodeMulti(sessionCookieName, cookie.Value, &id, codecs...); err != nil {
+		t.Fatalf("could not decode session cookie: %v", err)
+	}
+	return id
+}
+
+func TestCallbackRegeneratesSessionID(t *testing.T) {
+	for _, backend := range []string{"memstore", "redis"} {
+		t.Run(backend, func(t *testing.T) {
+			testCallbackRegeneratesSessionID(t, backend)
+		})
+	}
+}
+
+func testCallbackRegeneratesSessionID(t *testing.T, backend string) {
+	provider := newTestProvider(t)
+	server := newTestServer(t, testConfig(provider), backend)
+	startTestLogin(server)
+	server.router.GET("/callback", server.callbackHandler)
+	server.router.GET("/test/whoami", func(ctx *gin.Context) {
+		if u, ok := currentUser(ctx); ok {
+			ctx.String(http.StatusOK, u.Sub)
+		}
+	})
+
+	// the session cookie the browser came with, such as one planted by an
+	// attacker before the login
+	w := serve(server, "/test/login")
+	planted := responseCookie(w, sessionCookieName)
+	if planted == nil {
+		t.Fatalf("login set no session cookie")
+	}
+	plantedID := sessionID(t, planted)
+
+	w = serve(server, callbackURL(), planted)
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("callback = %d %s, want a redirect", w.Code, w.Body)
+	}
+	signedIn := responseCookie(w, sessionCookieName)
+	if signedIn == nil {
+		t.Fatalf("callback did not rewrite the session cookie")
+	}
+	if id := sessionID(t, signedIn); id == plantedID {
+		t.Errorf("session ID %q was kept across the callback", id)
+	}
+
+	// the planted cookie no longer references a session
+	w = serve(server, "/test/whoami", planted)
+	if w.Body.String() != "" {
+		t.Errorf("planted session = %q, want none", w.Body)
+	}
+	w = serve(server, "/test/whoami", signedIn)
+	if w.Body.String() != "auth0|test" {
+		t.Errorf("signed in session = %q, want auth0|test", w.Body)
+	}
+}
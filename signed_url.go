@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxSignedURLLifetime is the longest time a signed URL can be valid for.
+const maxSignedURLLifetime = 7 * 24 * time.Hour
+
+var (
+	// ErrSignedURLInvalid is returned for URLs without a valid signature.
+	ErrSignedURLInvalid = errors.New("the URL signature is invalid")
+	// ErrSignedURLExpired is returned for URLs used after they expired.
+	ErrSignedURLExpired = errors.New("the URL has expired")
+)
+
+// URLSigner signs URLs of protected resources with an HMAC-SHA256 of their
+// path, query and expiry, so that they can be handed to the browser or to
+// third parties without sharing a session or a token.
+//
+// URLs may also be bound to a user, in which case they are only accepted
+// from a session of that user. The user is part of the signed data but is
+// not written in the URL.
+type URLSigner struct {
+	key []byte
+}
+
+// NewURLSigner creates a signer keyed with secret. A random key is used when
+// secret is empty, so that URLs are only valid on this instance until it
+// restarts.
+func NewURLSigner(secret string) (*URLSigner, error) {
+	if secret != "" {
+		return &URLSigner{key: []byte(secret)}, nil
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("could not generate URL signing key: %v", err)
+	}
+	return &URLSigner{key: key}, nil
+}
+
+// signature returns the signature of path and query, bound to sub when set.
+// The query must not hold the signature itself.
+func (u *URLSigner) signature(path string, query url.Values, sub string) string {
+	mac := hmac.New(sha256.New, u.key)
+	mac.Write([]byte(path + "\n" + query.Encode() + "\n" + sub))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Sign returns rawURL, a path with an optional query, signed to be valid
+// until lifetime elapses and, when sub is set, only for the user sub.
+func (u *URLSigner) Sign(rawURL string, lifetime time.Duration, sub string) (string, time.Time, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.IsAbs() || parsed.Path == "" || parsed.Path[0] != '/' {
+		return "", time.Time{}, fmt.Errorf("%q is not an absolute path", rawURL)
+	}
+	if lifetime <= 0 || lifetime > maxSignedURLLifetime {
+		return "", time.Time{}, fmt.Errorf("the lifetime must be positive and at most %s", maxSignedURLLifetime)
+	}
+
+	expiresAt := time.Now().Add(lifetime).Truncate(time.Second)
+	query := parsed.Query()
+	query.Del("signature")
+	query.Set("expires", strconv.FormatInt(expiresAt.Unix(), 10))
+	query.Del("bound")
+	if sub != "" {
+		query.Set("bound", "1")
+	}
+	query.Set("signature", u.signature(parsed.Path, query, sub))
+
+	parsed.RawQuery = query.Encode()
+	parsed.Fragment = ""
+	return parsed.String(), expiresAt, nil
+}
+
+// Verify checks the signature and expiry of the URL of r. bound reports
+// whether the URL is bound to a user, sub being the signed in user it is
+// checked against.
+func (u *URLSigner) Verify(r *http.Request, sub string, now time.Time) (bound bool, err error) {
+	query := r.URL.Query()
+	signature := query.Get("signature")
+	query.Del("signature")
+	bound = query.Get("bound") == "1"
+	if !bound {
+		sub = ""
+	}
+
+	expected := u.signature(r.URL.Path, query, sub)
+	if signature == "" || !hmac.Equal([]byte(signature), []byte(expected)) {
+		return bound, ErrSignedURLInvalid
+	}
+
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		return bound, ErrSignedURLInvalid
+	}
+	if !now.Before(time.Unix(expires, 0)) {
+		return bound, ErrSignedURLExpired
+	}
+	return bound, nil
+}
+
+// RequireSignedURL only lets through requests for URLs signed by the server
+// signer. URLs bound to a user need a session of that user.
+func (s *Server) RequireSignedURL() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var sub string
+		if u, ok := currentUser(ctx); ok {
+			sub = u.Sub
+		}
+
+		bound, err := s.urlSigner.Verify(ctx.Request, sub, time.Now())
+		switch {
+		case errors.Is(err, ErrSignedURLExpired):
+			abortWithError(ctx, http.StatusGone, "expired_url", "the link has expired, please ask for a new one")
+			return
+		case err != nil && bound && sub == "":
+			abortWithError(ctx, http.StatusUnauthorized, "login_required", "the link is only valid for the user it was shared with, please log in")
+			return
+		case err != nil:
+			slog.WarnContext(ctx, "signed URL rejected", "err", err)
+			abortWithError(ctx, http.StatusForbidden, "invalid_signature", "the link is invalid")
+			return
+		}
+
+		// signed responses must not be served to others from shared caches
+		ctx.Header("Cache-Control", "private, no-store")
+		if bound {
+			ctx.Header("Referrer-Policy", "no-referrer")
+		}
+		ctx.Next()
+	}
+}
+
+// signedURLRequest is the body of the admin request signing a URL.
+type signedURLRequest struct {
+	Path      string `json:"path"`
+	ExpiresIn int    `json:"expires_in"` // in seconds
+	Sub       string `json:"sub"`        // the user the URL is bound to, if any
+}
+
+// signURLHandler signs the URL of a protected resource, such as a file
+// served under /files, for the admin API.
+func (s *Server) signURLHandler(ctx *gin.Context) {
+	var req signedURLRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "invalid_request", "could not parse request body")
+		return
+	}
+
+	signed, expiresAt, err := s.urlSigner.Sign(req.Path, time.Duration(req.ExpiresIn)*time.Second, req.Sub)
+	if err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"url": signed, "expires_at": expiresAt.UTC()})
+}
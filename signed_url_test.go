@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestURLSignerSign(t *testing.T) {
+	signer, err := NewURLSigner("url-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name     string
+		rawURL   string
+		lifetime time.Duration
+		wantErr  bool
+	}{
+		{"path", "/files/report.pdf", time.Hour, false},
+		{"path with query", "/files/report.pdf?page=2", time.Hour, false},
+		{"longest lifetime", "/files/report.pdf", maxSignedURLLifetime, false},
+		{"absolute URL", "https://example.com/files/report.pdf", time.Hour, true},
+		{"relative path", "files/report.pdf", time.Hour, true},
+		{"no path", "?page=2", time.Hour, true},
+		{"no lifetime", "/files/report.pdf", 0, true},
+		{"negative lifetime", "/files/report.pdf", -time.Hour, true},
+		{"lifetime too long", "/files/report.pdf", maxSignedURLLifetime + time.Second, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			signed, _, err := signer.Sign(tt.rawURL, tt.lifetime, "")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Sign = %q, %v, want error %t", signed, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestURLSignerVerify(t *testing.T) {
+	signer, err := NewURLSigner("url-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewURLSigner("other-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	sign := func(signer *URLSigner, sub string) string {
+		signed, _, err := signer.Sign("/files/report.pdf?page=2", time.Hour, sub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	// tamper changes the query of the signed URL with change.
+	tamper := func(signed string, change func(url.Values)) string {
+		u, _ := url.Parse(signed)
+		query := u.Query()
+		change(query)
+		u.RawQuery = query.Encode()
+		return u.String()
+	}
+
+	for _, tt := range []struct {
+		name      string
+		url       string
+		sub       string
+		now       time.Time
+		wantBound bool
+		wantErr   error
+	}{
+		{"signed", sign(signer, ""), "", now, false, nil},
+		{"signed for the user", sign(signer, "auth0|alice"), "auth0|alice", now, true, nil},
+		{"any user", sign(signer, ""), "auth0|bob", now, false, nil},
+		{"other user", sign(signer, "auth0|alice"), "auth0|bob", now, true, ErrSignedURLInvalid},
+		{"bound but signed out", sign(signer, "auth0|alice"), "", now, true, ErrSignedURLInvalid},
+		{"expired", sign(signer, ""), "", now.Add(time.Hour), false, ErrSignedURLExpired},
+		{"other key", sign(other, ""), "", now, false, ErrSignedURLInvalid},
+		{"no signature", "/files/report.pdf?page=2", "", now, false, ErrSignedURLInvalid},
+		{"other path", "/files/other.pdf?" + mustQuery(t, sign(signer, "")), "", now, false, ErrSignedURLInvalid},
+		{"query changed", tamper(sign(signer, ""), func(q url.Values) { q.Set("page", "3") }), "", now, false, ErrSignedURLInvalid},
+		{"expiry extended", tamper(sign(signer, ""), func(q url.Values) { q.Set("expires", "99999999999") }), "", now, false, ErrSignedURLInvalid},
+		{"binding removed", tamper(sign(signer, "auth0|alice"), func(q url.Values) { q.Del("bound") }), "auth0|bob", now, false, ErrSignedURLInvalid},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			bound, err := signer.Verify(r, tt.sub, tt.now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Verify = %v, want %v", err, tt.wantErr)
+			}
+			if bound != tt.wantBound {
+				t.Errorf("bound = %t, want %t", bound, tt.wantBound)
+			}
+		})
+	}
+}
+
+// mustQuery returns the raw query of rawURL.
+func mustQuery(t *testing.T, rawURL string) string {
+	t.Helper()
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.RawQuery
+}
+
+func TestRequireSignedURL(t *testing.T) {
+	signer, err := NewURLSigner("url-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{urlSigner: signer}
+	sign := func(sub string) string {
+		signed, _, err := signer.Sign("/files/report.pdf", time.Hour, sub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	expired := expiredSignedURL(t, signer)
+
+	for _, tt := range []struct {
+		name string
+		url  string
+		sub  string // the signed in user
+		want int
+	}{
+		{"signed", sign(""), "", http.StatusOK},
+		{"signed for the user", sign("auth0|alice"), "auth0|alice", http.StatusOK},
+		{"bound but signed out", sign("auth0|alice"), "", http.StatusUnauthorized},
+		{"other user", sign("auth0|alice"), "auth0|bob", http.StatusForbidden},
+		{"no signature", "/files/report.pdf", "", http.StatusForbidden},
+		{"expired", expired, "", http.StatusGone},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.GET("/files/report.pdf", func(ctx *gin.Context) {
+				if tt.sub != "" {
+					ctx.Set("user", &UserInfo{Sub: tt.sub})
+				}
+			}, server.RequireSignedURL(), func(ctx *gin.Context) {
+				ctx.Status(http.StatusOK)
+			})
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			if w.Code != tt.want {
+				t.Fatalf("status = %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+			if tt.want == http.StatusOK && w.Header().Get("Cache-Control") != "private, no-store" {
+				t.Errorf("Cache-Control = %q, want the response kept out of shared caches", w.Header().Get("Cache-Control"))
+			}
+		})
+	}
+}
+
+// expiredSignedURL returns a URL signed by signer that expired a minute ago.
+func expiredSignedURL(t *testing.T, signer *URLSigner) string {
+	t.Helper()
+
+	query := url.Values{"expires": {strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)}}
+	query.Set("signature", signer.signature("/files/report.pdf", query, ""))
+	return "/files/report.pdf?" + query.Encode()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+// sessionCookieName is the name of the session cookie of the default site.
+const sessionCookieName = "auth-sessions"
+
+// site is an auth0 application served on its own host names, besides the
+// default one of AUTH0_DOMAIN. Its logins, callbacks and sessions only use
+// its tenant, and its session cookie has a name of its own.
+type site struct {
+	name        string
+	hosts       []string // lower case, without port
+	tenant      *tenant
+	callbackURL *url.URL
+	cookieName  string
+}
+
+// loadSites creates the sites of configs, their tenants authenticating with
+// their client secret and the other settings of base.
+func loadSites(ctx context.Context, base *oauth2.Config, configs []SiteConfig) ([]*site, error) {
+	var sites []*site
+	for _, c := range configs {
+		t, err := newAuth0Tenant(ctx, base, TenantConfig{
+			Domain:       c.Domain,
+			Issuer:       c.Issuer,
+			ClientID:     c.ClientID,
+			ClientSecret: c.ClientSecret,
+			Organization: c.Organization,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not create provider of site %s: %v", c.Name, err)
+		}
+		callbackURL, err := url.Parse(c.CallbackURL)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse callback URL of site %s: %v", c.Name, err)
+		}
+
+		sites = append(sites, &site{
+			name:        c.Name,
+			hosts:       hostNames(c.Hosts),
+			tenant:      t,
+			callbackURL: callbackURL,
+			cookieName:  sessionCookieName + "-" + c.Name,
+		})
+	}
+	return sites, nil
+}
+
+// hostNames returns hosts in lower case.
+func hostNames(hosts []string) []string {
+	var names []string
+	for _, host := range hosts {
+		names = append(names, strings.ToLower(strings.TrimSpace(host)))
+	}
+	return names
+}
+
+// siteOf returns the site serving host, which may have a port, or nil for
+// the default site.
+func (s *Server) siteOf(host string) *site {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	host = strings.ToLower(host)
+	for _, site := range s.sites {
+		if containsString(site.hosts, host) {
+			return site
+		}
+	}
+	return nil
+}
+
+// site returns the site of the request set by Sessions, or nil for the
+// default site.
+func (s *Server) site(ctx *gin.Context) *site {
+	value, _ := ctx.Get("site")
+	site, _ := value.(*site)
+	return site
+}
+
+// Sessions loads the session of the request from store, in the session
+// cookie of the site of its host, so that the sites sharing a domain do not
+// share sessions. It records the site under "site".
+func (s *Server) Sessions(store sessions.Store) gin.HandlerFunc {
+	defaultSessions := sessions.Sessions(sessionCookieName, store)
+	siteSessions := make(map[*site]gin.HandlerFunc, len(s.sites))
+	for _, site := range s.sites {
+		siteSessions[site] = sessions.Sessions(site.cookieName, store)
+	}
+
+	return func(ctx *gin.Context) {
+		site := s.siteOf(ctx.Request.Host)
+		if site == nil {
+			defaultSessions(ctx)
+			return
+		}
+		ctx.Set("site", site)
+		siteSessions[site](ctx)
+	}
+}
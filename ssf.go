@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/coreos/go-oidc"
+	"github.com/gin-gonic/gin"
+)
+
+// Security event types handled by the receiver.
+const (
+	riscAccountDisabled      = "https://schemas.openid.net/secevent/risc/event-type/account-disabled"
+	riscCredentialCompromise = "https://schemas.openid.net/secevent/risc/event-type/credential-compromise"
+	riscSessionsRevoked      = "https://schemas.openid.net/secevent/risc/event-type/sessions-revoked"
+	caepSessionRevoked       = "https://schemas.openid.net/secevent/caep/event-type/session-revoked"
+	caepCredentialChange     = "https://schemas.openid.net/secevent/caep/event-type/credential-change"
+)
+
+// securityEventSubject identifies the user a security event is about.
+type securityEventSubject struct {
+	Format string `json:"format"`
+	Iss    string `json:"iss"`
+	Sub    string `json:"sub"`
+	Email  string `json:"email"`
+}
+
+// securityEventToken holds the claims of a Security Event Token (RFC 8417).
+type securityEventToken struct {
+	JTI    string                     `json:"jti"`
+	SubID  *securityEventSubject      `json:"sub_id"`
+	Events map[string]json.RawMessage `json:"events"`
+
+	issuedAt time.Time
+}
+
+// SecurityEventReceiver validates Security Event Tokens pushed by a
+// transmitter (RFC 8935), such as auth0, and revokes the sessions of the
+// users they are about.
+type SecurityEventReceiver struct {
+	verifier *oidc.IDTokenVerifier
+	audience string
+	leeway   time.Duration
+}
+
+// NewSecurityEventReceiver creates a receiver accepting tokens issued by
+// issuer for audience, signed with a key of the JWKS at jwksURL, fetched
+// with the client of ctx.
+func NewSecurityEventReceiver(ctx context.Context, issuer, audience, jwksURL string, leeway time.Duration) *SecurityEventReceiver {
+	keySet := oidc.NewRemoteKeySet(ctx, jwksURL)
+
+	return &SecurityEventReceiver{
+		// security event tokens have no exp and are not issued to the client
+		verifier: oidc.NewVerifier(issuer, keySet, &oidc.Config{SkipClientIDCheck: true, SkipExpiryCheck: true}),
+		audience: audience,
+		leeway:   leeway,
+	}
+}
+
+// verify checks the signature, issuer, audience and issue time of the token.
+func (r *SecurityEventReceiver) verify(ctx context.Context, raw string) (*securityEventToken, error) {
+	idToken, err := r.verifier.Verify(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	audienceOK := false
+	for _, aud := range idToken.Audience {
+		audienceOK = audienceOK || aud == r.audience
+	}
+	if !audienceOK {
+		return nil, fmt.Errorf("unexpected audience %q", idToken.Audience)
+	}
+
+	var times timeClaims
+	if err := idToken.Claims(&times); err != nil {
+		return nil, fmt.Errorf("could not parse token claims: %v", err)
+	}
+	if times.IssuedAt == nil {
+		return nil, fmt.Errorf("token has no iat claim")
+	}
+	if err := times.validate(time.Now(), r.leeway); err != nil {
+		return nil, err
+	}
+
+	var token securityEventToken
+	if err := idToken.Claims(&token); err != nil {
+		return nil, fmt.Errorf("could not parse token claims: %v", err)
+	}
+	if len(token.Events) == 0 {
+		return nil, fmt.Errorf("token has no events claim")
+	}
+
+	token.issuedAt = times.IssuedAt.Time
+	return &token, nil
+}
+
+// Verify implements WebhookVerifier, the deliveries being identified by the
+// jti of the token and sent at its iat.
+func (r *SecurityEventReceiver) Verify(ctx context.Context, req *http.Request, body []byte) (*WebhookDelivery, error) {
+	token, err := r.verify(ctx, string(body))
+	if err != nil {
+		return nil, err
+	}
+
+	id := token.JTI
+	if id == "" {
+		sum := sha256.Sum256(body)
+		id = hex.EncodeToString(sum[:])
+	}
+	return &WebhookDelivery{ID: id, SentAt: token.issuedAt, Payload: token}, nil
+}
+
+// securityEventError responds with the error format of RFC 8935.
+func securityEventError(ctx *gin.Context, code, description string) {
+	ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+		"err":         code,
+		"description": description,
+	})
+}
+
+// rejectSecurityEvent answers a rejected security event. A replayed event
+// is acknowledged again, the transmitter having missed the first response.
+func rejectSecurityEvent(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errWebhookReplay):
+		ctx.AbortWithStatus(http.StatusAccepted)
+	case errors.Is(err, errWebhookTimestamp):
+		securityEventError(ctx, "invalid_request", "the security event token is too old")
+	default:
+		securityEventError(ctx, "authentication_failed", "the security event token is invalid")
+	}
+}
+
+// securityEventsHandler receives Security Event Tokens and revokes the
+// sessions of the users which are disabled, compromised or whose sessions
+// were revoked upstream.
+func (s *Server) securityEventsHandler(ctx *gin.Context) {
+	token := webhookDelivery(ctx).Payload.(*securityEventToken)
+
+	for eventType, raw := range token.Events {
+		var event struct {
+			Subject *securityEventSubject `json:"subject"`
+		}
+		if err := json.Unmarshal(raw, &event); err != nil {
+			securityEventError(ctx, "invalid_request", "could not parse event "+eventType)
+			return
+		}
+
+		subject := event.Subject
+		if subject == nil {
+			subject = token.SubID
+		}
+		if subject == nil || subject.Sub == "" {
+			// only subjects identified by sub can be matched with sessions
+			slog.WarnContext(ctx, "security event ignored: unsupported subject", "event", eventType)
+			continue
+		}
+
+		switch eventType {
+		case riscAccountDisabled:
+			s.revocations.RevokeUser(subject.Sub)
+			if err := s.users.SetUserBlocked(ctx, subject.Sub, true); err != nil {
+				slog.ErrorContext(ctx, "could not block user", "err", err)
+			}
+		case riscCredentialCompromise, riscSessionsRevoked, caepSessionRevoked, caepCredentialChange:
+			s.revocations.RevokeUser(subject.Sub)
+		default:
+			continue
+		}
+
+		s.audit(ctx, auditSessionRevoked, subject.Sub, map[string]string{
+			"event": eventType,
+			"jti":   token.JTI,
+		})
+	}
+
+	ctx.Status(http.StatusAccepted)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+)
+
+// webFS holds the templates and static files shipped with the binary.
+//
+//go:embed web/template web/static
+var webFS embed.FS
+
+// staticFS returns the embedded static files.
+func staticFS() http.FileSystem {
+	static, err := fs.Sub(webFS, "web/static")
+	if err != nil {
+		panic(err)
+	}
+	return http.FS(static)
+}
+
+// pageTemplate is a page parsed along with the layout templates.
+type pageTemplate struct {
+	template *template.Template
+	entry    string // name of the template to execute
+}
+
+// templateRenderer renders pages, each one having its own template set so
+// that every page can define the blocks of the base layout.
+type templateRenderer struct {
+	pages map[string]pageTemplate
+}
+
+// loadTemplates parses the embedded templates. Files found in dir, when set,
+// replace the embedded file with the same name, so that deployers can
+// override any page or layout file (e.g. dir/home.html or
+// dir/layout/header.html) without recompiling.
+//
+// Pages defining a "content" block are rendered inside layout/base.html,
+// other pages are rendered as is.
+func loadTemplates(dir string) (*templateRenderer, error) {
+	embedded, err := fs.Sub(webFS, "web/template")
+	if err != nil {
+		return nil, err
+	}
+
+	sources := []fs.FS{embedded}
+	if dir != "" {
+		sources = append(sources, os.DirFS(dir))
+	}
+
+	layoutFiles, err := readTemplateFiles(sources, "layout")
+	if err != nil {
+		return nil, err
+	}
+
+	pageFiles, err := readTemplateFiles(sources, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	layout := template.New("")
+	for _, name := range sortedKeys(layoutFiles) {
+		if _, err := layout.New(name).Parse(layoutFiles[name]); err != nil {
+			return nil, fmt.Errorf("could not parse layout template %s: %v", name, err)
+		}
+	}
+
+	pages := make(map[string]pageTemplate, len(pageFiles))
+	for name, src := range pageFiles {
+		// check whether the page itself defines the content block, the base
+		// layout always declares an empty one
+		probe, err := template.New(name).Parse(src)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse template %s: %v", name, err)
+		}
+
+		entry := name
+		if probe.Lookup("content") != nil {
+			entry = "base.html"
+		}
+
+		t, err := layout.Clone()
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := t.New(name).Parse(src); err != nil {
+			return nil, fmt.Errorf("could not parse template %s: %v", name, err)
+		}
+
+		pages[name] = pageTemplate{template: t, entry: entry}
+	}
+
+	return &templateRenderer{pages: pages}, nil
+}
+
+// readTemplateFiles reads the HTML files of dir in every source, files of a
+// later source replacing the ones of an earlier source.
+func readTemplateFiles(sources []fs.FS, dir string) (map[string]string, error) {
+	files := make(map[string]string)
+	for _, source := range sources {
+		entries, err := fs.ReadDir(source, dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read template directory: %v", err)
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".html") {
+				continue
+			}
+
+			b, err := fs.ReadFile(source, path.Join(dir, entry.Name()))
+			if err != nil {
+				return nil, fmt.Errorf("could not read template %s: %v", entry.Name(), err)
+			}
+			files[entry.Name()] = string(b)
+		}
+	}
+
+	return files, nil
+}
+
+// sortedKeys returns the keys of m in lexical order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// renderHTML renders the template name with data, adding the values every
+// template can rely on:
+//
+//	.IsLoggedIn  whether the user is signed in
+//	.User        the signed in user, nil otherwise
+//	.CSRFToken   the CSRF token of the session, when CSRF protection is on
+//	.Flashes     the flash messages set since the last rendered page
+//	.Brand       the app name, logo, primary color and footer links
+//	.Locale      the locale of the user, "en" by default
+//	.Timezone    the time zone of the user
+//	.Degraded    whether the page is served from cached claims during an
+//	             identity provider outage
+func renderHTML(ctx *gin.Context, status int, name string, data gin.H) {
+	values := gin.H{}
+	for key, value := range data {
+		values[key] = value
+	}
+
+	user, loggedIn := currentUser(ctx)
+	values["IsLoggedIn"] = loggedIn
+	values["User"] = user
+	values["CSRFToken"] = ctx.GetString("csrf_token")
+	values["Flashes"] = consumeFlashes(ctx)
+	values["Brand"] = currentBrand(ctx)
+	values["Locale"] = currentLocale(ctx)
+	values["Timezone"] = currentPreferences(ctx).Location().String()
+	values["Degraded"] = ctx.GetBool("degraded")
+
+	ctx.HTML(status, name, values)
+}
+
+// Instance implements render.HTMLRender.
+func (r *templateRenderer) Instance(name string, data interface{}) render.Render {
+	page, ok := r.pages[name]
+	if !ok {
+		// executing an empty template fails with an error naming it
+		return render.HTML{Template: template.New(name), Name: name, Data: data}
+	}
+
+	return render.HTML{Template: page.template, Name: page.entry, Data: data}
+}
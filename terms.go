@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// LegalDocument is a versioned document users must accept, such as the
+// terms of service.
+type LegalDocument struct {
+	ID      string // stored with the acceptances
+	Title   string
+	Version string
+	Text    string
+}
+
+// Terms holds the legal documents users must accept before reaching the
+// pages for signed in users.
+type Terms struct {
+	documents []LegalDocument
+}
+
+// loadTerms reads the documents configured with TERMS_VERSION and
+// TERMS_FILE, and PRIVACY_POLICY_VERSION and PRIVACY_POLICY_FILE. It returns
+// nil when no document is configured.
+func loadTerms(config TermsConfig) (*Terms, error) {
+	terms := &Terms{}
+	for _, doc := range []struct{ id, title, env, version, file string }{
+		{"terms", "Terms of service", "TERMS", config.TermsVersion, config.TermsFile},
+		{"privacy", "Privacy policy", "PRIVACY_POLICY", config.PrivacyPolicyVersion, config.PrivacyPolicyFile},
+	} {
+		if doc.version == "" {
+			continue
+		}
+
+		if doc.file == "" {
+			return nil, fmt.Errorf("%s_FILE must be set with %s_VERSION", doc.env, doc.env)
+		}
+		text, err := ioutil.ReadFile(doc.file)
+		if err != nil {
+			return nil, fmt.Errorf("could not read %s: %v", strings.ToLower(doc.title), err)
+		}
+
+		terms.documents = append(terms.documents, LegalDocument{
+			ID:      doc.id,
+			Title:   doc.title,
+			Version: doc.version,
+			Text:    string(text),
+		})
+	}
+
+	if len(terms.documents) == 0 {
+		return nil, nil
+	}
+	return terms, nil
+}
+
+// key identifies the current versions of the documents, remembered in the
+// session once they are accepted.
+func (t *Terms) key() string {
+	versions := make([]string, len(t.documents))
+	for i, doc := range t.documents {
+		versions[i] = doc.ID + ":" + doc.Version
+	}
+	return strings.Join(versions, ",")
+}
+
+// pending returns the documents whose current version is not accepted.
+func (t *Terms) pending(acceptances []Acceptance) []LegalDocument {
+	var pending []LegalDocument
+	for _, doc := range t.documents {
+		accepted := false
+		for _, acceptance := range acceptances {
+			if acceptance.Document == doc.ID && acceptance.Version == doc.Version {
+				accepted = true
+				break
+			}
+		}
+		if !accepted {
+			pending = append(pending, doc)
+		}
+	}
+	return pending
+}
+
+// pendingDocuments returns the documents the signed in user still has to
+// accept.
+func (s *Server) pendingDocuments(ctx *gin.Context, sub string) ([]LegalDocument, error) {
+	acceptances, err := s.users.ListAcceptances(ctx, sub)
+	if err != nil {
+		return nil, err
+	}
+	return s.terms.pending(acceptances), nil
+}
+
+// RequireAcceptedTerms redirects signed in users to the terms page until
+// they accept the current version of every document.
+func (s *Server) RequireAcceptedTerms() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		session := sessions.Default(ctx)
+		if session.Get("terms_accepted") == s.terms.key() {
+			ctx.Next()
+			return
+		}
+
+		u, ok := currentUser(ctx)
+		if !ok {
+			ctx.Redirect(http.StatusSeeOther, "/")
+			ctx.Abort()
+			return
+		}
+
+		pending, err := s.pendingDocuments(ctx, u.Sub)
+		if err != nil {
+			slog.ErrorContext(ctx, "could not list acceptances", "err", err)
+			renderError(ctx, http.StatusInternalServerError, "Something went wrong", "An unexpected error occurred, please try again.")
+			return
+		}
+
+		if len(pending) > 0 {
+			ctx.Redirect(http.StatusSeeOther, "/terms")
+			ctx.Abort()
+			return
+		}
+
+		session.Set("terms_accepted", s.terms.key())
+		if err := session.Save(); err != nil {
+			slog.ErrorContext(ctx, "could not save session", "err", err)
+		}
+		ctx.Next()
+	}
+}
+
+// termsPage renders the documents the user has to accept.
+func (s *Server) termsPage(ctx *gin.Context) {
+	u, ok := currentUser(ctx)
+	if !ok {
+		ctx.Redirect(http.StatusSeeOther, "/")
+		return
+	}
+
+	pending, err := s.pendingDocuments(ctx, u.Sub)
+	if err != nil {
+		slog.ErrorContext(ctx, "could not list acceptances", "err", err)
+		renderError(ctx, http.StatusInternalServerError, "Something went wrong", "An unexpected error occurred, please try again.")
+		return
+	}
+
+	if len(pending) == 0 {
+		ctx.Redirect(http.StatusSeeOther, "/profile")
+		return
+	}
+
+	renderHTML(ctx, http.StatusOK, "terms.html", gin.H{"Documents": pending})
+}
+
+// termsHandler records the acceptance of the documents shown to the user.
+// The versions posted must be the current ones, so that a document updated
+// while the page was open is shown again.
+func (s *Server) termsHandler(ctx *gin.Context) {
+	u, ok := currentUser(ctx)
+	if !ok {
+		ctx.Redirect(http.StatusSeeOther, "/")
+		return
+	}
+
+	pending, err := s.pendingDocuments(ctx, u.Sub)
+	if err != nil {
+		slog.ErrorContext(ctx, "could not list acceptances", "err", err)
+		renderError(ctx, http.StatusInternalServerError, "Something went wrong", "An unexpected error occurred, please try again.")
+		return
+	}
+
+	for _, doc := range pending {
+		if ctx.PostForm(doc.ID) != doc.Version {
+			renderHTML(ctx, http.StatusBadRequest, "terms.html", gin.H{
+				"Documents": pending,
+				"Error":     "Please accept the documents to continue.",
+			})
+			return
+		}
+	}
+
+	now := time.Now()
+	for _, doc := range pending {
+		if err := s.users.RecordAcceptance(ctx, &Acceptance{
+			Sub:        u.Sub,
+			Document:   doc.ID,
+			Version:    doc.Version,
+			AcceptedAt: now,
+		}); err != nil {
+			slog.ErrorContext(ctx, "could not record acceptance", "err", err)
+			renderError(ctx, http.StatusInternalServerError, "Something went wrong", "Your acceptance could not be saved, please try again.")
+			return
+		}
+		s.audit(ctx, auditTermsAccepted, u.Sub, map[string]string{"document": doc.ID, "version": doc.Version})
+		s.notifyTermsAccepted(ctx, u, doc, now)
+	}
+
+	session := sessions.Default(ctx)
+	session.Set("terms_accepted", s.terms.key())
+	if err := session.Save(); err != nil {
+		ctx.JSON(http.StatusInternalServerError, "could not save session")
+		return
+	}
+
+	ctx.Redirect(http.StatusSeeOther, "/profile")
+}
+
+// acceptedDocuments returns the acceptances of the current documents
+// shown on the profile page.
+func (s *Server) acceptedDocuments(ctx *gin.Context, sub string) []gin.H {
+	if s.terms == nil {
+		return nil
+	}
+
+	acceptances, err := s.users.ListAcceptances(ctx, sub)
+	if err != nil {
+		slog.ErrorContext(ctx, "could not list acceptances", "err", err)
+		return nil
+	}
+
+	var accepted []gin.H
+	for _, doc := range s.terms.documents {
+		for _, acceptance := range acceptances {
+			if acceptance.Document == doc.ID && acceptance.Version == doc.Version {
+				accepted = append(accepted, gin.H{
+					"Title":      doc.Title,
+					"Version":    doc.Version,
+					"AcceptedAt": acceptance.AcceptedAt.In(currentPreferences(ctx).Location()).Format(time.RFC1123),
+				})
+			}
+		}
+	}
+	return accepted
+}
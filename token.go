@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/coreos/go-oidc"
+)
+
+// defaultClockSkew is the leeway applied to time based token claims when
+// none is configured.
+const defaultClockSkew = 60 * time.Second
+
+// numericDate is a JWT NumericDate claim, seconds since the epoch which may
+// hold a fractional part.
+type numericDate struct {
+	time.Time
+}
+
+func (d *numericDate) UnmarshalJSON(b []byte) error {
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err != nil {
+		return err
+	}
+
+	seconds, err := n.Float64()
+	if err != nil {
+		return err
+	}
+
+	whole, frac := math.Modf(seconds)
+	d.Time = time.Unix(int64(whole), int64(frac*1e9))
+	return nil
+}
+
+// timeClaims are the time based claims validated on every token.
+type timeClaims struct {
+	Expiry    *numericDate `json:"exp"`
+	IssuedAt  *numericDate `json:"iat"`
+	NotBefore *numericDate `json:"nbf"`
+	AuthTime  *numericDate `json:"auth_time"`
+}
+
+// validate checks the claims at now, tolerating a clock difference of up to
+// leeway with the token issuer. Missing claims are not checked.
+func (c timeClaims) validate(now time.Time, leeway time.Duration) error {
+	if c.Expiry != nil && now.Add(-leeway).After(c.Expiry.Time) {
+		return fmt.Errorf("token is expired (exp: %v)", c.Expiry.Time)
+	}
+
+	if c.NotBefore != nil && now.Add(leeway).Before(c.NotBefore.Time) {
+		return fmt.Errorf("token is not valid yet (nbf: %v)", c.NotBefore.Time)
+	}
+
+	if c.IssuedAt != nil && now.Add(leeway).Before(c.IssuedAt.Time) {
+		return fmt.Errorf("token is issued in the future (iat: %v)", c.IssuedAt.Time)
+	}
+
+	if c.AuthTime != nil && now.Add(leeway).Before(c.AuthTime.Time) {
+		return fmt.Errorf("token has an authentication time in the future (auth_time: %v)", c.AuthTime.Time)
+	}
+
+	return nil
+}
+
+// IDTokenVerifier verifies ID tokens and their time based claims with a clock
+// skew leeway.
+type IDTokenVerifier struct {
+	verifier *oidc.IDTokenVerifier
+	leeway   time.Duration
+}
+
+// Verify verifies the signature, issuer and audience of rawIDToken and then
+// its exp, iat, nbf and auth_time claims.
+func (v *IDTokenVerifier) Verify(ctx context.Context, rawIDToken string) (*oidc.IDToken, error) {
+	token, err := v.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims timeClaims
+	if err := token.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("could not parse token claims: %v", err)
+	}
+
+	if claims.Expiry == nil {
+		return nil, fmt.Errorf("token has no exp claim")
+	}
+
+	if err := claims.validate(time.Now(), v.leeway); err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
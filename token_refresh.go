@@ -0,0 +1,68 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+// accessTokenRefreshMargin is how long before its expiry the access token
+// of a session is renewed.
+const accessTokenRefreshMargin = 5 * time.Minute
+
+// RefreshAccessTokens renews the access token of the session with its
+// refresh token when it is about to expire, so users are not sent back to
+// the login page. Requests go on with the current token when it cannot be
+// renewed. It must run after LoadSessionTokens.
+func (s *Server) RefreshAccessTokens() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		tokens, ok := sessionTokens(ctx)
+		if !ok || tokens.RefreshToken == "" || tokens.Expiry.IsZero() || time.Until(tokens.Expiry) > accessTokenRefreshMargin {
+			ctx.Next()
+			return
+		}
+
+		// the token of the session is for the first resource, if any
+		var resource string
+		if len(s.resources) > 0 && s.tenant(ctx).isAuth0() {
+			resource = s.resources[0]
+		}
+
+		// concurrent requests share the refresh, the refresh token being
+		// rotated by the provider
+		result, err := s.calls.Do(ctx, "refresh\x00"+resource+"\x00"+hashTokenSecret(tokens.RefreshToken), func() (interface{}, error) {
+			return s.refreshForResource(ctx, tokens.RefreshToken, resource)
+		})
+		token, _ := result.(*oauth2.Token)
+		updated := *tokens
+		switch {
+		case err == nil:
+			s.idpHealth.succeeded()
+			updated.AccessToken, updated.TokenType, updated.Expiry = token.AccessToken, token.Type(), token.Expiry
+			updated.Scope = grantedScope(token, tokens.Scope)
+			if token.RefreshToken != "" {
+				updated.RefreshToken = token.RefreshToken
+			}
+			if resource != "" {
+				cacheResourceToken(&updated, resource, token)
+			}
+		case isIdPOutage(err):
+			s.idpHealth.failed(err)
+			markDegraded(ctx)
+			ctx.Next()
+			return
+		default:
+			// the refresh token was revoked or expired, the session ends
+			// with its access token
+			slog.ErrorContext(ctx, "could not refresh access token", "err", err)
+			updated.RefreshToken = ""
+		}
+
+		if err := s.saveSessionTokens(ctx, &updated); err != nil {
+			slog.ErrorContext(ctx, "could not save session tokens", "err", err)
+		}
+		ctx.Next()
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sessionTokensTTL is how long the tokens of a session are kept after they
+// were last written.
+const sessionTokensTTL = sessionIdleTimeout
+
+// ErrTokensNotFound is returned by a TokenStore when a session has no tokens.
+var ErrTokensNotFound = errors.New("session tokens not found")
+
+// SessionTokens are the tokens of a browser session, kept server-side. The
+// session cookie only references them by ID.
+type SessionTokens struct {
+	AccessToken  string                   `json:"access_token"`
+	TokenType    string                   `json:"token_type,omitempty"`
+	Expiry       time.Time                `json:"expiry,omitempty"`
+	Scope        string                   `json:"scope,omitempty"` // granted to the access token
+	RefreshToken string                   `json:"refresh_token,omitempty"`
+	IDToken      string                   `json:"id_token,omitempty"`  // sent as id_token_hint on logout
+	Resources    map[string]resourceToken `json:"resources,omitempty"` // tokens of the AUTH0_RESOURCES
+	// Profile holds the verified claims of the ID token of the login, or
+	// the profile read from the GitHub API, which identify the user.
+	Profile json.RawMessage `json:"profile,omitempty"`
+}
+
+// TokenStore keeps the tokens of the sessions.
+type TokenStore interface {
+	// Get returns the tokens of session id or ErrTokensNotFound.
+	Get(ctx context.Context, id string) (*SessionTokens, error)
+	// Put stores the tokens of session id for sessionTokensTTL.
+	Put(ctx context.Context, id string, tokens *SessionTokens) error
+	// Delete removes the tokens of session id.
+	Delete(ctx context.Context, id string) error
+}
+
+// NewTokenStore returns a store shared between instances in Redis when
+// redisClient is set, and an in memory store otherwise, whose sessions end
+// on restart.
+func NewTokenStore(redisClient *RedisClient) TokenStore {
+	if redisClient == nil {
+		return &memoryTokenStore{tokens: make(map[string]memoryTokens)}
+	}
+	return &redisTokenStore{client: redisClient}
+}
+
+// memoryTokens are tokens kept in memory until expires.
+type memoryTokens struct {
+	tokens  SessionTokens
+	expires time.Time
+}
+
+// memoryTokenStore keeps the tokens in memory, per instance.
+type memoryTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]memoryTokens
+	swept  time.Time
+}
+
+func (s *memoryTokenStore) Get(ctx context.Context, id string) (*SessionTokens, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.tokens[id]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, ErrTokensNotFound
+	}
+	tokens := entry.tokens
+	return &tokens, nil
+}
+
+func (s *memoryTokenStore) Put(ctx context.Context, id string, tokens *SessionTokens) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.swept) > time.Hour {
+		for k, entry := range s.tokens {
+			if now.After(entry.expires) {
+				delete(s.tokens, k)
+			}
+		}
+		s.swept = now
+	}
+
+	s.tokens[id] = memoryTokens{tokens: *tokens, expires: now.Add(sessionTokensTTL)}
+	return nil
+}
+
+func (s *memoryTokenStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.tokens, id)
+	return nil
+}
+
+// redisTokenStore keeps the tokens in Redis.
+type redisTokenStore struct {
+	client *RedisClient
+}
+
+func (s *redisTokenStore) Get(ctx context.Context, id string) (*SessionTokens, error) {
+	reply, err := s.client.Do(ctx, "GET", "session_tokens:"+id)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := reply.(string)
+	if !ok {
+		return nil, ErrTokensNotFound
+	}
+
+	var tokens SessionTokens
+	if err := json.Unmarshal([]byte(value), &tokens); err != nil {
+		return nil, err
+	}
+	return &tokens, nil
+}
+
+func (s *redisTokenStore) Put(ctx context.Context, id string, tokens *SessionTokens) error {
+	value, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.Do(ctx, "SET", "session_tokens:"+id, string(value), "PX", strconv.FormatInt(sessionTokensTTL.Milliseconds(), 10))
+	return err
+}
+
+func (s *redisTokenStore) Delete(ctx context.Context, id string) error {
+	_, err := s.client.Do(ctx, "DEL", "session_tokens:"+id)
+	return err
+}
+
+// LoadSessionTokens reads the tokens of the session from the token store
+// and sets them in the context under "session_tokens". Sessions whose
+// tokens are gone are not signed in.
+func (s *Server) LoadSessionTokens() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		session := defaultSession(ctx)
+		if session == nil {
+			ctx.Next()
+			return
+		}
+		id, _ := session.Get("tid").(string)
+		if id == "" {
+			ctx.Next()
+			return
+		}
+
+		tokens, err := s.tokens.Get(ctx, id)
+		if err != nil {
+			if !errors.Is(err, ErrTokensNotFound) {
+				slog.ErrorContext(ctx, "could not read session tokens", "err", err)
+			}
+			ctx.Next()
+			return
+		}
+
+		ctx.Set("session_tokens", tokens)
+		ctx.Next()
+	}
+}
+
+// sessionTokens returns the tokens of the session loaded by
+// LoadSessionTokens.
+func sessionTokens(ctx *gin.Context) (*SessionTokens, bool) {
+	value, _ := ctx.Get("session_tokens")
+	tokens, ok := value.(*SessionTokens)
+	return tokens, ok && tokens != nil
+}
+
+// saveSessionTokens stores tokens as the tokens of the session, under a new
+// ID when it has none yet. The session still needs to be saved.
+func (s *Server) saveSessionTokens(ctx *gin.Context, tokens *SessionTokens) error {
+	session := defaultSession(ctx)
+	if session == nil {
+		return errors.New("no session")
+	}
+
+	id, _ := session.Get("tid").(string)
+	if id == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		id = hex.EncodeToString(b)
+	}
+
+	if err := s.tokens.Put(ctx, id, tokens); err != nil {
+		return err
+	}
+	session.Set("tid", id)
+	ctx.Set("session_tokens", tokens)
+	return nil
+}
+
+// deleteSessionTokens removes the tokens of the session, which is no longer
+// signed in. The session still needs to be saved.
+func (s *Server) deleteSessionTokens(ctx *gin.Context) {
+	session := defaultSession(ctx)
+	if session == nil {
+		return
+	}
+	if id, ok := session.Get("tid").(string); ok {
+		if err := s.tokens.Delete(ctx, id); err != nil {
+			slog.ErrorContext(ctx, "could not delete session tokens", "err", err)
+		}
+	}
+	session.Delete("tid")
+	ctx.Set("session_tokens", (*SessionTokens)(nil))
+}
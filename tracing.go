@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// traceShutdownTimeout bounds the export of the pending spans on Close.
+const traceShutdownTimeout = 10 * time.Second
+
+// tracePropagator reads and writes the trace of a request in its W3C
+// traceparent header.
+var tracePropagator = propagation.TraceContext{}
+
+// Tracer records the spans of the requests and of the calls they make to
+// the identity provider, exported in batches to an OpenTelemetry collector
+// with OTLP over HTTP.
+type Tracer struct {
+	provider *sdktrace.TracerProvider
+	service  string
+}
+
+// parseTraceSampler returns the sampler of the OTEL_TRACES_SAMPLER name and
+// its OTEL_TRACES_SAMPLER_ARG ratio. The default samples every trace but
+// the ones the caller did not sample.
+func parseTraceSampler(name, arg string) (sdktrace.Sampler, error) {
+	ratio := 1.0
+	if arg != "" {
+		var err error
+		if ratio, err = strconv.ParseFloat(arg, 64); err != nil || ratio < 0 || ratio > 1 {
+			return nil, fmt.Errorf("OTEL_TRACES_SAMPLER_ARG must be a ratio between 0 and 1: %q", arg)
+		}
+	}
+
+	switch name {
+	case "", "parentbased_always_on":
+		return sdktrace.ParentBased(sdktrace.AlwaysSample()), nil
+	case "parentbased_always_off":
+		return sdktrace.ParentBased(sdktrace.NeverSample()), nil
+	case "parentbased_traceidratio":
+		return sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio)), nil
+	case "always_on":
+		return sdktrace.AlwaysSample(), nil
+	case "always_off":
+		return sdktrace.NeverSample(), nil
+	case "traceidratio":
+		return sdktrace.TraceIDRatioBased(ratio), nil
+	default:
+		return nil, fmt.Errorf("unsupported OTEL_TRACES_SAMPLER %q", name)
+	}
+}
+
+// loadTracer creates the tracer configured with the standard
+// OTEL_EXPORTER_OTLP_ENDPOINT (or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT),
+// OTEL_EXPORTER_OTLP_HEADERS, OTEL_SERVICE_NAME and OTEL_TRACES_SAMPLER
+// variables. It returns nil when no endpoint is set.
+func loadTracer(config TracingConfig) (*Tracer, error) {
+	endpoint := config.TracesEndpoint
+	if endpoint == "" {
+		if base := config.Endpoint; base != "" {
+			endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+		}
+	}
+	if endpoint == "" {
+		return nil, nil
+	}
+	if u, err := url.Parse(endpoint); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP traces endpoint %q", endpoint)
+	}
+
+	headers := map[string]string{}
+	for _, pair := range config.Headers {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("OTEL_EXPORTER_OTLP_HEADERS must be name=value pairs: %q", pair)
+		}
+		if decoded, err := url.QueryUnescape(value); err == nil {
+			value = decoded
+		}
+		headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+
+	sampler, err := parseTraceSampler(config.Sampler, config.SamplerArg)
+	if err != nil {
+		return nil, err
+	}
+
+	service := config.ServiceName
+	if service == "" {
+		service = "go-auth0"
+	}
+
+	// the exporter only connects when the first batch is sent
+	exporter, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpointURL(endpoint),
+		otlptracehttp.WithHeaders(headers),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not create OTLP exporter: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sampler),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(service))),
+	)
+	return &Tracer{provider: provider, service: service}, nil
+}
+
+// Close exports the pending spans and stops the exporter. Spans ended
+// afterwards are dropped.
+func (t *Tracer) Close() {
+	if t == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), traceShutdownTimeout)
+	defer cancel()
+	if err := t.provider.Shutdown(ctx); err != nil {
+		slog.Error("could not export spans", "err", err)
+	}
+}
+
+// Tracing records a server span for every request, continuing the trace of
+// the traceparent header of the caller.
+func Tracing(tracer *Tracer) gin.HandlerFunc {
+	if tracer == nil {
+		return func(ctx *gin.Context) { ctx.Next() }
+	}
+	return otelgin.Middleware(tracer.service,
+		otelgin.WithTracerProvider(tracer.provider),
+		otelgin.WithPropagators(tracePropagator),
+	)
+}
+
+// TraceID adds the trace ID of the span of Tracing to the log lines of the
+// request, also stored in the context under "trace_id" for the error
+// reports, and records the request ID on the span.
+func TraceID() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		span := trace.SpanFromContext(ctx.Request.Context())
+		if sc := span.SpanContext(); sc.IsValid() {
+			ctx.Set("trace_id", sc.TraceID().String())
+			addLogAttrs(ctx, slog.String("trace_id", sc.TraceID().String()))
+		}
+		ctx.Next()
+		span.SetAttributes(attribute.String("request_id", ctx.GetString("request_id")))
+	}
+}
+
+// newTracingTransport returns base recording a client span for the
+// requests sent through it, child of the span of the request being served
+// or the root of its own trace for the background calls, such as the JWKS
+// fetches, and passing the trace on in their traceparent header. It returns
+// base itself when tracing is off.
+func newTracingTransport(tracer *Tracer, base http.RoundTripper) http.RoundTripper {
+	if tracer == nil {
+		return base
+	}
+	return otelhttp.NewTransport(base,
+		otelhttp.WithTracerProvider(tracer.provider),
+		otelhttp.WithPropagators(tracePropagator),
+	)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// currentUser returns the signed in user, read from the claims kept with
+// the tokens of the session, or the user authenticated by APIAuth with an
+// access token. It returns false when the user is not signed in.
+func currentUser(ctx *gin.Context) (*UserInfo, bool) {
+	if user, ok := ctx.Get("user"); ok {
+		return user.(*UserInfo), true
+	}
+
+	profile, ok := sessionProfile(ctx)
+	if !ok {
+		return nil, false
+	}
+
+	var u UserInfo
+	if err := json.Unmarshal(profile, &u); err != nil || u.Sub == "" {
+		return nil, false
+	}
+
+	return &u, true
+}
+
+// sessionProfile returns the claims of the user saved server-side at login
+// with the tokens of the session. Sessions of access tokens have none.
+func sessionProfile(ctx *gin.Context) (json.RawMessage, bool) {
+	tokens, ok := sessionTokens(ctx)
+	if !ok || len(tokens.Profile) == 0 {
+		return nil, false
+	}
+	return tokens.Profile, true
+}
+
+// meHandler returns the profile of the authenticated user.
+func (s *Server) meHandler(ctx *gin.Context) {
+	u, _ := currentUser(ctx)
+	ctx.JSON(http.StatusOK, u)
+}
+
+// minJSONTime and maxJSONTime bound the Unix times of the years 0 to 9999,
+// the ones time.Time encodes in JSON.
+var (
+	minJSONTime = float64(time.Date(0, 1, 1, 0, 0, 0, 0, time.UTC).Unix())
+	maxJSONTime = float64(time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC).Unix())
+)
+
+// userInfoTimeLayouts are the timestamp layouts accepted for updated_at, in
+// addition to Unix timestamps.
+var userInfoTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	time.RFC1123Z,
+	time.RFC1123,
+	"2006-01-02",
+}
+
+// UnmarshalJSON decodes user information, accepting the different shapes
+// providers use for updated_at (RFC 3339 or other string layouts, Unix
+// seconds or milliseconds) and email_verified (boolean, string or number).
+// Values that cannot be understood are left empty instead of failing.
+func (u *UserInfo) UnmarshalJSON(b []byte) error {
+	type userInfo UserInfo
+	aux := struct {
+		*userInfo
+		UpdatedAt     json.RawMessage `json:"updated_at"`
+		EmailVerified json.RawMessage `json:"email_verified"`
+	}{
+		userInfo: (*userInfo)(u),
+	}
+
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+
+	u.UpdatedAt = parseFlexibleTime(aux.UpdatedAt)
+	u.EmailVerified = parseFlexibleBool(aux.EmailVerified)
+
+	return nil
+}
+
+// parseFlexibleTime parses a JSON timestamp given as a string or a number.
+func parseFlexibleTime(raw json.RawMessage) time.Time {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return time.Time{}
+	}
+
+	value := string(raw)
+	if raw[0] == '"' {
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return time.Time{}
+		}
+		value = strings.TrimSpace(value)
+	}
+
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		if math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+			return time.Time{}
+		}
+		// timestamps past the year 33658 in seconds are taken as milliseconds
+		if seconds > 1e12 || seconds < -1e12 {
+			seconds /= 1000
+		}
+		// times outside of the years 0 to 9999 cannot be encoded again in
+		// JSON, which would fail the profile page
+		if seconds < minJSONTime || seconds > maxJSONTime {
+			return time.Time{}
+		}
+		return time.Unix(int64(seconds), int64((seconds-float64(int64(seconds)))*1e9)).UTC()
+	}
+
+	for _, layout := range userInfoTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// parseFlexibleBool parses a JSON boolean given as a boolean, a string or a
+// number.
+func parseFlexibleBool(raw json.RawMessage) bool {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return false
+	}
+
+	value := string(raw)
+	if raw[0] == '"' {
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return false
+		}
+	}
+
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "true", "1", "yes":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+
+	"github.com/coreos/go-oidc"
+	"github.com/gin-gonic/gin"
+)
+
+// isSignedUserInfo reports whether a userinfo response with the given
+// content type is a JWT rather than JSON.
+func isSignedUserInfo(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "application/jwt"
+}
+
+// verifySignedUserInfo verifies the signature, issuer and audience of a
+// signed userinfo response and returns its JSON claims. Encrypted responses
+// are decrypted first when an ID token decryption key is configured.
+func (s *Server) verifySignedUserInfo(ctx *gin.Context, body []byte) ([]byte, error) {
+	raw := string(body)
+	if isEncryptedJWT(raw) {
+		if s.idTokenKeys == nil {
+			return nil, fmt.Errorf("userinfo response is encrypted but no decryption key is configured")
+		}
+
+		var err error
+		if raw, err = s.idTokenKeys.decrypt(raw); err != nil {
+			return nil, err
+		}
+	}
+
+	// userinfo responses are not required to carry exp
+	tenant := s.tenant(ctx)
+	verifier := oidc.NewVerifier(tenant.provider.issuer, tenant.provider.KeySet(), &oidc.Config{
+		ClientID:        tenant.oauth2config.ClientID,
+		SkipExpiryCheck: true,
+	})
+
+	token, err := verifier.Verify(ctx, raw)
+	if err != nil {
+		return nil, fmt.Errorf("could not verify userinfo response: %v", err)
+	}
+
+	var claims json.RawMessage
+	if err := token.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("could not parse userinfo response: %v", err)
+	}
+
+	return claims, nil
+}
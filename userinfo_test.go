@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestUserInfoUnmarshalUpdatedAt(t *testing.T) {
+	want := time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC)
+	for _, raw := range []string{
+		`"2023-11-14T22:13:20Z"`,
+		`"2023-11-14T22:13:20.000Z"`,
+		`"2023-11-14T22:13:20"`,
+		`"2023-11-14 22:13:20"`,
+		`" 2023-11-14T22:13:20Z "`,
+		`1700000000`,
+		`1700000000000`,
+		`"1700000000"`,
+	} {
+		var u UserInfo
+		if err := json.Unmarshal([]byte(`{"sub":"auth0|1","updated_at":`+raw+`}`), &u); err != nil {
+			t.Errorf("updated_at %s: %v", raw, err)
+			continue
+		}
+		if !u.UpdatedAt.Equal(want) {
+			t.Errorf("updated_at %s = %v, want %v", raw, u.UpdatedAt, want)
+		}
+	}
+
+	for _, raw := range []string{`null`, `""`, `"yesterday"`, `true`, `{}`, `[]`, `1e400`} {
+		var u UserInfo
+		if err := json.Unmarshal([]byte(`{"sub":"auth0|1","updated_at":`+raw+`}`), &u); err != nil {
+			t.Errorf("updated_at %s: %v", raw, err)
+			continue
+		}
+		if !u.UpdatedAt.IsZero() {
+			t.Errorf("updated_at %s = %v, want zero", raw, u.UpdatedAt)
+		}
+	}
+}
+
+func TestUserInfoUnmarshalEmailVerified(t *testing.T) {
+	for raw, want := range map[string]bool{
+		`true`:    true,
+		`false`:   false,
+		`"true"`:  true,
+		`"TRUE"`:  true,
+		`"false"`: false,
+		`1`:       true,
+		`0`:       false,
+		`"1"`:     true,
+		`"yes"`:   true,
+		`null`:    false,
+		`{}`:      false,
+	} {
+		var u UserInfo
+		if err := json.Unmarshal([]byte(`{"sub":"auth0|1","email_verified":`+raw+`}`), &u); err != nil {
+			t.Errorf("email_verified %s: %v", raw, err)
+			continue
+		}
+		if u.EmailVerified != want {
+			t.Errorf("email_verified %s = %v, want %v", raw, u.EmailVerified, want)
+		}
+	}
+}
+
+// FuzzUserInfoUnmarshal checks that any JSON value of updated_at and
+// email_verified is accepted, and that the profile decoded is rendered
+// again by the profile page and /api/v1/me.
+func FuzzUserInfoUnmarshal(f *testing.F) {
+	for _, seed := range [][2]string{
+		{`"2023-11-14T22:13:20Z"`, `true`},
+		{`1700000000`, `"true"`},
+		{`1700000000000`, `1`},
+		{`"2023-11-14 22:13:20"`, `"false"`},
+		{`null`, `null`},
+		{`-1e300`, `{}`},
+		{`"9999999999999999999"`, `[]`},
+		{`"Tue, 14 Nov 2023 22:13:20 +0000"`, `"yes"`},
+	} {
+		f.Add(seed[0], seed[1])
+	}
+
+	f.Fuzz(func(t *testing.T, updatedAt, emailVerified string) {
+		if !json.Valid([]byte(updatedAt)) || !json.Valid([]byte(emailVerified)) {
+			return
+		}
+
+		var u UserInfo
+		b := []byte(`{"sub":"auth0|1","name":"Jane","updated_at":` + updatedAt + `,"email_verified":` + emailVerified + `}`)
+		if err := json.Unmarshal(b, &u); err != nil {
+			t.Fatalf("Unmarshal(%s): %v", b, err)
+		}
+		if u.Sub != "auth0|1" || u.Name != "Jane" {
+			t.Fatalf("Unmarshal(%s) lost the other claims: %+v", b, u)
+		}
+
+		encoded, err := json.Marshal(&u)
+		if err != nil {
+			t.Fatalf("Marshal(%+v): %v", u, err)
+		}
+		var again UserInfo
+		if err := json.Unmarshal(encoded, &again); err != nil {
+			t.Fatalf("Unmarshal(%s): %v", encoded, err)
+		}
+		if !again.UpdatedAt.Equal(u.UpdatedAt) || again.EmailVerified != u.EmailVerified {
+			t.Fatalf("round trip of %s = %+v, want %+v", encoded, again, u)
+		}
+	})
+}
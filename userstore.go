@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrUserNotFound is returned by a UserStore when no user matches.
+var ErrUserNotFound = errors.New("user not found")
+
+// User is the local record of a user, kept in sync with auth0 at login and
+// through the user event webhook.
+type User struct {
+	Sub           string    `json:"sub"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	Name          string    `json:"name"`
+	Picture       string    `json:"picture"`
+	Blocked       bool      `json:"blocked"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	LastLoginAt   time.Time `json:"last_login_at"`
+}
+
+// Acceptance records that a user accepted a version of a legal document.
+type Acceptance struct {
+	Sub        string    `json:"sub"`
+	Document   string    `json:"document"`
+	Version    string    `json:"version"`
+	AcceptedAt time.Time `json:"accepted_at"`
+}
+
+// UserStore persists the local user records.
+type UserStore interface {
+	// UpsertUser creates the user or updates its profile fields. The blocked
+	// flag is only set when the user is created, and a zero LastLoginAt
+	// keeps the stored value.
+	UpsertUser(ctx context.Context, user *User) error
+	// SetUserBlocked updates the blocked flag of the user or returns
+	// ErrUserNotFound.
+	SetUserBlocked(ctx context.Context, sub string, blocked bool) error
+	// GetUser returns the user with the given sub or ErrUserNotFound.
+	GetUser(ctx context.Context, sub string) (*User, error)
+	// DeleteUser removes the user with the given sub, if any.
+	DeleteUser(ctx context.Context, sub string) error
+	// ListUsers returns all the users.
+	ListUsers(ctx context.Context) ([]User, error)
+	// RecordAcceptance records a document accepted by a user. Accepting the
+	// same version again keeps the first acceptance.
+	RecordAcceptance(ctx context.Context, acceptance *Acceptance) error
+	// ListAcceptances returns the documents accepted by the user, oldest
+	// first.
+	ListAcceptances(ctx context.Context, sub string) ([]Acceptance, error)
+	// GetPreferences returns the preferences of the user, the default ones
+	// when none were saved.
+	GetPreferences(ctx context.Context, sub string) (*Preferences, error)
+	// SavePreferences replaces the preferences of the user.
+	SavePreferences(ctx context.Context, sub string, preferences *Preferences) error
+	// CreateAccessToken stores a new personal access token.
+	CreateAccessToken(ctx context.Context, token *AccessToken) error
+	// GetAccessToken returns the token with the given ID or
+	// ErrAccessTokenNotFound.
+	GetAccessToken(ctx context.Context, id string) (*AccessToken, error)
+	// ListAccessTokens returns the tokens of the user, newest first.
+	ListAccessTokens(ctx context.Context, sub string) ([]AccessToken, error)
+	// TouchAccessToken records that the token was used at.
+	TouchAccessToken(ctx context.Context, id string, at time.Time) error
+	// RevokeAccessToken revokes a token of the user or returns
+	// ErrAccessTokenNotFound.
+	RevokeAccessToken(ctx context.Context, sub, id string, at time.Time) error
+	// CreateSession stores a new session record.
+	CreateSession(ctx context.Context, session *UserSession) error
+	// GetSession returns the session with the given ID or
+	// ErrSessionNotFound.
+	GetSession(ctx context.Context, id string) (*UserSession, error)
+	// ListSessions returns the sessions of the user which are not revoked,
+	// last seen first.
+	ListSessions(ctx context.Context, sub string) ([]UserSession, error)
+	// TouchSession records that the session was seen at from ip.
+	TouchSession(ctx context.Context, id string, at time.Time, ip string) error
+	// RevokeSession revokes a session of the user or returns
+	// ErrSessionNotFound.
+	RevokeSession(ctx context.Context, sub, id string, at time.Time) error
+	// RevokeProviderSession revokes the sessions made with the session
+	// providerSessionID of the identity provider, of the user sub when set,
+	// and returns the ones which were not revoked yet.
+	RevokeProviderSession(ctx context.Context, sub, providerSessionID string, at time.Time) ([]UserSession, error)
+}
+
+// NewUserStore returns a Postgres backed store when dsn is set, and an in
+// memory store otherwise.
+func NewUserStore(dsn string) (UserStore, error) {
+	if dsn == "" {
+		return newMemoryUserStore(), nil
+	}
+	return newPostgresUserStore(dsn)
+}
+
+// memoryUserStore keeps users in memory, they are lost on restart.
+type memoryUserStore struct {
+	mu          sync.RWMutex
+	users       map[string]User
+	acceptances map[string][]Acceptance // by sub
+	preferences map[string]Preferences  // by sub
+	tokens      map[string]AccessToken  // by ID
+	sessions    map[string]UserSession  // by ID
+}
+
+func newMemoryUserStore() *memoryUserStore {
+	return &memoryUserStore{
+		users:       make(map[string]User),
+		acceptances: make(map[string][]Acceptance),
+		preferences: make(map[string]Preferences),
+		tokens:      make(map[string]AccessToken),
+		sessions:    make(map[string]UserSession),
+	}
+}
+
+func (s *memoryUserStore) UpsertUser(ctx context.Context, user *User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	stored, ok := s.users[user.Sub]
+	if !ok {
+		stored = User{Sub: user.Sub, Blocked: user.Blocked, CreatedAt: now}
+	}
+
+	stored.Email = user.Email
+	stored.EmailVerified = user.EmailVerified
+	stored.Name = user.Name
+	stored.Picture = user.Picture
+	stored.UpdatedAt = now
+	if !user.LastLoginAt.IsZero() {
+		stored.LastLoginAt = user.LastLoginAt
+	}
+
+	s.users[user.Sub] = stored
+	return nil
+}
+
+func (s *memoryUserStore) GetUser(ctx context.Context, sub string) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[sub]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return &user, nil
+}
+
+func (s *memoryUserStore) SetUserBlocked(ctx context.Context, sub string, blocked bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[sub]
+	if !ok {
+		return ErrUserNotFound
+	}
+
+	user.Blocked = blocked
+	user.UpdatedAt = time.Now()
+	s.users[sub] = user
+	return nil
+}
+
+func (s *memoryUserStore) DeleteUser(ctx context.Context, sub string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.users, sub)
+	delete(s.acceptances, sub)
+	delete(s.preferences, sub)
+	for id, token := range s.tokens {
+		if token.Sub == sub {
+			delete(s.tokens, id)
+		}
+	}
+	for id, session := range s.sessions {
+		if session.Sub == sub {
+			delete(s.sessions, id)
+		}
+	}
+	return nil
+}
+
+func (s *memoryUserStore) ListUsers(ctx context.Context) ([]User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := make([]User, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+func (s *memoryUserStore) RecordAcceptance(ctx context.Context, acceptance *Acceptance) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, accepted := range s.acceptances[acceptance.Sub] {
+		if accepted.Document == acceptance.Document && accepted.Version == acceptance.Version {
+			return nil
+		}
+	}
+
+	s.acceptances[acceptance.Sub] = append(s.acceptances[acceptance.Sub], *acceptance)
+	return nil
+}
+
+func (s *memoryUserStore) ListAcceptances(ctx context.Context, sub string) ([]Acceptance, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]Acceptance(nil), s.acceptances[sub]...), nil
+}
+
+func (s *memoryUserStore) GetPreferences(ctx context.Context, sub string) (*Preferences, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	preferences, ok := s.preferences[sub]
+	if !ok {
+		return defaultPreferences(), nil
+	}
+
+	notifications := make(map[string]bool, len(preferences.Notifications))
+	for topic, enabled := range preferences.Notifications {
+		notifications[topic] = enabled
+	}
+	preferences.Notifications = notifications
+	return &preferences, nil
+}
+
+func (s *memoryUserStore) SavePreferences(ctx context.Context, sub string, preferences *Preferences) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := *preferences
+	stored.Notifications = make(map[string]bool, len(preferences.Notifications))
+	for topic, enabled := range preferences.Notifications {
+		stored.Notifications[topic] = enabled
+	}
+	s.preferences[sub] = stored
+	return nil
+}
+
+func (s *memoryUserStore) CreateAccessToken(ctx context.Context, token *AccessToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := *token
+	stored.Scopes = append([]string(nil), token.Scopes...)
+	s.tokens[token.ID] = stored
+	return nil
+}
+
+func (s *memoryUserStore) GetAccessToken(ctx context.Context, id string) (*AccessToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	token, ok := s.tokens[id]
+	if !ok {
+		return nil, ErrAccessTokenNotFound
+	}
+	return &token, nil
+}
+
+func (s *memoryUserStore) ListAccessTokens(ctx context.Context, sub string) ([]AccessToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var tokens []AccessToken
+	for _, token := range s.tokens {
+		if token.Sub == sub {
+			tokens = append(tokens, token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].CreatedAt.After(tokens[j].CreatedAt) })
+	return tokens, nil
+}
+
+func (s *memoryUserStore) TouchAccessToken(ctx context.Context, id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.tokens[id]
+	if !ok {
+		return ErrAccessTokenNotFound
+	}
+	token.LastUsedAt = &at
+	s.tokens[id] = token
+	return nil
+}
+
+func (s *memoryUserStore) RevokeAccessToken(ctx context.Context, sub, id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.tokens[id]
+	if !ok || token.Sub != sub {
+		return ErrAccessTokenNotFound
+	}
+	if token.RevokedAt == nil {
+		token.RevokedAt = &at
+		s.tokens[id] = token
+	}
+	return nil
+}
+
+func (s *memoryUserStore) CreateSession(ctx context.Context, session *UserSession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions[session.ID] = *session
+	return nil
+}
+
+func (s *memoryUserStore) GetSession(ctx context.Context, id string) (*UserSession, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	return &session, nil
+}
+
+func (s *memoryUserStore) ListSessions(ctx context.Context, sub string) ([]UserSession, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var sessions []UserSession
+	for _, session := range s.sessions {
+		if session.Sub == sub && session.RevokedAt == nil {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt) })
+	return sessions, nil
+}
+
+func (s *memoryUserStore) TouchSession(ctx context.Context, id string, at time.Time, ip string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return ErrSessionNotFound
+	}
+	session.LastSeenAt = at
+	session.IP = ip
+	s.sessions[id] = session
+	return nil
+}
+
+func (s *memoryUserStore) RevokeSession(ctx context.Context, sub, id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok || session.Sub != sub {
+		return ErrSessionNotFound
+	}
+	if session.RevokedAt == nil {
+		session.RevokedAt = &at
+		s.sessions[id] = session
+	}
+	return nil
+}
+
+func (s *memoryUserStore) RevokeProviderSession(ctx context.Context, sub, providerSessionID string, at time.Time) ([]UserSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var revoked []UserSession
+	for id, session := range s.sessions {
+		if session.ProviderSessionID != providerSessionID || (sub != "" && session.Sub != sub) || session.RevokedAt != nil {
+			continue
+		}
+		session.RevokedAt = &at
+		s.sessions[id] = session
+		revoked = append(revoked, session)
+	}
+	return revoked, nil
+}
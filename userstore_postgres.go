@@ -0,0 +1,524 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	// registers the postgres database/sql driver
+	_ "github.com/lib/pq"
+)
+
+// postgresUserSchema creates the users table when missing.
+const postgresUserSchema = `
+CREATE TABLE IF NOT EXISTS users (
+	sub            TEXT PRIMARY KEY,
+	email          TEXT NOT NULL DEFAULT '',
+	email_verified BOOLEAN NOT NULL DEFAULT FALSE,
+	name           TEXT NOT NULL DEFAULT '',
+	picture        TEXT NOT NULL DEFAULT '',
+	blocked        BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at     TIMESTAMPTZ NOT NULL,
+	updated_at     TIMESTAMPTZ NOT NULL,
+	last_login_at  TIMESTAMPTZ
+)`
+
+// postgresAcceptanceSchema creates the table of accepted documents when
+// missing.
+const postgresAcceptanceSchema = `
+CREATE TABLE IF NOT EXISTS acceptances (
+	sub         TEXT NOT NULL,
+	document    TEXT NOT NULL,
+	version     TEXT NOT NULL,
+	accepted_at TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (sub, document, version)
+)`
+
+// postgresPreferenceSchema creates the table of user preferences when
+// missing.
+const postgresPreferenceSchema = `
+CREATE TABLE IF NOT EXISTS preferences (
+	sub           TEXT PRIMARY KEY,
+	timezone      TEXT NOT NULL DEFAULT '',
+	locale        TEXT NOT NULL DEFAULT '',
+	notifications JSONB NOT NULL DEFAULT '{}',
+	updated_at    TIMESTAMPTZ NOT NULL
+)`
+
+// postgresAccessTokenSchema creates the table of personal access tokens when
+// missing.
+const postgresAccessTokenSchema = `
+CREATE TABLE IF NOT EXISTS access_tokens (
+	id           TEXT PRIMARY KEY,
+	sub          TEXT NOT NULL,
+	name         TEXT NOT NULL,
+	scopes       TEXT NOT NULL,
+	hash         TEXT NOT NULL,
+	created_at   TIMESTAMPTZ NOT NULL,
+	expires_at   TIMESTAMPTZ NOT NULL,
+	last_used_at TIMESTAMPTZ,
+	revoked_at   TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS access_tokens_sub ON access_tokens (sub)`
+
+// postgresSessionSchema creates the table of session records when missing.
+const postgresSessionSchema = `
+CREATE TABLE IF NOT EXISTS user_sessions (
+	id           TEXT PRIMARY KEY,
+	sub          TEXT NOT NULL,
+	user_agent   TEXT NOT NULL,
+	ip           TEXT NOT NULL,
+	location     TEXT NOT NULL DEFAULT '',
+	created_at   TIMESTAMPTZ NOT NULL,
+	last_seen_at TIMESTAMPTZ NOT NULL,
+	revoked_at   TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS user_sessions_sub ON user_sessions (sub);
+ALTER TABLE user_sessions ADD COLUMN IF NOT EXISTS provider_session_id TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS user_sessions_provider_session_id ON user_sessions (provider_session_id)`
+
+// postgresUserStore keeps users in a Postgres table.
+type postgresUserStore struct {
+	db *sql.DB
+}
+
+func newPostgresUserStore(dsn string) (*postgresUserStore, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("could not open database: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := db.ExecContext(ctx, postgresUserSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not create users table: %v", err)
+	}
+
+	if _, err := db.ExecContext(ctx, postgresAcceptanceSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not create acceptances table: %v", err)
+	}
+
+	if _, err := db.ExecContext(ctx, postgresPreferenceSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not create preferences table: %v", err)
+	}
+
+	if _, err := db.ExecContext(ctx, postgresAccessTokenSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not create access tokens table: %v", err)
+	}
+
+	if _, err := db.ExecContext(ctx, postgresSessionSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not create sessions table: %v", err)
+	}
+
+	return &postgresUserStore{db: db}, nil
+}
+
+func (s *postgresUserStore) UpsertUser(ctx context.Context, user *User) error {
+	var lastLogin sql.NullTime
+	if !user.LastLoginAt.IsZero() {
+		lastLogin = sql.NullTime{Time: user.LastLoginAt, Valid: true}
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO users (sub, email, email_verified, name, picture, blocked, created_at, updated_at, last_login_at)
+		VALUES ($1, $2, $3, $4, $5, $6, now(), now(), $7)
+		ON CONFLICT (sub) DO UPDATE SET
+			email = EXCLUDED.email,
+			email_verified = EXCLUDED.email_verified,
+			name = EXCLUDED.name,
+			picture = EXCLUDED.picture,
+			updated_at = now(),
+			last_login_at = COALESCE(EXCLUDED.last_login_at, users.last_login_at)`,
+		user.Sub, user.Email, user.EmailVerified, user.Name, user.Picture, user.Blocked, lastLogin,
+	)
+	if err != nil {
+		return fmt.Errorf("could not upsert user: %v", err)
+	}
+
+	return nil
+}
+
+// postgresUserColumns are the columns read by scanUser.
+const postgresUserColumns = `sub, email, email_verified, name, picture, blocked, created_at, updated_at, last_login_at`
+
+// scanUser reads a user selected with postgresUserColumns.
+func scanUser(row interface{ Scan(...interface{}) error }) (*User, error) {
+	var user User
+	var lastLogin sql.NullTime
+
+	err := row.Scan(&user.Sub, &user.Email, &user.EmailVerified, &user.Name, &user.Picture, &user.Blocked,
+		&user.CreatedAt, &user.UpdatedAt, &lastLogin)
+	if err != nil {
+		return nil, err
+	}
+
+	user.LastLoginAt = lastLogin.Time
+	return &user, nil
+}
+
+func (s *postgresUserStore) GetUser(ctx context.Context, sub string) (*User, error) {
+	user, err := scanUser(s.db.QueryRowContext(ctx, `SELECT `+postgresUserColumns+` FROM users WHERE sub = $1`, sub))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get user: %v", err)
+	}
+
+	return user, nil
+}
+
+func (s *postgresUserStore) SetUserBlocked(ctx context.Context, sub string, blocked bool) error {
+	result, err := s.db.ExecContext(ctx, `UPDATE users SET blocked = $2, updated_at = now() WHERE sub = $1`, sub, blocked)
+	if err != nil {
+		return fmt.Errorf("could not update user: %v", err)
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *postgresUserStore) DeleteUser(ctx context.Context, sub string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM users WHERE sub = $1`, sub); err != nil {
+		return fmt.Errorf("could not delete user: %v", err)
+	}
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM acceptances WHERE sub = $1`, sub); err != nil {
+		return fmt.Errorf("could not delete user acceptances: %v", err)
+	}
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM preferences WHERE sub = $1`, sub); err != nil {
+		return fmt.Errorf("could not delete user preferences: %v", err)
+	}
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM access_tokens WHERE sub = $1`, sub); err != nil {
+		return fmt.Errorf("could not delete user access tokens: %v", err)
+	}
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM user_sessions WHERE sub = $1`, sub); err != nil {
+		return fmt.Errorf("could not delete user sessions: %v", err)
+	}
+	return nil
+}
+
+func (s *postgresUserStore) ListUsers(ctx context.Context) ([]User, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+postgresUserColumns+` FROM users ORDER BY sub`)
+	if err != nil {
+		return nil, fmt.Errorf("could not list users: %v", err)
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not list users: %v", err)
+		}
+		users = append(users, *user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not list users: %v", err)
+	}
+	return users, nil
+}
+
+func (s *postgresUserStore) RecordAcceptance(ctx context.Context, acceptance *Acceptance) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO acceptances (sub, document, version, accepted_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (sub, document, version) DO NOTHING`,
+		acceptance.Sub, acceptance.Document, acceptance.Version, acceptance.AcceptedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("could not record acceptance: %v", err)
+	}
+	return nil
+}
+
+func (s *postgresUserStore) ListAcceptances(ctx context.Context, sub string) ([]Acceptance, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT sub, document, version, accepted_at FROM acceptances
+		WHERE sub = $1 ORDER BY accepted_at`, sub)
+	if err != nil {
+		return nil, fmt.Errorf("could not list acceptances: %v", err)
+	}
+	defer rows.Close()
+
+	var acceptances []Acceptance
+	for rows.Next() {
+		var acceptance Acceptance
+		if err := rows.Scan(&acceptance.Sub, &acceptance.Document, &acceptance.Version, &acceptance.AcceptedAt); err != nil {
+			return nil, fmt.Errorf("could not list acceptances: %v", err)
+		}
+		acceptances = append(acceptances, acceptance)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not list acceptances: %v", err)
+	}
+	return acceptances, nil
+}
+
+func (s *postgresUserStore) GetPreferences(ctx context.Context, sub string) (*Preferences, error) {
+	var preferences Preferences
+	var notifications []byte
+	var updatedAt time.Time
+	err := s.db.QueryRowContext(ctx, `
+		SELECT timezone, locale, notifications, updated_at FROM preferences WHERE sub = $1`, sub,
+	).Scan(&preferences.Timezone, &preferences.Locale, &notifications, &updatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return defaultPreferences(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get preferences: %v", err)
+	}
+
+	if err := json.Unmarshal(notifications, &preferences.Notifications); err != nil {
+		return nil, fmt.Errorf("could not decode notification preferences: %v", err)
+	}
+	preferences.UpdatedAt = &updatedAt
+	return &preferences, nil
+}
+
+func (s *postgresUserStore) SavePreferences(ctx context.Context, sub string, preferences *Preferences) error {
+	notifications, err := json.Marshal(preferences.Notifications)
+	if err != nil {
+		return err
+	}
+
+	updatedAt := time.Now()
+	if preferences.UpdatedAt != nil {
+		updatedAt = *preferences.UpdatedAt
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO preferences (sub, timezone, locale, notifications, updated_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (sub) DO UPDATE SET
+			timezone = EXCLUDED.timezone,
+			locale = EXCLUDED.locale,
+			notifications = EXCLUDED.notifications,
+			updated_at = EXCLUDED.updated_at`,
+		sub, preferences.Timezone, preferences.Locale, notifications, updatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("could not save preferences: %v", err)
+	}
+	return nil
+}
+
+func (s *postgresUserStore) CreateAccessToken(ctx context.Context, token *AccessToken) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO access_tokens (id, sub, name, scopes, hash, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		token.ID, token.Sub, token.Name, strings.Join(token.Scopes, " "), token.Hash, token.CreatedAt, token.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("could not create access token: %v", err)
+	}
+	return nil
+}
+
+// postgresAccessTokenColumns are the columns read by scanAccessToken.
+const postgresAccessTokenColumns = `id, sub, name, scopes, hash, created_at, expires_at, last_used_at, revoked_at`
+
+// scanAccessToken reads a token selected with postgresAccessTokenColumns.
+func scanAccessToken(row interface{ Scan(...interface{}) error }) (*AccessToken, error) {
+	var token AccessToken
+	var scopes string
+	var lastUsed, revoked sql.NullTime
+
+	err := row.Scan(&token.ID, &token.Sub, &token.Name, &scopes, &token.Hash,
+		&token.CreatedAt, &token.ExpiresAt, &lastUsed, &revoked)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Scopes = strings.Fields(scopes)
+	if lastUsed.Valid {
+		token.LastUsedAt = &lastUsed.Time
+	}
+	if revoked.Valid {
+		token.RevokedAt = &revoked.Time
+	}
+	return &token, nil
+}
+
+func (s *postgresUserStore) GetAccessToken(ctx context.Context, id string) (*AccessToken, error) {
+	token, err := scanAccessToken(s.db.QueryRowContext(ctx, `SELECT `+postgresAccessTokenColumns+` FROM access_tokens WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAccessTokenNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get access token: %v", err)
+	}
+	return token, nil
+}
+
+func (s *postgresUserStore) ListAccessTokens(ctx context.Context, sub string) ([]AccessToken, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+postgresAccessTokenColumns+` FROM access_tokens
+		WHERE sub = $1 ORDER BY created_at DESC`, sub)
+	if err != nil {
+		return nil, fmt.Errorf("could not list access tokens: %v", err)
+	}
+	defer rows.Close()
+
+	var tokens []AccessToken
+	for rows.Next() {
+		token, err := scanAccessToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not list access tokens: %v", err)
+		}
+		tokens = append(tokens, *token)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not list access tokens: %v", err)
+	}
+	return tokens, nil
+}
+
+func (s *postgresUserStore) TouchAccessToken(ctx context.Context, id string, at time.Time) error {
+	if _, err := s.db.ExecContext(ctx, `UPDATE access_tokens SET last_used_at = $2 WHERE id = $1`, id, at); err != nil {
+		return fmt.Errorf("could not update access token: %v", err)
+	}
+	return nil
+}
+
+func (s *postgresUserStore) RevokeAccessToken(ctx context.Context, sub, id string, at time.Time) error {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE access_tokens SET revoked_at = COALESCE(revoked_at, $3)
+		WHERE id = $1 AND sub = $2`, id, sub, at)
+	if err != nil {
+		return fmt.Errorf("could not revoke access token: %v", err)
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrAccessTokenNotFound
+	}
+	return nil
+}
+
+func (s *postgresUserStore) CreateSession(ctx context.Context, session *UserSession) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO user_sessions (id, sub, user_agent, ip, location, created_at, last_seen_at, provider_session_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		session.ID, session.Sub, session.UserAgent, session.IP, session.Location, session.CreatedAt, session.LastSeenAt,
+		session.ProviderSessionID,
+	)
+	if err != nil {
+		return fmt.Errorf("could not create session: %v", err)
+	}
+	return nil
+}
+
+// postgresSessionColumns are the columns read by scanSession.
+const postgresSessionColumns = `id, sub, user_agent, ip, location, created_at, last_seen_at, revoked_at, provider_session_id`
+
+// scanSession reads a session selected with postgresSessionColumns.
+func scanSession(row interface{ Scan(...interface{}) error }) (*UserSession, error) {
+	var session UserSession
+	var revoked sql.NullTime
+
+	err := row.Scan(&session.ID, &session.Sub, &session.UserAgent, &session.IP, &session.Location,
+		&session.CreatedAt, &session.LastSeenAt, &revoked, &session.ProviderSessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if revoked.Valid {
+		session.RevokedAt = &revoked.Time
+	}
+	return &session, nil
+}
+
+func (s *postgresUserStore) GetSession(ctx context.Context, id string) (*UserSession, error) {
+	session, err := scanSession(s.db.QueryRowContext(ctx, `SELECT `+postgresSessionColumns+` FROM user_sessions WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get session: %v", err)
+	}
+	return session, nil
+}
+
+func (s *postgresUserStore) ListSessions(ctx context.Context, sub string) ([]UserSession, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+postgresSessionColumns+` FROM user_sessions
+		WHERE sub = $1 AND revoked_at IS NULL ORDER BY last_seen_at DESC`, sub)
+	if err != nil {
+		return nil, fmt.Errorf("could not list sessions: %v", err)
+	}
+	defer rows.Close()
+
+	var sessions []UserSession
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not list sessions: %v", err)
+		}
+		sessions = append(sessions, *session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not list sessions: %v", err)
+	}
+	return sessions, nil
+}
+
+func (s *postgresUserStore) TouchSession(ctx context.Context, id string, at time.Time, ip string) error {
+	if _, err := s.db.ExecContext(ctx, `UPDATE user_sessions SET last_seen_at = $2, ip = $3 WHERE id = $1`, id, at, ip); err != nil {
+		return fmt.Errorf("could not update session: %v", err)
+	}
+	return nil
+}
+
+func (s *postgresUserStore) RevokeSession(ctx context.Context, sub, id string, at time.Time) error {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE user_sessions SET revoked_at = COALESCE(revoked_at, $3)
+		WHERE id = $1 AND sub = $2`, id, sub, at)
+	if err != nil {
+		return fmt.Errorf("could not revoke session: %v", err)
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func (s *postgresUserStore) RevokeProviderSession(ctx context.Context, sub, providerSessionID string, at time.Time) ([]UserSession, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		UPDATE user_sessions SET revoked_at = $3
+		WHERE provider_session_id = $1 AND ($2 = '' OR sub = $2) AND revoked_at IS NULL
+		RETURNING `+postgresSessionColumns, providerSessionID, sub, at)
+	if err != nil {
+		return nil, fmt.Errorf("could not revoke sessions: %v", err)
+	}
+	defer rows.Close()
+
+	var sessions []UserSession
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not revoke sessions: %v", err)
+		}
+		sessions = append(sessions, *session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not revoke sessions: %v", err)
+	}
+	return sessions, nil
+}
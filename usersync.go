@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// userSyncPageSize is the number of users fetched per page.
+	userSyncPageSize = 100
+	// userSyncMaxResults is the number of results the Management API returns
+	// at most for a single search.
+	userSyncMaxResults = 1000
+)
+
+// managementUser is a user as returned by the Management API.
+type managementUser struct {
+	UserID        string    `json:"user_id"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	Name          string    `json:"name"`
+	Picture       string    `json:"picture"`
+	Blocked       bool      `json:"blocked"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// UserSync reconciles the local user store with the users of the auth0
+// tenant.
+//
+// Users updated since the last checkpoint are fetched in updated_at order,
+// and the checkpoint is saved after every page, so an interrupted run resumes
+// where it stopped. Users deleted from auth0 are removed from the store when
+// the whole tenant fits in a single search.
+type UserSync struct {
+	management     *ManagementClient
+	users          UserStore
+	checkpointFile string // file persisting the checkpoint, kept in memory when empty
+	checkpoint     time.Time
+}
+
+// NewUserSync creates a UserSync, loading the checkpoint from
+// checkpointFile when it exists.
+func NewUserSync(management *ManagementClient, users UserStore, checkpointFile string) (*UserSync, error) {
+	userSync := &UserSync{
+		management:     management,
+		users:          users,
+		checkpointFile: checkpointFile,
+	}
+
+	if checkpointFile == "" {
+		return userSync, nil
+	}
+
+	b, err := ioutil.ReadFile(checkpointFile)
+	if os.IsNotExist(err) {
+		return userSync, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read user sync checkpoint: %v", err)
+	}
+
+	checkpoint, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse user sync checkpoint: %v", err)
+	}
+	userSync.checkpoint = checkpoint
+
+	return userSync, nil
+}
+
+// Run synchronizes the users once.
+func (s *UserSync) Run(ctx context.Context) error {
+	if err := s.syncUpdated(ctx); err != nil {
+		return err
+	}
+	return s.syncDeleted(ctx)
+}
+
+// syncUpdated copies the users updated since the checkpoint.
+func (s *UserSync) syncUpdated(ctx context.Context) error {
+	query := url.Values{
+		"search_engine": {"v3"},
+		"sort":          {"updated_at:1"},
+		"per_page":      {strconv.Itoa(userSyncPageSize)},
+		"fields":        {"user_id,email,email_verified,name,picture,blocked,updated_at"},
+	}
+	if !s.checkpoint.IsZero() {
+		query.Set("q", "updated_at:["+s.checkpoint.UTC().Format("2006-01-02T15:04:05.000Z")+" TO *]")
+	}
+
+	for page := 0; page*userSyncPageSize < userSyncMaxResults; page++ {
+		query.Set("page", strconv.Itoa(page))
+
+		var users []managementUser
+		if err := s.management.get(ctx, "/users", query, &users); err != nil {
+			return fmt.Errorf("could not list users: %v", err)
+		}
+
+		for _, u := range users {
+			user := &User{
+				Sub:           u.UserID,
+				Email:         u.Email,
+				EmailVerified: u.EmailVerified,
+				Name:          u.Name,
+				Picture:       u.Picture,
+				Blocked:       u.Blocked,
+			}
+			if err := s.users.UpsertUser(ctx, user); err != nil {
+				return err
+			}
+			if err := s.users.SetUserBlocked(ctx, user.Sub, user.Blocked); err != nil {
+				return err
+			}
+		}
+
+		if len(users) > 0 {
+			if err := s.saveCheckpoint(users[len(users)-1].UpdatedAt); err != nil {
+				return err
+			}
+		}
+
+		if len(users) < userSyncPageSize {
+			return nil
+		}
+	}
+
+	// more users are left, the next run continues from the checkpoint
+	return nil
+}
+
+// syncDeleted removes the local users which no longer exist in auth0.
+func (s *UserSync) syncDeleted(ctx context.Context) error {
+	query := url.Values{
+		"per_page":       {strconv.Itoa(userSyncPageSize)},
+		"fields":         {"user_id"},
+		"include_totals": {"true"},
+	}
+
+	remote := make(map[string]bool)
+	for page := 0; ; page++ {
+		query.Set("page", strconv.Itoa(page))
+
+		var result struct {
+			Users []managementUser `json:"users"`
+			Total int              `json:"total"`
+		}
+		if err := s.management.get(ctx, "/users", query, &result); err != nil {
+			return fmt.Errorf("could not list users: %v", err)
+		}
+
+		if result.Total > userSyncMaxResults {
+			slog.WarnContext(ctx, "user sync: users exceed the search limit, deleted users are not reconciled", "users", result.Total)
+			return nil
+		}
+
+		for _, u := range result.Users {
+			remote[u.UserID] = true
+		}
+
+		if len(result.Users) < userSyncPageSize {
+			break
+		}
+	}
+
+	local, err := s.users.ListUsers(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, user := range local {
+		if remote[user.Sub] {
+			continue
+		}
+		if err := s.users.DeleteUser(ctx, user.Sub); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// saveCheckpoint records that the users updated up to t were synchronized.
+func (s *UserSync) saveCheckpoint(t time.Time) error {
+	s.checkpoint = t
+	if s.checkpointFile == "" {
+		return nil
+	}
+
+	if err := ioutil.WriteFile(s.checkpointFile, []byte(t.UTC().Format(time.RFC3339Nano)), 0o600); err != nil {
+		return fmt.Errorf("could not save user sync checkpoint: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxWebhookBodySize is the largest webhook payload accepted.
+const maxWebhookBodySize = 1 << 20
+
+// userEvent is the payload posted by the auth0 action when a user is
+// created, changed, blocked or deleted.
+type userEvent struct {
+	Type string `json:"type"` // user.created, user.updated, user.blocked, user.unblocked or user.deleted
+	User struct {
+		UserID        string `json:"user_id"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+		Picture       string `json:"picture"`
+		Blocked       bool   `json:"blocked"`
+	} `json:"user"`
+}
+
+// userEventsHandler handles the user events sent by auth0 and applies them
+// to the local user store.
+func (s *Server) userEventsHandler(ctx *gin.Context) {
+	body := webhookDelivery(ctx).Payload.([]byte)
+
+	var event userEvent
+	if err := json.Unmarshal(body, &event); err != nil || event.User.UserID == "" {
+		abortWithError(ctx, http.StatusBadRequest, "invalid_request", "could not parse user event")
+		return
+	}
+
+	user := &User{
+		Sub:           event.User.UserID,
+		Email:         event.User.Email,
+		EmailVerified: event.User.EmailVerified,
+		Name:          event.User.Name,
+		Picture:       event.User.Picture,
+		Blocked:       event.User.Blocked,
+	}
+
+	var err error
+	switch event.Type {
+	case "user.created", "user.updated":
+		err = s.users.UpsertUser(ctx, user)
+		if err == nil {
+			err = s.users.SetUserBlocked(ctx, user.Sub, user.Blocked)
+		}
+	case "user.blocked", "user.unblocked":
+		err = s.users.SetUserBlocked(ctx, user.Sub, event.Type == "user.blocked")
+		if errors.Is(err, ErrUserNotFound) {
+			// the user never signed in here, keep a record of the flag
+			user.Blocked = event.Type == "user.blocked"
+			err = s.users.UpsertUser(ctx, user)
+		}
+	case "user.deleted":
+		err = s.users.DeleteUser(ctx, user.Sub)
+	default:
+		abortWithError(ctx, http.StatusBadRequest, "invalid_request", "unknown user event type")
+		return
+	}
+
+	if err != nil {
+		slog.ErrorContext(ctx, "could not apply user event", "type", event.Type, "err", err)
+		abortWithError(ctx, http.StatusInternalServerError, "server_error", "could not apply user event")
+		return
+	}
+
+	s.audit(ctx, auditUserEvent, user.Sub, map[string]string{"event": event.Type})
+	ctx.Status(http.StatusNoContent)
+}
+
+// RejectBlockedUsers denies access to users flagged as blocked in the local
+// user store. It must run after IsAuthenticated.
+func (s *Server) RejectBlockedUsers() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		u, ok := currentUser(ctx)
+		if !ok {
+			ctx.Next()
+			return
+		}
+
+		user, err := s.users.GetUser(ctx, u.Sub)
+		if err == nil && user.Blocked {
+			s.audit(ctx, auditAccessDenied, user.Sub, map[string]string{"reason": "blocked"})
+			renderError(ctx, http.StatusForbidden, "Account blocked", "Your account has been blocked.")
+			return
+		}
+
+		ctx.Next()
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultWebhookTolerance is how old a webhook delivery can be, and how
+	// long it is remembered to reject replays.
+	defaultWebhookTolerance = 5 * time.Minute
+	// defaultWebhookReplayRetention is how long the deliveries without a
+	// timestamp are remembered, as they never expire.
+	defaultWebhookReplayRetention = 30 * 24 * time.Hour
+)
+
+var (
+	// errWebhookSignature is returned for deliveries without a valid
+	// signature.
+	errWebhookSignature = errors.New("invalid signature")
+	// errWebhookTimestamp is returned for deliveries whose timestamp is
+	// missing or outside of the tolerance.
+	errWebhookTimestamp = errors.New("timestamp outside of the tolerance")
+	// errWebhookReplay is returned for deliveries already received.
+	errWebhookReplay = errors.New("delivery already received")
+)
+
+// WebhookDelivery is a verified webhook delivery.
+type WebhookDelivery struct {
+	ID      string      // unique ID of the delivery, to detect replays
+	SentAt  time.Time   // when the delivery was signed, zero when it is not timestamped
+	Payload interface{} // verified payload, the body for HMAC signatures
+}
+
+// WebhookVerifier checks that a webhook delivery comes from the expected
+// sender, signed with a shared secret or as a JWT.
+type WebhookVerifier interface {
+	Verify(ctx context.Context, r *http.Request, body []byte) (*WebhookDelivery, error)
+}
+
+// hmacWebhookVerifier verifies deliveries signed with an HMAC-SHA256 keyed
+// with a shared secret, in a "sha256=<hex digest>" header. When a timestamp
+// header is sent, the signature covers "<timestamp>.<body>"; otherwise it
+// covers the body, unless timestamps are required.
+type hmacWebhookVerifier struct {
+	secret           []byte
+	signatureHeader  string
+	timestampHeader  string
+	requireTimestamp bool
+}
+
+func (v *hmacWebhookVerifier) Verify(ctx context.Context, r *http.Request, body []byte) (*WebhookDelivery, error) {
+	digest, err := hex.DecodeString(strings.TrimPrefix(r.Header.Get(v.signatureHeader), "sha256="))
+	if err != nil || len(digest) == 0 {
+		return nil, errWebhookSignature
+	}
+
+	signed := body
+	var sentAt time.Time
+	if raw := r.Header.Get(v.timestampHeader); raw != "" {
+		unix, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, errWebhookTimestamp
+		}
+		sentAt = time.Unix(unix, 0)
+		signed = append([]byte(raw+"."), body...)
+	} else if v.requireTimestamp {
+		return nil, errWebhookTimestamp
+	}
+
+	mac := hmac.New(sha256.New, v.secret)
+	mac.Write(signed)
+	if !hmac.Equal(digest, mac.Sum(nil)) {
+		return nil, errWebhookSignature
+	}
+	return &WebhookDelivery{ID: hex.EncodeToString(digest), SentAt: sentAt, Payload: body}, nil
+}
+
+// replayCache remembers the webhook deliveries received recently.
+type replayCache interface {
+	// seen records key until expires, reporting whether it was already
+	// recorded.
+	seen(ctx context.Context, key string, expires time.Time) (bool, error)
+}
+
+// newReplayCache returns a cache shared between instances in Redis when
+// redisClient is set, and an in memory cache otherwise.
+func newReplayCache(redisClient *RedisClient) replayCache {
+	if redisClient == nil {
+		return &memoryReplayCache{keys: make(map[string]time.Time)}
+	}
+	return &redisReplayCache{client: redisClient}
+}
+
+// memoryReplayCache keeps the deliveries in memory, per instance.
+type memoryReplayCache struct {
+	mu   sync.Mutex
+	keys map[string]time.Time
+}
+
+func (c *memoryReplayCache) seen(ctx context.Context, key string, expires time.Time) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for k, until := range c.keys {
+		if now.After(until) {
+			delete(c.keys, k)
+		}
+	}
+
+	if _, ok := c.keys[key]; ok {
+		return true, nil
+	}
+	c.keys[key] = expires
+	return false, nil
+}
+
+// redisReplayCache keeps the deliveries in Redis.
+type redisReplayCache struct {
+	client *RedisClient
+}
+
+func (c *redisReplayCache) seen(ctx context.Context, key string, expires time.Time) (bool, error) {
+	ttl := time.Until(expires).Milliseconds()
+	if ttl <= 0 {
+		ttl = 1
+	}
+	reply, err := c.client.Do(ctx, "SET", "webhook_replay:"+key, "1", "NX", "PX", strconv.FormatInt(ttl, 10))
+	if err != nil {
+		return false, err
+	}
+	return reply == nil, nil
+}
+
+// Webhooks verifies the deliveries of every webhook receiver, rejecting the
+// replayed ones.
+type Webhooks struct {
+	tolerance time.Duration
+	retention time.Duration
+	replays   replayCache
+}
+
+// NewWebhooks creates receivers accepting deliveries up to tolerance old,
+// remembered in replays. The deliveries without a timestamp are remembered
+// for retention instead.
+func NewWebhooks(tolerance, retention time.Duration, replays replayCache) *Webhooks {
+	return &Webhooks{tolerance: tolerance, retention: retention, replays: replays}
+}
+
+// check rejects the deliveries to the receiver name sent outside of the
+// tolerance or received before.
+func (w *Webhooks) check(ctx *gin.Context, name string, delivery *WebhookDelivery) error {
+	// a delivery without a timestamp can be replayed at any time, it is
+	// remembered for the retention rather than the tolerance
+	expires := time.Now().Add(w.retention)
+	if !delivery.SentAt.IsZero() {
+		if age := time.Since(delivery.SentAt); age > w.tolerance || age < -w.tolerance {
+			return errWebhookTimestamp
+		}
+		expires = delivery.SentAt.Add(w.tolerance)
+	}
+
+	replayed, err := w.replays.seen(ctx, name+":"+delivery.ID, expires)
+	if err != nil {
+		// deliveries are not lost while the cache is unavailable
+		slog.ErrorContext(ctx, "could not check webhook replay", "err", err)
+		return nil
+	}
+	if replayed {
+		return errWebhookReplay
+	}
+	return nil
+}
+
+// HMACVerifier returns a verifier of deliveries signed with secret in the
+// X-Webhook-Signature header, timestamped in X-Webhook-Timestamp.
+func (w *Webhooks) HMACVerifier(secret string, requireTimestamp bool) WebhookVerifier {
+	return &hmacWebhookVerifier{
+		secret:           []byte(secret),
+		signatureHeader:  "X-Webhook-Signature",
+		timestampHeader:  "X-Webhook-Timestamp",
+		requireTimestamp: requireTimestamp,
+	}
+}
+
+// VerifyWebhook reads the body of the deliveries to the receiver name and
+// lets through the ones verified by verifier, sent within the tolerance and
+// not received before, their WebhookDelivery being set in the context under
+// "webhook". The others are audited and answered with reject.
+func (s *Server) VerifyWebhook(name string, verifier WebhookVerifier, reject func(ctx *gin.Context, err error)) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		body, err := ioutil.ReadAll(io.LimitReader(ctx.Request.Body, maxWebhookBodySize))
+		if err != nil {
+			abortWithError(ctx, http.StatusBadRequest, "invalid_request", "could not read request body")
+			return
+		}
+
+		delivery, err := verifier.Verify(ctx, ctx.Request, body)
+		if err == nil {
+			err = s.webhooks.check(ctx, name, delivery)
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "webhook rejected", "webhook", name, "err", err)
+			s.audit(ctx, auditWebhookRejected, "", map[string]string{"webhook": name, "reason": webhookRejectReason(err)})
+			reject(ctx, err)
+			ctx.Abort()
+			return
+		}
+
+		ctx.Set("webhook", delivery)
+		ctx.Next()
+	}
+}
+
+// webhookRejectReason returns the reason of a rejection in audit events.
+func webhookRejectReason(err error) string {
+	switch {
+	case errors.Is(err, errWebhookReplay):
+		return "replayed"
+	case errors.Is(err, errWebhookTimestamp):
+		return "invalid_timestamp"
+	case errors.Is(err, errWebhookSignature):
+		return "invalid_signature"
+	default:
+		return "invalid_token"
+	}
+}
+
+// webhookDelivery returns the delivery verified by VerifyWebhook.
+func webhookDelivery(ctx *gin.Context) *WebhookDelivery {
+	return ctx.MustGet("webhook").(*WebhookDelivery)
+}
+
+// rejectWebhook answers a rejected delivery with a JSON error.
+func rejectWebhook(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errWebhookReplay):
+		abortWithError(ctx, http.StatusConflict, "replayed", "the webhook delivery was already received")
+	case errors.Is(err, errWebhookTimestamp):
+		abortWithError(ctx, http.StatusUnauthorized, "invalid_timestamp", "the webhook timestamp is missing or too old")
+	default:
+		abortWithError(ctx, http.StatusUnauthorized, "invalid_signature", "the webhook signature is invalid")
+	}
+}
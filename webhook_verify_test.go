@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testWebhookSecret = "webhook-secret"
+
+// webhookSignature returns the X-Webhook-Signature of payload.
+func webhookSignature(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestHMACWebhookVerifier(t *testing.T) {
+	const body = `{"type":"user.updated"}`
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+
+	for _, tt := range []struct {
+		name             string
+		signature        string
+		timestamp        string
+		requireTimestamp bool
+		wantErr          error
+	}{
+		{"timestamped", webhookSignature(testWebhookSecret, now+"."+body), now, true, nil},
+		{"body signed", webhookSignature(testWebhookSecret, body), "", false, nil},
+		{"timestamp required", webhookSignature(testWebhookSecret, body), "", true, errWebhookTimestamp},
+		{"timestamp not signed", webhookSignature(testWebhookSecret, body), now, true, errWebhookSignature},
+		{"invalid timestamp", webhookSignature(testWebhookSecret, "soon."+body), "soon", true, errWebhookTimestamp},
+		{"other secret", webhookSignature("other-secret", now+"."+body), now, true, errWebhookSignature},
+		{"no signature", "", now, true, errWebhookSignature},
+		{"not hex", "sha256=zz", now, true, errWebhookSignature},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier := NewWebhooks(defaultWebhookTolerance, defaultWebhookReplayRetention, newReplayCache(nil)).HMACVerifier(testWebhookSecret, tt.requireTimestamp)
+			r := httptest.NewRequest("POST", "/webhooks/auth0/users", nil)
+			if tt.signature != "" {
+				r.Header.Set("X-Webhook-Signature", tt.signature)
+			}
+			if tt.timestamp != "" {
+				r.Header.Set("X-Webhook-Timestamp", tt.timestamp)
+			}
+
+			delivery, err := verifier.Verify(r.Context(), r, []byte(body))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Verify = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (tt.timestamp == "") != delivery.SentAt.IsZero() {
+				t.Errorf("SentAt = %v with timestamp %q", delivery.SentAt, tt.timestamp)
+			}
+		})
+	}
+}
+
+func TestWebhooksCheck(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		sentAt  time.Time
+		wantErr error
+	}{
+		{"recent", time.Now().Add(-time.Minute), nil},
+		{"not timestamped", time.Time{}, nil},
+		{"too old", time.Now().Add(-defaultWebhookTolerance - time.Minute), errWebhookTimestamp},
+		{"in the future", time.Now().Add(defaultWebhookTolerance + time.Minute), errWebhookTimestamp},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			webhooks := NewWebhooks(defaultWebhookTolerance, defaultWebhookReplayRetention, newReplayCache(nil))
+			ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+			ctx.Request = httptest.NewRequest("POST", "/webhooks/auth0/users", nil)
+
+			delivery := &WebhookDelivery{ID: "delivery", SentAt: tt.sentAt}
+			if err := webhooks.check(ctx, "users", delivery); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("check = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if err := webhooks.check(ctx, "users", delivery); !errors.Is(err, errWebhookReplay) {
+				t.Errorf("replayed check = %v, want %v", err, errWebhookReplay)
+			}
+			// receivers remember their deliveries apart
+			if err := webhooks.check(ctx, "logout", delivery); err != nil {
+				t.Errorf("check of another receiver = %v", err)
+			}
+		})
+	}
+}
+
+func TestMemoryReplayCacheRetention(t *testing.T) {
+	cache := newReplayCache(nil).(*memoryReplayCache)
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest("POST", "/", nil)
+
+	// a delivery without a timestamp outlives the tolerance
+	webhooks := NewWebhooks(defaultWebhookTolerance, defaultWebhookReplayRetention, cache)
+	if err := webhooks.check(ctx, "users", &WebhookDelivery{ID: "untimestamped"}); err != nil {
+		t.Fatal(err)
+	}
+	if until := cache.keys["users:untimestamped"]; time.Until(until) < defaultWebhookReplayRetention-time.Minute {
+		t.Errorf("untimestamped delivery remembered until %v, want the retention", until)
+	}
+
+	if seen, _ := cache.seen(ctx, "expired", time.Now().Add(-time.Second)); seen {
+		t.Fatal("new key reported as seen")
+	}
+	if seen, _ := cache.seen(ctx, "expired", time.Now().Add(time.Minute)); seen {
+		t.Error("expired key still remembered")
+	}
+}